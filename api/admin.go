@@ -90,12 +90,20 @@ func (a *Api) forceDocumentProcessing(c echo.Context) error {
 	switch body.FromStep {
 	case "hash":
 		step = models.ProcessHash
+	case "preprocess":
+		step = models.ProcessPreprocess
 	case "thumbnail":
 		step = models.ProcessThumbnail
+	case "barcodes":
+		step = models.ProcessBarcodes
 	case "content":
 		step = models.ProcessParseContent
+	case "searchable-pdf":
+		step = models.ProcessSearchablePdf
 	case "detect-language":
 		step = models.ProcessDetectLanguage
+	case "extract-fields":
+		step = models.ProcessExtractFields
 	case "rules":
 		step = models.ProcessRules
 	case "fts":
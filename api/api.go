@@ -35,9 +35,11 @@ import (
 	"tryffel.net/go/virtualpaper/config"
 	"tryffel.net/go/virtualpaper/models"
 	"tryffel.net/go/virtualpaper/services"
+	"tryffel.net/go/virtualpaper/services/importer"
 	"tryffel.net/go/virtualpaper/services/process"
 	"tryffel.net/go/virtualpaper/services/scheduler"
 	"tryffel.net/go/virtualpaper/services/search"
+	"tryffel.net/go/virtualpaper/services/webhook"
 	"tryffel.net/go/virtualpaper/storage"
 )
 
@@ -48,9 +50,11 @@ type Api struct {
 	privateRouter *echo.Group
 	adminRouter   *echo.Group
 
-	cors    http.Handler
-	cron    *scheduler.CronJobs
-	process *process.Manager
+	cors     http.Handler
+	cron     *scheduler.CronJobs
+	process  *process.Manager
+	importer *importer.Importer
+	webhooks *webhook.Dispatcher
 
 	adminService    *services.AdminService
 	authService     *services.AuthService
@@ -59,6 +63,8 @@ type Api struct {
 	ruleService     *services.RuleService
 	userService     *services.UserService
 	propertyService *services.PropertyService
+	archiveService  *services.ArchiveService
+	webhookService  *services.WebhookService
 }
 
 // NewApi initializes new api instance. It connects to database and opens http port.
@@ -83,7 +89,8 @@ func NewApi(database *storage.Database) (*Api, error) {
 		return api, err
 	}
 
-	api.process, err = process.NewManager(database, search)
+	api.webhooks = webhook.NewDispatcher(database)
+	api.process, err = process.NewManager(database, search, api.webhooks)
 	if err != nil {
 		return api, err
 	}
@@ -94,12 +101,31 @@ func NewApi(database *storage.Database) (*Api, error) {
 	}
 
 	api.authService = services.NewAuthService(database)
-	api.documentService = services.NewDocumentService(database, search, api.process)
+	api.documentService = services.NewDocumentService(database, search, api.process, api.webhooks)
+	api.process.SetDocumentSplitter(api.documentService)
 	api.metadataService = services.NewMetadataService(database, api.process)
 	api.ruleService = services.NewRuleService(database, search, api.process)
 	api.userService = services.NewUserServices(database, search)
 	api.adminService = services.NewAdminService(database, api.process, search)
 	api.propertyService = services.NewPropertyService(database, api.process)
+	api.archiveService = services.NewArchiveService(database, api.process)
+	api.webhookService = services.NewWebhookService(database)
+
+	importDirs, err := importer.DirectoriesFromConfig(database, config.C.Processing.ImportDirs)
+	if err != nil {
+		return api, err
+	}
+	api.importer = importer.NewImporter(api.documentService, importDirs,
+		config.C.Processing.ImportInterval, config.C.Processing.ImportSettleTime)
+
+	mailAccounts, err := importer.MailAccountsFromConfig(database, config.C.MailImport.Accounts)
+	if err != nil {
+		return api, err
+	}
+	err = api.cron.AddMailImport(importer.NewMailImporter(api.documentService, mailAccounts), config.C.MailImport.PollInterval)
+	if err != nil {
+		return api, err
+	}
 	api.addRoutesV2()
 	return api, err
 }
@@ -111,6 +137,8 @@ func (a *Api) Serve() error {
 	}
 
 	a.cron.Start()
+	a.importer.Start()
+	a.webhooks.Start()
 
 	go func() {
 		addr := fmt.Sprintf("%s:%d", config.C.Api.Host, config.C.Api.Port)
@@ -135,6 +163,8 @@ func (a *Api) Serve() error {
 		a.echo.Logger.Fatal(err)
 	}
 
+	a.importer.Stop()
+	a.webhooks.Stop()
 	a.cron.Stop()
 
 	logrus.Info("server stopped")
@@ -146,6 +176,8 @@ type VersionResponse struct {
 	Name    string `json:"name"`
 	Version string `json:"version"`
 	Commit  string `json:"commit"`
+	// OidcEnabled is true if users can log in with single sign-on.
+	OidcEnabled bool `json:"oidc_enabled"`
 }
 
 // MimeTypesSupportedResponse conatains info on mime types that server can extract.
@@ -164,6 +196,8 @@ func (a *Api) getVersionV2(c echo.Context) error {
 		Name:    "VirtualPaper",
 		Version: config.Version,
 		Commit:  config.Commit,
+
+		OidcEnabled: config.C.Oidc.Enabled,
 	}
 	return c.JSON(http.StatusOK, v)
 }
@@ -0,0 +1,107 @@
+/*
+ * Virtualpaper is a service to manage users paper documents in virtual format.
+ * Copyright (C) 2024  Tero Vierimaa
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package api
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"tryffel.net/go/virtualpaper/models/aggregates"
+)
+
+type ApiTokenRequest struct {
+	Name   string   `json:"name" valid:"stringlength(1|200)"`
+	Scopes []string `json:"scopes" valid:"-"`
+	// ExpiresAt is unix timestamp in milliseconds. If 0, token does not expire.
+	ExpiresAt int64 `json:"expires_at" valid:"-"`
+}
+
+func (a *Api) getApiTokens(c echo.Context) error {
+	// swagger:route GET /api/v1/auth/tokens Authentication GetApiTokens
+	// Get api tokens
+	//
+	// responses:
+	//   200: ApiToken
+	ctx := c.(UserContext)
+	opOk := false
+	defer logCrudApiToken(ctx.UserId, "get list", &opOk, "")
+
+	tokens, err := a.authService.GetApiTokens(getContext(c), ctx.UserId)
+	if err != nil {
+		return err
+	}
+	data := make([]aggregates.ApiToken, len(*tokens))
+	for i, v := range *tokens {
+		data[i] = *aggregates.ApiTokenToAggregate(&v)
+	}
+	opOk = true
+	return resourceList(c, data, len(data))
+}
+
+func (a *Api) addApiToken(c echo.Context) error {
+	// swagger:route POST /api/v1/auth/tokens Authentication AddApiToken
+	// Add api token. Response contains the token, which cannot be retrieved later.
+	// Tokens are used in header 'Authorization: Bearer <token>'.
+	//
+	// responses:
+	//   200: ApiToken
+	ctx := c.(UserContext)
+	opOk := false
+	defer logCrudApiToken(ctx.UserId, "create", &opOk, "")
+	data := &ApiTokenRequest{}
+	err := unMarshalBody(c.Request(), data)
+	if err != nil {
+		return err
+	}
+
+	expiresAt := time.Time{}
+	if data.ExpiresAt != 0 {
+		expiresAt = time.UnixMilli(data.ExpiresAt)
+	}
+	token, raw, err := a.authService.CreateApiToken(getContext(c), ctx.User, data.Name, data.Scopes, expiresAt)
+	if err != nil {
+		return err
+	}
+	resp := aggregates.ApiTokenToAggregate(token)
+	resp.Token = raw
+	opOk = true
+	return c.JSON(http.StatusOK, resp)
+}
+
+func (a *Api) deleteApiToken(c echo.Context) error {
+	// swagger:route DELETE /api/v1/auth/tokens/{id} Authentication DeleteApiToken
+	// Revoke api token
+	//
+	// responses:
+	//   200:
+	ctx := c.(UserContext)
+	id, err := bindPathIdInt(c)
+	if err != nil {
+		return err
+	}
+	opOk := false
+	defer logCrudApiToken(ctx.UserId, "delete", &opOk, "token: %d", id)
+	err = a.authService.RevokeApiToken(getContext(c), ctx.UserId, id)
+	if err != nil {
+		return err
+	}
+	opOk = true
+	return c.String(http.StatusOK, "")
+}
@@ -0,0 +1,96 @@
+/*
+ * Virtualpaper is a service to manage users paper documents in virtual format.
+ * Copyright (C) 2024  Tero Vierimaa
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"tryffel.net/go/virtualpaper/errors"
+	"tryffel.net/go/virtualpaper/util/logger"
+)
+
+func (a *Api) exportArchive(c echo.Context) error {
+	// swagger:route GET /api/v1/archive/export Archive ExportArchive
+	// Export all documents, metadata, properties and rules of the user as a zip archive.
+	// Large archives are better exported with 'virtualpaper manage export'.
+	// Produces:
+	// - application/zip
+	//
+	// Responses:
+	//  200: RespOk
+	ctx := c.(UserContext)
+	opOk := false
+	defer logCrudArchive(ctx.UserId, "export", &opOk, "")
+
+	resp := c.Response()
+	resp.Header().Set("Content-Type", "application/zip")
+	resp.Header().Set("Content-Disposition",
+		fmt.Sprintf("attachment; filename=virtualpaper-%s-%s.zip", ctx.User.Name, time.Now().Format("2006-01-02")))
+	resp.WriteHeader(http.StatusOK)
+
+	err := a.archiveService.Export(getContext(c), ctx.UserId, resp)
+	if err != nil {
+		// headers are already sent, so the error can only be logged.
+		logger.Context(getContext(c)).Errorf("export archive: %v", err)
+		return nil
+	}
+	opOk = true
+	return nil
+}
+
+func (a *Api) importArchive(c echo.Context) error {
+	// swagger:route POST /api/v1/archive/import Archive ImportArchive
+	// Import archive created with export. Documents that already exist are not imported again.
+	// Consumes:
+	// - multipart/form-data
+	//
+	// Responses:
+	//  200: RespOk
+	//  400: RespBadRequest
+	ctx := c.(UserContext)
+	opOk := false
+	defer logCrudArchive(ctx.UserId, "import", &opOk, "")
+
+	req := c.Request()
+	err := req.ParseMultipartForm(1024 * 1024 * 500)
+	if err != nil {
+		userError := errors.ErrInvalid
+		userError.ErrMsg = fmt.Sprintf("invalid form: %v", err)
+		userError.Err = err
+		return userError
+	}
+	file, header, err := req.FormFile("file")
+	if err != nil {
+		userError := errors.ErrInvalid
+		userError.ErrMsg = fmt.Sprintf("invalid file: %v", err)
+		userError.Err = err
+		return userError
+	}
+	defer file.Close()
+
+	result, err := a.archiveService.Import(getContext(c), ctx.UserId, file, header.Size)
+	if err != nil {
+		return err
+	}
+	opOk = true
+	return c.JSON(http.StatusOK, result)
+}
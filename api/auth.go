@@ -30,6 +30,7 @@ import (
 	"time"
 	"tryffel.net/go/virtualpaper/config"
 	"tryffel.net/go/virtualpaper/errors"
+	"tryffel.net/go/virtualpaper/models"
 	"tryffel.net/go/virtualpaper/services"
 	log "tryffel.net/go/virtualpaper/util/logger"
 )
@@ -55,6 +56,9 @@ func (a *Api) authorizeUserV2() echo.MiddlewareFunc {
 			if len(parts) != 2 {
 				return
 			}
+			if strings.HasPrefix(parts[1], models.ApiTokenPrefix) {
+				return a.authorizeApiToken(c, next, parts[1], authErr)
+			}
 
 			userId, tokenKey, err := validateToken(c, parts[1], config.C.Api.Key)
 			if userId == "" || err != nil {
@@ -96,6 +100,61 @@ func (a *Api) authorizeUserV2() echo.MiddlewareFunc {
 	}
 }
 
+// authorizeApiToken authorizes request with api token. Scopes of the token are checked with requireScope.
+func (a *Api) authorizeApiToken(c echo.Context, next echo.HandlerFunc, rawToken string, authErr error) error {
+	user, token, err := a.authService.GetUserByApiToken(getContext(c), rawToken, c.RealIP())
+	if err != nil {
+		if errors.Is(err, errors.ErrRecordNotFound) || errors.Is(err, errors.ErrUnauthorized) {
+			return authErr
+		}
+		return fmt.Errorf("get api token from database: %v", err)
+	}
+	ctx := UserContext{
+		Context: Context{Context: c, pagination: PageParams{
+			Page:     1,
+			PageSize: 20,
+		},
+			sort: SortKey{},
+		},
+		Admin:    user.IsAdmin,
+		UserId:   user.Id,
+		User:     user,
+		ApiToken: token,
+	}
+	return next(ctx)
+}
+
+// requireScope restricts requests that are authenticated with api token to tokens that have the scope.
+// Read scope is required for GET requests and write scope for other requests. Empty scope denies
+// api tokens. Login sessions are not restricted.
+func (a *Api) requireScope(read, write string) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			ctx, ok := c.(UserContext)
+			if !ok {
+				c.Logger().Error("no UserContext found")
+				return echo.ErrInternalServerError
+			}
+			if ctx.ApiToken == nil {
+				return next(c)
+			}
+			scope := write
+			if c.Request().Method == http.MethodGet || c.Request().Method == http.MethodHead {
+				scope = read
+			}
+			if scope == "" || !ctx.ApiToken.HasScope(scope) {
+				e := errors.ErrForbidden
+				e.ErrMsg = "api token does not have the required scope"
+				if scope != "" {
+					e.ErrMsg += ": " + scope
+				}
+				return e
+			}
+			return next(c)
+		}
+	}
+}
+
 func (a *Api) ConfirmAuthorizedToken() echo.MiddlewareFunc {
 	return func(next echo.HandlerFunc) echo.HandlerFunc {
 		return func(c echo.Context) error {
@@ -104,6 +163,12 @@ func (a *Api) ConfirmAuthorizedToken() echo.MiddlewareFunc {
 				c.Logger().Error("no UserContext found")
 				return echo.ErrInternalServerError
 			}
+			if ctx.ApiToken != nil {
+				// api tokens cannot be confirmed with password
+				err := errors.ErrInvalid
+				err.ErrMsg = "authentication required"
+				return err
+			}
 
 			err := a.authService.ConfirmAuthToken(getContext(c), ctx.TokenKey)
 			if err != nil {
@@ -212,6 +277,8 @@ func validateToken(c echo.Context, tokenString string, privateKey string) (strin
 type LoginRequest struct {
 	Username string `valid:"username,required"`
 	Password string `valid:"required"`
+	// Code is totp or recovery code, if user has enabled two-factor authentication.
+	Code string `valid:"optional"`
 }
 
 type LoginResponse struct {
@@ -245,7 +312,10 @@ func (a *Api) LoginV2(c echo.Context) error {
 	ua := useragent.Parse(c.Request().Header.Get("User-Agent"))
 	userAgent := fmt.Sprintf("%s %s, %s %s", ua.OS, ua.OSVersion, ua.Name, ua.Version)
 
-	authToken, err := a.authService.Login(getContext(c), dto.Username, dto.Password, userAgent, c.RealIP())
+	authToken, err := a.authService.Login(getContext(c), dto.Username, dto.Password, dto.Code, userAgent, c.RealIP())
+	if services.IsTwoFactorRequired(err) {
+		return err
+	}
 	if err != nil {
 		log.Entry(ctx).WithField("user", dto.Username).WithField("remoteAddr", remoteAddr).Infof("Failed login attempt")
 		// request takes about the same time with invalid password & invalid user
@@ -278,6 +348,10 @@ func (a *Api) Logout(c echo.Context) error {
 		// should not happen, user is required to authenticate
 		return c.JSON(200, map[string]string{"status": "ok"})
 	}
+	if ctx.ApiToken != nil {
+		// api tokens are revoked separately
+		return c.JSON(200, map[string]string{"status": "ok"})
+	}
 	err := a.authService.RevokeToken(getContext(c), ctx.TokenKey)
 	if err != nil {
 		if errors.Is(err, errors.ErrRecordNotFound) {
@@ -347,6 +421,8 @@ func (a *Api) CreateResetPasswordToken(c echo.Context) error {
 
 type AuthConfirmationRequest struct {
 	Password string `json:"password" valid:"stringlength(8|150)"`
+	// Code is totp or recovery code, if user has enabled two-factor authentication.
+	Code string `json:"code" valid:"optional"`
 }
 
 func (a *Api) ConfirmAuthentication(c echo.Context) error {
@@ -361,7 +437,7 @@ func (a *Api) ConfirmAuthentication(c echo.Context) error {
 	}
 	user := c.(UserContext)
 	remoteAddr := getRemoteAddr(req)
-	err = a.authService.ConfirmAuthentication(getContext(c), user.User, dto.Password, remoteAddr, user.TokenKey)
+	err = a.authService.ConfirmAuthentication(getContext(c), user.User, dto.Password, dto.Code, remoteAddr, user.TokenKey)
 	if err != nil {
 		return err
 	}
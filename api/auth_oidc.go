@@ -0,0 +1,124 @@
+/*
+ * Virtualpaper is a service to manage users paper documents in virtual format.
+ * Copyright (C) 2024  Tero Vierimaa
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/labstack/echo/v4"
+	"github.com/mileusna/useragent"
+	"tryffel.net/go/virtualpaper/config"
+)
+
+type OidcLoginUrlResponse struct {
+	Url string `json:"url"`
+}
+
+type OidcCallbackRequest struct {
+	Code  string `json:"code" valid:"required"`
+	State string `json:"state" valid:"required"`
+	// TwoFactorCode is totp or recovery code, if user has enabled two-factor authentication.
+	// If it is required but missing, request fails and can be retried with the same state and the code.
+	TwoFactorCode string `json:"two_factor_code" valid:"optional"`
+}
+
+func (a *Api) getOidcLoginUrl(c echo.Context) error {
+	// swagger:route GET /api/v1/auth/oidc/login Authentication OidcLoginUrl
+	// Start single sign-on login. Client must redirect user to the returned url.
+	// After authentication, identity provider redirects user to the configured redirect url
+	// with parameters 'code' and 'state', which are posted to /api/v1/auth/oidc/callback.
+	//
+	// responses:
+	//   200: OidcLoginUrlResponse
+	url, err := a.authService.OidcLoginUrl(getContext(c))
+	if err != nil {
+		return err
+	}
+	return c.JSON(http.StatusOK, OidcLoginUrlResponse{Url: url})
+}
+
+func (a *Api) oidcCallback(c echo.Context) error {
+	// swagger:route POST /api/v1/auth/oidc/callback Authentication OidcCallback
+	// Complete single sign-on login.
+	//
+	// responses:
+	//   200: LoginResponse
+	dto := &OidcCallbackRequest{}
+	err := unMarshalBody(c.Request(), dto)
+	if err != nil {
+		return err
+	}
+
+	ua := useragent.Parse(c.Request().Header.Get("User-Agent"))
+	userAgent := fmt.Sprintf("%s %s, %s %s", ua.OS, ua.OSVersion, ua.Name, ua.Version)
+
+	authToken, err := a.authService.OidcLogin(getContext(c), dto.Code, dto.State, dto.TwoFactorCode, userAgent, c.RealIP())
+	if err != nil {
+		return err
+	}
+
+	token, err := newToken(strconv.Itoa(authToken.UserId), authToken.Key, config.C.Api.Key)
+	if err != nil {
+		c.Logger().Errorf("Create new token: %v", err)
+		return respInternalErrorV2(c, err)
+	}
+	return c.JSON(http.StatusOK, &LoginResponse{
+		UserId: authToken.UserId,
+		Token:  token,
+	})
+}
+
+func (a *Api) getOidcConfirmUrl(c echo.Context) error {
+	// swagger:route GET /api/v1/auth/oidc/confirm Authentication OidcConfirmUrl
+	// Start confirming the session with single sign-on, for users that have no password.
+	// Client must redirect user to the returned url. Identity provider requires user to authenticate again
+	// and redirects user to the configured redirect url, and parameters 'code' and 'state' are posted
+	// to /api/v1/auth/oidc/confirm.
+	//
+	// responses:
+	//   200: OidcLoginUrlResponse
+	user := c.(UserContext)
+	url, err := a.authService.OidcConfirmUrl(getContext(c), user.TokenKey)
+	if err != nil {
+		return err
+	}
+	return c.JSON(http.StatusOK, OidcLoginUrlResponse{Url: url})
+}
+
+func (a *Api) confirmOidcAuthentication(c echo.Context) error {
+	// swagger:route POST /api/v1/auth/oidc/confirm Authentication OidcConfirmAuthentication
+	// Confirm the session with single sign-on. Identity must be linked to the user.
+	//
+	// responses:
+	//   200:
+	dto := &OidcCallbackRequest{}
+	err := unMarshalBody(c.Request(), dto)
+	if err != nil {
+		return err
+	}
+	user := c.(UserContext)
+	err = a.authService.OidcConfirmAuthentication(getContext(c), user.User, dto.Code, dto.State, dto.TwoFactorCode,
+		getRemoteAddr(c.Request()), user.TokenKey)
+	if err != nil {
+		return err
+	}
+	return c.JSON(http.StatusOK, "")
+}
@@ -0,0 +1,67 @@
+/*
+ * Virtualpaper is a service to manage users paper documents in virtual format.
+ * Copyright (C) 2024  Tero Vierimaa
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+	"tryffel.net/go/virtualpaper/errors"
+	"tryffel.net/go/virtualpaper/models"
+)
+
+func TestApi_requireScope(t *testing.T) {
+	a := &Api{}
+	ok := func(c echo.Context) error {
+		return c.String(http.StatusOK, "ok")
+	}
+
+	tests := []struct {
+		name      string
+		method    string
+		token     *models.ApiToken
+		read      string
+		write     string
+		wantError bool
+	}{
+		{"session", http.MethodPost, nil, "", "", false},
+		{"read scope", http.MethodGet, &models.ApiToken{Scopes: "documents:read"}, "documents:read", "documents:write", false},
+		{"missing write scope", http.MethodPut, &models.ApiToken{Scopes: "documents:read"}, "documents:read", "documents:write", true},
+		{"write scope", http.MethodDelete, &models.ApiToken{Scopes: "documents:read,documents:write"}, "documents:read", "documents:write", false},
+		{"admin grants all", http.MethodPost, &models.ApiToken{Scopes: "admin"}, "documents:read", "metadata:write", false},
+		{"session only", http.MethodGet, &models.ApiToken{Scopes: "admin"}, "", "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, "/", nil)
+			c := echo.New().NewContext(req, httptest.NewRecorder())
+			ctx := UserContext{Context: Context{Context: c}, ApiToken: tt.token}
+
+			err := a.requireScope(tt.read, tt.write)(ok)(ctx)
+			if tt.wantError {
+				assert.True(t, errors.Is(err, errors.ErrForbidden))
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+}
@@ -19,6 +19,7 @@
 package api
 
 import (
+	"bytes"
 	"fmt"
 	"github.com/labstack/echo/v4"
 	"io"
@@ -201,10 +202,73 @@ func (a *Api) getDocumentPreview(c echo.Context) error {
 	return nil
 }
 
+func (a *Api) getDocumentPages(c echo.Context) error {
+	// swagger:route GET /api/v1/documents/{id}/pages Documents GetDocumentPages
+	// Get pages of the document with urls to page previews of each size.
+	// responses:
+	//   200: RespOk
+	//   404: RespNotFound
+
+	id := c.Param("id")
+	pageCount, err := a.documentService.GetPageCount(getContext(c), id)
+	if err != nil {
+		return err
+	}
+
+	pages := make([]aggregates.DocumentPage, pageCount)
+	for i := range pages {
+		pages[i].Page = i + 1
+		pages[i].Previews = make(map[string]string, len(models.PreviewSizes))
+		for _, size := range models.PreviewSizes {
+			pages[i].Previews[size.String()] = fmt.Sprintf("%s/api/v1/documents/%s/pages/%d/preview?size=%s",
+				config.C.Api.PublicUrl, id, i+1, size)
+		}
+	}
+	return resourceList(c, pages, pageCount)
+}
+
+func (a *Api) getDocumentPagePreview(c echo.Context) error {
+	// swagger:route GET /api/v1/documents/{id}/pages/{page}/preview Documents GetDocumentPagePreview
+	// Get preview of a single page as png image. Query parameter 'size' is one of 'small', 'medium' (default)
+	// or 'large'.
+	// responses:
+	//   400: RespBadRequest
+	//   404: RespNotFound
+
+	id := c.Param("id")
+	page, err := bindPathInt(c, "page")
+	if err != nil {
+		return err
+	}
+	size := models.PreviewSize(c.QueryParam("size"))
+	if size == "" {
+		size = models.PreviewSizeMedium
+	}
+
+	file, fileSize, err := a.documentService.GetPagePreview(getContext(c), id, page, size)
+	if err != nil {
+		return err
+	}
+
+	header := c.Response().Header()
+	header.Set("Content-Type", "image/png")
+	header.Set("Content-Length", strconv.Itoa(fileSize))
+	header.Set("Content-Disposition", fmt.Sprintf("attachment; filename=%s-%d-%s.png", id, page, size))
+	header.Set("Cache-Control", "max-age=600")
+
+	defer file.Close()
+	_, err = io.Copy(c.Response(), file)
+	if err != nil {
+		logrus.Errorf("send file over http: %v", err)
+	}
+	return nil
+}
+
 func (a *Api) uploadFile(c echo.Context) error {
 	// swagger:route POST /api/v1/documents Documents UploadFile
 	// Upload new document file. New document already contains id, name, filename and timestamps.
 	// Otherwise document is not processed yet and lacks other fields.
+	// Optional form field 'ocr_languages' (e.g. 'fin,eng') sets the languages used for OCR.
 	// Consumes:
 	// - multipart/form-data
 	//
@@ -220,14 +284,41 @@ func (a *Api) uploadFile(c echo.Context) error {
 		logCrudDocument(ctx.UserId, "upload", &opOk, "document: %s", documentId)
 	}()
 
+	file, err := readUploadedFile(c, ctx.UserId)
+	if err != nil {
+		return err
+	}
+	defer file.File.Close()
+	file.OcrLanguages = models.ParseOcrLanguages(c.Request().FormValue("ocr_languages"))
+
+	doc, err := a.documentService.UploadFile(c.Request().Context(), file)
+
+	if errors.Is(err, errors.ErrAlreadyExists) && doc != nil {
+		body := DocumentExistsResponse{
+			Error: "document exists",
+			Id:    doc.Id,
+			Name:  doc.Name,
+		}
+		return c.JSON(http.StatusBadRequest, body)
+	}
+
+	if err != nil {
+		return err
+	}
+	opOk = true
+	return c.JSON(http.StatusOK, responseFromDocument(doc))
+}
+
+// readUploadedFile reads file from multipart form. Caller must close the file.
+func readUploadedFile(c echo.Context, userId int) (*services.UploadedFile, error) {
 	req := c.Request()
 
-	err = req.ParseMultipartForm(1024 * 1024 * 500)
+	err := req.ParseMultipartForm(1024 * 1024 * 500)
 	if err != nil {
 		userError := errors.ErrInvalid
 		userError.ErrMsg = fmt.Sprintf("invalid form: %v", err)
 		userError.Err = err
-		return userError
+		return nil, userError
 	}
 	formKey := req.FormValue("name")
 	reader, header, err := req.FormFile(formKey)
@@ -235,7 +326,7 @@ func (a *Api) uploadFile(c echo.Context) error {
 		userError := errors.ErrInvalid
 		userError.ErrMsg = fmt.Sprintf("invalid file: %v", err)
 		userError.Err = err
-		return userError
+		return nil, userError
 	}
 
 	sanitizedFormKey := govalidator.SafeFileName(formKey)
@@ -246,52 +337,42 @@ func (a *Api) uploadFile(c echo.Context) error {
 		mimetype = "text/plain"
 	}
 
-	defer reader.Close()
 	buf := make([]byte, 500)
 	_, err = reader.Read(buf)
 	if err != nil {
-		return respInternalErrorV2(fmt.Errorf("peek file contents: %v", err))
+		reader.Close()
+		return nil, respInternalErrorV2(fmt.Errorf("peek file contents: %v", err))
 	}
 
 	_, err = reader.Seek(0, io.SeekStart)
 	if err != nil {
-		return respInternalErrorV2(fmt.Errorf("seek file to start: %v", err))
+		reader.Close()
+		return nil, respInternalErrorV2(fmt.Errorf("seek file to start: %v", err))
 	}
 
 	detectedFileType := http.DetectContentType(buf)
 	if strings.HasPrefix(detectedFileType, "text/plain") {
 		detectedFileType = "text/plain"
 	}
+	if process.IsMailMimetype(mimetype) {
+		// content sniffing does not recognize emails. Buffer is padded with zeros if file is smaller.
+		detectedFileType = process.DetectMailMimetype(bytes.TrimRight(buf, "\x00"))
+	}
 	if detectedFileType != mimetype {
 		logger.Context(c.Request().Context()).Warnf("uploaded document detected mimetype does not match reported, given %s, detected %s", header.Filename, detectedFileType)
 		userError := errors.ErrInvalid
 		userError.ErrMsg = fmt.Sprintf("illegal mimetype")
 		userError.Err = err
-		return userError
+		reader.Close()
+		return nil, userError
 	}
-
-	doc, err := a.documentService.UploadFile(c.Request().Context(), &services.UploadedFile{
-		UserId:   ctx.UserId,
+	return &services.UploadedFile{
+		UserId:   userId,
 		Filename: name,
 		Mimetype: mimetype,
 		Size:     header.Size,
 		File:     reader,
-	})
-
-	if errors.Is(err, errors.ErrAlreadyExists) && doc != nil {
-		body := DocumentExistsResponse{
-			Error: "document exists",
-			Id:    doc.Id,
-			Name:  doc.Name,
-		}
-		return c.JSON(http.StatusBadRequest, body)
-	}
-
-	if err != nil {
-		return err
-	}
-	opOk = true
-	return c.JSON(http.StatusOK, responseFromDocument(doc))
+	}, nil
 }
 
 func (a *Api) getEmptyDocument(resp http.ResponseWriter, req *http.Request) {
@@ -300,18 +381,28 @@ func (a *Api) getEmptyDocument(resp http.ResponseWriter, req *http.Request) {
 }
 
 func (a *Api) downloadDocument(c echo.Context) error {
-	// swagger:route GET /api/v1/documents/{id} Documents DownloadDocument
-	// Downloads original document
+	// swagger:route GET /api/v1/documents/{id}/download Documents DownloadDocument
+	// Downloads document file. Query parameter 'variant' is either 'original' (default) or 'searchable',
+	// which returns the generated pdf with OCR text layer.
 	// Responses:
 	//  200: Document
+	//  400: RespBadRequest
+	//  404: RespNotFound
 
 	ctx := c.(UserContext)
 	var err error
 	id := c.Param("id")
+	variant := c.QueryParam("variant")
+	if variant == "" {
+		variant = services.DocumentFileOriginal
+	}
 
 	opOk := false
-	defer logCrudDocument(ctx.UserId, "download", &opOk, "document: %s", id)
-	file, err := a.documentService.DocumentFile(id)
+	defer logCrudDocument(ctx.UserId, "download", &opOk, "document: %s, variant: %s", id, variant)
+	file, err := a.documentService.DocumentFile(id, variant)
+	if err != nil {
+		return err
+	}
 	defer file.File.Close()
 
 	resp := c.Response()
@@ -433,8 +524,9 @@ func (a *Api) searchDocuments(userId int, filter *search.DocumentFilter, c echo.
 }
 
 func (a *Api) requestDocumentProcessing(c echo.Context) error {
-	// swagger:route POST /api/v1/location Documents RequestProcessing
-	// Request document re-processing
+	// swagger:route POST /api/v1/documents/{id}/process Documents RequestProcessing
+	// Request document re-processing. Optional query parameter 'ocr_languages' (e.g. 'fin,eng') sets
+	// the languages used for OCR and extracts the content again. Empty value resets the languages.
 	// Responses:
 	//   200: RespOk
 	//   400: RespBadRequest
@@ -446,7 +538,35 @@ func (a *Api) requestDocumentProcessing(c echo.Context) error {
 	id := bindPathId(c)
 	opOk := false
 	defer logCrudDocument(ctx.UserId, "schedule processing", &opOk, "document: %s", id)
-	err := a.documentService.RequestProcessing(getContext(c), ctx.UserId, id)
+	var ocrLanguages models.OcrLanguages
+	if c.QueryParams().Has("ocr_languages") {
+		ocrLanguages = models.ParseOcrLanguages(c.QueryParam("ocr_languages"))
+		if ocrLanguages == nil {
+			ocrLanguages = models.OcrLanguages{}
+		}
+	}
+	err := a.documentService.RequestProcessing(getContext(c), ctx.UserId, id, ocrLanguages)
+	opOk = err == nil
+	if err != nil {
+		return err
+	}
+	return c.String(http.StatusOK, "")
+}
+
+func (a *Api) cancelDocumentProcessing(c echo.Context) error {
+	// swagger:route DELETE /api/v1/documents/{id}/process Documents CancelProcessing
+	// Cancel document processing. Pending steps are removed and ongoing processing, e.g. OCR, is stopped.
+	// Responses:
+	//   200: RespOk
+	//   401: RespForbidden
+	//   403: RespNotFound
+	//   500: RespInternalError
+
+	ctx := c.(UserContext)
+	id := bindPathId(c)
+	opOk := false
+	defer logCrudDocument(ctx.UserId, "cancel processing", &opOk, "document: %s", id)
+	err := a.documentService.CancelProcessing(getContext(c), id)
 	opOk = err == nil
 	if err != nil {
 		return err
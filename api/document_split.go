@@ -0,0 +1,126 @@
+/*
+ * Virtualpaper is a service to manage users paper documents in virtual format.
+ * Copyright (C) 2024  Tero Vierimaa
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package api
+
+import (
+	"net/http"
+
+	"github.com/labstack/echo/v4"
+	"tryffel.net/go/virtualpaper/models"
+	"tryffel.net/go/virtualpaper/models/aggregates"
+	"tryffel.net/go/virtualpaper/services"
+)
+
+type SplitDocumentRequest struct {
+	// Ranges are page ranges of new documents, e.g. '1-3' or '4'.
+	Ranges            []string `json:"ranges" valid:"-"`
+	SplitAtBlankPages bool     `json:"split_at_blank_pages" valid:"optional"`
+	SeparatorText     string   `json:"separator_text" valid:"optional,maxstringlength(200)"`
+	DeleteOriginal    bool     `json:"delete_original" valid:"optional"`
+}
+
+type MergeDocumentsRequest struct {
+	DocumentIds     []string `json:"documents" valid:"required,uuidarray~Invalid ids"`
+	Name            string   `json:"name" valid:"optional,maxstringlength(200)"`
+	DeleteOriginals bool     `json:"delete_originals" valid:"optional"`
+}
+
+func (a *Api) splitDocument(c echo.Context) error {
+	// swagger:route POST /api/v1/documents/{id}/split Documents SplitDocument
+	// Split pdf document to new documents by page ranges, or at blank pages or pages containing separator text.
+	// Metadata, properties and links are copied to the new documents.
+	// consumes:
+	//  - application/json
+	//
+	// Responses:
+	//   200: Document
+	//   400: RespBadRequest
+	//   401: RespForbidden
+	//   403: RespNotFound
+	//   500: RespInternalError
+
+	ctx := c.(UserContext)
+	id := bindPathId(c)
+	dto := &SplitDocumentRequest{}
+	err := unMarshalBody(c.Request(), dto)
+	if err != nil {
+		return err
+	}
+
+	req := &services.SplitDocumentRequest{
+		Ranges:            make([]models.PageRange, len(dto.Ranges)),
+		SplitAtBlankPages: dto.SplitAtBlankPages,
+		SeparatorText:     dto.SeparatorText,
+		DeleteOriginal:    dto.DeleteOriginal,
+	}
+	for i, v := range dto.Ranges {
+		req.Ranges[i], err = models.ParsePageRange(v)
+		if err != nil {
+			return err
+		}
+	}
+
+	opOk := false
+	defer logCrudDocument(ctx.UserId, "split", &opOk, "document: %s, ranges: %v, blank pages: %t", id, dto.Ranges, dto.SplitAtBlankPages)
+	docs, err := a.documentService.SplitDocument(getContext(c), ctx.UserId, id, req)
+	if err != nil {
+		return err
+	}
+	opOk = true
+	resp := make([]*aggregates.Document, len(docs))
+	for i, v := range docs {
+		resp[i] = responseFromDocument(v)
+	}
+	return c.JSON(http.StatusOK, resp)
+}
+
+func (a *Api) mergeDocuments(c echo.Context) error {
+	// swagger:route POST /api/v1/documents/merge Documents MergeDocuments
+	// Merge pdf documents to a new document. Pages are in the same order as documents.
+	// Metadata, properties and links are copied to the new document.
+	// consumes:
+	//  - application/json
+	//
+	// Responses:
+	//   200: Document
+	//   400: RespBadRequest
+	//   401: RespForbidden
+	//   403: RespNotFound
+	//   500: RespInternalError
+
+	ctx := c.(UserContext)
+	dto := &MergeDocumentsRequest{}
+	err := unMarshalBody(c.Request(), dto)
+	if err != nil {
+		return err
+	}
+
+	opOk := false
+	defer logCrudDocument(ctx.UserId, "merge", &opOk, "documents: %v", dto.DocumentIds)
+	doc, err := a.documentService.MergeDocuments(getContext(c), ctx.UserId, &services.MergeDocumentsRequest{
+		DocumentIds:     dto.DocumentIds,
+		Name:            dto.Name,
+		DeleteOriginals: dto.DeleteOriginals,
+	})
+	if err != nil {
+		return err
+	}
+	opOk = true
+	return c.JSON(http.StatusOK, responseFromDocument(doc))
+}
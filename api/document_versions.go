@@ -0,0 +1,137 @@
+/*
+ * Virtualpaper is a service to manage users paper documents in virtual format.
+ * Copyright (C) 2024  Tero Vierimaa
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package api
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+
+	"github.com/labstack/echo/v4"
+	"github.com/sirupsen/logrus"
+)
+
+func (a *Api) getDocumentVersions(c echo.Context) error {
+	// swagger:route GET /api/v1/documents/{id}/versions Documents GetDocumentVersions
+	// Get previous versions of the document file. Current file is not included.
+	// Responses:
+	//   200: RespOk
+	//   401: RespForbidden
+	//   404: RespNotFound
+
+	ctx := c.(UserContext)
+	id := c.Param("id")
+	opOk := false
+	defer logCrudDocument(ctx.UserId, "get versions", &opOk, "document: %s", id)
+	data, err := a.documentService.GetVersions(getContext(c), id)
+	if err != nil {
+		return err
+	}
+	opOk = true
+	return resourceList(c, data, len(*data))
+}
+
+func (a *Api) uploadDocumentVersion(c echo.Context) error {
+	// swagger:route POST /api/v1/documents/{id}/versions Documents UploadDocumentVersion
+	// Upload new file for existing document. Previous file is stored as a version and the document is
+	// processed again. Metadata, properties, sharing and links are kept.
+	// Consumes:
+	// - multipart/form-data
+	//
+	// Responses:
+	//  200: Document
+	//  400: RespBadRequest
+	ctx := c.(UserContext)
+	id := c.Param("id")
+	opOk := false
+	defer logCrudDocument(ctx.UserId, "upload version", &opOk, "document: %s", id)
+
+	file, err := readUploadedFile(c, ctx.UserId)
+	if err != nil {
+		return err
+	}
+	defer file.File.Close()
+
+	doc, err := a.documentService.UploadNewVersion(getContext(c), ctx.UserId, id, file)
+	if err != nil {
+		return err
+	}
+	opOk = true
+	return c.JSON(http.StatusOK, responseFromDocument(doc))
+}
+
+func (a *Api) downloadDocumentVersion(c echo.Context) error {
+	// swagger:route GET /api/v1/documents/{id}/versions/{version}/download Documents DownloadDocumentVersion
+	// Download previous version of the document file.
+	// Responses:
+	//  200: Document
+	//  404: RespNotFound
+
+	ctx := c.(UserContext)
+	id := c.Param("id")
+	version, err := bindPathInt(c, "version")
+	if err != nil {
+		return err
+	}
+
+	opOk := false
+	defer logCrudDocument(ctx.UserId, "download version", &opOk, "document: %s, version: %d", id, version)
+	file, err := a.documentService.DocumentVersionFile(getContext(c), id, version)
+	if err != nil {
+		return err
+	}
+	defer file.File.Close()
+
+	resp := c.Response()
+	resp.Header().Set("Content-Type", file.Mimetype)
+	resp.Header().Set("Content-Length", strconv.Itoa(int(file.Size)))
+	resp.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%s-v%d", id, version))
+
+	_, err = io.Copy(resp, file.File)
+	if err != nil {
+		logrus.Errorf("send file over http: %v", err)
+	}
+	opOk = true
+	return nil
+}
+
+func (a *Api) restoreDocumentVersion(c echo.Context) error {
+	// swagger:route POST /api/v1/documents/{id}/versions/{version}/restore Documents RestoreDocumentVersion
+	// Restore previous version of the document file. Current file is stored as a new version.
+	// Responses:
+	//  200: Document
+	//  404: RespNotFound
+
+	ctx := c.(UserContext)
+	id := c.Param("id")
+	version, err := bindPathInt(c, "version")
+	if err != nil {
+		return err
+	}
+
+	opOk := false
+	defer logCrudDocument(ctx.UserId, "restore version", &opOk, "document: %s, version: %d", id, version)
+	doc, err := a.documentService.RestoreVersion(getContext(c), ctx.UserId, id, version)
+	if err != nil {
+		return err
+	}
+	opOk = true
+	return c.JSON(http.StatusOK, responseFromDocument(doc))
+}
@@ -53,6 +53,30 @@ func logCrudRule(userId int, action string, success *bool, fmt string, args ...i
 	logCrudOp("processing-rule", action, userId, success).Infof(fmt, args...)
 }
 
+func logCrudWebhook(userId int, action string, success *bool, fmt string, args ...interface{}) {
+	logCrudOp("webhook", action, userId, success).Infof(fmt, args...)
+}
+
+func logCrudApiToken(userId int, action string, success *bool, fmt string, args ...interface{}) {
+	logCrudOp("api-token", action, userId, success).Infof(fmt, args...)
+}
+
+func logCrudSession(userId int, action string, success *bool, fmt string, args ...interface{}) {
+	logCrudOp("session", action, userId, success).Infof(fmt, args...)
+}
+
+func logCrudTwoFactor(userId int, action string, success *bool, fmt string, args ...interface{}) {
+	logCrudOp("two-factor", action, userId, success).Infof(fmt, args...)
+}
+
+func logCrudShareLink(userId int, action string, success *bool, fmt string, args ...interface{}) {
+	logCrudOp("share-link", action, userId, success).Infof(fmt, args...)
+}
+
+func logCrudArchive(userId int, action string, success *bool, fmt string, args ...interface{}) {
+	logCrudOp("archive", action, userId, success).Infof(fmt, args...)
+}
+
 func logCrudAdminUsers(userId int, action string, success *bool, fmt string, args ...interface{}) {
 	logCrudOp("admin-users", action, userId, success).Infof(fmt, args...)
 }
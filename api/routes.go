@@ -19,6 +19,7 @@
 package api
 
 import (
+	"github.com/labstack/echo/v4"
 	"golang.org/x/time/rate"
 	"time"
 	"tryffel.net/go/virtualpaper/config"
@@ -29,7 +30,8 @@ func (api *Api) addRoutesV2() {
 	api.publicRouter = api.echo.Group("")
 	api.apiRouter = api.publicRouter.Group("/api")
 	api.privateRouter = api.apiRouter.Group("/v1", api.authorizeUserV2())
-	api.adminRouter = api.privateRouter.Group("/admin", api.AuthorizeAdminV2())
+	api.adminRouter = api.privateRouter.Group("/admin", api.AuthorizeAdminV2(),
+		api.requireScope(models.ApiScopeAdmin, models.ApiScopeAdmin))
 
 	api.publicRouter.StaticFS("/", static())
 	api.publicRouter.GET("/api/v1/swagger.json", serverSwaggerDoc)
@@ -43,73 +45,139 @@ func (api *Api) addRoutesV2() {
 		authGroup = api.apiRouter.Group("/v1/auth", authRateLimiter)
 	}
 
+	// public share links do not require authentication. Unlocking password protected links is rate limited.
+	shareGroup := api.apiRouter.Group("/v1/share/:token")
+	shareUnlock := []echo.MiddlewareFunc{}
+	if !config.C.Api.AuthRatelimitDisabled {
+		shareUnlock = append(shareUnlock, newRateLimiter(rate.Every(time.Second*60), 15, time.Minute*15))
+	}
+	shareGroup.GET("", api.getSharedDocument)
+	shareGroup.POST("/unlock", api.unlockSharedDocument, shareUnlock...)
+	shareGroup.GET("/download", api.downloadSharedDocument)
+	shareGroup.GET("/preview", api.getSharedDocumentPreview)
+	shareGroup.GET("/pages/:page/preview", api.getSharedDocumentPagePreview)
+	shareGroup.GET("/content", api.getSharedDocumentContent)
+
 	mDocOwner := mDocumentOwner(api.documentService)
 	mDocCanRead := mDocumentReadAccess(api.documentService)
 	mDocCanWrite := mDocumentWriteAccess(api.documentService)
 	mMetadataOwner := mMetadataKeyOwner(api.metadataService)
 	mRule := mRuleOwner(api.ruleService)
 	mPropertyOwner := mPropertyOwner(api.propertyService)
+	mWebhook := mWebhookOwner(api.webhookService)
+
+	// requests authenticated with api tokens are limited by the token scopes.
+	mDocumentsRead := api.requireScope(models.ApiScopeDocumentsRead, "")
+	mSessionOnly := api.requireScope("", "")
+	documentsRouter := api.privateRouter.Group("/documents",
+		api.requireScope(models.ApiScopeDocumentsRead, models.ApiScopeDocumentsWrite))
+	archiveRouter := api.privateRouter.Group("/archive",
+		api.requireScope(models.ApiScopeDocumentsRead, models.ApiScopeDocumentsWrite))
+	metadataScope := api.requireScope(models.ApiScopeDocumentsRead, models.ApiScopeMetadataWrite)
+	metadataRouter := api.privateRouter.Group("/metadata", metadataScope)
+	propertiesRouter := api.privateRouter.Group("/properties", metadataScope)
+	processingRouter := api.privateRouter.Group("/processing", metadataScope)
+	webhooksRouter := api.privateRouter.Group("/webhooks", metadataScope)
 
 	authGroup.POST("/login", api.LoginV2)
+	authGroup.GET("/oidc/login", api.getOidcLoginUrl)
+	authGroup.POST("/oidc/callback", api.oidcCallback)
 	api.privateRouter.POST("/auth/logout", api.Logout)
-	api.privateRouter.POST("/auth/confirm", api.ConfirmAuthentication)
+	api.privateRouter.POST("/auth/confirm", api.ConfirmAuthentication, mSessionOnly)
+	api.privateRouter.GET("/auth/oidc/confirm", api.getOidcConfirmUrl, mSessionOnly)
+	api.privateRouter.POST("/auth/oidc/confirm", api.confirmOidcAuthentication, mSessionOnly)
+	api.privateRouter.GET("/auth/tokens", api.getApiTokens, mSessionOnly)
+	api.privateRouter.POST("/auth/tokens", api.addApiToken, mSessionOnly)
+	api.privateRouter.DELETE("/auth/tokens/:id", api.deleteApiToken, mSessionOnly)
+	api.privateRouter.GET("/auth/sessions", api.getSessions, mSessionOnly)
+	api.privateRouter.DELETE("/auth/sessions", api.deleteOtherSessions, mSessionOnly)
+	api.privateRouter.DELETE("/auth/sessions/:id", api.deleteSession, mSessionOnly)
+	api.privateRouter.GET("/auth/totp", api.getTwoFactorStatus, mSessionOnly)
+	api.privateRouter.POST("/auth/totp", api.startTotpEnrollment, mSessionOnly, api.ConfirmAuthorizedToken())
+	api.privateRouter.POST("/auth/totp/enable", api.enableTotp, mSessionOnly, api.ConfirmAuthorizedToken())
+	api.privateRouter.DELETE("/auth/totp", api.disableTotp, mSessionOnly, api.ConfirmAuthorizedToken())
+	api.privateRouter.POST("/auth/totp/recovery-codes", api.regenerateRecoveryCodes, mSessionOnly, api.ConfirmAuthorizedToken())
 	authGroup.POST("/reset-password", api.ResetPassword)
 	authGroup.POST("/forgot-password", api.CreateResetPasswordToken)
 
-	api.privateRouter.GET("/filetypes", api.getSupportedFileTypes)
-	api.privateRouter.GET("/admin/systeminfo", api.getSystemInfo)
-
-	api.privateRouter.GET("/documents/stats", api.getUserDocumentStatistics)
-	api.privateRouter.POST("/documents", api.uploadFile)
-	api.privateRouter.GET("/documents", api.getDocuments, mPagination(), mSort(&models.Document{})).Name = "get-documents"
-	api.privateRouter.GET("/documents/deleted", api.getDeletedDocuments, mPagination(), mSort(&models.Document{})).Name = "get-deleted-documents"
-	api.privateRouter.GET("/documents/:id", api.getDocument, mDocCanRead("id")).Name = "get-document"
-	api.privateRouter.PUT("/documents/:id", api.updateDocument, mDocCanWrite("id"))
-	api.privateRouter.PUT("/documents/:id/sharing", api.updateDocumentSharing, mDocOwner("id"))
-	api.privateRouter.DELETE("/documents/:id", api.deleteDocument, mDocOwner("id"))
-	api.privateRouter.POST("/documents/deleted/:id/restore", api.restoreDeletedDocument, mDocOwner("id"))
-	api.privateRouter.DELETE("/documents/deleted/:id", api.flushDeletedDocument, mDocOwner("id"))
-	api.privateRouter.GET("/documents/:id/show", api.getDocument, mDocOwner("id")).Name = "get-document"
-	api.privateRouter.GET("/documents/:id/preview", api.getDocumentPreview, mDocCanRead("id"))
-	api.privateRouter.GET("/documents/:id/content", api.getDocumentContent, mDocCanRead("id"))
-	api.privateRouter.GET("/documents/:id/download", api.downloadDocument, mDocCanRead("id"))
-	api.privateRouter.GET("/documents/:id/linked-documents", api.getLinkedDocuments, mDocOwner("id"))
-	api.privateRouter.POST("/documents/:id/process", api.requestDocumentProcessing, mDocOwner("id"))
-	api.privateRouter.PUT("/documents/:id/linked-documents", api.updateLinkedDocuments, mDocOwner("id"))
-	api.privateRouter.GET("/documents/:id/history", api.getDocumentHistory, mDocCanRead("id"))
-	api.privateRouter.GET("/documents/:id/jobs", api.getDocumentLogs, mDocCanRead("id"))
-
-	api.privateRouter.POST("/documents/bulkEdit", api.bulkEditDocuments)
-
-	api.privateRouter.POST("/documents/search/suggest", api.searchSuggestions).Name = "search-suggest"
-
-	api.privateRouter.GET("/metadata/search", api.searchMetadata, mPagination())
-	api.privateRouter.GET("/metadata/keys", api.getMetadataKeys, mPagination(), mSort(&models.MetadataKeyAnnotated{}))
-	api.privateRouter.POST("/metadata/keys", api.addMetadataKey)
-	api.privateRouter.PUT("/metadata/keys/:id", api.updateMetadataKey, mMetadataOwner("id"))
-	api.privateRouter.GET("/metadata/keys/:id", api.getMetadataKey, mMetadataOwner("id"))
-	api.privateRouter.GET("/metadata/keys/:id/values", api.getMetadataKeyValues, mMetadataOwner("id"), mPagination(), mSort(&models.MetadataValue{}))
-	api.privateRouter.POST("/metadata/keys/:id/values", api.addMetadataValue, mMetadataOwner("id"))
-	api.privateRouter.DELETE("/metadata/keys/:id", api.deleteMetadataKey, mMetadataOwner("id"))
-	api.privateRouter.PUT("/metadata/keys/:keyId/values/:valueId", api.updateMetadataValue, mMetadataOwner("keyId"))
-	api.privateRouter.DELETE("/metadata/keys/:keyId/values/:valueId", api.deleteMetadataValue, mMetadataOwner("keyId"))
-
-	api.privateRouter.GET("/properties", api.GetProperties, mPagination(), mSort(&models.Property{}))
-	api.privateRouter.POST("/properties", api.AddProperty)
-	api.privateRouter.GET("/properties/:id", api.GetProperty, mPropertyOwner("id"), mPagination(), mSort(&models.MetadataKeyAnnotated{}))
-	api.privateRouter.PUT("/properties/:id", api.UpdateProperty, mPropertyOwner("id"))
-
-	api.privateRouter.GET("/processing/rules", api.getUserRules, mPagination(), mSort(&models.Rule{}))
-	api.privateRouter.PUT("/processing/rules/reorder", api.reorderRules)
-	api.privateRouter.POST("/processing/rules", api.addUserRule)
-	api.privateRouter.GET("/processing/rules/:id", api.getUserRule, mRule("id"))
-	api.privateRouter.PUT("/processing/rules/:id", api.updateUserRule, mRule("id"))
-	api.privateRouter.DELETE("/processing/rules/:id", api.deleteUserRule, mRule("id"))
-	api.privateRouter.PUT("/processing/rules/:id/test", api.testRule, mRule("id"))
-
-	api.privateRouter.GET("/preferences/user", api.getUserPreferences).Name = "get-user-preferences"
-	api.privateRouter.PUT("/preferences/user", api.updateUserPreferences)
-	api.privateRouter.GET("/users", api.GetUsers)
+	api.privateRouter.GET("/filetypes", api.getSupportedFileTypes, mDocumentsRead)
+	api.privateRouter.GET("/admin/systeminfo", api.getSystemInfo, mDocumentsRead)
+
+	documentsRouter.GET("/stats", api.getUserDocumentStatistics)
+	documentsRouter.POST("", api.uploadFile)
+	documentsRouter.GET("", api.getDocuments, mPagination(), mSort(&models.Document{})).Name = "get-documents"
+	documentsRouter.GET("/deleted", api.getDeletedDocuments, mPagination(), mSort(&models.Document{})).Name = "get-deleted-documents"
+	documentsRouter.GET("/:id", api.getDocument, mDocCanRead("id")).Name = "get-document"
+	documentsRouter.PUT("/:id", api.updateDocument, mDocCanWrite("id"))
+	documentsRouter.PUT("/:id/sharing", api.updateDocumentSharing, mDocOwner("id"))
+	documentsRouter.DELETE("/:id", api.deleteDocument, mDocOwner("id"))
+	documentsRouter.POST("/deleted/:id/restore", api.restoreDeletedDocument, mDocOwner("id"))
+	documentsRouter.DELETE("/deleted/:id", api.flushDeletedDocument, mDocOwner("id"))
+	documentsRouter.GET("/:id/show", api.getDocument, mDocOwner("id")).Name = "get-document"
+	documentsRouter.GET("/:id/preview", api.getDocumentPreview, mDocCanRead("id"))
+	documentsRouter.GET("/:id/pages", api.getDocumentPages, mDocCanRead("id"))
+	documentsRouter.GET("/:id/pages/:page/preview", api.getDocumentPagePreview, mDocCanRead("id"))
+	documentsRouter.GET("/:id/content", api.getDocumentContent, mDocCanRead("id"))
+	documentsRouter.GET("/:id/download", api.downloadDocument, mDocCanRead("id"))
+	documentsRouter.GET("/:id/linked-documents", api.getLinkedDocuments, mDocOwner("id"))
+	documentsRouter.POST("/:id/process", api.requestDocumentProcessing, mDocOwner("id"))
+	documentsRouter.DELETE("/:id/process", api.cancelDocumentProcessing, mDocOwner("id"))
+	documentsRouter.POST("/:id/split", api.splitDocument, mDocOwner("id"))
+	documentsRouter.POST("/merge", api.mergeDocuments)
+	documentsRouter.PUT("/:id/linked-documents", api.updateLinkedDocuments, mDocOwner("id"))
+	documentsRouter.GET("/:id/history", api.getDocumentHistory, mDocCanRead("id"))
+	documentsRouter.GET("/:id/jobs", api.getDocumentLogs, mDocCanRead("id"))
+	documentsRouter.GET("/:id/versions", api.getDocumentVersions, mDocCanRead("id"))
+	documentsRouter.POST("/:id/versions", api.uploadDocumentVersion, mDocCanWrite("id"))
+	documentsRouter.GET("/:id/versions/:version/download", api.downloadDocumentVersion, mDocCanRead("id"))
+	documentsRouter.POST("/:id/versions/:version/restore", api.restoreDocumentVersion, mDocCanWrite("id"))
+
+	documentsRouter.GET("/:id/share-links", api.getShareLinks, mDocOwner("id"))
+	documentsRouter.POST("/:id/share-links", api.addShareLink, mDocOwner("id"))
+	documentsRouter.DELETE("/:id/share-links/:linkId", api.deleteShareLink, mDocOwner("id"))
+	documentsRouter.GET("/:id/share-links/:linkId/access", api.getShareLinkAccess, mDocOwner("id"))
+
+	documentsRouter.POST("/bulkEdit", api.bulkEditDocuments)
+
+	documentsRouter.POST("/search/suggest", api.searchSuggestions).Name = "search-suggest"
+
+	metadataRouter.GET("/search", api.searchMetadata, mPagination())
+	metadataRouter.GET("/keys", api.getMetadataKeys, mPagination(), mSort(&models.MetadataKeyAnnotated{}))
+	metadataRouter.POST("/keys", api.addMetadataKey)
+	metadataRouter.PUT("/keys/:id", api.updateMetadataKey, mMetadataOwner("id"))
+	metadataRouter.GET("/keys/:id", api.getMetadataKey, mMetadataOwner("id"))
+	metadataRouter.GET("/keys/:id/values", api.getMetadataKeyValues, mMetadataOwner("id"), mPagination(), mSort(&models.MetadataValue{}))
+	metadataRouter.POST("/keys/:id/values", api.addMetadataValue, mMetadataOwner("id"))
+	metadataRouter.DELETE("/keys/:id", api.deleteMetadataKey, mMetadataOwner("id"))
+	metadataRouter.PUT("/keys/:keyId/values/:valueId", api.updateMetadataValue, mMetadataOwner("keyId"))
+	metadataRouter.DELETE("/keys/:keyId/values/:valueId", api.deleteMetadataValue, mMetadataOwner("keyId"))
+
+	propertiesRouter.GET("", api.GetProperties, mPagination(), mSort(&models.Property{}))
+	propertiesRouter.POST("", api.AddProperty)
+	propertiesRouter.GET("/:id", api.GetProperty, mPropertyOwner("id"), mPagination(), mSort(&models.MetadataKeyAnnotated{}))
+	propertiesRouter.PUT("/:id", api.UpdateProperty, mPropertyOwner("id"))
+
+	processingRouter.GET("/rules", api.getUserRules, mPagination(), mSort(&models.Rule{}))
+	processingRouter.PUT("/rules/reorder", api.reorderRules)
+	processingRouter.POST("/rules", api.addUserRule)
+	processingRouter.GET("/rules/:id", api.getUserRule, mRule("id"))
+	processingRouter.PUT("/rules/:id", api.updateUserRule, mRule("id"))
+	processingRouter.DELETE("/rules/:id", api.deleteUserRule, mRule("id"))
+	processingRouter.PUT("/rules/:id/test", api.testRule, mRule("id"))
+
+	api.privateRouter.GET("/preferences/user", api.getUserPreferences, mDocumentsRead).Name = "get-user-preferences"
+	api.privateRouter.PUT("/preferences/user", api.updateUserPreferences, mSessionOnly)
+	api.privateRouter.GET("/users", api.GetUsers, mDocumentsRead)
+
+	webhooksRouter.GET("", api.getWebhooks)
+	webhooksRouter.POST("", api.addWebhook)
+	webhooksRouter.GET("/:id", api.getWebhook, mWebhook("id"))
+	webhooksRouter.PUT("/:id", api.updateWebhook, mWebhook("id"))
+	webhooksRouter.DELETE("/:id", api.deleteWebhook, mWebhook("id"))
+	webhooksRouter.GET("/:id/deliveries", api.getWebhookDeliveries, mWebhook("id"), mPagination())
+
+	archiveRouter.GET("/export", api.exportArchive)
+	archiveRouter.POST("/import", api.importArchive)
 
 	api.adminRouter.GET("/documents/process", api.getDocumentProcessQueue)
 	api.adminRouter.POST("/documents/process", api.forceDocumentProcessing)
@@ -119,4 +187,6 @@ func (api *Api) addRoutesV2() {
 	api.adminRouter.POST("/users", api.adminAddUser, api.ConfirmAuthorizedToken())
 	api.adminRouter.GET("/users/:id", api.adminGetUser)
 	api.adminRouter.PUT("/users/:id", api.adminUpdateUser, api.ConfirmAuthorizedToken())
+	api.adminRouter.POST("/users/:id/logout", api.adminLogoutUser, api.ConfirmAuthorizedToken())
+	api.adminRouter.DELETE("/users/:id/totp", api.adminResetTwoFactor, api.ConfirmAuthorizedToken())
 }
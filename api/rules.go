@@ -41,11 +41,23 @@ type Rule struct {
 
 	Conditions []RuleCondition `json:"conditions" valid:"-"`
 	Actions    []RuleAction    `json:"actions" valid:"-"`
+	// ConditionGroup is the condition tree. If not set, mode and conditions are used instead.
+	ConditionGroup *RuleConditionGroup `json:"condition_group,omitempty" valid:"-"`
+}
+
+// RuleConditionGroup is a group of conditions and nested groups that matches all or any of them.
+type RuleConditionGroup struct {
+	Id         int                  `json:"id" valid:"-"`
+	Mode       string               `json:"mode" valid:"-"`
+	Inverted   bool                 `json:"inverted" valid:"-"`
+	Conditions []RuleCondition      `json:"conditions" valid:"-"`
+	Groups     []RuleConditionGroup `json:"groups" valid:"-"`
 }
 
 type RuleCondition struct {
 	Id              int             `json:"id" valid:"-"`
 	RuleId          int             `json:"rule_id" valid:"-"`
+	GroupId         int             `json:"group_id" valid:"-"`
 	Enabled         bool            `json:"enabled" valid:"-"`
 	CaseInsensitive bool            `json:"case_insensitive" valid:"-"`
 	Inverted        bool            `json:"inverted_match" valid:"-"`
@@ -54,6 +66,7 @@ type RuleCondition struct {
 	Value           string          `json:"value" valid:"-"`
 	DateFmt         string          `json:"date_fmt" valid:"-"`
 	Metadata        models.Metadata `json:"metadata" valid:"-"`
+	Property        RuleProperty    `json:"property" valid:"-"`
 }
 
 type RuleAction struct {
@@ -64,6 +77,21 @@ type RuleAction struct {
 	Action      string          `json:"action" valid:"-"`
 	Value       string          `json:"value" valid:"-"`
 	Metadata    models.Metadata `json:"metadata" valid:"-"`
+	Share       RuleActionShare `json:"share" valid:"-"`
+	Property    RuleProperty    `json:"property" valid:"-"`
+}
+
+// RuleActionShare is the user to share document with.
+type RuleActionShare struct {
+	UserId      int                `json:"user_id" valid:"-"`
+	UserName    string             `json:"user_name" valid:"-"`
+	Permissions models.Permissions `json:"permissions" valid:"-"`
+}
+
+// RuleProperty is the property to match with or to add to document.
+type RuleProperty struct {
+	PropertyId   int    `json:"property_id" valid:"-"`
+	PropertyName string `json:"property_name" valid:"-"`
 }
 
 type RuleTest struct {
@@ -78,6 +106,9 @@ func (r *RuleAction) ToAction() *models.RuleAction {
 		Value:         r.Value,
 		MetadataKey:   models.IntId(r.Metadata.KeyId),
 		MetadataValue: models.IntId(r.Metadata.ValueId),
+		ShareUserId:   models.IntId(r.Share.UserId),
+		Permissions:   r.Share.Permissions,
+		PropertyId:    models.IntId(r.Property.PropertyId),
 	}
 }
 
@@ -95,6 +126,15 @@ func actionToResp(action *models.RuleAction) RuleAction {
 			ValueId: int(action.MetadataValue),
 			Value:   action.MetadataValueName.String(),
 		},
+		Share: RuleActionShare{
+			UserId:      int(action.ShareUserId),
+			UserName:    action.ShareUserName.String(),
+			Permissions: action.Permissions,
+		},
+		Property: RuleProperty{
+			PropertyId:   int(action.PropertyId),
+			PropertyName: action.PropertyName.String(),
+		},
 	}
 }
 
@@ -109,6 +149,7 @@ func (r *RuleCondition) ToCondition() *models.RuleCondition {
 		DateFmt:         r.DateFmt,
 		MetadataKey:     models.IntId(r.Metadata.KeyId),
 		MetadataValue:   models.IntId(r.Metadata.ValueId),
+		PropertyId:      models.IntId(r.Property.PropertyId),
 	}
 }
 
@@ -116,6 +157,7 @@ func conditionToResp(cond *models.RuleCondition) RuleCondition {
 	return RuleCondition{
 		Id:              cond.Id,
 		RuleId:          cond.RuleId,
+		GroupId:         int(cond.GroupId),
 		Enabled:         cond.Enabled,
 		CaseInsensitive: cond.CaseInsensitive,
 		Inverted:        cond.Inverted,
@@ -130,9 +172,54 @@ func conditionToResp(cond *models.RuleCondition) RuleCondition {
 			ValueId: int(cond.MetadataValue),
 			Value:   cond.MetadataValueName.String(),
 		},
+		Property: RuleProperty{
+			PropertyId:   int(cond.PropertyId),
+			PropertyName: cond.PropertyName.String(),
+		},
 	}
 }
 
+func (g *RuleConditionGroup) ToConditionGroup() (*models.RuleConditionGroup, error) {
+	mode := models.RuleMatchAll
+	err := mode.FromString(g.Mode)
+	if err != nil {
+		return nil, err
+	}
+	group := &models.RuleConditionGroup{
+		Mode:       mode,
+		Inverted:   g.Inverted,
+		Conditions: make([]*models.RuleCondition, len(g.Conditions)),
+		Groups:     make([]*models.RuleConditionGroup, len(g.Groups)),
+	}
+	for i, v := range g.Conditions {
+		group.Conditions[i] = v.ToCondition()
+	}
+	for i, v := range g.Groups {
+		group.Groups[i], err = v.ToConditionGroup()
+		if err != nil {
+			return nil, err
+		}
+	}
+	return group, nil
+}
+
+func conditionGroupToResp(group *models.RuleConditionGroup) RuleConditionGroup {
+	resp := RuleConditionGroup{
+		Id:         group.Id,
+		Mode:       group.Mode.String(),
+		Inverted:   group.Inverted,
+		Conditions: make([]RuleCondition, len(group.Conditions)),
+		Groups:     make([]RuleConditionGroup, len(group.Groups)),
+	}
+	for i, v := range group.Conditions {
+		resp.Conditions[i] = conditionToResp(v)
+	}
+	for i, v := range group.Groups {
+		resp.Groups[i] = conditionGroupToResp(v)
+	}
+	return resp
+}
+
 func ruleToResp(rule *models.Rule) *Rule {
 	resp := &Rule{
 		Id:          rule.Id,
@@ -155,6 +242,8 @@ func ruleToResp(rule *models.Rule) *Rule {
 	for i, v := range rule.Actions {
 		resp.Actions[i] = actionToResp(v)
 	}
+	group := conditionGroupToResp(rule.ConditionTree())
+	resp.ConditionGroup = &group
 	return resp
 }
 
@@ -183,6 +272,14 @@ func (r *Rule) ToRule() (*models.Rule, error) {
 	for i, v := range r.Actions {
 		rule.Actions[i] = v.ToAction()
 	}
+
+	if r.ConditionGroup != nil {
+		group, err := r.ConditionGroup.ToConditionGroup()
+		if err != nil {
+			return nil, err
+		}
+		rule.SetConditionTree(group)
+	}
 	return rule, nil
 }
 
@@ -0,0 +1,113 @@
+/*
+ * Virtualpaper is a service to manage users paper documents in virtual format.
+ * Copyright (C) 2024  Tero Vierimaa
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package api
+
+import (
+	"net/http"
+
+	"github.com/labstack/echo/v4"
+	"tryffel.net/go/virtualpaper/models/aggregates"
+)
+
+type RevokeSessionsResponse struct {
+	Revoked int `json:"revoked"`
+	// RevokedApiTokens is the number of api tokens revoked when admin logs out the user.
+	RevokedApiTokens int `json:"revoked_api_tokens,omitempty"`
+}
+
+func (a *Api) getSessions(c echo.Context) error {
+	// swagger:route GET /api/v1/auth/sessions Authentication GetSessions
+	// Get active login sessions of the user
+	//
+	// responses:
+	//   200: Session
+	ctx := c.(UserContext)
+	opOk := false
+	defer logCrudSession(ctx.UserId, "get list", &opOk, "")
+
+	tokens, err := a.authService.GetSessions(getContext(c), ctx.UserId)
+	if err != nil {
+		return err
+	}
+	data := make([]aggregates.Session, len(*tokens))
+	for i, v := range *tokens {
+		data[i] = *aggregates.SessionToAggregate(&v, ctx.TokenKey)
+	}
+	opOk = true
+	return resourceList(c, data, len(data))
+}
+
+func (a *Api) deleteSession(c echo.Context) error {
+	// swagger:route DELETE /api/v1/auth/sessions/{id} Authentication DeleteSession
+	// Revoke login session
+	//
+	// responses:
+	//   200:
+	ctx := c.(UserContext)
+	id, err := bindPathIdInt(c)
+	if err != nil {
+		return err
+	}
+	opOk := false
+	defer logCrudSession(ctx.UserId, "delete", &opOk, "session: %d", id)
+	err = a.authService.RevokeSession(getContext(c), ctx.UserId, id)
+	if err != nil {
+		return err
+	}
+	opOk = true
+	return c.String(http.StatusOK, "")
+}
+
+func (a *Api) deleteOtherSessions(c echo.Context) error {
+	// swagger:route DELETE /api/v1/auth/sessions Authentication DeleteOtherSessions
+	// Revoke all login sessions except the current one
+	//
+	// responses:
+	//   200: RevokeSessionsResponse
+	ctx := c.(UserContext)
+	opOk := false
+	defer logCrudSession(ctx.UserId, "delete", &opOk, "other sessions")
+	count, err := a.authService.RevokeOtherSessions(getContext(c), ctx.UserId, ctx.TokenKey)
+	if err != nil {
+		return err
+	}
+	opOk = true
+	return c.JSON(http.StatusOK, RevokeSessionsResponse{Revoked: count})
+}
+
+func (a *Api) adminLogoutUser(c echo.Context) error {
+	// swagger:route POST /api/v1/admin/users/{id}/logout Admin AdminLogoutUser
+	// Revoke all login sessions and api tokens of the user
+	//
+	// responses:
+	//   200: RevokeSessionsResponse
+	ctx := c.(UserContext)
+	userId, err := bindPathIdInt(c)
+	if err != nil {
+		return err
+	}
+	opOk := false
+	defer logCrudAdminUsers(ctx.UserId, "logout", &opOk, "log out user %d", userId)
+	sessions, apiTokens, err := a.adminService.ForceLogoutUser(getContext(c), ctx.UserId, userId)
+	if err != nil {
+		return err
+	}
+	opOk = true
+	return c.JSON(http.StatusOK, RevokeSessionsResponse{Revoked: sessions, RevokedApiTokens: apiTokens})
+}
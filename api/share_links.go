@@ -0,0 +1,421 @@
+/*
+ * Virtualpaper is a service to manage users paper documents in virtual format.
+ * Copyright (C) 2024  Tero Vierimaa
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package api
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+	"github.com/labstack/echo/v4"
+	"github.com/sirupsen/logrus"
+	"tryffel.net/go/virtualpaper/config"
+	"tryffel.net/go/virtualpaper/errors"
+	"tryffel.net/go/virtualpaper/models"
+	"tryffel.net/go/virtualpaper/models/aggregates"
+	"tryffel.net/go/virtualpaper/services"
+)
+
+// shareAccessExpire is the lifetime of the access token that is issued after unlocking
+// a password protected share link.
+const shareAccessExpire = time.Hour
+
+type ShareLinkRequest struct {
+	Name string `json:"name" valid:"maxstringlength(200),optional"`
+	// ExpiresAt is unix timestamp in milliseconds.
+	ExpiresAt int64 `json:"expires_at" valid:"required"`
+	// Password is optional.
+	Password     string `json:"password" valid:"maxstringlength(200),optional"`
+	AllowContent bool   `json:"allow_content" valid:"-"`
+}
+
+type ShareLinkUnlockRequest struct {
+	Password string `json:"password" valid:"required"`
+}
+
+type ShareLinkUnlockResponse struct {
+	// Access is passed as query parameter 'access' in subsequent requests.
+	Access    string `json:"access"`
+	ExpiresAt int64  `json:"expires_at"`
+}
+
+func (a *Api) getShareLinks(c echo.Context) error {
+	// swagger:route GET /api/v1/documents/{id}/share-links Documents GetShareLinks
+	// Get public share links of the document, including revoked and expired links.
+	//
+	// responses:
+	//   200: ShareLink
+	ctx := c.(UserContext)
+	id := c.Param("id")
+	opOk := false
+	defer logCrudShareLink(ctx.UserId, "get list", &opOk, "document: %s", id)
+
+	links, err := a.documentService.GetShareLinks(getContext(c), id)
+	if err != nil {
+		return err
+	}
+	data := make([]aggregates.ShareLink, len(*links))
+	for i, v := range *links {
+		data[i] = *aggregates.ShareLinkToAggregate(&v)
+	}
+	opOk = true
+	return resourceList(c, data, len(data))
+}
+
+func (a *Api) addShareLink(c echo.Context) error {
+	// swagger:route POST /api/v1/documents/{id}/share-links Documents AddShareLink
+	// Create public share link. Response contains the link url, which cannot be retrieved later.
+	//
+	// responses:
+	//   200: ShareLink
+	//   400: RespBadRequest
+	ctx := c.(UserContext)
+	id := c.Param("id")
+	opOk := false
+	defer logCrudShareLink(ctx.UserId, "create", &opOk, "document: %s", id)
+
+	dto := &ShareLinkRequest{}
+	err := unMarshalBody(c.Request(), dto)
+	if err != nil {
+		return err
+	}
+	link, token, err := a.documentService.CreateShareLink(getContext(c), ctx.UserId, id, &services.NewShareLink{
+		Name:         dto.Name,
+		ExpiresAt:    time.UnixMilli(dto.ExpiresAt),
+		Password:     dto.Password,
+		AllowContent: dto.AllowContent,
+	})
+	if err != nil {
+		return err
+	}
+	resp := aggregates.ShareLinkToAggregate(link)
+	resp.Token = token
+	resp.Url = aggregates.ShareLinkUrl(token)
+	opOk = true
+	return c.JSON(http.StatusOK, resp)
+}
+
+func (a *Api) deleteShareLink(c echo.Context) error {
+	// swagger:route DELETE /api/v1/documents/{id}/share-links/{linkId} Documents DeleteShareLink
+	// Revoke public share link
+	//
+	// responses:
+	//   200:
+	//   404: RespNotFound
+	ctx := c.(UserContext)
+	id := c.Param("id")
+	linkId, err := bindPathInt(c, "linkId")
+	if err != nil {
+		return err
+	}
+	opOk := false
+	defer logCrudShareLink(ctx.UserId, "delete", &opOk, "document: %s, link: %d", id, linkId)
+	err = a.documentService.RevokeShareLink(getContext(c), ctx.UserId, id, linkId)
+	if err != nil {
+		return err
+	}
+	opOk = true
+	return c.String(http.StatusOK, "")
+}
+
+func (a *Api) getShareLinkAccess(c echo.Context) error {
+	// swagger:route GET /api/v1/documents/{id}/share-links/{linkId}/access Documents GetShareLinkAccess
+	// Get access history of the public share link
+	//
+	// responses:
+	//   200: ShareLinkAccess
+	//   404: RespNotFound
+	ctx := c.(UserContext)
+	id := c.Param("id")
+	linkId, err := bindPathInt(c, "linkId")
+	if err != nil {
+		return err
+	}
+	opOk := false
+	defer logCrudShareLink(ctx.UserId, "get access", &opOk, "document: %s, link: %d", id, linkId)
+
+	access, err := a.documentService.GetShareLinkAccess(getContext(c), id, linkId)
+	if err != nil {
+		return err
+	}
+	data := make([]aggregates.ShareLinkAccess, len(*access))
+	for i, v := range *access {
+		data[i] = *aggregates.ShareLinkAccessToAggregate(&v)
+	}
+	opOk = true
+	return resourceList(c, data, len(data))
+}
+
+func (a *Api) getSharedDocument(c echo.Context) error {
+	// swagger:route GET /api/v1/share/{token} Share GetSharedDocument
+	// Get document info of public share link. Authentication is not required.
+	// If the link is password protected and query parameter 'access' is missing, only the document name is returned.
+	//
+	// responses:
+	//   200: SharedDocument
+	//   404: RespNotFound
+	setShareHeaders(c)
+	link, doc, err := a.documentService.GetSharedDocument(getContext(c), c.Param("token"))
+	if err != nil {
+		return err
+	}
+	locked := validateShareAccess(c, link) != nil
+	if !locked {
+		a.documentService.RecordShareLinkAccess(getContext(c), link, models.ShareLinkAccessView,
+			c.RealIP(), c.Request().UserAgent())
+	}
+	return c.JSON(http.StatusOK, aggregates.SharedDocumentToAggregate(link, doc, locked))
+}
+
+func (a *Api) unlockSharedDocument(c echo.Context) error {
+	// swagger:route POST /api/v1/share/{token}/unlock Share UnlockSharedDocument
+	// Unlock password protected share link. Returns access token, that is valid for an hour.
+	//
+	// responses:
+	//   200: ShareLinkUnlockResponse
+	//   403: RespForbidden
+	//   404: RespNotFound
+	setShareHeaders(c)
+	link, _, err := a.documentService.GetSharedDocument(getContext(c), c.Param("token"))
+	if err != nil {
+		return err
+	}
+	dto := &ShareLinkUnlockRequest{}
+	err = unMarshalBody(c.Request(), dto)
+	if err != nil {
+		return err
+	}
+	if !link.PasswordMatches(dto.Password) {
+		a.documentService.RecordShareLinkAccess(getContext(c), link, models.ShareLinkAccessDenied,
+			c.RealIP(), c.Request().UserAgent())
+		e := errors.ErrForbidden
+		e.ErrMsg = "invalid password"
+		return e
+	}
+	a.documentService.RecordShareLinkAccess(getContext(c), link, models.ShareLinkAccessUnlock,
+		c.RealIP(), c.Request().UserAgent())
+
+	expiresAt := time.Now().Add(shareAccessExpire)
+	if link.ExpiresAt.Before(expiresAt) {
+		expiresAt = link.ExpiresAt
+	}
+	access, err := newShareAccessToken(link, expiresAt)
+	if err != nil {
+		return fmt.Errorf("create access token: %v", err)
+	}
+	return c.JSON(http.StatusOK, ShareLinkUnlockResponse{
+		Access:    access,
+		ExpiresAt: expiresAt.Unix() * 1000,
+	})
+}
+
+func (a *Api) downloadSharedDocument(c echo.Context) error {
+	// swagger:route GET /api/v1/share/{token}/download Share DownloadSharedDocument
+	// Download the original file of the shared document.
+	//
+	// responses:
+	//   200:
+	//   403: RespForbidden
+	//   404: RespNotFound
+	setShareHeaders(c)
+	link, doc, err := a.sharedDocumentAccess(c)
+	if err != nil {
+		return err
+	}
+	file, err := a.documentService.DocumentFile(doc.Id, services.DocumentFileOriginal)
+	if err != nil {
+		return err
+	}
+	defer file.File.Close()
+	a.documentService.RecordShareLinkAccess(getContext(c), link, models.ShareLinkAccessDownload,
+		c.RealIP(), c.Request().UserAgent())
+
+	resp := c.Response()
+	resp.Header().Set("Content-Type", file.Mimetype)
+	resp.Header().Set("Content-Length", strconv.Itoa(int(file.Size)))
+	resp.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", doc.Filename))
+
+	_, err = io.Copy(resp, file.File)
+	if err != nil {
+		logrus.Errorf("send file over http: %v", err)
+	}
+	return nil
+}
+
+func (a *Api) getSharedDocumentPreview(c echo.Context) error {
+	// swagger:route GET /api/v1/share/{token}/preview Share GetSharedDocumentPreview
+	// Get preview image of the first page of shared document.
+	//
+	// responses:
+	//   403: RespForbidden
+	//   404: RespNotFound
+	setShareHeaders(c)
+	_, doc, err := a.sharedDocumentAccess(c)
+	if err != nil {
+		return err
+	}
+	file, size, err := a.documentService.GetPreview(getContext(c), doc.Id)
+	if err != nil {
+		return err
+	}
+	return sendSharePreview(c, file, size)
+}
+
+func (a *Api) getSharedDocumentPagePreview(c echo.Context) error {
+	// swagger:route GET /api/v1/share/{token}/pages/{page}/preview Share GetSharedDocumentPagePreview
+	// Get preview of a single page of shared document. Query parameter 'size' is one of 'small', 'medium' (default)
+	// or 'large'.
+	//
+	// responses:
+	//   400: RespBadRequest
+	//   403: RespForbidden
+	//   404: RespNotFound
+	setShareHeaders(c)
+	page, err := bindPathInt(c, "page")
+	if err != nil {
+		return err
+	}
+	size := models.PreviewSize(c.QueryParam("size"))
+	if size == "" {
+		size = models.PreviewSizeMedium
+	}
+	_, doc, err := a.sharedDocumentAccess(c)
+	if err != nil {
+		return err
+	}
+	file, fileSize, err := a.documentService.GetPagePreview(getContext(c), doc.Id, page, size)
+	if err != nil {
+		return err
+	}
+	return sendSharePreview(c, file, fileSize)
+}
+
+func (a *Api) getSharedDocumentContent(c echo.Context) error {
+	// swagger:route GET /api/v1/share/{token}/content Share GetSharedDocumentContent
+	// Get parsed text content of shared document, if the link allows it.
+	//
+	// responses:
+	//   403: RespForbidden
+	//   404: RespNotFound
+	setShareHeaders(c)
+	link, doc, err := a.sharedDocumentAccess(c)
+	if err != nil {
+		return err
+	}
+	if !link.AllowContent {
+		e := errors.ErrForbidden
+		e.ErrMsg = "content is not shared"
+		return e
+	}
+	content, err := a.documentService.GetContent(getContext(c), doc.Id)
+	if err != nil {
+		return err
+	}
+	a.documentService.RecordShareLinkAccess(getContext(c), link, models.ShareLinkAccessContent,
+		c.RealIP(), c.Request().UserAgent())
+	return c.String(http.StatusOK, *content)
+}
+
+// sharedDocumentAccess returns the shared document if the link is active and
+// the request has access to it.
+func (a *Api) sharedDocumentAccess(c echo.Context) (*models.DocumentShareLink, *models.Document, error) {
+	link, doc, err := a.documentService.GetSharedDocument(getContext(c), c.Param("token"))
+	if err != nil {
+		return nil, nil, err
+	}
+	err = validateShareAccess(c, link)
+	if err != nil {
+		return nil, nil, err
+	}
+	return link, doc, nil
+}
+
+func sendSharePreview(c echo.Context, file io.ReadCloser, size int) error {
+	defer file.Close()
+	header := c.Response().Header()
+	header.Set("Content-Type", "image/png")
+	header.Set("Content-Length", strconv.Itoa(size))
+
+	_, err := io.Copy(c.Response(), file)
+	if err != nil {
+		logrus.Errorf("send file over http: %v", err)
+	}
+	return nil
+}
+
+// setShareHeaders disables caching and indexing of shared documents.
+func setShareHeaders(c echo.Context) {
+	header := c.Response().Header()
+	header.Set("Cache-Control", "no-store")
+	header.Set("X-Robots-Tag", "noindex, nofollow")
+	header.Set("Referrer-Policy", "no-referrer")
+}
+
+const tokenClaimShareLink = "share_link"
+
+// shareAccessKey is the signing key of share link access tokens. It differs from the login token key
+// so that access tokens cannot be used for authentication.
+func shareAccessKey() []byte {
+	return []byte(config.C.Api.Key + "share-link")
+}
+
+func newShareAccessToken(link *models.DocumentShareLink, expiresAt time.Time) (string, error) {
+	claims := jwt.MapClaims{
+		tokenClaimShareLink: strconv.Itoa(link.Id),
+		"nbf":               time.Now().Unix(),
+		"exp":               expiresAt.Unix(),
+	}
+	return jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString(shareAccessKey())
+}
+
+// validateShareAccess checks that the request has access to the share link. Links without password
+// are always accessible, otherwise query parameter 'access' must contain a valid access token.
+func validateShareAccess(c echo.Context, link *models.DocumentShareLink) error {
+	if !link.HasPassword() {
+		return nil
+	}
+	e := errors.ErrForbidden
+	e.ErrMsg = "password required"
+
+	raw := c.QueryParam("access")
+	if raw == "" {
+		return e
+	}
+	token, err := jwt.Parse(raw, func(token *jwt.Token) (interface{}, error) {
+		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
+			return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
+		}
+		return shareAccessKey(), nil
+	})
+	if err != nil || !token.Valid {
+		return e
+	}
+	claims, ok := token.Claims.(jwt.MapClaims)
+	if !ok {
+		return e
+	}
+	linkId, ok := claims[tokenClaimShareLink].(string)
+	if !ok || linkId != strconv.Itoa(link.Id) {
+		return e
+	}
+	return nil
+}
@@ -0,0 +1,72 @@
+/*
+ * Virtualpaper is a service to manage users paper documents in virtual format.
+ * Copyright (C) 2024  Tero Vierimaa
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+	"tryffel.net/go/virtualpaper/config"
+	"tryffel.net/go/virtualpaper/models"
+)
+
+func TestValidateShareAccess(t *testing.T) {
+	if config.C == nil {
+		config.C = &config.Config{}
+	}
+	config.C.Api.Key = "test-key"
+
+	link := &models.DocumentShareLink{Id: 5}
+	other := &models.DocumentShareLink{Id: 6}
+	assert.NoError(t, link.SetPassword("secret"))
+	assert.NoError(t, other.SetPassword("secret"))
+
+	valid, err := newShareAccessToken(link, time.Now().Add(time.Minute))
+	assert.NoError(t, err)
+	expired, err := newShareAccessToken(link, time.Now().Add(-time.Minute))
+	assert.NoError(t, err)
+	loginToken, err := newToken("1", "abc", config.C.Api.Key)
+	assert.NoError(t, err)
+
+	tests := []struct {
+		name    string
+		link    *models.DocumentShareLink
+		access  string
+		wantErr bool
+	}{
+		{"no password", &models.DocumentShareLink{Id: 5}, "", false},
+		{"missing access", link, "", true},
+		{"valid access", link, valid, false},
+		{"other link", other, valid, true},
+		{"expired", link, expired, true},
+		{"login token", link, loginToken, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/?access="+tt.access, nil)
+			c := echo.New().NewContext(req, httptest.NewRecorder())
+			err := validateShareAccess(c, tt.link)
+			assert.Equal(t, tt.wantErr, err != nil)
+		})
+	}
+}
@@ -0,0 +1,155 @@
+/*
+ * Virtualpaper is a service to manage users paper documents in virtual format.
+ * Copyright (C) 2024  Tero Vierimaa
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package api
+
+import (
+	"net/http"
+
+	"github.com/labstack/echo/v4"
+)
+
+type TwoFactorStatusResponse struct {
+	Enabled bool `json:"enabled"`
+	// RecoveryCodes is the number of unused recovery codes.
+	RecoveryCodes int `json:"recovery_codes"`
+}
+
+type TotpEnrollmentResponse struct {
+	Secret string `json:"secret"`
+	// Url is the otpauth url to show as QR code.
+	Url string `json:"url"`
+}
+
+type TotpCodeRequest struct {
+	Code string `json:"code" valid:"required"`
+}
+
+type RecoveryCodesResponse struct {
+	// RecoveryCodes are shown only once.
+	RecoveryCodes []string `json:"recovery_codes"`
+}
+
+func (a *Api) getTwoFactorStatus(c echo.Context) error {
+	// swagger:route GET /api/v1/auth/totp Authentication GetTwoFactorStatus
+	// Get two-factor authentication status
+	//
+	// responses:
+	//   200: TwoFactorStatusResponse
+	ctx := c.(UserContext)
+	status, err := a.authService.GetTwoFactorStatus(getContext(c), ctx.UserId)
+	if err != nil {
+		return err
+	}
+	return c.JSON(http.StatusOK, TwoFactorStatusResponse{Enabled: status.Enabled, RecoveryCodes: status.RecoveryCodes})
+}
+
+func (a *Api) startTotpEnrollment(c echo.Context) error {
+	// swagger:route POST /api/v1/auth/totp Authentication StartTotpEnrollment
+	// Create new totp secret. Two-factor authentication is enabled after verifying a code
+	// with /api/v1/auth/totp/enable.
+	//
+	// responses:
+	//   200: TotpEnrollmentResponse
+	ctx := c.(UserContext)
+	opOk := false
+	defer logCrudTwoFactor(ctx.UserId, "create", &opOk, "start totp enrollment")
+	enrollment, err := a.authService.StartTotpEnrollment(getContext(c), ctx.User)
+	if err != nil {
+		return err
+	}
+	opOk = true
+	return c.JSON(http.StatusOK, TotpEnrollmentResponse{Secret: enrollment.Secret, Url: enrollment.Url})
+}
+
+func (a *Api) enableTotp(c echo.Context) error {
+	// swagger:route POST /api/v1/auth/totp/enable Authentication EnableTotp
+	// Enable two-factor authentication. Response contains recovery codes, which cannot be retrieved later.
+	//
+	// responses:
+	//   200: RecoveryCodesResponse
+	ctx := c.(UserContext)
+	dto := &TotpCodeRequest{}
+	err := unMarshalBody(c.Request(), dto)
+	if err != nil {
+		return err
+	}
+	opOk := false
+	defer logCrudTwoFactor(ctx.UserId, "update", &opOk, "enable totp")
+	codes, err := a.authService.EnableTotp(getContext(c), ctx.UserId, dto.Code)
+	if err != nil {
+		return err
+	}
+	opOk = true
+	return c.JSON(http.StatusOK, RecoveryCodesResponse{RecoveryCodes: codes})
+}
+
+func (a *Api) disableTotp(c echo.Context) error {
+	// swagger:route DELETE /api/v1/auth/totp Authentication DisableTotp
+	// Disable two-factor authentication
+	//
+	// responses:
+	//   200:
+	ctx := c.(UserContext)
+	opOk := false
+	defer logCrudTwoFactor(ctx.UserId, "delete", &opOk, "disable totp")
+	err := a.authService.DisableTotp(getContext(c), ctx.UserId)
+	if err != nil {
+		return err
+	}
+	opOk = true
+	return c.String(http.StatusOK, "")
+}
+
+func (a *Api) regenerateRecoveryCodes(c echo.Context) error {
+	// swagger:route POST /api/v1/auth/totp/recovery-codes Authentication RegenerateRecoveryCodes
+	// Replace recovery codes with new ones
+	//
+	// responses:
+	//   200: RecoveryCodesResponse
+	ctx := c.(UserContext)
+	opOk := false
+	defer logCrudTwoFactor(ctx.UserId, "update", &opOk, "regenerate recovery codes")
+	codes, err := a.authService.RegenerateRecoveryCodes(getContext(c), ctx.UserId)
+	if err != nil {
+		return err
+	}
+	opOk = true
+	return c.JSON(http.StatusOK, RecoveryCodesResponse{RecoveryCodes: codes})
+}
+
+func (a *Api) adminResetTwoFactor(c echo.Context) error {
+	// swagger:route DELETE /api/v1/admin/users/{id}/totp Admin AdminResetTwoFactor
+	// Disable two-factor authentication of the user
+	//
+	// responses:
+	//   200:
+	ctx := c.(UserContext)
+	userId, err := bindPathIdInt(c)
+	if err != nil {
+		return err
+	}
+	opOk := false
+	defer logCrudAdminUsers(ctx.UserId, "update", &opOk, "reset two-factor authentication of user %d", userId)
+	err = a.adminService.ResetTwoFactor(getContext(c), ctx.UserId, userId)
+	if err != nil {
+		return err
+	}
+	opOk = true
+	return c.String(http.StatusOK, "")
+}
@@ -37,6 +37,8 @@ type UserPreferences struct {
 	DocumentsSize       int64  `json:"documents_size"`
 	DocumentsSizeString string `json:"documents_size_string"`
 	IsAdmin             bool   `json:"is_admin"`
+	// OcrLanguages are preferred languages for OCR
+	OcrLanguages []string `json:"ocr_languages"`
 }
 
 func (u *UserPreferences) copyUser(userPref *models.UserPreferences) {
@@ -49,6 +51,10 @@ func (u *UserPreferences) copyUser(userPref *models.UserPreferences) {
 	u.DocumentsSize = int64(userPref.DocumentsSize)
 	u.DocumentsSizeString = models.GetPrettySize(u.DocumentsSize)
 	u.IsAdmin = userPref.IsAdmin
+	u.OcrLanguages = userPref.OcrLanguages
+	if u.OcrLanguages == nil {
+		u.OcrLanguages = []string{}
+	}
 }
 
 func (a *Api) getUserPreferences(c echo.Context) error {
@@ -75,6 +81,8 @@ func (a *Api) getUserPreferences(c echo.Context) error {
 // swagger:model UserPreferences
 type ReqUserPreferences struct {
 	Email string `json:"email" valid:"email,optional"`
+	// OcrLanguages sets preferred languages for OCR. Empty list resets them to use all available languages.
+	OcrLanguages *[]string `json:"ocr_languages" valid:"-"`
 }
 
 func (a *Api) updateUserPreferences(c echo.Context) error {
@@ -96,6 +104,9 @@ func (a *Api) updateUserPreferences(c echo.Context) error {
 		DocumentsSize: 0,
 		IsAdmin:       ctx.User.IsAdmin,
 	}
+	if dto.OcrLanguages != nil {
+		pref.OcrLanguages = append(models.OcrLanguages{}, *dto.OcrLanguages...)
+	}
 
 	err = a.userService.UpdatePreferences(getContext(ctx), pref)
 	if err != nil {
@@ -70,4 +70,6 @@ type UserContext struct {
 	UserId   int
 	User     *models.User
 	TokenKey string
+	// ApiToken is set if request was authenticated with api token instead of login session.
+	ApiToken *models.ApiToken
 }
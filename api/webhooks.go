@@ -0,0 +1,201 @@
+/*
+ * Virtualpaper is a service to manage users paper documents in virtual format.
+ * Copyright (C) 2024  Tero Vierimaa
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package api
+
+import (
+	"net/http"
+
+	"github.com/labstack/echo/v4"
+	"tryffel.net/go/virtualpaper/models"
+	"tryffel.net/go/virtualpaper/models/aggregates"
+)
+
+type WebhookRequest struct {
+	Name string `json:"name" valid:"stringlength(1|200)"`
+	Url  string `json:"url" valid:"url,maxstringlength(2000)"`
+	// Events to subscribe to. Empty list subscribes to all events.
+	Events  []string `json:"events" valid:"-"`
+	Enabled bool     `json:"enabled" valid:"-"`
+	// Secret to sign payloads with. If empty, a secret is generated for new webhooks
+	// and existing secret is kept for updated webhooks.
+	Secret string `json:"secret" valid:"maxstringlength(200),optional"`
+}
+
+func (r *WebhookRequest) ToWebhook() *models.Webhook {
+	webhook := &models.Webhook{
+		Name:    r.Name,
+		Url:     r.Url,
+		Enabled: r.Enabled,
+		Secret:  r.Secret,
+	}
+	webhook.SetEvents(r.Events)
+	return webhook
+}
+
+func (a *Api) getWebhooks(c echo.Context) error {
+	// swagger:route GET /api/v1/webhooks Webhooks GetWebhooks
+	// Get webhooks
+	//
+	// responses:
+	//   200: Webhook
+	ctx := c.(UserContext)
+	opOk := false
+	defer logCrudWebhook(ctx.UserId, "get list", &opOk, "")
+
+	webhooks, err := a.webhookService.GetWebhooks(getContext(c), ctx.UserId)
+	if err != nil {
+		return err
+	}
+	data := make([]aggregates.Webhook, len(*webhooks))
+	for i, v := range *webhooks {
+		data[i] = *aggregates.WebhookToAggregate(&v)
+	}
+	opOk = true
+	return resourceList(c, data, len(data))
+}
+
+func (a *Api) getWebhook(c echo.Context) error {
+	// swagger:route GET /api/v1/webhooks/{id} Webhooks GetWebhook
+	// Get webhook
+	//
+	// responses:
+	//   200: Webhook
+	ctx := c.(UserContext)
+	id, err := bindPathIdInt(c)
+	if err != nil {
+		return err
+	}
+	opOk := false
+	defer logCrudWebhook(ctx.UserId, "get", &opOk, "webhook: %d", id)
+
+	webhook, err := a.webhookService.GetWebhook(getContext(c), id)
+	if err != nil {
+		return err
+	}
+	opOk = true
+	return c.JSON(http.StatusOK, aggregates.WebhookToAggregate(webhook))
+}
+
+func (a *Api) addWebhook(c echo.Context) error {
+	// swagger:route POST /api/v1/webhooks Webhooks AddWebhook
+	// Add webhook. Response contains the secret that is used to sign payloads.
+	//
+	// responses:
+	//   200: Webhook
+	ctx := c.(UserContext)
+	opOk := false
+	defer logCrudWebhook(ctx.UserId, "create", &opOk, "")
+	data := &WebhookRequest{}
+	err := unMarshalBody(c.Request(), data)
+	if err != nil {
+		return err
+	}
+
+	webhook := data.ToWebhook()
+	webhook.UserId = ctx.UserId
+	err = a.webhookService.AddWebhook(getContext(c), webhook)
+	if err != nil {
+		return err
+	}
+	resp := aggregates.WebhookToAggregate(webhook)
+	resp.Secret = webhook.Secret
+	opOk = true
+	return c.JSON(http.StatusOK, resp)
+}
+
+func (a *Api) updateWebhook(c echo.Context) error {
+	// swagger:route PUT /api/v1/webhooks/{id} Webhooks UpdateWebhook
+	// Update webhook. Secret is returned only if it was changed.
+	//
+	// responses:
+	//   200: Webhook
+	ctx := c.(UserContext)
+	id, err := bindPathIdInt(c)
+	if err != nil {
+		return err
+	}
+	opOk := false
+	defer logCrudWebhook(ctx.UserId, "update", &opOk, "webhook: %d", id)
+	data := &WebhookRequest{}
+	err = unMarshalBody(c.Request(), data)
+	if err != nil {
+		return err
+	}
+
+	webhook := data.ToWebhook()
+	webhook.Id = id
+	err = a.webhookService.UpdateWebhook(getContext(c), webhook)
+	if err != nil {
+		return err
+	}
+	resp := aggregates.WebhookToAggregate(webhook)
+	if data.Secret != "" {
+		resp.Secret = webhook.Secret
+	}
+	opOk = true
+	return c.JSON(http.StatusOK, resp)
+}
+
+func (a *Api) deleteWebhook(c echo.Context) error {
+	// swagger:route DELETE /api/v1/webhooks/{id} Webhooks DeleteWebhook
+	// Delete webhook
+	//
+	// responses:
+	//   200:
+	ctx := c.(UserContext)
+	id, err := bindPathIdInt(c)
+	if err != nil {
+		return err
+	}
+	opOk := false
+	defer logCrudWebhook(ctx.UserId, "delete", &opOk, "webhook: %d", id)
+	err = a.webhookService.DeleteWebhook(getContext(c), id)
+	if err != nil {
+		return err
+	}
+	opOk = true
+	return c.String(http.StatusOK, "")
+}
+
+func (a *Api) getWebhookDeliveries(c echo.Context) error {
+	// swagger:route GET /api/v1/webhooks/{id}/deliveries Webhooks GetWebhookDeliveries
+	// Get webhook deliveries, latest first.
+	//
+	// responses:
+	//   200: WebhookDelivery
+	ctx := c.(UserContext)
+	id, err := bindPathIdInt(c)
+	if err != nil {
+		return err
+	}
+	paging := getPagination(c)
+	opOk := false
+	defer logCrudWebhook(ctx.UserId, "get deliveries", &opOk, "webhook: %d", id)
+
+	deliveries, total, err := a.webhookService.GetDeliveries(getContext(c), id, paging.toPagination())
+	if err != nil {
+		return err
+	}
+	data := make([]aggregates.WebhookDelivery, len(*deliveries))
+	for i, v := range *deliveries {
+		data[i] = *aggregates.WebhookDeliveryToAggregate(&v)
+	}
+	opOk = true
+	return resourceList(c, data, total)
+}
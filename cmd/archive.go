@@ -0,0 +1,139 @@
+/*
+ * Virtualpaper is a service to manage users paper documents in virtual format.
+ * Copyright (C) 2024  Tero Vierimaa
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package cmd
+
+import (
+	"os"
+
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+	"tryffel.net/go/virtualpaper/config"
+	"tryffel.net/go/virtualpaper/services"
+	"tryffel.net/go/virtualpaper/storage"
+)
+
+var exportCmd = &cobra.Command{
+	Use:   "export",
+	Short: "Export user's documents to an archive",
+	Long: `Export all documents of the user with original files, previews, metadata, properties, 
+rules, linked documents, sharing and history to a zip archive. 
+The archive can be imported to another instance with 'virtualpaper manage import'.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		initConfig()
+		err := config.InitLogging()
+		if err != nil {
+			logrus.Fatalf("init log: %v", err)
+			return
+		}
+		defer config.DeinitLogging()
+
+		if archiveUser == "" || archiveFile == "" {
+			logrus.Fatalf("both --user and --file must be set")
+		}
+
+		db, err := storage.NewDatabase(config.C.Database)
+		if err != nil {
+			logrus.Fatalf("Connect to database: %v", err)
+		}
+		defer db.Close()
+
+		user, err := db.UserStore.GetUserByName(archiveUser)
+		if err != nil {
+			logrus.Fatalf("user not found: %v", err)
+		}
+
+		file, err := os.OpenFile(archiveFile, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0600)
+		if err != nil {
+			logrus.Fatalf("create archive: %v", err)
+		}
+
+		err = services.NewArchiveService(db, nil).Export(cmd.Context(), user.Id, file)
+		closeErr := file.Close()
+		if err != nil {
+			os.Remove(archiveFile)
+			logrus.Fatalf("export archive: %v", err)
+		}
+		if closeErr != nil {
+			logrus.Fatalf("close archive: %v", closeErr)
+		}
+		logrus.Infof("Exported documents of user %s to %s", user.Name, archiveFile)
+	},
+}
+
+var importCmd = &cobra.Command{
+	Use:   "import",
+	Short: "Import archive to user",
+	Long: `Import archive created with 'virtualpaper manage export' to the user. 
+Documents that user already has are not imported again. Existing metadata keys, values and properties
+with same names are reused. Imported documents are indexed once server is running.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		initConfig()
+		err := config.InitLogging()
+		if err != nil {
+			logrus.Fatalf("init log: %v", err)
+			return
+		}
+		defer config.DeinitLogging()
+
+		if archiveUser == "" || archiveFile == "" {
+			logrus.Fatalf("both --user and --file must be set")
+		}
+
+		db, err := storage.NewDatabase(config.C.Database)
+		if err != nil {
+			logrus.Fatalf("Connect to database: %v", err)
+		}
+		defer db.Close()
+
+		user, err := db.UserStore.GetUserByName(archiveUser)
+		if err != nil {
+			logrus.Fatalf("user not found: %v", err)
+		}
+
+		file, err := os.Open(archiveFile)
+		if err != nil {
+			logrus.Fatalf("open archive: %v", err)
+		}
+		defer file.Close()
+		stat, err := file.Stat()
+		if err != nil {
+			logrus.Fatalf("stat archive: %v", err)
+		}
+
+		result, err := services.NewArchiveService(db, nil).Import(cmd.Context(), user.Id, file, stat.Size())
+		if err != nil {
+			logrus.Fatalf("import archive: %v", err)
+		}
+		logrus.Infof("Imported %d documents (%d already existed), %d metadata keys, %d metadata values, "+
+			"%d properties and %d rules", result.Documents, result.DuplicateDocuments, result.MetadataKeys,
+			result.MetadataValues, result.Properties, result.Rules)
+	},
+}
+
+var archiveUser string
+var archiveFile string
+
+func init() {
+	manageCmd.AddCommand(exportCmd)
+	manageCmd.AddCommand(importCmd)
+	for _, v := range []*cobra.Command{exportCmd, importCmd} {
+		v.PersistentFlags().StringVarP(&archiveUser, "user", "u", "", "Username")
+		v.PersistentFlags().StringVarP(&archiveFile, "file", "f", "", "Archive file")
+	}
+}
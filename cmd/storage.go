@@ -0,0 +1,199 @@
+/*
+ * Virtualpaper is a service to manage users paper documents in virtual format.
+ * Copyright (C) 2024  Tero Vierimaa
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package cmd
+
+import (
+	"encoding/base64"
+	"fmt"
+
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+	"tryffel.net/go/virtualpaper/config"
+	"tryffel.net/go/virtualpaper/models"
+	"tryffel.net/go/virtualpaper/storage"
+	"tryffel.net/go/virtualpaper/storage/blob"
+)
+
+var migrateStorageCmd = &cobra.Command{
+	Use:   "migrate-storage",
+	Short: "Copy document files and previews to another storage backend",
+	Long: `Copy all document files, previous versions and previews from one storage backend to another, 
+e.g. from local disk to S3. Both backends are read from the configuration file. Each copied file is 
+verified by comparing its hash with the original. Files that already exist in target are skipped, 
+so the migration can be run again if it fails. Files are not removed from the source backend.
+After migration, set storage.backend to the new backend and restart the server.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		initConfig()
+		err := config.InitLogging()
+		if err != nil {
+			logrus.Fatalf("init log: %v", err)
+			return
+		}
+		defer config.DeinitLogging()
+
+		if migrateFrom == migrateTo {
+			logrus.Fatalf("--from and --to must be different backends")
+		}
+
+		source, err := blob.NewStore(migrateFrom)
+		if err != nil {
+			logrus.Fatalf("init source storage: %v", err)
+		}
+		target, err := blob.NewStore(migrateTo)
+		if err != nil {
+			logrus.Fatalf("init target storage: %v", err)
+		}
+
+		logrus.Infof("Migrate files from storage '%s' to '%s'", source.Name(), target.Name())
+		result, err := blob.Migrate(cmd.Context(), source, target, blob.Prefixes, func(key string, err error) {
+			logrus.Errorf("migrate file %s: %v", key, err)
+		})
+		if err != nil {
+			logrus.Fatalf("migrate storage: %v", err)
+		}
+		logrus.Infof("Copied %d files (%s), %d already existed, %d failed", result.Copied,
+			models.GetPrettySize(result.Bytes), result.Skipped, result.Failed)
+		if result.Failed > 0 {
+			logrus.Fatalf("some files could not be migrated, see errors above")
+		}
+	},
+}
+
+var encryptStorageCmd = &cobra.Command{
+	Use:   "encrypt-storage",
+	Short: "Encrypt existing document files and previews",
+	Long: `Encrypt all document files, previous versions and previews that are not yet encrypted, 
+using storage.master_key from the configuration file. New files are encrypted automatically once master key is set, 
+this command encrypts files that were stored before that. Each file is verified after encrypting it 
+before the original file is replaced. Files that are already encrypted are skipped, 
+so the command can be run again if it fails.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		initConfig()
+		err := config.InitLogging()
+		if err != nil {
+			logrus.Fatalf("init log: %v", err)
+			return
+		}
+		defer config.DeinitLogging()
+
+		if config.C.Storage.MasterKey == "" {
+			logrus.Fatalf("storage.master_key is not set")
+		}
+		db, err := storage.NewDatabase(config.C.Database)
+		if err != nil {
+			logrus.Fatalf("Connect to database: %v", err)
+		}
+		defer db.Close()
+
+		files, ok := db.Files.(*blob.EncryptedStore)
+		if !ok {
+			logrus.Fatalf("storage is not encrypted")
+		}
+		result, err := blob.Encrypt(cmd.Context(), files, blob.Prefixes, func(key string, err error) {
+			logrus.Errorf("encrypt file %s: %v", key, err)
+		})
+		if err != nil {
+			logrus.Fatalf("encrypt storage: %v", err)
+		}
+		logrus.Infof("Encrypted %d files (%s), %d already encrypted, %d failed", result.Copied,
+			models.GetPrettySize(result.Bytes), result.Skipped, result.Failed)
+		if result.Failed > 0 {
+			logrus.Fatalf("some files could not be encrypted, see errors above")
+		}
+	},
+}
+
+var rotateMasterKeyCmd = &cobra.Command{
+	Use:   "rotate-master-key",
+	Short: "Change master key for encrypting stored files",
+	Long: `Encrypt users' data keys with a new master key. Current key is read from storage.master_key 
+in the configuration file. If --new-key is not given, a new key is generated and printed. 
+Files do not need to be encrypted again. After rotating, set storage.master_key to the new key and restart the server. 
+Server must not be running during rotation.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		initConfig()
+		err := config.InitLogging()
+		if err != nil {
+			logrus.Fatalf("init log: %v", err)
+			return
+		}
+		defer config.DeinitLogging()
+
+		if config.C.Storage.MasterKey == "" {
+			logrus.Fatalf("storage.master_key is not set")
+		}
+		oldKey, err := blob.ParseKey(config.C.Storage.MasterKey)
+		if err != nil {
+			logrus.Fatalf("invalid storage.master_key: %v", err)
+		}
+
+		var newKey []byte
+		if newMasterKey == "" {
+			newKey, err = blob.GenerateKey()
+			if err != nil {
+				logrus.Fatalf("generate key: %v", err)
+			}
+		} else {
+			newKey, err = blob.ParseKey(newMasterKey)
+			if err != nil {
+				logrus.Fatalf("invalid new key: %v", err)
+			}
+		}
+
+		db, err := storage.NewDatabase(config.C.Database)
+		if err != nil {
+			logrus.Fatalf("Connect to database: %v", err)
+		}
+		defer db.Close()
+
+		tx, err := storage.NewTx(db, cmd.Context())
+		if err != nil {
+			logrus.Fatalf("begin transaction: %v", err)
+		}
+		defer tx.Close()
+		rotated, err := db.EncryptionStore.RotateMasterKey(tx, oldKey, newKey)
+		if err != nil {
+			logrus.Fatalf("rotate master key: %v", err)
+		}
+		err = tx.Commit()
+		if err != nil {
+			logrus.Fatalf("save data keys: %v", err)
+		}
+
+		logrus.Infof("Encrypted %d data keys with new master key", rotated)
+		if newMasterKey == "" {
+			// print to stdout only, the key must not end up in log files
+			fmt.Printf("New master key: %s\n", base64.StdEncoding.EncodeToString(newKey))
+		}
+		fmt.Println("Set storage.master_key to the new key and restart the server.")
+	},
+}
+
+var migrateFrom string
+var migrateTo string
+var newMasterKey string
+
+func init() {
+	manageCmd.AddCommand(migrateStorageCmd)
+	manageCmd.AddCommand(encryptStorageCmd)
+	manageCmd.AddCommand(rotateMasterKeyCmd)
+	rotateMasterKeyCmd.PersistentFlags().StringVar(&newMasterKey, "new-key", "", "New base64-encoded master key")
+	migrateStorageCmd.PersistentFlags().StringVar(&migrateFrom, "from", blob.BackendLocal, "Source storage backend")
+	migrateStorageCmd.PersistentFlags().StringVar(&migrateTo, "to", blob.BackendS3, "Target storage backend")
+}
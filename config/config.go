@@ -23,8 +23,11 @@ type Config struct {
 	Api         Api
 	Database    Database
 	Processing  Processing
+	Storage     Storage
 	Meilisearch Meilisearch
 	Mail        Mail
+	MailImport  MailImport
+	Oidc        Oidc
 	Logging     Logging
 	CronJobs    CronJobs
 }
@@ -69,12 +72,77 @@ type Processing struct {
 	PandocBin    string
 	ImagickBin   string
 	TesseractBin string
+	// OcrWorkers is the number of pages of a single document that are processed with OCR concurrently.
+	OcrWorkers int
+	// OcrTwoPass runs OCR again with only the detected language, if multiple languages were used.
+	OcrTwoPass bool
+
+	// Preprocess rotates and deskews pages of scanned documents before generating previews and OCR.
+	Preprocess bool
+	// RemoveBlankPages removes pages with less ink than BlankPageThreshold when preprocessing.
+	RemoveBlankPages bool
+	// BlankPageThreshold is the ratio of dark pixels in page below which the page is considered blank.
+	BlankPageThreshold float64
+
+	// SearchablePdf enables generating searchable pdf with OCR text layer for scanned documents.
+	SearchablePdf bool
+	// GhostscriptBin is used to convert searchable pdf to PDF/A. If empty, pdf is kept as tesseract outputs it.
+	GhostscriptBin string
+
+	// PdfSeparateBin and PdfUniteBin are poppler tools used for splitting and merging pdf documents.
+	PdfSeparateBin string
+	PdfUniteBin    string
+
+	// Barcodes enables detecting barcodes and QR codes from pages with zbarimg.
+	Barcodes   bool
+	ZbarimgBin string
+	// BarcodeProperty is the name of the property that barcodes are stored to. Property is created if it does not exist.
+	BarcodeProperty string
+	// BarcodeSeparator is the payload of a separator sheet. If set, documents are split at pages containing
+	// a barcode with the payload, and the original document is moved to trash.
+	BarcodeSeparator string
+
+	// ExtractFields enables extracting amounts, IBANs, reference numbers and due dates from document content.
+	// Fields are stored as document properties, which are created for each user if they do not exist.
+	ExtractFields bool
+
+	// ImportDirs maps usernames to directories that are watched for new documents.
+	// Files are imported once they stop changing and are then moved to 'done' or 'failed' subdirectory.
+	ImportDirs map[string]string
+	// ImportInterval is the interval for scanning import directories.
+	ImportInterval time.Duration
+	// ImportSettleTime is the minimum time a file must stay unchanged before it is imported.
+	ImportSettleTime time.Duration
 
 	// application directories. Stored by default in ./media/{previews, documents}.
 	PreviewsDir  string
 	DocumentsDir string
 }
 
+// Storage contains configuration for storing document files and previews.
+type Storage struct {
+	// Backend is either 'local' (default) or 's3'. Local backend stores files in Processing.DataDir.
+	Backend string
+	S3      S3Storage
+	// MasterKey is base64-encoded 256-bit key. If set, stored files are encrypted with per-user data keys,
+	// which are encrypted with master key.
+	MasterKey string
+}
+
+// S3Storage contains configuration for S3-compatible storage backend.
+type S3Storage struct {
+	Endpoint  string
+	Region    string
+	Bucket    string
+	AccessKey string
+	SecretKey string
+	// Prefix is prepended to all object keys.
+	Prefix string
+	NoTLS  bool
+	// PathStyle forces path-style bucket addressing, which e.g. MinIO usually requires.
+	PathStyle bool
+}
+
 // Meilisearch contains search-engine configuration
 type Meilisearch struct {
 	Url    string
@@ -99,6 +167,50 @@ type Mail struct {
 	ErrorRecipient string
 }
 
+// MailImport contains configuration for importing documents from IMAP mailboxes.
+type MailImport struct {
+	Disabled     bool
+	PollInterval time.Duration
+	Accounts     []MailImportAccount
+}
+
+// MailImportAccount is a single IMAP account. Supported attachments from unseen messages
+// in Folders are imported as documents for User.
+type MailImportAccount struct {
+	// Virtualpaper user to import documents for
+	User     string   `mapstructure:"user"`
+	Host     string   `mapstructure:"host"`
+	Port     int      `mapstructure:"port"`
+	Username string   `mapstructure:"username"`
+	Password string   `mapstructure:"password"`
+	NoTLS    bool     `mapstructure:"no_tls"`
+	Folders  []string `mapstructure:"folders"`
+	// ProcessedFolder, if set, is where processed messages are moved.
+	// Otherwise messages are only marked as seen.
+	ProcessedFolder string `mapstructure:"processed_folder"`
+}
+
+// Oidc contains configuration for OpenID Connect single sign-on.
+type Oidc struct {
+	Enabled      bool
+	Issuer       string
+	ClientId     string
+	ClientSecret string
+	// RedirectUrl is the login callback page registered at the provider.
+	// Defaults to <api.public_url>/#/login/oidc.
+	RedirectUrl string
+	Scopes      []string
+	// AutoRegister creates users that log in for the first time. Otherwise only users that
+	// already exist, matched by email, can log in.
+	AutoRegister bool
+	// UsernameClaim is the claim used as the username of new users.
+	UsernameClaim string
+	// If AdminClaim is set, user is an administrator when the claim contains any of AdminValues.
+	// Administrator rights are updated on every login.
+	AdminClaim  string
+	AdminValues []string
+}
+
 // Logging configuration
 type Logging struct {
 	Loglevel      string
@@ -152,6 +264,43 @@ func ConfigFromViper() error {
 			PandocBin:    viper.GetString("processing.pandoc_bin"),
 			ImagickBin:   viper.GetString("processing.imagick_bin"),
 			TesseractBin: viper.GetString("processing.tesseract_bin"),
+			OcrWorkers:   viper.GetInt("processing.ocr_workers"),
+			OcrTwoPass:   viper.GetBool("processing.ocr_two_pass"),
+
+			Preprocess:         viper.GetBool("processing.preprocess"),
+			RemoveBlankPages:   viper.GetBool("processing.remove_blank_pages"),
+			BlankPageThreshold: viper.GetFloat64("processing.blank_page_threshold"),
+
+			SearchablePdf:  viper.GetBool("processing.searchable_pdf"),
+			GhostscriptBin: viper.GetString("processing.ghostscript_bin"),
+
+			PdfSeparateBin: viper.GetString("processing.pdfseparate_bin"),
+			PdfUniteBin:    viper.GetString("processing.pdfunite_bin"),
+
+			Barcodes:         viper.GetBool("processing.barcodes"),
+			ZbarimgBin:       viper.GetString("processing.zbarimg_bin"),
+			BarcodeProperty:  viper.GetString("processing.barcode_property"),
+			BarcodeSeparator: viper.GetString("processing.barcode_separator"),
+
+			ExtractFields: viper.GetBool("processing.extract_fields"),
+
+			ImportDirs:       viper.GetStringMapString("processing.import_dirs"),
+			ImportInterval:   viper.GetDuration("processing.import_interval"),
+			ImportSettleTime: viper.GetDuration("processing.import_settle_time"),
+		},
+		Storage: Storage{
+			Backend: viper.GetString("storage.backend"),
+			S3: S3Storage{
+				Endpoint:  viper.GetString("storage.s3.endpoint"),
+				Region:    viper.GetString("storage.s3.region"),
+				Bucket:    viper.GetString("storage.s3.bucket"),
+				AccessKey: viper.GetString("storage.s3.access_key"),
+				SecretKey: viper.GetString("storage.s3.secret_key"),
+				Prefix:    viper.GetString("storage.s3.prefix"),
+				NoTLS:     viper.GetBool("storage.s3.no_tls"),
+				PathStyle: viper.GetBool("storage.s3.path_style"),
+			},
+			MasterKey: viper.GetString("storage.master_key"),
 		},
 		Meilisearch: Meilisearch{
 			Url:    viper.GetString("meilisearch.url"),
@@ -167,6 +316,22 @@ func ConfigFromViper() error {
 			From:           viper.GetString("mail.from"),
 			ErrorRecipient: viper.GetString("mail.error_recipient"),
 		},
+		MailImport: MailImport{
+			Disabled:     viper.GetBool("mail_import.disabled"),
+			PollInterval: viper.GetDuration("mail_import.poll_interval"),
+		},
+		Oidc: Oidc{
+			Enabled:       viper.GetBool("oidc.enabled"),
+			Issuer:        viper.GetString("oidc.issuer"),
+			ClientId:      viper.GetString("oidc.client_id"),
+			ClientSecret:  viper.GetString("oidc.client_secret"),
+			RedirectUrl:   viper.GetString("oidc.redirect_url"),
+			Scopes:        viper.GetStringSlice("oidc.scopes"),
+			AutoRegister:  viper.GetBool("oidc.auto_register"),
+			UsernameClaim: viper.GetString("oidc.username_claim"),
+			AdminClaim:    viper.GetString("oidc.admin_claim"),
+			AdminValues:   viper.GetStringSlice("oidc.admin_values"),
+		},
 		Logging: Logging{
 			Loglevel:      viper.GetString("logging.log_level"),
 			LogDirectory:  viper.GetString("logging.directory"),
@@ -181,7 +346,10 @@ func ConfigFromViper() error {
 		},
 	}
 
-	var err error
+	err := viper.UnmarshalKey("mail_import.accounts", &c.MailImport.Accounts)
+	if err != nil {
+		return fmt.Errorf("parse mail_import.accounts: %v", err)
+	}
 
 	C = c
 	return err
@@ -209,6 +377,9 @@ func InitConfig() error {
 	C.Processing.TmpDir, inputChanged = setVar(C.Processing.TmpDir, defaultTmpDir)
 	C.Processing.DataDir, dataChanged = setVar(C.Processing.DataDir, "data")
 	C.Meilisearch.Index, indexChanged = setVar(C.Meilisearch.Index, "virtualpaper")
+	if C.Storage.Backend == "" {
+		C.Storage.Backend = "local"
+	}
 
 	if C.Api.TokenExpireSec != 0 {
 		C.Api.TokenExpire = time.Second * time.Duration(C.Api.TokenExpireSec)
@@ -220,6 +391,42 @@ func InitConfig() error {
 		changed = true
 	}
 
+	if C.Processing.BlankPageThreshold == 0 {
+		C.Processing.BlankPageThreshold = 0.005
+	}
+	if C.Processing.BarcodeProperty == "" {
+		C.Processing.BarcodeProperty = "barcode"
+	}
+
+	if C.Processing.ImportInterval == 0 {
+		C.Processing.ImportInterval = time.Second * 10
+	}
+	if C.Processing.ImportSettleTime == 0 {
+		C.Processing.ImportSettleTime = time.Second * 5
+	}
+
+	if C.MailImport.PollInterval == 0 {
+		C.MailImport.PollInterval = time.Minute * 5
+	}
+	for i := range C.MailImport.Accounts {
+		if C.MailImport.Accounts[i].Port == 0 {
+			C.MailImport.Accounts[i].Port = 993
+		}
+		if len(C.MailImport.Accounts[i].Folders) == 0 {
+			C.MailImport.Accounts[i].Folders = []string{"INBOX"}
+		}
+	}
+
+	if len(C.Oidc.Scopes) == 0 {
+		C.Oidc.Scopes = []string{"openid", "profile", "email"}
+	}
+	if C.Oidc.UsernameClaim == "" {
+		C.Oidc.UsernameClaim = "preferred_username"
+	}
+	if C.Oidc.RedirectUrl == "" && C.Api.PublicUrl != "" {
+		C.Oidc.RedirectUrl = strings.TrimSuffix(C.Api.PublicUrl, "/") + "/#/login/oidc"
+	}
+
 	if !path.IsAbs(C.Processing.DataDir) {
 		curDir, err := os.Getwd()
 		if err != nil {
@@ -248,6 +455,13 @@ func InitConfig() error {
 			C.Processing.MaxWorkers = 1
 		}
 	}
+	if C.Processing.OcrWorkers == 0 {
+		// share remaining cpus between workers
+		C.Processing.OcrWorkers = runtime.NumCPU() / C.Processing.MaxWorkers
+		if C.Processing.OcrWorkers == 0 {
+			C.Processing.OcrWorkers = 1
+		}
+	}
 
 	if C.Mail.Host != "" {
 		C.Mail.Enabled = true
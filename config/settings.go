@@ -6,7 +6,7 @@ import (
 )
 
 const (
-	SchemaVersion = 22
+	SchemaVersion = 23
 )
 
 const (
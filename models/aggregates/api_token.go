@@ -0,0 +1,37 @@
+package aggregates
+
+import "tryffel.net/go/virtualpaper/models"
+
+// ApiToken
+// swagger:response ApiToken
+type ApiToken struct {
+	Id     int      `json:"id"`
+	Name   string   `json:"name"`
+	Prefix string   `json:"prefix"`
+	Scopes []string `json:"scopes"`
+	// Token is only returned when it is created.
+	Token string `json:"token,omitempty"`
+	// ExpiresAt is 0 if token does not expire.
+	ExpiresAt  int64  `json:"expires_at"`
+	LastUsedAt int64  `json:"last_used_at"`
+	LastUsedIp string `json:"last_used_ip"`
+	CreatedAt  int64  `json:"created_at"`
+}
+
+func ApiTokenToAggregate(token *models.ApiToken) *ApiToken {
+	resp := &ApiToken{
+		Id:         token.Id,
+		Name:       token.Name,
+		Prefix:     token.Prefix,
+		Scopes:     token.ScopeList(),
+		LastUsedIp: token.LastUsedIp,
+		CreatedAt:  token.CreatedAt.Unix() * 1000,
+	}
+	if !token.ExpiresAt.IsZero() {
+		resp.ExpiresAt = token.ExpiresAt.Unix() * 1000
+	}
+	if !token.LastUsedAt.IsZero() {
+		resp.LastUsedAt = token.LastUsedAt.Unix() * 1000
+	}
+	return resp
+}
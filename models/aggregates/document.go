@@ -32,6 +32,17 @@ type Document struct {
 	Lang        string                 `json:"lang"`
 	Shares      int                    `json:"shares"`
 	Favorite    bool                   `json:"favorite"`
+	PageCount   int                    `json:"page_count"`
+	// OcrLanguages are the languages set for OCR of this document, if any.
+	OcrLanguages []string `json:"ocr_languages"`
+	// PageMatches are the pages that matched search query.
+	PageMatches []models.PageMatch `json:"page_matches,omitempty"`
+}
+
+// DocumentPage is a single page of the document with urls to its previews by size.
+type DocumentPage struct {
+	Page     int               `json:"page"`
+	Previews map[string]string `json:"previews"`
 }
 
 func DocumentToAggregate(doc *models.Document, shares *[]models.DocumentSharePermission) *Document {
@@ -56,6 +67,12 @@ func DocumentToAggregate(doc *models.Document, shares *[]models.DocumentSharePer
 		Lang:        doc.Lang.String(),
 		Shares:      doc.Shares,
 		Favorite:    doc.Favorite,
+		PageCount:   doc.PageCount,
+		PageMatches: doc.PageMatches,
+	}
+	resp.OcrLanguages = doc.OcrLanguages
+	if resp.OcrLanguages == nil {
+		resp.OcrLanguages = []string{}
 	}
 	if doc.DeletedAt.Valid {
 		resp.DeletedAt = doc.DeletedAt.Time.Unix() * 1000
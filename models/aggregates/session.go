@@ -0,0 +1,34 @@
+package aggregates
+
+import "tryffel.net/go/virtualpaper/models"
+
+// Session is user's login session.
+// swagger:response Session
+type Session struct {
+	Id        int    `json:"id"`
+	UserAgent string `json:"user_agent"`
+	IpAddr    string `json:"ip_addr"`
+	LastSeen  int64  `json:"last_seen"`
+	CreatedAt int64  `json:"created_at"`
+	// ExpiresAt is 0 if session does not expire.
+	ExpiresAt int64 `json:"expires_at"`
+	// Current is true for the session that made the request.
+	Current bool `json:"current"`
+}
+
+func SessionToAggregate(token *models.Token, currentKey string) *Session {
+	resp := &Session{
+		Id:        token.Id,
+		UserAgent: token.Name,
+		IpAddr:    token.IpAddr,
+		CreatedAt: token.CreatedAt.Unix() * 1000,
+		Current:   token.Key == currentKey,
+	}
+	if !token.LastSeen.IsZero() {
+		resp.LastSeen = token.LastSeen.Unix() * 1000
+	}
+	if !token.ExpiresAt.IsZero() {
+		resp.ExpiresAt = token.ExpiresAt.Unix() * 1000
+	}
+	return resp
+}
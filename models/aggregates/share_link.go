@@ -0,0 +1,112 @@
+package aggregates
+
+import (
+	"fmt"
+
+	"tryffel.net/go/virtualpaper/config"
+	"tryffel.net/go/virtualpaper/models"
+)
+
+// ShareLink is a public link to a document.
+// swagger:response ShareLink
+type ShareLink struct {
+	Id   int    `json:"id"`
+	Name string `json:"name"`
+	// Token is only returned when the link is created.
+	Token string `json:"token,omitempty"`
+	// Url is the public url of the link. It is only returned when the link is created.
+	Url               string `json:"url,omitempty"`
+	PasswordProtected bool   `json:"password_protected"`
+	AllowContent      bool   `json:"allow_content"`
+	// Active is false if link has expired or has been revoked.
+	Active         bool  `json:"active"`
+	AccessCount    int   `json:"access_count"`
+	LastAccessedAt int64 `json:"last_accessed_at"`
+	ExpiresAt      int64 `json:"expires_at"`
+	// RevokedAt is 0 if link has not been revoked.
+	RevokedAt int64 `json:"revoked_at"`
+	CreatedAt int64 `json:"created_at"`
+}
+
+func ShareLinkToAggregate(link *models.DocumentShareLink) *ShareLink {
+	resp := &ShareLink{
+		Id:                link.Id,
+		Name:              link.Name,
+		PasswordProtected: link.HasPassword(),
+		AllowContent:      link.AllowContent,
+		Active:            link.IsActive(),
+		AccessCount:       link.AccessCount,
+		ExpiresAt:         link.ExpiresAt.Unix() * 1000,
+		CreatedAt:         link.CreatedAt.Unix() * 1000,
+	}
+	if link.LastAccessedAt.Valid {
+		resp.LastAccessedAt = link.LastAccessedAt.Time.Unix() * 1000
+	}
+	if link.RevokedAt.Valid {
+		resp.RevokedAt = link.RevokedAt.Time.Unix() * 1000
+	}
+	return resp
+}
+
+// ShareLinkUrl returns the url that opens the shared document in the frontend.
+func ShareLinkUrl(token string) string {
+	return fmt.Sprintf("%s/#/share/%s", config.C.Api.PublicUrl, token)
+}
+
+// ShareLinkAccess is an audit record of share link usage.
+// swagger:response ShareLinkAccess
+type ShareLinkAccess struct {
+	Id        int    `json:"id"`
+	Action    string `json:"action"`
+	IpAddr    string `json:"ip_addr"`
+	UserAgent string `json:"user_agent"`
+	CreatedAt int64  `json:"created_at"`
+}
+
+func ShareLinkAccessToAggregate(access *models.DocumentShareLinkAccess) *ShareLinkAccess {
+	return &ShareLinkAccess{
+		Id:        access.Id,
+		Action:    access.Action,
+		IpAddr:    access.IpAddr,
+		UserAgent: access.UserAgent,
+		CreatedAt: access.CreatedAt.Unix() * 1000,
+	}
+}
+
+// SharedDocument is the document info that is visible through a public link.
+// If the link is password protected and has not been unlocked, only the name and Locked are set.
+// swagger:response SharedDocument
+type SharedDocument struct {
+	Name   string `json:"name"`
+	Locked bool   `json:"locked"`
+	// ExpiresAt is the expiration of the link.
+	ExpiresAt    int64  `json:"expires_at"`
+	Filename     string `json:"filename,omitempty"`
+	Description  string `json:"description,omitempty"`
+	Mimetype     string `json:"mimetype,omitempty"`
+	Size         int64  `json:"size,omitempty"`
+	PrettySize   string `json:"pretty_size,omitempty"`
+	PageCount    int    `json:"page_count,omitempty"`
+	Date         int64  `json:"date,omitempty"`
+	AllowContent bool   `json:"allow_content"`
+}
+
+func SharedDocumentToAggregate(link *models.DocumentShareLink, doc *models.Document, locked bool) *SharedDocument {
+	resp := &SharedDocument{
+		Name:      doc.Name,
+		Locked:    locked,
+		ExpiresAt: link.ExpiresAt.Unix() * 1000,
+	}
+	if locked {
+		return resp
+	}
+	resp.Filename = doc.Filename
+	resp.Description = doc.Description
+	resp.Mimetype = doc.Mimetype
+	resp.Size = doc.Size
+	resp.PrettySize = doc.GetSize()
+	resp.PageCount = doc.PageCount
+	resp.Date = doc.Date.Unix() * 1000
+	resp.AllowContent = link.AllowContent
+	return resp
+}
@@ -0,0 +1,59 @@
+package aggregates
+
+import "tryffel.net/go/virtualpaper/models"
+
+// Webhook
+// swagger:response Webhook
+type Webhook struct {
+	Id      int      `json:"id"`
+	Name    string   `json:"name"`
+	Url     string   `json:"url"`
+	Events  []string `json:"events"`
+	Enabled bool     `json:"enabled"`
+	// Secret is only returned when it is created or changed.
+	Secret    string `json:"secret,omitempty"`
+	CreatedAt int64  `json:"created_at"`
+	UpdatedAt int64  `json:"updated_at"`
+}
+
+func WebhookToAggregate(webhook *models.Webhook) *Webhook {
+	return &Webhook{
+		Id:        webhook.Id,
+		Name:      webhook.Name,
+		Url:       webhook.Url,
+		Events:    webhook.EventList(),
+		Enabled:   webhook.Enabled,
+		CreatedAt: webhook.CreatedAt.Unix() * 1000,
+		UpdatedAt: webhook.UpdatedAt.Unix() * 1000,
+	}
+}
+
+// WebhookDelivery
+// swagger:response WebhookDelivery
+type WebhookDelivery struct {
+	Id            int    `json:"id"`
+	Event         string `json:"event"`
+	DocumentId    string `json:"document_id"`
+	Status        string `json:"status"`
+	Attempts      int    `json:"attempts"`
+	ResponseCode  int    `json:"response_code"`
+	Error         string `json:"error"`
+	NextAttemptAt int64  `json:"next_attempt_at"`
+	CreatedAt     int64  `json:"created_at"`
+	UpdatedAt     int64  `json:"updated_at"`
+}
+
+func WebhookDeliveryToAggregate(delivery *models.WebhookDelivery) *WebhookDelivery {
+	return &WebhookDelivery{
+		Id:            delivery.Id,
+		Event:         delivery.Event,
+		DocumentId:    delivery.DocumentId,
+		Status:        delivery.Status,
+		Attempts:      delivery.Attempts,
+		ResponseCode:  delivery.ResponseCode,
+		Error:         delivery.Error,
+		NextAttemptAt: delivery.NextAttemptAt.Unix() * 1000,
+		CreatedAt:     delivery.CreatedAt.Unix() * 1000,
+		UpdatedAt:     delivery.UpdatedAt.Unix() * 1000,
+	}
+}
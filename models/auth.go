@@ -1,9 +1,12 @@
 package models
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
 	"fmt"
 	"github.com/hashicorp/go-uuid"
 	"github.com/sirupsen/logrus"
+	"strings"
 	"time"
 )
 
@@ -48,3 +51,118 @@ func (t *Token) ConfirmationExpired() bool {
 	}
 	return t.LastConfirmed.Add(time.Minute * 15).Before(time.Now())
 }
+
+const (
+	ApiScopeDocumentsRead  = "documents:read"
+	ApiScopeDocumentsWrite = "documents:write"
+	ApiScopeMetadataWrite  = "metadata:write"
+	// ApiScopeAdmin grants all scopes. Only administrators can use it.
+	ApiScopeAdmin = "admin"
+
+	// ApiTokenPrefix distinguishes api tokens from login session tokens.
+	ApiTokenPrefix = "vpat_"
+)
+
+// ApiScopes contains all scopes that api tokens can have.
+var ApiScopes = []string{
+	ApiScopeDocumentsRead,
+	ApiScopeDocumentsWrite,
+	ApiScopeMetadataWrite,
+	ApiScopeAdmin,
+}
+
+// IsValidApiScope returns true if scope is a known api token scope.
+func IsValidApiScope(scope string) bool {
+	for _, v := range ApiScopes {
+		if v == scope {
+			return true
+		}
+	}
+	return false
+}
+
+// ApiToken is a long-lived personal token for scripts and integrations.
+// Only the hash of the token is stored, the token itself is shown once when it is created.
+type ApiToken struct {
+	Id     int    `db:"id"`
+	UserId int    `db:"user_id"`
+	Name   string `db:"name"`
+	Hash   string `db:"token_hash"`
+	// Prefix is the beginning of the token that helps user to identify it.
+	Prefix string `db:"prefix"`
+	// Scopes is a comma-separated list of granted scopes.
+	Scopes string `db:"scopes"`
+	// ExpiresAt is zero if token does not expire.
+	ExpiresAt  time.Time `db:"expires_at"`
+	LastUsedAt time.Time `db:"last_used_at"`
+	LastUsedIp string    `db:"last_used_ip"`
+	Timestamp
+}
+
+// HashApiToken returns the hash that is stored for the token.
+func HashApiToken(token string) string {
+	hash := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(hash[:])
+}
+
+// ScopeList returns list of granted scopes.
+func (t *ApiToken) ScopeList() []string {
+	if t.Scopes == "" {
+		return []string{}
+	}
+	return strings.Split(t.Scopes, ",")
+}
+
+// SetScopes sets granted scopes.
+func (t *ApiToken) SetScopes(scopes []string) {
+	t.Scopes = strings.Join(scopes, ",")
+}
+
+// HasScope returns true if token is granted the scope. Admin scope grants all scopes.
+func (t *ApiToken) HasScope(scope string) bool {
+	for _, v := range t.ScopeList() {
+		if v == scope || v == ApiScopeAdmin {
+			return true
+		}
+	}
+	return false
+}
+
+func (t *ApiToken) HasExpired() bool {
+	if t.ExpiresAt.IsZero() {
+		return false
+	}
+	return t.ExpiresAt.Before(time.Now())
+}
+
+// UserIdentity links user to an account at external identity provider.
+type UserIdentity struct {
+	Id        int       `db:"id"`
+	UserId    int       `db:"user_id"`
+	Issuer    string    `db:"issuer"`
+	Subject   string    `db:"subject"`
+	Email     string    `db:"email"`
+	CreatedAt time.Time `db:"created_at"`
+	LastLogin time.Time `db:"last_login"`
+}
+
+// UserTotp is user's time-based one-time password secret for two-factor authentication.
+type UserTotp struct {
+	UserId int    `db:"user_id"`
+	Secret string `db:"secret"`
+	// Enabled is false until user has verified the secret with a code.
+	Enabled bool `db:"enabled"`
+	// LastUsedStep is the time step of the last accepted code. Codes cannot be reused.
+	LastUsedStep int64 `db:"last_used_step"`
+	Timestamp
+}
+
+// HashRecoveryCode returns the hash that is stored for the two-factor recovery code.
+// Dashes and whitespace are ignored.
+func HashRecoveryCode(code string) string {
+	code = strings.ToLower(strings.Join(strings.FieldsFunc(code, func(r rune) bool {
+		return r == '-' || r == ' '
+	}), ""))
+	hash := sha256.Sum256([]byte(code))
+	return hex.EncodeToString(hash[:])
+}
@@ -0,0 +1,13 @@
+package models
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestHashRecoveryCode(t *testing.T) {
+	hash := HashRecoveryCode("abcde-fghij")
+	assert.Equal(t, hash, HashRecoveryCode("ABCDE FGHIJ"))
+	assert.Equal(t, hash, HashRecoveryCode("abcdefghij"))
+	assert.NotEqual(t, hash, HashRecoveryCode("abcde-fghik"))
+}
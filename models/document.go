@@ -52,6 +52,12 @@ type Document struct {
 	Lang        Lang `db:"lang"`
 	Shares      int  `db:"shares"`
 	Favorite    bool `db:"favorite"`
+	// PageCount is the number of pages in document file. It is set when generating previews.
+	PageCount int `db:"page_count"`
+	// OcrLanguages overrides the languages used for OCR. If empty, user's preferred languages are used.
+	OcrLanguages OcrLanguages `db:"ocr_languages"`
+	// PageMatches are the pages that matched search query. It is only set for search results.
+	PageMatches []PageMatch
 
 	DeletedAt sql.NullTime `db:"deleted_at"`
 }
@@ -81,6 +87,50 @@ func (d *Document) Init() {
 	}
 }
 
+// DocumentPage is the extracted text of a single page. Pages start from 1.
+type DocumentPage struct {
+	DocumentId string `db:"document_id"`
+	Page       int    `db:"page"`
+	Content    string `db:"content"`
+}
+
+// PageMatch is a page that matched search query, with a snippet of the page content.
+// Matches in snippet are highlighted.
+type PageMatch struct {
+	Page    int    `json:"page"`
+	Snippet string `json:"snippet"`
+}
+
+// PreviewSize is the size of page preview.
+type PreviewSize string
+
+const (
+	PreviewSizeSmall  PreviewSize = "small"
+	PreviewSizeMedium PreviewSize = "medium"
+	PreviewSizeLarge  PreviewSize = "large"
+)
+
+// PreviewSizes are the sizes that page previews are generated with.
+var PreviewSizes = []PreviewSize{PreviewSizeSmall, PreviewSizeMedium, PreviewSizeLarge}
+
+// Height returns the height of preview in pixels, or 0 if size is unknown.
+func (p PreviewSize) Height() int {
+	switch p {
+	case PreviewSizeSmall:
+		return 200
+	case PreviewSizeMedium:
+		return 500
+	case PreviewSizeLarge:
+		return 1200
+	default:
+		return 0
+	}
+}
+
+func (p PreviewSize) String() string {
+	return string(p)
+}
+
 // IsImage returns true if document file is image.
 func (d *Document) IsImage() bool {
 	return strings.Contains(d.Mimetype, "image/")
@@ -91,6 +141,12 @@ func (d *Document) IsPdf() bool {
 	return strings.ToLower(d.Mimetype) == "application/pdf"
 }
 
+// IsEmail returns true if document file is an email message, either .eml or Outlook .msg.
+func (d *Document) IsEmail() bool {
+	mimetype := strings.ToLower(d.Mimetype)
+	return mimetype == "message/rfc822" || mimetype == "application/vnd.ms-outlook"
+}
+
 // GetType returns either 'pdf' or 'image' depending on type of content.
 func (d *Document) GetType() string {
 	if d.IsPdf() {
@@ -322,6 +378,20 @@ func (dh *DocumentHistory) SortNoCase() []string {
 	return dh.FilterAttributes()
 }
 
+// DocumentVersion is a previous file of the document. The current file is not listed in versions.
+type DocumentVersion struct {
+	Id         int       `db:"id" json:"id"`
+	DocumentId string    `db:"document_id" json:"document_id"`
+	Version    int       `db:"version" json:"version"`
+	UserId     int       `db:"user_id" json:"user_id"`
+	User       string    `db:"user" json:"user"`
+	Filename   string    `db:"filename" json:"filename"`
+	Hash       string    `db:"hash" json:"hash"`
+	Mimetype   string    `db:"mimetype" json:"mimetype"`
+	Size       int64     `db:"size" json:"size"`
+	CreatedAt  time.Time `db:"created_at" json:"created_at"`
+}
+
 type DocumentMetadataHistoryEntry struct {
 	KeyId   int `json:"key_id"`
 	ValueId int `json:"value_id"`
@@ -345,6 +415,12 @@ const (
 	DocumentHistoryActionPropertyAdd    = "add property"
 	DocumentHistoryActionPropertyUpdate = "update property"
 	DocumentHistoryActionPropertyRemove = "remove property"
+	DocumentHistoryActionNewVersion     = "new version"
+	DocumentHistoryActionRestoreVersion = "restore version"
+	DocumentHistoryActionSplit          = "split"
+	DocumentHistoryActionMerge          = "merge"
+	DocumentHistoryActionShareLink      = "create share link"
+	DocumentHistoryActionRevokeLink     = "revoke share link"
 )
 
 // Diffs returns a list of DocumentHistory items from d -> newDocument.
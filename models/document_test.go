@@ -23,6 +23,7 @@ import (
 	"reflect"
 	"testing"
 	"time"
+	"tryffel.net/go/virtualpaper/config"
 )
 
 func TestDocument_Diff(t *testing.T) {
@@ -176,3 +177,32 @@ func TestDocument_Metadata_Diff(t *testing.T) {
 		})
 	}
 }
+
+func TestParseOcrLanguages(t *testing.T) {
+	tests := []struct {
+		input string
+		want  OcrLanguages
+	}{
+		{"", nil},
+		{" , ", nil},
+		{"fin", OcrLanguages{"fin"}},
+		{"fin,eng", OcrLanguages{"fin", "eng"}},
+		{"fin+eng", OcrLanguages{"fin", "eng"}},
+		{" fin , eng ,", OcrLanguages{"fin", "eng"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			assert.Equal(t, tt.want, ParseOcrLanguages(tt.input))
+		})
+	}
+}
+
+func TestOcrLanguages_Validate(t *testing.T) {
+	config.C = &config.Config{Processing: config.Processing{OcrLanguages: []string{"eng", "fin"}}}
+	defer func() { config.C = nil }()
+
+	assert.NoError(t, OcrLanguages{}.Validate())
+	assert.NoError(t, OcrLanguages{"fin"}.Validate())
+	assert.NoError(t, OcrLanguages{"fin", "eng"}.Validate())
+	assert.Error(t, OcrLanguages{"fin", "swe"}.Validate())
+}
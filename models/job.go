@@ -87,31 +87,43 @@ type ProcessStep string
 
 const (
 	ProcessHash           ProcessStep = "hash"
+	ProcessPreprocess     ProcessStep = "preprocess"
 	ProcessThumbnail      ProcessStep = "thumbnail"
+	ProcessBarcodes       ProcessStep = "barcodes"
 	ProcessParseContent   ProcessStep = "extract"
+	ProcessSearchablePdf  ProcessStep = "searchable-pdf"
 	ProcessDetectLanguage ProcessStep = "detect-language"
+	ProcessExtractFields  ProcessStep = "extract-fields"
 	ProcessRules          ProcessStep = "rules"
 	ProcessFts            ProcessStep = "fts"
 )
 
 // ProcessStepsAll is a list of default steps to run for new document.
-var ProcessStepsAll = []ProcessStep{ProcessHash, ProcessThumbnail, ProcessParseContent, ProcessDetectLanguage, ProcessRules, ProcessFts}
+var ProcessStepsAll = []ProcessStep{ProcessHash, ProcessPreprocess, ProcessThumbnail, ProcessBarcodes, ProcessParseContent, ProcessSearchablePdf, ProcessDetectLanguage, ProcessExtractFields, ProcessRules, ProcessFts}
 
 // ProcessStepsOrder is the order in which the steps are to be run in ascending order.
 var ProcessStepsOrder = map[ProcessStep]int{
 	ProcessHash:           1,
-	ProcessThumbnail:      2,
-	ProcessParseContent:   3,
-	ProcessDetectLanguage: 4,
-	ProcessRules:          5,
-	ProcessFts:            6,
+	ProcessPreprocess:     2,
+	ProcessThumbnail:      3,
+	ProcessBarcodes:       4,
+	ProcessParseContent:   5,
+	ProcessSearchablePdf:  6,
+	ProcessDetectLanguage: 7,
+	ProcessExtractFields:  8,
+	ProcessRules:          9,
+	ProcessFts:            10,
 }
 
 var ProcessStepsKeys = map[ProcessStep]string{
 	ProcessHash:           "hash",
+	ProcessPreprocess:     "preprocess",
 	ProcessThumbnail:      "thumbnail",
+	ProcessBarcodes:       "barcodes",
 	ProcessParseContent:   "content",
+	ProcessSearchablePdf:  "searchable-pdf",
 	ProcessDetectLanguage: "detect-language",
+	ProcessExtractFields:  "extract-fields",
 	ProcessRules:          "rules",
 	ProcessFts:            "fts",
 }
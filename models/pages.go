@@ -0,0 +1,100 @@
+package models
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"tryffel.net/go/virtualpaper/errors"
+)
+
+// PageRange is an inclusive range of pages. Pages start from 1.
+type PageRange struct {
+	From int `json:"from"`
+	To   int `json:"to"`
+}
+
+func (p PageRange) String() string {
+	if p.From == p.To {
+		return strconv.Itoa(p.From)
+	}
+	return fmt.Sprintf("%d-%d", p.From, p.To)
+}
+
+// Pages returns the number of pages in range.
+func (p PageRange) Pages() int {
+	return p.To - p.From + 1
+}
+
+// ParsePageRange parses page range in format '3' or '1-4'.
+func ParsePageRange(s string) (PageRange, error) {
+	e := errors.ErrInvalid
+	e.ErrMsg = fmt.Sprintf("invalid page range: '%s'", s)
+
+	from, to, isRange := strings.Cut(strings.TrimSpace(s), "-")
+	if !isRange {
+		to = from
+	}
+	var err error
+	pages := PageRange{}
+	pages.From, err = strconv.Atoi(strings.TrimSpace(from))
+	if err != nil {
+		return pages, e
+	}
+	pages.To, err = strconv.Atoi(strings.TrimSpace(to))
+	if err != nil {
+		return pages, e
+	}
+	if pages.From < 1 || pages.To < pages.From {
+		return pages, e
+	}
+	return pages, nil
+}
+
+// ValidatePageRanges ensures ranges are within pageCount pages. If pageCount is 0, only
+// the ranges themselves are validated.
+func ValidatePageRanges(ranges []PageRange, pageCount int) error {
+	e := errors.ErrInvalid
+	if len(ranges) == 0 {
+		e.ErrMsg = "no page ranges"
+		return e
+	}
+	for _, v := range ranges {
+		if v.From < 1 || v.To < v.From {
+			e.ErrMsg = fmt.Sprintf("invalid page range: '%s'", v)
+			return e
+		}
+		if pageCount > 0 && v.To > pageCount {
+			e.ErrMsg = fmt.Sprintf("page range '%s' exceeds page count %d", v, pageCount)
+			return e
+		}
+	}
+	return nil
+}
+
+// PageRangesBetween returns ranges of pages between separator pages. Separator pages are not included
+// in any range, and empty ranges are skipped.
+func PageRangesBetween(pageCount int, separators []int) []PageRange {
+	isSeparator := make(map[int]bool, len(separators))
+	for _, v := range separators {
+		isSeparator[v] = true
+	}
+	ranges := make([]PageRange, 0)
+	from := 0
+	for page := 1; page <= pageCount; page++ {
+		if isSeparator[page] {
+			if from > 0 {
+				ranges = append(ranges, PageRange{From: from, To: page - 1})
+				from = 0
+			}
+			continue
+		}
+		if from == 0 {
+			from = page
+		}
+	}
+	if from > 0 {
+		ranges = append(ranges, PageRange{From: from, To: pageCount})
+	}
+	return ranges
+}
@@ -0,0 +1,63 @@
+package models
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParsePageRange(t *testing.T) {
+	tests := []struct {
+		input   string
+		want    PageRange
+		wantErr bool
+	}{
+		{"1", PageRange{From: 1, To: 1}, false},
+		{"2-5", PageRange{From: 2, To: 5}, false},
+		{" 3 - 4 ", PageRange{From: 3, To: 4}, false},
+		{"", PageRange{}, true},
+		{"0-2", PageRange{}, true},
+		{"5-2", PageRange{}, true},
+		{"a-2", PageRange{}, true},
+		{"1-", PageRange{}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			got, err := ParsePageRange(tt.input)
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestValidatePageRanges(t *testing.T) {
+	assert.NoError(t, ValidatePageRanges([]PageRange{{1, 2}, {3, 5}}, 5))
+	assert.NoError(t, ValidatePageRanges([]PageRange{{1, 20}}, 0))
+	assert.Error(t, ValidatePageRanges(nil, 5))
+	assert.Error(t, ValidatePageRanges([]PageRange{{1, 6}}, 5))
+	assert.Error(t, ValidatePageRanges([]PageRange{{3, 2}}, 5))
+}
+
+func TestPageRangesBetween(t *testing.T) {
+	tests := []struct {
+		name       string
+		pageCount  int
+		separators []int
+		want       []PageRange
+	}{
+		{"no separators", 3, nil, []PageRange{{1, 3}}},
+		{"separator in middle", 5, []int{3}, []PageRange{{1, 2}, {4, 5}}},
+		{"separators at edges", 5, []int{1, 5}, []PageRange{{2, 4}}},
+		{"consecutive separators", 6, []int{2, 3}, []PageRange{{1, 1}, {4, 6}}},
+		{"all separators", 2, []int{1, 2}, []PageRange{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, PageRangesBetween(tt.pageCount, tt.separators))
+		})
+	}
+}
@@ -167,6 +167,9 @@ type DocumentProperty struct {
 	PropertyName string `json:"property_name" db:"property_name"`
 	Value        string `json:"value"`
 	Description  string `json:"description" db:"description"`
+	// GeneratedBy is the processing step that stored the value, or empty if value was added otherwise.
+	// Values are replaced when the step is run again.
+	GeneratedBy ProcessStep `json:"generated_by" db:"generated_by"`
 	Timestamp
 }
 
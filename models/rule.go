@@ -22,6 +22,7 @@ import (
 	"database/sql/driver"
 	"encoding/json"
 	"fmt"
+	"path"
 	"regexp"
 	"strings"
 
@@ -105,12 +106,44 @@ type Rule struct {
 	Timestamp
 	Triggers RuleTriggerArray `db:"triggers"`
 
+	// Conditions contains all conditions of the rule. If ConditionGroup is set, conditions are
+	// in the order of ConditionGroup.AllConditions.
 	Conditions []*RuleCondition
 	Actions    []*RuleAction
+	// ConditionGroup is the root of the condition tree. If nil, the tree consists of
+	// a single group with Mode and Conditions.
+	ConditionGroup *RuleConditionGroup
+}
+
+// MaxConditionGroupDepth is the max depth of nested condition groups, root group being at depth 1.
+const MaxConditionGroupDepth = 5
+
+// ConditionTree returns the root group of rule conditions.
+func (r *Rule) ConditionTree() *RuleConditionGroup {
+	if r.ConditionGroup != nil {
+		return r.ConditionGroup
+	}
+	return &RuleConditionGroup{
+		RuleId:     r.Id,
+		Mode:       r.Mode,
+		Conditions: r.Conditions,
+	}
+}
+
+// SetConditionTree sets root group of rule conditions and updates Mode and Conditions to match the tree.
+func (r *Rule) SetConditionTree(root *RuleConditionGroup) {
+	r.ConditionGroup = root
+	r.Mode = root.Mode
+	r.Conditions = root.AllConditions()
 }
 
 func (r *Rule) Validate() error {
-	for i, v := range r.Conditions {
+	err := r.ConditionTree().validate(1)
+	if err != nil {
+		return err
+	}
+
+	for i, v := range r.ConditionTree().AllConditions() {
 		err := v.Validate()
 		if err != nil {
 			if isErr, ok := err.(errors.Error); ok {
@@ -126,6 +159,74 @@ func (r *Rule) Validate() error {
 	if len(r.Actions) == 0 {
 		return errors.ErrInvalid
 	}
+
+	for i, v := range r.Actions {
+		err := v.Validate()
+		if err != nil {
+			if isErr, ok := err.(errors.Error); ok {
+				isErr.ErrMsg = fmt.Sprintf("action %d: %s", i+1, isErr.ErrMsg)
+				return isErr
+			}
+			return fmt.Errorf("action %d: %v", i+1, err)
+		}
+	}
+	return nil
+}
+
+// RuleConditionGroup is a node in the condition tree of a rule. Group matches if all (RuleMatchAll)
+// or any (RuleMatchAny) of its enabled conditions and subgroups match. Inverted group negates the result.
+type RuleConditionGroup struct {
+	Id       int                    `db:"id"`
+	RuleId   int                    `db:"rule_id"`
+	ParentId IntId                  `db:"parent_id"`
+	Mode     RuleConditionMatchType `db:"mode"`
+	Inverted bool                   `db:"inverted"`
+	Order    int                    `db:"group_order"`
+
+	Conditions []*RuleCondition
+	Groups     []*RuleConditionGroup
+}
+
+// AllConditions returns conditions of the group and its subgroups, depth-first.
+func (g *RuleConditionGroup) AllConditions() []*RuleCondition {
+	conditions := make([]*RuleCondition, 0, len(g.Conditions))
+	conditions = append(conditions, g.Conditions...)
+	for _, v := range g.Groups {
+		conditions = append(conditions, v.AllConditions()...)
+	}
+	return conditions
+}
+
+// AllGroups returns the group and its subgroups, depth-first.
+func (g *RuleConditionGroup) AllGroups() []*RuleConditionGroup {
+	groups := []*RuleConditionGroup{g}
+	for _, v := range g.Groups {
+		groups = append(groups, v.AllGroups()...)
+	}
+	return groups
+}
+
+func (g *RuleConditionGroup) validate(depth int) error {
+	err := errors.ErrInvalid
+	if depth > MaxConditionGroupDepth {
+		err.ErrMsg = fmt.Sprintf("condition groups can be nested at most %d levels", MaxConditionGroupDepth)
+		return err
+	}
+	if g.Mode != RuleMatchAll && g.Mode != RuleMatchAny {
+		err.ErrMsg = "invalid group mode"
+		return err
+	}
+	// root group may be empty, but nested groups must have conditions
+	if depth > 1 && len(g.Conditions) == 0 && len(g.Groups) == 0 {
+		err.ErrMsg = "condition group is empty"
+		return err
+	}
+	for _, v := range g.Groups {
+		e := v.validate(depth + 1)
+		if e != nil {
+			return e
+		}
+	}
 	return nil
 }
 
@@ -157,6 +258,21 @@ const (
 	RuleConditionMetadataCount         RuleConditionType = "metadata_count"
 	RuleConditionMetadataCountLessThan RuleConditionType = "metadata_count_less_than"
 	RuleConditionMetadataCountMoreThan RuleConditionType = "metadata_count_more_than"
+
+	RuleConditionPropertyExists   RuleConditionType = "property_exists"
+	RuleConditionPropertyIs       RuleConditionType = "property_is"
+	RuleConditionPropertyLessThan RuleConditionType = "property_less_than"
+	RuleConditionPropertyMoreThan RuleConditionType = "property_more_than"
+
+	RuleConditionMimetypeIs      RuleConditionType = "mimetype_is"
+	RuleConditionSizeLessThan    RuleConditionType = "size_less_than"
+	RuleConditionSizeMoreThan    RuleConditionType = "size_more_than"
+	RuleConditionLangIs          RuleConditionType = "lang_is"
+	RuleConditionFilenameMatches RuleConditionType = "filename_matches"
+	RuleConditionIsShared        RuleConditionType = "document_shared"
+
+	RuleConditionBarcodeIs       RuleConditionType = "barcode_is"
+	RuleConditionBarcodeContains RuleConditionType = "barcode_contains"
 )
 
 var AllConditionTypes = []RuleConditionType{
@@ -181,13 +297,50 @@ var AllConditionTypes = []RuleConditionType{
 	RuleConditionMetadataCount,
 	RuleConditionMetadataCountLessThan,
 	RuleConditionMetadataCountMoreThan,
+
+	RuleConditionPropertyExists,
+	RuleConditionPropertyIs,
+	RuleConditionPropertyLessThan,
+	RuleConditionPropertyMoreThan,
+
+	RuleConditionMimetypeIs,
+	RuleConditionSizeLessThan,
+	RuleConditionSizeMoreThan,
+	RuleConditionLangIs,
+	RuleConditionFilenameMatches,
+	RuleConditionIsShared,
+
+	RuleConditionBarcodeIs,
+	RuleConditionBarcodeContains,
+}
+
+// IsPropertyCondition returns true if condition matches document properties.
+func (r RuleConditionType) IsPropertyCondition() bool {
+	return strings.HasPrefix(string(r), "property_")
+}
+
+// IsBarcodeCondition returns true if condition matches barcodes detected from document.
+func (r RuleConditionType) IsBarcodeCondition() bool {
+	return strings.HasPrefix(string(r), "barcode_")
+}
+
+// IsAttributeCondition returns true if condition matches document attributes:
+// properties, mimetype, size, language, filename, sharing or barcodes.
+func (r RuleConditionType) IsAttributeCondition() bool {
+	switch r {
+	case RuleConditionMimetypeIs, RuleConditionSizeLessThan, RuleConditionSizeMoreThan, RuleConditionLangIs,
+		RuleConditionFilenameMatches, RuleConditionIsShared, RuleConditionBarcodeIs, RuleConditionBarcodeContains:
+		return true
+	}
+	return r.IsPropertyCondition()
 }
 
 type RuleCondition struct {
-	Id              int  `db:"id"`
-	RuleId          int  `db:"rule_id"`
-	Enabled         bool `db:"enabled"`
-	CaseInsensitive bool `db:"case_insensitive"`
+	Id              int   `db:"id"`
+	RuleId          int   `db:"rule_id"`
+	GroupId         IntId `db:"group_id"`
+	Enabled         bool  `db:"enabled"`
+	CaseInsensitive bool  `db:"case_insensitive"`
 	// Inverted inverts the match result
 	Inverted      bool              `db:"inverted_match"`
 	ConditionType RuleConditionType `db:"condition_type"`
@@ -203,6 +356,12 @@ type RuleCondition struct {
 	MetadataValue     IntId `db:"metadata_value"`
 	MetadataKeyName   Text  `db:"metadata_key_name"`
 	MetadataValueName Text  `db:"metadata_value_name"`
+
+	// Property to match with
+	PropertyId      IntId `db:"property_id"`
+	PropertyName    Text  `db:"property_name"`
+	PropertyType    Text  `db:"property_type"`
+	PropertyDateFmt Text  `db:"property_date_fmt"`
 }
 
 func (r *RuleCondition) Validate() error {
@@ -270,6 +429,50 @@ func (r *RuleCondition) Validate() error {
 			err.ErrMsg = "regex must be enabled when parsing date"
 		}
 	}
+
+	if r.ConditionType.IsPropertyCondition() {
+		if r.PropertyId == 0 {
+			err.ErrMsg = "must have property defined"
+			return err
+		}
+		if r.ConditionType != RuleConditionPropertyExists && r.Value == "" {
+			err.ErrMsg = "matching value is empty"
+			return err
+		}
+	}
+
+	if r.ConditionType.IsBarcodeCondition() && r.Value == "" {
+		err.ErrMsg = "matching value is empty"
+		return err
+	}
+
+	switch r.ConditionType {
+	case RuleConditionMimetypeIs:
+		if !strings.Contains(r.Value, "/") {
+			err.ErrMsg = "mimetype must be in format 'type/subtype' or 'type/*'"
+			return err
+		}
+	case RuleConditionSizeLessThan, RuleConditionSizeMoreThan:
+		_, sizeErr := ParseFileSize(r.Value)
+		if sizeErr != nil {
+			err.ErrMsg = sizeErr.Error()
+			return err
+		}
+	case RuleConditionLangIs:
+		if !reLangCode.MatchString(r.Value) {
+			err.ErrMsg = "value must be a two-letter language code"
+			return err
+		}
+	case RuleConditionFilenameMatches:
+		if !r.IsRegex {
+			_, matchErr := path.Match(r.Value, "")
+			if matchErr != nil {
+				err.ErrMsg = "invalid filename pattern"
+				err.Err = matchErr
+				return err
+			}
+		}
+	}
 	return nil
 }
 
@@ -291,8 +494,30 @@ const (
 	RuleActionAddMetadata       RuleActionType = "metadata_add"
 	RuleActionRemoveMetadata    RuleActionType = "metadata_remove"
 	RuleActionSetDate           RuleActionType = "date_set"
+	RuleActionShare             RuleActionType = "share"
+	RuleActionSetFavorite       RuleActionType = "favorite_set"
+	RuleActionAddProperty       RuleActionType = "property_add"
+	RuleActionLinkDocuments     RuleActionType = "documents_link"
+	RuleActionSetLanguage       RuleActionType = "lang_set"
+	RuleActionDelete            RuleActionType = "document_delete"
 )
 
+var AllActionTypes = []RuleActionType{
+	RuleActionSetName,
+	RuleActionAppendName,
+	RuleActionSetDescription,
+	RuleActionAppendDescription,
+	RuleActionAddMetadata,
+	RuleActionRemoveMetadata,
+	RuleActionSetDate,
+	RuleActionShare,
+	RuleActionSetFavorite,
+	RuleActionAddProperty,
+	RuleActionLinkDocuments,
+	RuleActionSetLanguage,
+	RuleActionDelete,
+}
+
 type RuleAction struct {
 	Id      int  `db:"id"`
 	RuleId  int  `db:"rule_id"`
@@ -306,6 +531,81 @@ type RuleAction struct {
 	MetadataValue     IntId          `db:"metadata_value"`
 	MetadataKeyName   Text           `db:"metadata_key_name"`
 	MetadataValueName Text           `db:"metadata_value_name"`
+
+	// User to share document with and the permissions to grant
+	ShareUserId   IntId       `db:"share_user_id"`
+	ShareUserName Text        `db:"share_user_name"`
+	Permissions   Permissions `db:"permissions"`
+
+	// Property to add to document. For generated properties value is generated when the action is run.
+	PropertyId   IntId `db:"property_id"`
+	PropertyName Text  `db:"property_name"`
+}
+
+var reLangCode = regexp.MustCompile("^[a-z]{2}$")
+
+func (r *RuleAction) Validate() error {
+	err := errors.ErrInvalid
+
+	validType := false
+	for _, v := range AllActionTypes {
+		if r.Action == v {
+			validType = true
+			break
+		}
+	}
+	if !validType {
+		err.ErrMsg = fmt.Sprintf("invalid action type: %s", r.Action)
+		return err
+	}
+
+	switch r.Action {
+	case RuleActionAddMetadata:
+		if r.MetadataKey == 0 || r.MetadataValue == 0 {
+			err.ErrMsg = "must have metadata key and value defined"
+			return err
+		}
+	case RuleActionRemoveMetadata:
+		if r.MetadataKey == 0 {
+			err.ErrMsg = "must have metadata key defined"
+			return err
+		}
+	case RuleActionShare:
+		if r.ShareUserId == 0 {
+			err.ErrMsg = "must have user to share with"
+			return err
+		}
+		if !r.Permissions.Read {
+			err.ErrMsg = "shared document must be readable"
+			return err
+		}
+	case RuleActionSetFavorite:
+		if r.Value != "true" && r.Value != "false" {
+			err.ErrMsg = "value must be boolean (true|false)"
+			return err
+		}
+	case RuleActionAddProperty:
+		if r.PropertyId == 0 {
+			err.ErrMsg = "must have property defined"
+			return err
+		}
+	case RuleActionLinkDocuments:
+		if strings.TrimSpace(r.Value) == "" {
+			err.ErrMsg = "search query is empty"
+			return err
+		}
+	case RuleActionSetLanguage:
+		if !reLangCode.MatchString(r.Value) {
+			err.ErrMsg = "value must be a two-letter language code"
+			return err
+		}
+	}
+	return nil
+}
+
+// Favorite returns value of RuleActionSetFavorite.
+func (r *RuleAction) Favorite() bool {
+	return r.Value == "true"
 }
 
 type MetadataRuleType string
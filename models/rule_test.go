@@ -0,0 +1,172 @@
+package models
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRuleAction_Validate(t *testing.T) {
+	tests := []struct {
+		name    string
+		action  RuleAction
+		wantErr bool
+	}{
+		{"unknown type", RuleAction{Action: "unknown"}, true},
+		{"set name", RuleAction{Action: RuleActionSetName, Value: "name"}, false},
+		{"add metadata", RuleAction{Action: RuleActionAddMetadata, MetadataKey: 1, MetadataValue: 2}, false},
+		{"add metadata no value", RuleAction{Action: RuleActionAddMetadata, MetadataKey: 1}, true},
+		{"share", RuleAction{Action: RuleActionShare, ShareUserId: 2, Permissions: Permissions{Read: true}}, false},
+		{"share no user", RuleAction{Action: RuleActionShare, Permissions: Permissions{Read: true}}, true},
+		{"share no read", RuleAction{Action: RuleActionShare, ShareUserId: 2, Permissions: Permissions{Write: true}}, true},
+		{"favorite", RuleAction{Action: RuleActionSetFavorite, Value: "false"}, false},
+		{"favorite invalid", RuleAction{Action: RuleActionSetFavorite, Value: "yes"}, true},
+		{"property", RuleAction{Action: RuleActionAddProperty, PropertyId: 1}, false},
+		{"property missing", RuleAction{Action: RuleActionAddProperty, Value: "a"}, true},
+		{"link", RuleAction{Action: RuleActionLinkDocuments, Value: "invoice"}, false},
+		{"link empty query", RuleAction{Action: RuleActionLinkDocuments, Value: " "}, true},
+		{"language", RuleAction{Action: RuleActionSetLanguage, Value: "fi"}, false},
+		{"language invalid", RuleAction{Action: RuleActionSetLanguage, Value: "finnish"}, true},
+		{"delete", RuleAction{Action: RuleActionDelete}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.action.Validate()
+			if tt.wantErr {
+				assert.Error(t, err)
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+}
+
+func TestRuleCondition_Validate(t *testing.T) {
+	tests := []struct {
+		name      string
+		condition RuleCondition
+		wantErr   bool
+	}{
+		{"property exists", RuleCondition{ConditionType: RuleConditionPropertyExists, PropertyId: 1}, false},
+		{"property no id", RuleCondition{ConditionType: RuleConditionPropertyExists}, true},
+		{"property is", RuleCondition{ConditionType: RuleConditionPropertyIs, PropertyId: 1, Value: "a"}, false},
+		{"property less than no value", RuleCondition{ConditionType: RuleConditionPropertyLessThan, PropertyId: 1}, true},
+		{"mimetype", RuleCondition{ConditionType: RuleConditionMimetypeIs, Value: "image/*"}, false},
+		{"mimetype invalid", RuleCondition{ConditionType: RuleConditionMimetypeIs, Value: "pdf"}, true},
+		{"size", RuleCondition{ConditionType: RuleConditionSizeMoreThan, Value: "10 MB"}, false},
+		{"size invalid", RuleCondition{ConditionType: RuleConditionSizeLessThan, Value: "big"}, true},
+		{"language", RuleCondition{ConditionType: RuleConditionLangIs, Value: "en"}, false},
+		{"language invalid", RuleCondition{ConditionType: RuleConditionLangIs, Value: "english"}, true},
+		{"filename", RuleCondition{ConditionType: RuleConditionFilenameMatches, Value: "invoice-*.pdf"}, false},
+		{"filename invalid pattern", RuleCondition{ConditionType: RuleConditionFilenameMatches, Value: "[a"}, true},
+		{"shared", RuleCondition{ConditionType: RuleConditionIsShared}, false},
+		{"barcode", RuleCondition{ConditionType: RuleConditionBarcodeIs, Value: "6410405082657"}, false},
+		{"barcode empty", RuleCondition{ConditionType: RuleConditionBarcodeContains}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.condition.Validate()
+			if tt.wantErr {
+				assert.Error(t, err)
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+}
+
+func TestParseFileSize(t *testing.T) {
+	tests := []struct {
+		size    string
+		want    int64
+		wantErr bool
+	}{
+		{"1024", 1024, false},
+		{"10 KiB", 10 * 1024, false},
+		{"1.5MB", 1024 * 1024 * 3 / 2, false},
+		{"2g", 2 * 1024 * 1024 * 1024, false},
+		{"100 b", 100, false},
+		{"", 0, true},
+		{"-1", 0, true},
+		{"ten", 0, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.size, func(t *testing.T) {
+			got, err := ParseFileSize(tt.size)
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestRule_Validate_conditionGroups(t *testing.T) {
+	condition := func() *RuleCondition {
+		return &RuleCondition{Enabled: true, ConditionType: RuleConditionLangIs, Value: "en"}
+	}
+	nested := func(depth int) *RuleConditionGroup {
+		root := &RuleConditionGroup{Mode: RuleMatchAll, Conditions: []*RuleCondition{condition()}}
+		group := root
+		for i := 1; i < depth; i++ {
+			child := &RuleConditionGroup{Mode: RuleMatchAny, Conditions: []*RuleCondition{condition()}}
+			group.Groups = []*RuleConditionGroup{child}
+			group = child
+		}
+		return root
+	}
+
+	tests := []struct {
+		name    string
+		group   *RuleConditionGroup
+		wantErr bool
+	}{
+		{"empty root", &RuleConditionGroup{Mode: RuleMatchAll}, false},
+		{"max depth", nested(MaxConditionGroupDepth), false},
+		{"too deep", nested(MaxConditionGroupDepth + 1), true},
+		{"empty subgroup", &RuleConditionGroup{Mode: RuleMatchAll, Groups: []*RuleConditionGroup{{Mode: RuleMatchAny}}}, true},
+		{"invalid mode", &RuleConditionGroup{Mode: RuleMatchAll, Groups: []*RuleConditionGroup{
+			{Conditions: []*RuleCondition{condition()}}}}, true},
+		{"invalid nested condition", &RuleConditionGroup{Mode: RuleMatchAll, Groups: []*RuleConditionGroup{
+			{Mode: RuleMatchAll, Conditions: []*RuleCondition{{ConditionType: RuleConditionLangIs, Value: "english"}}}}}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rule := &Rule{Actions: []*RuleAction{{Action: RuleActionSetName, Value: "name"}}}
+			rule.SetConditionTree(tt.group)
+			err := rule.Validate()
+			if tt.wantErr {
+				assert.Error(t, err)
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+}
+
+func TestRule_SetConditionTree(t *testing.T) {
+	first := &RuleCondition{ConditionType: RuleConditionLangIs, Value: "en"}
+	second := &RuleCondition{ConditionType: RuleConditionLangIs, Value: "fi"}
+	third := &RuleCondition{ConditionType: RuleConditionLangIs, Value: "sv"}
+	root := &RuleConditionGroup{
+		Mode:       RuleMatchAny,
+		Conditions: []*RuleCondition{first},
+		Groups: []*RuleConditionGroup{
+			{Mode: RuleMatchAll, Conditions: []*RuleCondition{second}, Groups: []*RuleConditionGroup{
+				{Mode: RuleMatchAll, Conditions: []*RuleCondition{third}},
+			}},
+		},
+	}
+
+	rule := &Rule{Mode: RuleMatchAll}
+	rule.SetConditionTree(root)
+	assert.Equal(t, RuleMatchAny, rule.Mode)
+	assert.Equal(t, []*RuleCondition{first, second, third}, rule.Conditions)
+	assert.Len(t, root.AllGroups(), 3)
+
+	flat := &Rule{Mode: RuleMatchAny, Conditions: []*RuleCondition{first}}
+	assert.Equal(t, RuleMatchAny, flat.ConditionTree().Mode)
+	assert.Equal(t, []*RuleCondition{first}, flat.ConditionTree().AllConditions())
+}
@@ -0,0 +1,85 @@
+package models
+
+import (
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+const (
+	ShareLinkAccessView     = "view"
+	ShareLinkAccessDownload = "download"
+	ShareLinkAccessContent  = "content"
+	ShareLinkAccessUnlock   = "unlock"
+	// ShareLinkAccessDenied is a failed unlock attempt with wrong password.
+	ShareLinkAccessDenied = "denied"
+)
+
+// DocumentShareLink is a public link that gives access to a single document without an account.
+// Only the hash of the link token is stored.
+type DocumentShareLink struct {
+	Id         int    `db:"id"`
+	DocumentId string `db:"document_id"`
+	// UserId is the user that created the link.
+	UserId int    `db:"user_id"`
+	Name   string `db:"name"`
+	Hash   string `db:"token_hash"`
+	// Password is the hashed password, or empty if link is not password protected.
+	Password string `db:"password"`
+	// AllowContent allows viewing the parsed text content of the document.
+	AllowContent   bool         `db:"allow_content"`
+	ExpiresAt      time.Time    `db:"expires_at"`
+	AccessCount    int          `db:"access_count"`
+	LastAccessedAt sql.NullTime `db:"last_accessed_at"`
+	RevokedAt      sql.NullTime `db:"revoked_at"`
+	Timestamp
+}
+
+// HashShareLinkToken returns the hash that is stored for the link token.
+func HashShareLinkToken(token string) string {
+	hash := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(hash[:])
+}
+
+func (l *DocumentShareLink) HasPassword() bool {
+	return l.Password != ""
+}
+
+// SetPassword hashes and sets the password. Empty password removes the protection.
+func (l *DocumentShareLink) SetPassword(password string) error {
+	if password == "" {
+		l.Password = ""
+		return nil
+	}
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return err
+	}
+	l.Password = string(hash)
+	return nil
+}
+
+func (l *DocumentShareLink) PasswordMatches(password string) bool {
+	if l.Password == "" {
+		return true
+	}
+	return bcrypt.CompareHashAndPassword([]byte(l.Password), []byte(password)) == nil
+}
+
+// IsActive returns true if link has not been revoked and has not expired.
+func (l *DocumentShareLink) IsActive() bool {
+	return !l.RevokedAt.Valid && l.ExpiresAt.After(time.Now())
+}
+
+// DocumentShareLinkAccess is an audit record of share link usage.
+type DocumentShareLinkAccess struct {
+	Id        int       `db:"id"`
+	LinkId    int       `db:"link_id"`
+	Action    string    `db:"action"`
+	IpAddr    string    `db:"ip_address"`
+	UserAgent string    `db:"user_agent"`
+	CreatedAt time.Time `db:"created_at"`
+}
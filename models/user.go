@@ -78,6 +78,8 @@ type UserPreferences struct {
 	DocumentCount Int       `json:"documents_count" db:"documents_count"`
 	DocumentsSize Int       `json:"documents_size" db:"documents_size"`
 	IsAdmin       bool      `json:"is_admin" db:"is_admin"`
+	// OcrLanguages are user's preferred languages for OCR. If empty, all configured languages are used.
+	OcrLanguages OcrLanguages `json:"ocr_languages" db:"-"`
 }
 
 type UserInfo struct {
@@ -92,7 +94,8 @@ type UserInfo struct {
 	IsAdmin       bool      `json:"is_admin" db:"admin"`
 	LastSeen      time.Time `json:"last_seen" db:"last_seen"`
 
-	Indexing bool `json:"indexing"`
+	Indexing         bool `json:"indexing"`
+	TwoFactorEnabled bool `json:"two_factor_enabled" db:"two_factor_enabled"`
 }
 
 type PasswordResetToken struct {
@@ -139,3 +142,11 @@ func (p *PasswordResetToken) TokenMatches(token string) (bool, error) {
 	}
 	return false, err
 }
+
+// UserDataKey is user's key for encrypting stored files. The key itself is encrypted with server master key.
+type UserDataKey struct {
+	Timestamp
+	UserId      int    `db:"user_id"`
+	WrappedKey  string `db:"wrapped_key"`
+	MasterKeyId string `db:"master_key_id"`
+}
@@ -22,7 +22,11 @@ import (
 	"database/sql/driver"
 	"fmt"
 	"strconv"
+	"strings"
 	"time"
+
+	"tryffel.net/go/virtualpaper/config"
+	"tryffel.net/go/virtualpaper/errors"
 )
 
 // GetSize returns human-formatted size
@@ -47,6 +51,41 @@ func GetPrettySize(bytes int64) string {
 
 }
 
+// ParseFileSize parses size in bytes from string, e.g. '1024', '10 KiB' or '1.5MB'.
+// Units are case-insensitive and multiples of 1024.
+func ParseFileSize(size string) (int64, error) {
+	size = strings.ToLower(strings.TrimSpace(size))
+	multiplier := int64(1)
+	for _, unit := range []struct {
+		suffixes   []string
+		multiplier int64
+	}{
+		{[]string{"gib", "gb", "g"}, 1024 * 1024 * 1024},
+		{[]string{"mib", "mb", "m"}, 1024 * 1024},
+		{[]string{"kib", "kb", "k"}, 1024},
+		{[]string{"b"}, 1},
+	} {
+		found := false
+		for _, suffix := range unit.suffixes {
+			if strings.HasSuffix(size, suffix) {
+				size = strings.TrimSpace(strings.TrimSuffix(size, suffix))
+				multiplier = unit.multiplier
+				found = true
+				break
+			}
+		}
+		if found {
+			break
+		}
+	}
+
+	value, err := strconv.ParseFloat(size, 64)
+	if err != nil || value < 0 {
+		return 0, fmt.Errorf("invalid size: '%s'", size)
+	}
+	return int64(value * float64(multiplier)), nil
+}
+
 type IntId uint64
 
 func (i IntId) Value() (driver.Value, error) {
@@ -130,3 +169,67 @@ func (l Lang) Value() (driver.Value, error) {
 func (l Lang) String() string {
 	return string(l)
 }
+
+// OcrLanguages is a list of tesseract language codes, e.g. 'eng' or 'fin'.
+// It is stored as a comma-separated string.
+type OcrLanguages []string
+
+// ParseOcrLanguages parses languages separated by ',' or '+'.
+func ParseOcrLanguages(value string) OcrLanguages {
+	splits := strings.FieldsFunc(value, func(r rune) bool {
+		return r == ',' || r == '+'
+	})
+	langs := make(OcrLanguages, 0, len(splits))
+	for _, v := range splits {
+		v = strings.TrimSpace(v)
+		if v != "" {
+			langs = append(langs, v)
+		}
+	}
+	if len(langs) == 0 {
+		return nil
+	}
+	return langs
+}
+
+// Validate ensures all languages are configured in processing.ocr_languages,
+// which are the languages installed for tesseract.
+func (l OcrLanguages) Validate() error {
+	for _, v := range l {
+		found := false
+		for _, installed := range config.C.Processing.OcrLanguages {
+			if v == installed {
+				found = true
+				break
+			}
+		}
+		if !found {
+			e := errors.ErrInvalid
+			e.ErrMsg = fmt.Sprintf("ocr language '%s' is not available", v)
+			return e
+		}
+	}
+	return nil
+}
+
+func (l *OcrLanguages) Scan(src interface{}) error {
+	switch value := src.(type) {
+	case nil:
+		*l = nil
+	case string:
+		*l = ParseOcrLanguages(value)
+	case []byte:
+		*l = ParseOcrLanguages(string(value))
+	default:
+		return fmt.Errorf("invalid type: %v, expected string", src)
+	}
+	return nil
+}
+
+func (l OcrLanguages) Value() (driver.Value, error) {
+	return l.String(), nil
+}
+
+func (l OcrLanguages) String() string {
+	return strings.Join(l, ",")
+}
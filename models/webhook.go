@@ -0,0 +1,95 @@
+package models
+
+import (
+	"strings"
+	"time"
+)
+
+const (
+	WebhookEventDocumentCreated   = "document.created"
+	WebhookEventDocumentProcessed = "document.processed"
+	WebhookEventDocumentUpdated   = "document.updated"
+	WebhookEventDocumentShared    = "document.shared"
+	WebhookEventDocumentDeleted   = "document.deleted"
+)
+
+// WebhookEvents contains all events that webhooks can subscribe to.
+var WebhookEvents = []string{
+	WebhookEventDocumentCreated,
+	WebhookEventDocumentProcessed,
+	WebhookEventDocumentUpdated,
+	WebhookEventDocumentShared,
+	WebhookEventDocumentDeleted,
+}
+
+// IsValidWebhookEvent returns true if event is a known webhook event.
+func IsValidWebhookEvent(event string) bool {
+	for _, v := range WebhookEvents {
+		if v == event {
+			return true
+		}
+	}
+	return false
+}
+
+// Webhook is a user's subscription to document events.
+type Webhook struct {
+	Id     int    `db:"id"`
+	UserId int    `db:"user_id"`
+	Name   string `db:"name"`
+	Url    string `db:"url"`
+	// Secret is used to sign payloads.
+	Secret string `db:"secret"`
+	// Events is a comma-separated list of subscribed events. Empty list subscribes to all events.
+	Events  string `db:"events"`
+	Enabled bool   `db:"enabled"`
+	Timestamp
+}
+
+// EventList returns list of subscribed events.
+func (w *Webhook) EventList() []string {
+	if w.Events == "" {
+		return []string{}
+	}
+	return strings.Split(w.Events, ",")
+}
+
+// SetEvents sets subscribed events.
+func (w *Webhook) SetEvents(events []string) {
+	w.Events = strings.Join(events, ",")
+}
+
+// Subscribes returns true if webhook is subscribed to event.
+func (w *Webhook) Subscribes(event string) bool {
+	if w.Events == "" {
+		return true
+	}
+	for _, v := range w.EventList() {
+		if v == event {
+			return true
+		}
+	}
+	return false
+}
+
+const (
+	WebhookDeliveryPending = "pending"
+	WebhookDeliverySuccess = "success"
+	WebhookDeliveryFailed  = "failed"
+)
+
+// WebhookDelivery is a single event sent to a webhook.
+type WebhookDelivery struct {
+	Id           int    `db:"id"`
+	WebhookId    int    `db:"webhook_id"`
+	Event        string `db:"event"`
+	DocumentId   string `db:"document_id"`
+	Payload      string `db:"payload"`
+	Status       string `db:"status"`
+	Attempts     int    `db:"attempts"`
+	ResponseCode int    `db:"response_code"`
+	Error        string `db:"error"`
+	// NextAttemptAt is the time when pending delivery is sent next time.
+	NextAttemptAt time.Time `db:"next_attempt_at"`
+	Timestamp
+}
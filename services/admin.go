@@ -129,6 +129,13 @@ func (service *AdminService) GetUser(ctx context.Context, id int) (*models.UserI
 	if err != nil {
 		return nil, err
 	}
+	twoFactorEnabled := false
+	userTotp, err := service.db.AuthStore.GetUserTotp(id)
+	if err == nil {
+		twoFactorEnabled = userTotp.Enabled
+	} else if !errors.Is(err, errors.ErrRecordNotFound) {
+		return nil, err
+	}
 
 	info := &models.UserInfo{
 		UserId:        userInfo.Id,
@@ -142,6 +149,8 @@ func (service *AdminService) GetUser(ctx context.Context, id int) (*models.UserI
 		IsAdmin:       userInfo.IsAdmin,
 		LastSeen:      time.Time{},
 		Indexing:      searchStatus.Indexing,
+
+		TwoFactorEnabled: twoFactorEnabled,
 	}
 	return info, nil
 }
@@ -220,6 +229,40 @@ func (service *AdminService) UpdateUser(ctx context.Context, adminUser int, upda
 	}, nil
 }
 
+// ForceLogoutUser revokes all login sessions and api tokens of the user. It returns the number of sessions
+// and api tokens revoked.
+func (service *AdminService) ForceLogoutUser(ctx context.Context, adminUser int, userId int) (int, int, error) {
+	_, err := service.db.UserStore.GetUser(userId)
+	if err != nil {
+		return 0, 0, err
+	}
+	sessions, err := service.db.AuthStore.RevokeUserTokens(userId, "")
+	if err != nil {
+		return 0, 0, err
+	}
+	apiTokens, err := service.db.AuthStore.DeleteUserApiTokens(userId)
+	if err != nil {
+		return sessions, 0, err
+	}
+	logger.Context(ctx).Infof("Log out user %d from %d sessions and %d api tokens by admin user %d",
+		userId, sessions, apiTokens, adminUser)
+	return sessions, apiTokens, nil
+}
+
+// ResetTwoFactor disables two-factor authentication of the user, e.g. when user has lost their device.
+func (service *AdminService) ResetTwoFactor(ctx context.Context, adminUser int, userId int) error {
+	_, err := service.db.UserStore.GetUser(userId)
+	if err != nil {
+		return err
+	}
+	err = service.db.AuthStore.DeleteUserTotp(userId)
+	if err != nil {
+		return err
+	}
+	logger.Context(ctx).Infof("Reset two-factor authentication of user %d by admin user %d", userId, adminUser)
+	return nil
+}
+
 type NewUser struct {
 	Name     string
 	Email    string
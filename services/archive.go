@@ -0,0 +1,826 @@
+package services
+
+import (
+	"archive/zip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"tryffel.net/go/virtualpaper/config"
+	"tryffel.net/go/virtualpaper/errors"
+	"tryffel.net/go/virtualpaper/models"
+	"tryffel.net/go/virtualpaper/services/process"
+	"tryffel.net/go/virtualpaper/storage"
+	"tryffel.net/go/virtualpaper/util/logger"
+)
+
+// ArchiveVersion is the version of the archive format. It is increased whenever the manifest
+// changes in a way older versions cannot read.
+const ArchiveVersion = 1
+
+const (
+	archiveManifestFile = "manifest.json"
+	archiveDocumentsDir = "documents/"
+	archivePreviewsDir  = "previews/"
+)
+
+// ArchiveManifest describes the contents of an exported archive. Ids are the ids in the
+// exporting instance, and they are remapped when importing.
+type ArchiveManifest struct {
+	Version        int                    `json:"version"`
+	ServerVersion  string                 `json:"server_version"`
+	CreatedAt      time.Time              `json:"created_at"`
+	User           string                 `json:"user"`
+	MetadataKeys   []models.MetadataKey   `json:"metadata_keys"`
+	MetadataValues []models.MetadataValue `json:"metadata_values"`
+	Properties     []models.Property      `json:"properties"`
+	Rules          []*models.Rule         `json:"rules"`
+	Documents      []ArchiveDocument      `json:"documents"`
+}
+
+type ArchiveDocument struct {
+	Id          string                    `json:"id"`
+	Name        string                    `json:"name"`
+	Description string                    `json:"description"`
+	Content     string                    `json:"content"`
+	Filename    string                    `json:"filename"`
+	Hash        string                    `json:"hash"`
+	Mimetype    string                    `json:"mimetype"`
+	Size        int64                     `json:"size"`
+	Date        time.Time                 `json:"date"`
+	Lang        models.Lang               `json:"lang"`
+	Favorite    bool                      `json:"favorite"`
+	CreatedAt   time.Time                 `json:"created_at"`
+	UpdatedAt   time.Time                 `json:"updated_at"`
+	DeletedAt   *time.Time                `json:"deleted_at"`
+	Metadata    []models.Metadata         `json:"metadata"`
+	Properties  []models.DocumentProperty `json:"properties"`
+	Links       []string                  `json:"linked_documents"`
+	Sharing     []ArchiveShare            `json:"sharing"`
+	History     []models.DocumentHistory  `json:"history"`
+	// File is the path of the document file inside the archive.
+	File string `json:"file"`
+	// Preview is the path of the preview image inside the archive, if document has one.
+	Preview string `json:"preview"`
+}
+
+// ArchiveShare is a document shared with another user. Users are matched by username.
+type ArchiveShare struct {
+	User        string             `json:"user"`
+	Permissions models.Permissions `json:"permissions"`
+}
+
+// ArchiveImportResult summarizes an import.
+type ArchiveImportResult struct {
+	Documents          int `json:"documents"`
+	DuplicateDocuments int `json:"duplicate_documents"`
+	MetadataKeys       int `json:"metadata_keys"`
+	MetadataValues     int `json:"metadata_values"`
+	Properties         int `json:"properties"`
+	Rules              int `json:"rules"`
+	// SkippedRules is the number of rules that could not be imported.
+	SkippedRules int `json:"skipped_rules"`
+}
+
+type ArchiveService struct {
+	db      *storage.Database
+	process *process.Manager
+}
+
+// NewArchiveService creates new archive service. Manager can be nil, in which case
+// imported documents are only added to processing queue.
+func NewArchiveService(db *storage.Database, manager *process.Manager) *ArchiveService {
+	return &ArchiveService{
+		db:      db,
+		process: manager,
+	}
+}
+
+// Export writes all documents and related data of the user to w as a zip archive.
+func (service *ArchiveService) Export(ctx context.Context, userId int, w io.Writer) error {
+	user, err := service.db.UserStore.GetUser(userId)
+	if err != nil {
+		return err
+	}
+	manifest, err := service.buildManifest(ctx, user)
+	if err != nil {
+		return err
+	}
+
+	logger.Context(ctx).WithField("user", userId).Infof("export archive with %d documents", len(manifest.Documents))
+	archive := zip.NewWriter(w)
+	for i, doc := range manifest.Documents {
+		err = service.addFileToArchive(ctx, archive, doc.File, storage.DocumentKey(doc.Id))
+		if err != nil {
+			return fmt.Errorf("add document %s to archive: %v", doc.Id, err)
+		}
+
+		err = service.addFileToArchive(ctx, archive, doc.Preview, storage.PreviewKey(doc.Id))
+		if errors.Is(err, os.ErrNotExist) {
+			manifest.Documents[i].Preview = ""
+		} else if err != nil {
+			return fmt.Errorf("add preview %s to archive: %v", doc.Id, err)
+		}
+	}
+
+	manifestFile, err := archive.Create(archiveManifestFile)
+	if err != nil {
+		return fmt.Errorf("create manifest: %v", err)
+	}
+	encoder := json.NewEncoder(manifestFile)
+	encoder.SetIndent("", "  ")
+	err = encoder.Encode(manifest)
+	if err != nil {
+		return fmt.Errorf("write manifest: %v", err)
+	}
+	return archive.Close()
+}
+
+func (service *ArchiveService) buildManifest(ctx context.Context, user *models.User) (*ArchiveManifest, error) {
+	manifest := &ArchiveManifest{
+		Version:       ArchiveVersion,
+		ServerVersion: config.Version,
+		CreatedAt:     time.Now(),
+		User:          user.Name,
+	}
+
+	keys, err := service.db.MetadataStore.GetAllUserKeys(service.db, user.Id)
+	if err != nil {
+		return nil, err
+	}
+	manifest.MetadataKeys = *keys
+	values, err := service.db.MetadataStore.GetAllUserValues(service.db, user.Id)
+	if err != nil {
+		return nil, err
+	}
+	manifest.MetadataValues = *values
+	properties, err := service.db.PropertyStore.GetAllUserProperties(service.db, user.Id)
+	if err != nil {
+		return nil, err
+	}
+	manifest.Properties = *properties
+	manifest.Rules, _, err = service.db.RuleStore.GetUserRules(service.db, user.Id, storage.Paging{Limit: config.MaxRows}, "", "")
+	if err != nil {
+		return nil, err
+	}
+
+	documents, err := service.db.DocumentStore.GetAllUserDocuments(service.db, user.Id)
+	if err != nil {
+		return nil, err
+	}
+	manifest.Documents = make([]ArchiveDocument, len(*documents))
+	for i, v := range *documents {
+		doc, err := service.archiveDocument(user.Id, &v)
+		if err != nil {
+			return nil, fmt.Errorf("document %s: %v", v.Id, err)
+		}
+		manifest.Documents[i] = *doc
+	}
+	return manifest, nil
+}
+
+func (service *ArchiveService) archiveDocument(userId int, doc *models.Document) (*ArchiveDocument, error) {
+	archived := &ArchiveDocument{
+		Id:          doc.Id,
+		Name:        doc.Name,
+		Description: doc.Description,
+		Content:     doc.Content,
+		Filename:    doc.Filename,
+		Hash:        doc.Hash,
+		Mimetype:    doc.Mimetype,
+		Size:        doc.Size,
+		Date:        doc.Date,
+		Lang:        doc.Lang,
+		Favorite:    doc.Favorite,
+		CreatedAt:   doc.CreatedAt,
+		UpdatedAt:   doc.UpdatedAt,
+		File:        archiveDocumentsDir + doc.Id,
+		Preview:     archivePreviewsDir + doc.Id + ".png",
+	}
+	if doc.DeletedAt.Valid {
+		archived.DeletedAt = &doc.DeletedAt.Time
+	}
+
+	metadata, err := service.db.MetadataStore.GetDocumentMetadata(service.db, userId, doc.Id)
+	if err != nil {
+		return nil, err
+	}
+	archived.Metadata = *metadata
+	properties, err := service.db.PropertyStore.GetDocumentProperties(service.db, doc.Id)
+	if err != nil {
+		return nil, err
+	}
+	archived.Properties = *properties
+
+	links, err := service.db.MetadataStore.GetLinkedDocuments(userId, doc.Id)
+	if err != nil {
+		return nil, err
+	}
+	archived.Links = make([]string, len(links))
+	for i, v := range links {
+		archived.Links[i] = v.DocumentId
+	}
+
+	shares, err := service.db.DocumentStore.GetSharedUsers(service.db, doc.Id)
+	if err != nil {
+		return nil, err
+	}
+	archived.Sharing = make([]ArchiveShare, len(*shares))
+	for i, v := range *shares {
+		archived.Sharing[i] = ArchiveShare{User: v.Username, Permissions: v.Permissions}
+	}
+
+	history, err := service.db.DocumentStore.GetDocumentHistory(userId, doc.Id)
+	if err != nil {
+		return nil, err
+	}
+	archived.History = *history
+	return archived, nil
+}
+
+func (service *ArchiveService) addFileToArchive(ctx context.Context, archive *zip.Writer, name, key string) error {
+	file, err := service.db.Files.Get(ctx, key)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	writer, err := archive.Create(name)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(writer, file)
+	return err
+}
+
+// ReadArchiveManifest reads and validates manifest from archive.
+func ReadArchiveManifest(archive *zip.Reader) (*ArchiveManifest, error) {
+	file, err := archive.Open(archiveManifestFile)
+	if err != nil {
+		e := errors.ErrInvalid
+		e.ErrMsg = "archive does not contain manifest"
+		e.Err = err
+		return nil, e
+	}
+	defer file.Close()
+
+	manifest := &ArchiveManifest{}
+	err = json.NewDecoder(file).Decode(manifest)
+	if err != nil {
+		e := errors.ErrInvalid
+		e.ErrMsg = "invalid manifest"
+		e.Err = err
+		return nil, e
+	}
+	if manifest.Version < 1 || manifest.Version > ArchiveVersion {
+		e := errors.ErrInvalid
+		e.ErrMsg = fmt.Sprintf("unsupported archive version: %d", manifest.Version)
+		return nil, e
+	}
+	return manifest, nil
+}
+
+// archiveImport holds the mapping from ids in the archive to ids in this instance.
+type archiveImport struct {
+	userId     int
+	manifest   *ArchiveManifest
+	archive    *zip.Reader
+	result     *ArchiveImportResult
+	keys       map[int]int
+	values     map[int]int
+	properties map[int]*models.Property
+	documents  map[string]string
+	// created lists documents that were created during the import. Duplicate documents are not modified.
+	created []ArchiveDocument
+	users   map[string]int
+}
+
+// Import reads archive created with Export and adds its contents to the user. Ids are remapped and
+// documents that user already has (by hash) are not imported again. Existing metadata keys, values and
+// properties with same names are reused.
+func (service *ArchiveService) Import(ctx context.Context, userId int, file io.ReaderAt, size int64) (*ArchiveImportResult, error) {
+	archive, err := zip.NewReader(file, size)
+	if err != nil {
+		e := errors.ErrInvalid
+		e.ErrMsg = "invalid archive"
+		e.Err = err
+		return nil, e
+	}
+	manifest, err := ReadArchiveManifest(archive)
+	if err != nil {
+		return nil, err
+	}
+
+	state := &archiveImport{
+		userId:     userId,
+		manifest:   manifest,
+		archive:    archive,
+		result:     &ArchiveImportResult{},
+		keys:       map[int]int{},
+		values:     map[int]int{},
+		properties: map[int]*models.Property{},
+		documents:  map[string]string{},
+		users:      map[string]int{},
+	}
+
+	logger.Context(ctx).WithField("user", userId).Infof("import archive (version %d) with %d documents from user %s",
+		manifest.Version, len(manifest.Documents), manifest.User)
+
+	err = service.importMetadata(state)
+	if err != nil {
+		return nil, fmt.Errorf("import metadata: %v", err)
+	}
+	err = service.importProperties(state)
+	if err != nil {
+		return nil, fmt.Errorf("import properties: %v", err)
+	}
+	err = service.importRules(ctx, state)
+	if err != nil {
+		return nil, fmt.Errorf("import rules: %v", err)
+	}
+
+	for _, v := range manifest.Documents {
+		err = service.importDocument(ctx, state, v)
+		if err != nil {
+			return state.result, fmt.Errorf("import document %s: %v", v.Id, err)
+		}
+	}
+
+	// links and history can only be added once all documents exist
+	for _, v := range state.created {
+		err = service.importDocumentLinks(state, v)
+		if err != nil {
+			return state.result, fmt.Errorf("import linked documents for %s: %v", v.Id, err)
+		}
+	}
+	for _, v := range state.created {
+		err = service.db.DocumentStore.ReplaceDocumentHistory(service.db, state.documents[v.Id], state.remapHistory(service, v.History))
+		if err != nil {
+			return state.result, fmt.Errorf("import history for %s: %v", v.Id, err)
+		}
+	}
+
+	for _, v := range state.created {
+		docId := state.documents[v.Id]
+		steps := []models.ProcessStep{models.ProcessFts}
+		if v.Preview == "" {
+			steps = []models.ProcessStep{models.ProcessThumbnail, models.ProcessFts}
+		}
+		err = service.db.JobStore.ForceProcessingDocument(service.db, docId, steps)
+		if err != nil {
+			return state.result, fmt.Errorf("add document %s for processing: %v", docId, err)
+		}
+		if service.process != nil {
+			err = service.process.AddDocumentForProcessing(docId)
+			if err != nil {
+				logger.Context(ctx).Errorf("schedule processing for imported document %s: %v", docId, err)
+			}
+		}
+	}
+	return state.result, nil
+}
+
+func (service *ArchiveService) importMetadata(state *archiveImport) error {
+	existingKeys, err := service.db.MetadataStore.GetAllUserKeys(service.db, state.userId)
+	if err != nil {
+		return err
+	}
+	keysByName := map[string]int{}
+	for _, v := range *existingKeys {
+		keysByName[v.Key] = v.Id
+	}
+
+	for _, v := range state.manifest.MetadataKeys {
+		if id, ok := keysByName[v.Key]; ok {
+			state.keys[v.Id] = id
+			continue
+		}
+		key := v
+		err = service.db.MetadataStore.CreateKey(state.userId, &key)
+		if err != nil {
+			return fmt.Errorf("create key %s: %v", v.Key, err)
+		}
+		state.keys[v.Id] = key.Id
+		state.result.MetadataKeys += 1
+	}
+
+	existingValues, err := service.db.MetadataStore.GetAllUserValues(service.db, state.userId)
+	if err != nil {
+		return err
+	}
+	valueId := func(keyId int, value string) string {
+		return fmt.Sprintf("%d-%s", keyId, value)
+	}
+	valuesByName := map[string]int{}
+	for _, v := range *existingValues {
+		valuesByName[valueId(v.KeyId, v.Value)] = v.Id
+	}
+
+	for _, v := range state.manifest.MetadataValues {
+		keyId, ok := state.keys[v.KeyId]
+		if !ok {
+			return fmt.Errorf("value %d refers to unknown key %d", v.Id, v.KeyId)
+		}
+		if id, ok := valuesByName[valueId(keyId, v.Value)]; ok {
+			state.values[v.Id] = id
+			continue
+		}
+		value := v
+		value.UserId = state.userId
+		value.KeyId = keyId
+		err = service.db.MetadataStore.CreateValue(&value)
+		if err != nil {
+			return fmt.Errorf("create value %s: %v", v.Value, err)
+		}
+		state.values[v.Id] = value.Id
+		state.result.MetadataValues += 1
+	}
+	return nil
+}
+
+func (service *ArchiveService) importProperties(state *archiveImport) error {
+	existing, err := service.db.PropertyStore.GetAllUserProperties(service.db, state.userId)
+	if err != nil {
+		return err
+	}
+	byName := map[string]*models.Property{}
+	for i, v := range *existing {
+		byName[v.Name] = &(*existing)[i]
+	}
+
+	for _, v := range state.manifest.Properties {
+		if property, ok := byName[v.Name]; ok {
+			state.properties[v.Id] = property
+			continue
+		}
+		property := v
+		property.User = state.userId
+		err = service.db.PropertyStore.AddProperty(service.db, &property)
+		if err != nil {
+			return fmt.Errorf("add property %s: %v", v.Name, err)
+		}
+		state.properties[v.Id] = &property
+		state.result.Properties += 1
+	}
+	return nil
+}
+
+func (service *ArchiveService) importRules(ctx context.Context, state *archiveImport) error {
+	existing, _, err := service.db.RuleStore.GetUserRules(service.db, state.userId, storage.Paging{Limit: config.MaxRows}, "", "")
+	if err != nil {
+		return err
+	}
+	names := map[string]bool{}
+	for _, v := range existing {
+		names[v.Name] = true
+	}
+
+	for _, v := range state.manifest.Rules {
+		if names[v.Name] {
+			continue
+		}
+		if !state.remapRule(service, v) {
+			logger.Context(ctx).Warnf("skip importing rule '%s': rule refers to metadata, property or user that does not exist", v.Name)
+			state.result.SkippedRules += 1
+			continue
+		}
+		err = service.addRule(ctx, state.userId, v)
+		if err != nil {
+			logger.Context(ctx).Warnf("skip importing rule '%s': %v", v.Name, err)
+			state.result.SkippedRules += 1
+			continue
+		}
+		state.result.Rules += 1
+	}
+	return nil
+}
+
+// addRule adds rule in a transaction, so that rule is not left without some of its conditions or actions.
+func (service *ArchiveService) addRule(ctx context.Context, userId int, rule *models.Rule) error {
+	tx, err := storage.NewTx(service.db, ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Close()
+	err = service.db.RuleStore.AddRule(tx, userId, rule)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// remapRule replaces metadata, property and user ids in rule with ids in this instance. Users to share
+// with are looked up by name. It returns false if some reference cannot be mapped.
+func (state *archiveImport) remapRule(service *ArchiveService, rule *models.Rule) bool {
+	remap := func(key, value *models.IntId) bool {
+		if *key > 0 {
+			id, ok := state.keys[int(*key)]
+			if !ok {
+				return false
+			}
+			*key = models.IntId(id)
+		}
+		if *value > 0 {
+			id, ok := state.values[int(*value)]
+			if !ok {
+				return false
+			}
+			*value = models.IntId(id)
+		}
+		return true
+	}
+
+	for _, v := range rule.ConditionTree().AllConditions() {
+		if !remap(&v.MetadataKey, &v.MetadataValue) {
+			return false
+		}
+		if v.PropertyId > 0 && !state.remapProperty(&v.PropertyId) {
+			return false
+		}
+	}
+	for _, v := range rule.Actions {
+		if !remap(&v.MetadataKey, &v.MetadataValue) {
+			return false
+		}
+		if v.PropertyId > 0 && !state.remapProperty(&v.PropertyId) {
+			return false
+		}
+		if v.ShareUserId > 0 || v.ShareUserName != "" {
+			userId := state.lookupUser(service, v.ShareUserName.String())
+			if userId == 0 || userId == state.userId {
+				return false
+			}
+			v.ShareUserId = models.IntId(userId)
+		}
+	}
+	return true
+}
+
+// remapProperty replaces property id with the id of the property that was imported or matched by name.
+// Properties that are not in the archive, e.g. global properties of other users, cannot be mapped.
+func (state *archiveImport) remapProperty(id *models.IntId) bool {
+	property, ok := state.properties[int(*id)]
+	if !ok {
+		return false
+	}
+	*id = models.IntId(property.Id)
+	return true
+}
+
+func (service *ArchiveService) importDocument(ctx context.Context, state *archiveImport, archived ArchiveDocument) error {
+	existing, err := service.db.DocumentStore.GetByHash(state.userId, archived.Hash)
+	if err != nil {
+		return err
+	}
+	if existing.Id != "" {
+		state.documents[archived.Id] = existing.Id
+		state.result.DuplicateDocuments += 1
+		return nil
+	}
+
+	doc := &models.Document{
+		UserId:      state.userId,
+		Name:        archived.Name,
+		Description: archived.Description,
+		Content:     archived.Content,
+		Filename:    archived.Filename,
+		Hash:        archived.Hash,
+		Mimetype:    archived.Mimetype,
+		Size:        archived.Size,
+		Date:        archived.Date,
+		Lang:        archived.Lang,
+		Favorite:    archived.Favorite,
+	}
+	// files are stored and verified before the document is created, and removed if the import fails,
+	// so that the import does not leave documents without files.
+	doc.Init()
+	err = service.importDocumentFiles(ctx, state, archived, doc.Id)
+	if err == nil {
+		err = service.db.DocumentStore.Create(service.db, doc)
+		if err == nil {
+			err = service.importDocumentData(ctx, state, archived, doc.Id)
+			if err != nil {
+				deleteErr := service.db.DocumentStore.DeleteDocument(doc.Id)
+				if deleteErr != nil {
+					logger.Context(ctx).Errorf("delete partially imported document %s: %v", doc.Id, deleteErr)
+				}
+			}
+		}
+	}
+	if err != nil {
+		deleteErr := process.DeleteDocument(ctx, service.db.Files, doc.Id)
+		if deleteErr != nil {
+			logger.Context(ctx).Errorf("delete files of partially imported document %s: %v", doc.Id, deleteErr)
+		}
+		return err
+	}
+
+	state.documents[archived.Id] = doc.Id
+	state.created = append(state.created, archived)
+	state.result.Documents += 1
+	return nil
+}
+
+// importDocumentFiles stores file and preview of the document from archive and verifies the file hash.
+func (service *ArchiveService) importDocumentFiles(ctx context.Context, state *archiveImport, archived ArchiveDocument, docId string) error {
+	err := service.extractArchiveFile(ctx, state.archive, archived.File, storage.DocumentKey(docId))
+	if err != nil {
+		return fmt.Errorf("extract file: %v", err)
+	}
+	hash, err := service.blobHash(ctx, storage.DocumentKey(docId))
+	if err != nil {
+		return err
+	}
+	if hash != archived.Hash {
+		e := errors.ErrInvalid
+		e.ErrMsg = fmt.Sprintf("file hash does not match for document %s", archived.Id)
+		return e
+	}
+
+	if archived.Preview != "" {
+		err = service.extractArchiveFile(ctx, state.archive, archived.Preview, storage.PreviewKey(docId))
+		if err != nil {
+			return fmt.Errorf("extract preview: %v", err)
+		}
+	}
+	return nil
+}
+
+// importDocumentData adds metadata, properties and sharing of the created document and moves it to trash
+// if it was deleted.
+func (service *ArchiveService) importDocumentData(ctx context.Context, state *archiveImport, archived ArchiveDocument, docId string) error {
+	tx, err := storage.NewTx(service.db, ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Close()
+
+	metadata := make([]models.Metadata, 0, len(archived.Metadata))
+	for _, v := range archived.Metadata {
+		metadata = append(metadata, models.Metadata{KeyId: state.keys[v.KeyId], ValueId: state.values[v.ValueId]})
+	}
+	err = service.db.MetadataStore.UpdateDocumentKeyValues(tx, state.userId, docId, metadata)
+	if err != nil {
+		return fmt.Errorf("add metadata: %v", err)
+	}
+
+	for _, v := range archived.Properties {
+		property, ok := state.properties[v.Property]
+		if !ok {
+			return fmt.Errorf("unknown property %d", v.Property)
+		}
+		err = service.db.PropertyStore.AddDocumentProperty(tx, property, docId, v.Value, v.Description, v.GeneratedBy, false)
+		if err != nil {
+			return fmt.Errorf("add property: %v", err)
+		}
+	}
+
+	sharing := make([]models.UpdateUserSharing, 0, len(archived.Sharing))
+	for _, v := range archived.Sharing {
+		userId := state.lookupUser(service, v.User)
+		if userId == 0 || userId == state.userId {
+			logger.Context(ctx).Warnf("document %s was shared with user '%s', who does not exist, skip sharing",
+				archived.Id, v.User)
+			continue
+		}
+		sharing = append(sharing, models.UpdateUserSharing{UserId: userId, Permissions: v.Permissions})
+	}
+	if len(sharing) > 0 {
+		err = service.db.DocumentStore.UpdateSharing(tx, docId, &sharing)
+		if err != nil {
+			return fmt.Errorf("update sharing: %v", err)
+		}
+	}
+
+	if archived.DeletedAt != nil {
+		err = service.db.DocumentStore.MarkDocumentDeleted(tx, state.userId, docId)
+		if err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+func (service *ArchiveService) importDocumentLinks(state *archiveImport, archived ArchiveDocument) error {
+	if len(archived.Links) == 0 {
+		return nil
+	}
+	docId := state.documents[archived.Id]
+	links := make([]string, 0, len(archived.Links))
+	for _, v := range archived.Links {
+		if linked, ok := state.documents[v]; ok && linked != docId {
+			links = append(links, linked)
+		}
+	}
+	existing, err := service.db.MetadataStore.GetLinkedDocuments(state.userId, docId)
+	if err != nil {
+		return err
+	}
+	for _, v := range existing {
+		found := false
+		for _, link := range links {
+			if link == v.DocumentId {
+				found = true
+				break
+			}
+		}
+		if !found {
+			links = append(links, v.DocumentId)
+		}
+	}
+	return service.db.MetadataStore.UpdateLinkedDocuments(service.db, state.userId, docId, links)
+}
+
+// lookupUser returns id for username, or 0 if user does not exist.
+func (state *archiveImport) lookupUser(service *ArchiveService, name string) int {
+	if id, ok := state.users[name]; ok {
+		return id
+	}
+	id := 0
+	if name != "" {
+		user, err := service.db.UserStore.GetUserByName(name)
+		if err == nil {
+			id = user.Id
+		}
+	}
+	state.users[name] = id
+	return id
+}
+
+// remapHistory maps users and ids in history items. Users that do not exist are stored as server.
+func (state *archiveImport) remapHistory(service *ArchiveService, history []models.DocumentHistory) []models.DocumentHistory {
+	items := make([]models.DocumentHistory, len(history))
+	for i, v := range history {
+		v.UserId = 0
+		if v.User == state.manifest.User {
+			v.UserId = state.userId
+		} else if v.User != "" {
+			v.UserId = state.lookupUser(service, v.User)
+		}
+
+		switch v.Action {
+		case models.DocumentHistoryActionMetadataAdd, models.DocumentHistoryActionMetadataRemove:
+			v.OldValue = state.remapHistoryMetadata(v.OldValue)
+			v.NewValue = state.remapHistoryMetadata(v.NewValue)
+		case models.DocumentHistoryActionPropertyAdd:
+			id, err := strconv.Atoi(v.NewValue)
+			if property, ok := state.properties[id]; err == nil && ok {
+				v.NewValue = strconv.Itoa(property.Id)
+			}
+		}
+		items[i] = v
+	}
+	return items
+}
+
+func (state *archiveImport) remapHistoryMetadata(value string) string {
+	if value == "" {
+		return value
+	}
+	entry := &models.DocumentMetadataHistoryEntry{}
+	err := json.Unmarshal([]byte(value), entry)
+	if err != nil {
+		return value
+	}
+	entry.KeyId = state.keys[entry.KeyId]
+	entry.ValueId = state.values[entry.ValueId]
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return value
+	}
+	return string(data)
+}
+
+func (service *ArchiveService) extractArchiveFile(ctx context.Context, archive *zip.Reader, name, key string) error {
+	if name == "" || strings.Contains(name, "..") {
+		e := errors.ErrInvalid
+		e.ErrMsg = fmt.Sprintf("invalid file name in archive: '%s'", name)
+		return e
+	}
+	input, err := archive.Open(name)
+	if err != nil {
+		return err
+	}
+	defer input.Close()
+
+	stat, err := input.Stat()
+	if err != nil {
+		return err
+	}
+	return service.db.Files.Put(ctx, key, input, stat.Size())
+}
+
+// blobHash returns hash of the stored file, calculated the same way as process.GetHash does.
+func (service *ArchiveService) blobHash(ctx context.Context, key string) (string, error) {
+	file, err := service.db.Files.Get(ctx, key)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+	return process.GetReaderHash(file)
+}
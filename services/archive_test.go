@@ -0,0 +1,389 @@
+package services
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"tryffel.net/go/virtualpaper/errors"
+	"tryffel.net/go/virtualpaper/models"
+	"tryffel.net/go/virtualpaper/storage"
+	"tryffel.net/go/virtualpaper/storage/blob"
+)
+
+func newTestArchiveService(t *testing.T) (*ArchiveService, sqlmock.Sqlmock, blob.Store) {
+	db, mock, err := storage.NewMockDatabase(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	db.Files = blob.NewLocalStore(t.TempDir())
+	return &ArchiveService{db: db}, mock, db.Files
+}
+
+// testArchive returns zip archive with given files.
+func testArchive(t *testing.T, files map[string]string) *zip.Reader {
+	t.Helper()
+	buf := &bytes.Buffer{}
+	writer := zip.NewWriter(buf)
+	for name, content := range files {
+		file, err := writer.Create(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = file.Write([]byte(content))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	err := writer.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	archive, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return archive
+}
+
+func newTestArchiveImport(archive *zip.Reader, manifest *ArchiveManifest) *archiveImport {
+	return &archiveImport{
+		userId:     1,
+		manifest:   manifest,
+		archive:    archive,
+		result:     &ArchiveImportResult{},
+		keys:       map[int]int{1: 11},
+		values:     map[int]int{2: 22},
+		properties: map[int]*models.Property{3: {Id: 33, Name: "amount"}},
+		documents:  map[string]string{},
+		users:      map[string]int{},
+	}
+}
+
+func testArchiveRule(name string, key models.IntId) *models.Rule {
+	return &models.Rule{
+		Name:     name,
+		Enabled:  true,
+		Mode:     models.RuleMatchAll,
+		Triggers: models.RuleTriggerArray{models.RuleTriggerCreate},
+		Actions:  []*models.RuleAction{{Enabled: true, Action: models.RuleActionRemoveMetadata, MetadataKey: key}},
+	}
+}
+
+func expectGetRules(mock sqlmock.Sqlmock, names ...string) {
+	rows := sqlmock.NewRows([]string{"id", "user_id", "name"})
+	for i, v := range names {
+		rows.AddRow(i+1, 1, v)
+	}
+	mock.ExpectQuery("SELECT \\* FROM rules WHERE user_id = \\$1").WillReturnRows(rows)
+	mock.ExpectQuery("SELECT count\\(id\\) as total FROM RULES").WillReturnRows(sqlmock.NewRows([]string{"total"}).AddRow(len(names)))
+	mock.ExpectQuery("FROM rule_conditions").WillReturnRows(sqlmock.NewRows([]string{"id"}))
+	mock.ExpectQuery("FROM rule_condition_groups").WillReturnRows(sqlmock.NewRows([]string{"id"}))
+	mock.ExpectQuery("FROM rule_actions").WillReturnRows(sqlmock.NewRows([]string{"id"}))
+}
+
+func TestArchiveService_importRules(t *testing.T) {
+	service, mock, _ := newTestArchiveService(t)
+	added := testArchiveRule("remove key", 1)
+	state := newTestArchiveImport(nil, &ArchiveManifest{Rules: []*models.Rule{
+		testArchiveRule("existing", 1),
+		testArchiveRule("unknown key", 5),
+		testArchiveRule("insert fails", 1),
+		added,
+	}})
+
+	expectGetRules(mock, "existing")
+	mock.ExpectBegin()
+	mock.ExpectQuery("INSERT INTO rules").WillReturnError(errors.New("connection lost"))
+	mock.ExpectRollback()
+	mock.ExpectBegin()
+	mock.ExpectQuery("INSERT INTO rules").WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(2))
+	mock.ExpectExec("INSERT INTO rule_actions").WithArgs(2, true, false, models.RuleActionRemoveMetadata, "",
+		models.IntId(11), models.IntId(0), models.IntId(0), nil, models.IntId(0)).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectQuery("INSERT INTO rule_condition_groups").WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(3))
+	mock.ExpectCommit()
+
+	err := service.importRules(context.Background(), state)
+	if err != nil {
+		t.Fatalf("importRules() error = %v", err)
+	}
+	if state.result.Rules != 1 || state.result.SkippedRules != 2 {
+		t.Errorf("importRules() rules = %d, skipped = %d, want 1, 2", state.result.Rules, state.result.SkippedRules)
+	}
+	if added.Id != 2 {
+		t.Errorf("added rule id = %d, want 2", added.Id)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestArchiveService_importDocument(t *testing.T) {
+	ctx := context.Background()
+	archived := ArchiveDocument{
+		Id:       "archived-doc",
+		Name:     "invoice",
+		Filename: "invoice.pdf",
+		Mimetype: "application/pdf",
+		Size:     8,
+		File:     archiveDocumentsDir + "archived-doc",
+		Preview:  archivePreviewsDir + "archived-doc.png",
+	}
+	files := map[string]string{
+		archived.File:    "document",
+		archived.Preview: "preview",
+	}
+
+	expectCreate := func(mock sqlmock.Sqlmock) {
+		mock.ExpectQuery("INSERT INTO documents").WillReturnRows(sqlmock.NewRows([]string{"id"}))
+		mock.ExpectExec("INSERT INTO document_history").WillReturnResult(sqlmock.NewResult(0, 1))
+	}
+	// created document only has the id generated during import
+	createdId := func(t *testing.T, state *archiveImport) string {
+		t.Helper()
+		if len(state.documents) != 1 {
+			t.Fatalf("imported documents = %v, want 1", state.documents)
+		}
+		return state.documents[archived.Id]
+	}
+
+	t.Run("import", func(t *testing.T) {
+		service, mock, store := newTestArchiveService(t)
+		archived := archived
+		archived.Hash = testFileHash(t, "document")
+		state := newTestArchiveImport(testArchive(t, files), &ArchiveManifest{})
+
+		expectGetByHash(mock, archived.Hash, "")
+		expectCreate(mock)
+		mock.ExpectBegin()
+		mock.ExpectQuery("FROM documents d").WillReturnRows(sqlmock.NewRows([]string{"key_id"}))
+		mock.ExpectExec("DELETE FROM document_metadata").WillReturnResult(sqlmock.NewResult(0, 0))
+		mock.ExpectQuery("FROM documents d").WillReturnRows(sqlmock.NewRows([]string{"key_id"}))
+		mock.ExpectCommit()
+
+		err := service.importDocument(ctx, state, archived)
+		if err != nil {
+			t.Fatalf("importDocument() error = %v", err)
+		}
+		docId := createdId(t, state)
+		if got := readTestFile(t, store, storage.DocumentKey(docId)); got != "document" {
+			t.Errorf("document file = %s, want document", got)
+		}
+		if got := readTestFile(t, store, storage.PreviewKey(docId)); got != "preview" {
+			t.Errorf("preview file = %s, want preview", got)
+		}
+		if state.result.Documents != 1 || len(state.created) != 1 {
+			t.Errorf("importDocument() result = %+v, created = %d", state.result, len(state.created))
+		}
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Error(err)
+		}
+	})
+
+	t.Run("duplicate", func(t *testing.T) {
+		service, mock, _ := newTestArchiveService(t)
+		archived := archived
+		archived.Hash = testFileHash(t, "document")
+		state := newTestArchiveImport(testArchive(t, files), &ArchiveManifest{})
+		expectGetByHash(mock, archived.Hash, testVersionDocId)
+
+		err := service.importDocument(ctx, state, archived)
+		if err != nil {
+			t.Fatalf("importDocument() error = %v", err)
+		}
+		if state.documents[archived.Id] != testVersionDocId {
+			t.Errorf("duplicate mapped to %s, want %s", state.documents[archived.Id], testVersionDocId)
+		}
+		if state.result.DuplicateDocuments != 1 || len(state.created) != 0 {
+			t.Errorf("importDocument() result = %+v, created = %d", state.result, len(state.created))
+		}
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Error(err)
+		}
+	})
+
+	t.Run("hash mismatch", func(t *testing.T) {
+		service, mock, store := newTestArchiveService(t)
+		archived := archived
+		archived.Hash = testFileHash(t, "other document")
+		state := newTestArchiveImport(testArchive(t, files), &ArchiveManifest{})
+		expectGetByHash(mock, archived.Hash, "")
+
+		err := service.importDocument(ctx, state, archived)
+		if !errors.Is(err, errors.ErrInvalid) {
+			t.Errorf("importDocument() error = %v, want ErrInvalid", err)
+		}
+		stored, err := store.List(ctx, "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(stored) != 0 {
+			t.Errorf("files left after failed import: %v", stored)
+		}
+		if len(state.documents) != 0 || state.result.Documents != 0 {
+			t.Errorf("failed document was added to import result")
+		}
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Error(err)
+		}
+	})
+
+	t.Run("delete document when metadata fails", func(t *testing.T) {
+		service, mock, store := newTestArchiveService(t)
+		archived := archived
+		archived.Hash = testFileHash(t, "document")
+		state := newTestArchiveImport(testArchive(t, files), &ArchiveManifest{})
+
+		expectGetByHash(mock, archived.Hash, "")
+		expectCreate(mock)
+		mock.ExpectBegin()
+		mock.ExpectQuery("FROM documents d").WillReturnError(errors.New("connection lost"))
+		mock.ExpectRollback()
+		mock.ExpectExec("DELETE FROM documents WHERE id").WillReturnResult(sqlmock.NewResult(0, 1))
+
+		err := service.importDocument(ctx, state, archived)
+		if err == nil {
+			t.Fatalf("importDocument() succeeded, want error")
+		}
+		stored, err := store.List(ctx, "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(stored) != 0 {
+			t.Errorf("files left after failed import: %v", stored)
+		}
+		if len(state.documents) != 0 || len(state.created) != 0 {
+			t.Errorf("failed document was added to import result")
+		}
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Error(err)
+		}
+	})
+}
+
+func TestArchiveService_importDocumentLinks(t *testing.T) {
+	service, mock, _ := newTestArchiveService(t)
+	state := newTestArchiveImport(nil, &ArchiveManifest{})
+	state.documents = map[string]string{"a": "new-a", "b": "new-b", "c": "new-c"}
+	archived := ArchiveDocument{Id: "a", Links: []string{"b", "missing", "a"}}
+
+	mock.ExpectQuery("FROM linked_documents l").
+		WillReturnRows(sqlmock.NewRows([]string{"doc_a_id", "doc_b_id", "doc_a_name", "doc_b_name", "created_at"}).
+			AddRow("new-c", "new-a", "c", "a", time.Now()))
+	mock.ExpectBegin()
+	mock.ExpectExec("DELETE FROM linked_documents").WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("INSERT INTO linked_documents").WithArgs("new-a", "new-b", "new-a", "new-c").
+		WillReturnResult(sqlmock.NewResult(0, 2))
+	mock.ExpectExec("INSERT INTO document_history").WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	err := service.importDocumentLinks(state, archived)
+	if err != nil {
+		t.Fatalf("importDocumentLinks() error = %v", err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestArchiveImport_remapHistory(t *testing.T) {
+	service, mock, _ := newTestArchiveService(t)
+	state := newTestArchiveImport(nil, &ArchiveManifest{User: "exporter"})
+	state.users = map[string]int{"other": 5, "removed": 0}
+
+	metadata := func(key, value int) string {
+		data, err := json.Marshal(models.DocumentMetadataHistoryEntry{KeyId: key, ValueId: value})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return string(data)
+	}
+
+	history := []models.DocumentHistory{
+		{Action: models.DocumentHistoryActionCreate, User: "exporter", UserId: 100, NewValue: "invoice"},
+		{Action: models.DocumentHistoryActionMetadataAdd, User: "other", UserId: 101, NewValue: metadata(1, 2)},
+		{Action: models.DocumentHistoryActionMetadataRemove, User: "removed", UserId: 102, OldValue: metadata(1, 2)},
+		{Action: models.DocumentHistoryActionPropertyAdd, NewValue: "3"},
+	}
+	want := []models.DocumentHistory{
+		{Action: models.DocumentHistoryActionCreate, User: "exporter", UserId: 1, NewValue: "invoice"},
+		{Action: models.DocumentHistoryActionMetadataAdd, User: "other", UserId: 5, NewValue: metadata(11, 22)},
+		{Action: models.DocumentHistoryActionMetadataRemove, User: "removed", UserId: 0, OldValue: metadata(11, 22)},
+		{Action: models.DocumentHistoryActionPropertyAdd, NewValue: "33"},
+	}
+
+	got := state.remapHistory(service, history)
+	if len(got) != len(want) {
+		t.Fatalf("remapHistory() = %d items, want %d", len(got), len(want))
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("remapHistory()[%d] = %+v, want %+v", i, got[i], want[i])
+		}
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestArchiveImport_remapRule(t *testing.T) {
+	service, mock, _ := newTestArchiveService(t)
+
+	shareRule := func(userId models.IntId, user models.Text) *models.Rule {
+		return &models.Rule{Name: "share", Mode: models.RuleMatchAll, Actions: []*models.RuleAction{
+			{Action: models.RuleActionShare, ShareUserId: userId, ShareUserName: user},
+		}}
+	}
+	propertyRule := func(conditionProperty, actionProperty models.IntId) *models.Rule {
+		return &models.Rule{Name: "property", Mode: models.RuleMatchAll,
+			Conditions: []*models.RuleCondition{{ConditionType: models.RuleConditionPropertyExists, PropertyId: conditionProperty}},
+			Actions:    []*models.RuleAction{{Action: models.RuleActionAddProperty, PropertyId: actionProperty}},
+		}
+	}
+
+	tests := []struct {
+		name       string
+		rule       *models.Rule
+		want       bool
+		wantUser   models.IntId
+		wantAction models.IntId
+	}{
+		{name: "share user by name", rule: shareRule(100, "other"), want: true, wantUser: 5},
+		{name: "share user does not exist", rule: shareRule(100, "removed"), want: false},
+		{name: "share user without name", rule: shareRule(100, ""), want: false},
+		{name: "share with importing user", rule: shareRule(100, "importer"), want: false},
+		{name: "property", rule: propertyRule(3, 3), want: true, wantAction: 33},
+		{name: "unknown condition property", rule: propertyRule(4, 3), want: false},
+		{name: "unknown action property", rule: propertyRule(3, 4), want: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			state := newTestArchiveImport(nil, &ArchiveManifest{})
+			state.users = map[string]int{"other": 5, "removed": 0, "": 0, "importer": 1}
+			if got := state.remapRule(service, tt.rule); got != tt.want {
+				t.Fatalf("remapRule() = %v, want %v", got, tt.want)
+			}
+			if !tt.want {
+				return
+			}
+			action := tt.rule.Actions[0]
+			if action.ShareUserId != tt.wantUser || action.PropertyId != tt.wantAction {
+				t.Errorf("remapRule() action user = %d, property = %d, want %d, %d",
+					action.ShareUserId, action.PropertyId, tt.wantUser, tt.wantAction)
+			}
+			for _, v := range tt.rule.ConditionTree().AllConditions() {
+				if v.PropertyId != 33 {
+					t.Errorf("remapRule() condition property = %d, want 33", v.PropertyId)
+				}
+			}
+		})
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
@@ -3,31 +3,63 @@ package services
 import (
 	"context"
 	"fmt"
+	"github.com/patrickmn/go-cache"
 	"golang.org/x/crypto/bcrypt"
+	"sync"
 	"time"
 	"tryffel.net/go/virtualpaper/config"
 	"tryffel.net/go/virtualpaper/errors"
 	"tryffel.net/go/virtualpaper/models"
 	"tryffel.net/go/virtualpaper/services/mail"
+	"tryffel.net/go/virtualpaper/services/oidc"
 	"tryffel.net/go/virtualpaper/storage"
 	"tryffel.net/go/virtualpaper/util/logger"
 )
 
 type AuthService struct {
 	db *storage.Database
+
+	oidcLock *sync.Mutex
+	oidc     *oidc.Provider
+	// pending oidc logins by state
+	oidcLogins *cache.Cache
 }
 
 func NewAuthService(db *storage.Database) *AuthService {
 	return &AuthService{
-		db: db,
+		db:         db,
+		oidcLock:   &sync.Mutex{},
+		oidcLogins: cache.New(oidcLoginTimeout, oidcLoginTimeout),
 	}
 }
 
-func (service *AuthService) Login(ctx context.Context, username, password, userAgent, ipAddrs string) (*models.Token, error) {
+// Login authenticates user with password and, if user has enabled it, two-factor code.
+// If code is missing, returned error satisfies IsTwoFactorRequired.
+func (service *AuthService) Login(ctx context.Context, username, password, code, userAgent, ipAddrs string) (*models.Token, error) {
 	userId, err := service.db.UserStore.TryLogin(username, password)
 	if err != nil {
 		return nil, err
 	}
+	err = service.verifySecondFactor(ctx, userId, code)
+	if err != nil {
+		if IsTwoFactorRequired(err) {
+			e := errors.ErrUnauthorized
+			e.ErrMsg = TwoFactorRequiredMsg
+			return nil, e
+		}
+		return nil, err
+	}
+	authToken, err := service.createSession(ctx, userId, userAgent, ipAddrs)
+	if err != nil {
+		return nil, err
+	}
+	logger.Entry(ctx).WithField("user", username).WithField("remoteAddr", ipAddrs).Infof("User logged in")
+	service.notifyLogin(ctx, userId, userAgent, ipAddrs)
+	return authToken, nil
+}
+
+// createSession creates a new login session for the user.
+func (service *AuthService) createSession(ctx context.Context, userId int, userAgent, ipAddrs string) (*models.Token, error) {
 	authToken := &models.Token{
 		Id:            0,
 		UserId:        userId,
@@ -39,7 +71,7 @@ func (service *AuthService) Login(ctx context.Context, username, password, userA
 	if config.C.Api.TokenExpireSec != 0 {
 		authToken.ExpiresAt = time.Now().Add(config.C.Api.TokenExpire)
 	}
-	err = authToken.Init()
+	err := authToken.Init()
 	if err != nil {
 		return nil, fmt.Errorf("init token: %v", err)
 	}
@@ -47,11 +79,15 @@ func (service *AuthService) Login(ctx context.Context, username, password, userA
 	if err != nil {
 		return nil, fmt.Errorf("persist auth token: %v", err)
 	}
+	return authToken, nil
+}
 
-	logger.Entry(ctx).WithField("user", username).WithField("remoteAddr", ipAddrs).Infof("User logged in")
+// notifyLogin sends email about the new login to the user, if user has email.
+func (service *AuthService) notifyLogin(ctx context.Context, userId int, userAgent, ipAddrs string) {
 	user, err := service.db.UserStore.GetUser(userId)
 	if err != nil {
-		return nil, fmt.Errorf("get user: %v", err)
+		logger.Context(ctx).Errorf("get user %d for logged-in email: %v", userId, err)
+		return
 	}
 	if user.Email != "" {
 		logger.Context(ctx).Infof("Send email for logged in user to %s", user.Email)
@@ -67,7 +103,6 @@ user agent: %s,
 			logger.Context(ctx).Errorf("send logged-in email to user: %s: %v", user.Email, err)
 		}
 	}
-	return authToken, nil
 }
 
 func (service *AuthService) GetUserByToken(ctx context.Context, tokenKey string, userId int) (user *models.User, token *models.Token, tokenError error) {
@@ -165,6 +200,31 @@ func (service *AuthService) RevokeToken(ctx context.Context, tokenKey string) er
 	return service.db.AuthStore.RevokeToken(tokenKey)
 }
 
+// GetSessions returns user's active login sessions.
+func (service *AuthService) GetSessions(ctx context.Context, userId int) (*[]models.Token, error) {
+	return service.db.AuthStore.GetUserTokens(userId)
+}
+
+// RevokeSession logs out user's session by its id.
+func (service *AuthService) RevokeSession(ctx context.Context, userId, id int) error {
+	err := service.db.AuthStore.RevokeUserToken(userId, id)
+	if err != nil {
+		return err
+	}
+	logger.Context(ctx).WithField("user", userId).Infof("revoked session %d", id)
+	return nil
+}
+
+// RevokeOtherSessions logs out all user's sessions except the current one.
+func (service *AuthService) RevokeOtherSessions(ctx context.Context, userId int, currentTokenKey string) (int, error) {
+	count, err := service.db.AuthStore.RevokeUserTokens(userId, currentTokenKey)
+	if err != nil {
+		return 0, err
+	}
+	logger.Context(ctx).WithField("user", userId).Infof("revoked %d other sessions", count)
+	return count, nil
+}
+
 func (service *AuthService) CreateResetPasswordToken(ctx context.Context, email string) error {
 	user, err := service.db.UserStore.GetUserByEmail(email)
 	userOk := user != nil && err == nil
@@ -212,13 +272,22 @@ func (service *AuthService) CreateResetPasswordToken(ctx context.Context, email
 	return nil
 }
 
-func (service *AuthService) ConfirmAuthentication(ctx context.Context, user *models.User, password, remoteAddr, tokenKey string) error {
+func (service *AuthService) ConfirmAuthentication(ctx context.Context, user *models.User, password, code, remoteAddr, tokenKey string) error {
 	userId, err := service.db.UserStore.TryLogin(user.Name, password)
 	if userId == -1 || err != nil {
 		logger.Context(ctx).Infof("Failed authentication confirmation for user %d, token %s, from remote %s", user.Id, tokenKey, remoteAddr)
 		return errors.ErrForbidden
 	}
+	err = service.verifySecondFactor(ctx, userId, code)
+	if err != nil {
+		logger.Context(ctx).Infof("Failed two-factor authentication confirmation for user %d, token %s, from remote %s", user.Id, tokenKey, remoteAddr)
+		return err
+	}
+	return service.confirmToken(ctx, user, remoteAddr, tokenKey)
+}
 
+// confirmToken marks the session confirmed after user has authenticated again.
+func (service *AuthService) confirmToken(ctx context.Context, user *models.User, remoteAddr, tokenKey string) error {
 	token, err := service.db.AuthStore.GetToken(tokenKey, true)
 	if err != nil {
 		return err
@@ -259,3 +328,88 @@ func hashPasswordResetToken(token string) (string, error) {
 	}
 	return string(bytes), nil
 }
+
+// apiTokenLastUsedInterval limits how often the last used time of api token is stored.
+const apiTokenLastUsedInterval = time.Minute
+
+// CreateApiToken creates new api token for user. It returns the token, which is not stored and cannot be
+// retrieved later.
+func (service *AuthService) CreateApiToken(ctx context.Context, user *models.User, name string, scopes []string, expiresAt time.Time) (*models.ApiToken, string, error) {
+	if len(scopes) == 0 {
+		e := errors.ErrInvalid
+		e.ErrMsg = "at least one scope is required"
+		return nil, "", e
+	}
+	for _, v := range scopes {
+		if !models.IsValidApiScope(v) {
+			e := errors.ErrInvalid
+			e.ErrMsg = fmt.Sprintf("invalid scope: %s", v)
+			return nil, "", e
+		}
+		if v == models.ApiScopeAdmin && !user.IsAdmin {
+			e := errors.ErrForbidden
+			e.ErrMsg = "only administrators can create tokens with admin scope"
+			return nil, "", e
+		}
+	}
+	if !expiresAt.IsZero() && expiresAt.Before(time.Now()) {
+		e := errors.ErrInvalid
+		e.ErrMsg = "expiration time must be in the future"
+		return nil, "", e
+	}
+
+	secret, err := config.RandomStringCrypt(40)
+	if err != nil {
+		return nil, "", fmt.Errorf("generate token: %v", err)
+	}
+	raw := models.ApiTokenPrefix + secret
+	token := &models.ApiToken{
+		UserId:    user.Id,
+		Name:      name,
+		Hash:      models.HashApiToken(raw),
+		Prefix:    raw[:len(models.ApiTokenPrefix)+6],
+		ExpiresAt: expiresAt,
+	}
+	token.SetScopes(scopes)
+	err = service.db.AuthStore.InsertApiToken(token)
+	if err != nil {
+		return nil, "", err
+	}
+	logger.Context(ctx).WithField("user", user.Id).Infof("created api token %d with scopes %s", token.Id, token.Scopes)
+	return token, raw, nil
+}
+
+func (service *AuthService) GetApiTokens(ctx context.Context, userId int) (*[]models.ApiToken, error) {
+	return service.db.AuthStore.GetApiTokens(userId)
+}
+
+func (service *AuthService) RevokeApiToken(ctx context.Context, userId, id int) error {
+	return service.db.AuthStore.DeleteApiToken(userId, id)
+}
+
+// GetUserByApiToken validates api token and returns its user. Last used time of the token is updated.
+func (service *AuthService) GetUserByApiToken(ctx context.Context, rawToken string, ipAddr string) (*models.User, *models.ApiToken, error) {
+	token, err := service.db.AuthStore.GetApiTokenByHash(models.HashApiToken(rawToken))
+	if err != nil {
+		return nil, nil, err
+	}
+	if token.HasExpired() {
+		return nil, nil, errors.ErrUnauthorized
+	}
+
+	user, err := service.db.UserStore.GetUser(token.UserId)
+	if err != nil {
+		return nil, nil, err
+	}
+	if !user.IsActive {
+		return nil, nil, errors.ErrUnauthorized
+	}
+
+	if time.Since(token.LastUsedAt) > apiTokenLastUsedInterval || token.LastUsedIp != ipAddr {
+		err = service.db.AuthStore.UpdateApiTokenLastUsed(token, time.Now(), ipAddr)
+		if err != nil {
+			logger.Context(ctx).Errorf("update last used time of api token %d: %v", token.Id, err)
+		}
+	}
+	return user, token, nil
+}
@@ -0,0 +1,389 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	"tryffel.net/go/virtualpaper/config"
+	"tryffel.net/go/virtualpaper/errors"
+	"tryffel.net/go/virtualpaper/models"
+	"tryffel.net/go/virtualpaper/services/oidc"
+	"tryffel.net/go/virtualpaper/util/logger"
+)
+
+// user has this much time to authenticate at the identity provider
+const oidcLoginTimeout = time.Minute * 10
+
+// number of invalid two-factor codes allowed for a single login
+const oidcSecondFactorAttempts = 5
+
+type oidcLogin struct {
+	nonce        string
+	codeVerifier string
+	createdAt    time.Time
+	// confirmToken is the session to confirm, if user is re-authenticating instead of logging in.
+	confirmToken string
+	// userId is set once the identity is verified and login is waiting for the two-factor code.
+	userId   int
+	attempts int
+}
+
+// getOidcProvider returns the configured provider. Discovery is done on first use
+// and retried on next login if provider could not be reached.
+func (service *AuthService) getOidcProvider(ctx context.Context) (*oidc.Provider, error) {
+	if !config.C.Oidc.Enabled {
+		e := errors.ErrRecordNotFound
+		e.ErrMsg = "single sign-on is not enabled"
+		return nil, e
+	}
+
+	service.oidcLock.Lock()
+	defer service.oidcLock.Unlock()
+	if service.oidc != nil {
+		return service.oidc, nil
+	}
+
+	provider, err := oidc.NewProvider(ctx, oidc.Config{
+		Issuer:       config.C.Oidc.Issuer,
+		ClientId:     config.C.Oidc.ClientId,
+		ClientSecret: config.C.Oidc.ClientSecret,
+		RedirectUrl:  config.C.Oidc.RedirectUrl,
+		Scopes:       config.C.Oidc.Scopes,
+	}, nil)
+	if err != nil {
+		return nil, fmt.Errorf("init oidc provider: %v", err)
+	}
+	service.oidc = provider
+	return provider, nil
+}
+
+// OidcLoginUrl starts a new single sign-on login and returns the url of the identity provider
+// to redirect the user to.
+func (service *AuthService) OidcLoginUrl(ctx context.Context) (string, error) {
+	return service.newOidcLogin(ctx, "")
+}
+
+// OidcConfirmUrl starts re-authentication of the session at the identity provider and returns the url
+// to redirect the user to. Users that log in with single sign-on have no password to confirm the session with.
+func (service *AuthService) OidcConfirmUrl(ctx context.Context, tokenKey string) (string, error) {
+	return service.newOidcLogin(ctx, tokenKey)
+}
+
+func (service *AuthService) newOidcLogin(ctx context.Context, confirmToken string) (string, error) {
+	provider, err := service.getOidcProvider(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	state, err := oidc.RandomString()
+	if err != nil {
+		return "", fmt.Errorf("generate state: %v", err)
+	}
+	login := &oidcLogin{createdAt: time.Now(), confirmToken: confirmToken}
+	login.nonce, err = oidc.RandomString()
+	if err != nil {
+		return "", fmt.Errorf("generate nonce: %v", err)
+	}
+	login.codeVerifier, err = oidc.RandomString()
+	if err != nil {
+		return "", fmt.Errorf("generate code verifier: %v", err)
+	}
+	service.oidcLogins.SetDefault(state, login)
+	if confirmToken != "" {
+		return provider.ReauthenticateUrl(state, login.nonce, login.codeVerifier), nil
+	}
+	return provider.AuthCodeUrl(state, login.nonce, login.codeVerifier), nil
+}
+
+// OidcLogin completes single sign-on login with the authorization code and state returned by
+// the identity provider. User is linked or created and a new session is returned.
+// If user has enabled two-factor authentication, login fails with TwoFactorRequiredMsg until it is
+// retried with the same state and a valid totpCode.
+func (service *AuthService) OidcLogin(ctx context.Context, code, state, totpCode, userAgent, ipAddrs string) (*models.Token, error) {
+	provider, err := service.getOidcProvider(ctx)
+	if err != nil {
+		return nil, err
+	}
+	login, found := service.takeOidcLogin(state)
+	if !found || login.confirmToken != "" {
+		e := errors.ErrUnauthorized
+		e.ErrMsg = "login has expired, please try again"
+		return nil, e
+	}
+
+	var user *models.User
+	if login.userId != 0 {
+		user, err = service.db.UserStore.GetUser(login.userId)
+	} else {
+		var claims oidc.Claims
+		claims, err = service.verifyOidcCode(ctx, provider, login, code, ipAddrs)
+		if err != nil {
+			return nil, err
+		}
+		user, err = service.getOidcUser(ctx, claims)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if !user.IsActive {
+		logger.Context(ctx).WithField("user", user.Id).Infof("oidc login for inactive user")
+		return nil, errors.ErrUnauthorized
+	}
+
+	err = service.verifyOidcSecondFactor(ctx, state, login, user.Id, totpCode)
+	if err != nil {
+		if IsTwoFactorRequired(err) {
+			e := errors.ErrUnauthorized
+			e.ErrMsg = TwoFactorRequiredMsg
+			return nil, e
+		}
+		return nil, err
+	}
+
+	authToken, err := service.createSession(ctx, user.Id, userAgent, ipAddrs)
+	if err != nil {
+		return nil, err
+	}
+	logger.Entry(ctx).WithField("user", user.Name).WithField("remoteAddr", ipAddrs).Infof("User logged in with oidc")
+	service.notifyLogin(ctx, user.Id, userAgent, ipAddrs)
+	return authToken, nil
+}
+
+// takeOidcLogin returns the pending login for state. State is valid only once,
+// unless login is stored again while waiting for the two-factor code.
+func (service *AuthService) takeOidcLogin(state string) (*oidcLogin, bool) {
+	cached, found := service.oidcLogins.Get(state)
+	if !found {
+		return nil, false
+	}
+	service.oidcLogins.Delete(state)
+	return cached.(*oidcLogin), true
+}
+
+// verifyOidcCode exchanges the authorization code and returns the verified claims of the ID token.
+func (service *AuthService) verifyOidcCode(ctx context.Context, provider *oidc.Provider, login *oidcLogin, code, ipAddrs string) (oidc.Claims, error) {
+	rawIdToken, err := provider.Exchange(ctx, code, login.codeVerifier)
+	if err != nil {
+		logger.Context(ctx).WithField("remoteAddr", ipAddrs).Warnf("oidc code exchange: %v", err)
+		return nil, errors.ErrUnauthorized
+	}
+	claims, err := provider.VerifyIdToken(ctx, rawIdToken, login.nonce)
+	if err != nil {
+		logger.Context(ctx).WithField("remoteAddr", ipAddrs).Warnf("oidc login: %v", err)
+		return nil, errors.ErrUnauthorized
+	}
+	return claims, nil
+}
+
+// OidcConfirmAuthentication confirms the session with the authorization code and state returned by
+// the identity provider after re-authentication started with OidcConfirmUrl. The identity must be linked to the user.
+// If user has enabled two-factor authentication, confirmation can be retried with the same state and totpCode.
+func (service *AuthService) OidcConfirmAuthentication(ctx context.Context, user *models.User, code, state, totpCode, remoteAddr, tokenKey string) error {
+	provider, err := service.getOidcProvider(ctx)
+	if err != nil {
+		return err
+	}
+	login, found := service.takeOidcLogin(state)
+	if !found || login.confirmToken != tokenKey {
+		e := errors.ErrForbidden
+		e.ErrMsg = "authentication has expired, please try again"
+		return e
+	}
+
+	if login.userId == 0 {
+		claims, err := service.verifyOidcCode(ctx, provider, login, code, remoteAddr)
+		if err != nil {
+			return errors.ErrForbidden
+		}
+		if claims.AuthTime().Before(login.createdAt.Add(-time.Minute)) {
+			logger.Context(ctx).Infof("Identity provider did not re-authenticate user %d, auth time: %s", user.Id, claims.AuthTime())
+			return errors.ErrForbidden
+		}
+		identity, err := service.db.UserStore.GetUserIdentity(config.C.Oidc.Issuer, claims.Subject())
+		if err != nil && !errors.Is(err, errors.ErrRecordNotFound) {
+			return err
+		}
+		if err != nil || identity.UserId != user.Id {
+			logger.Context(ctx).Infof("Failed oidc authentication confirmation for user %d, token %s, from remote %s: subject '%s' is not linked to user",
+				user.Id, tokenKey, remoteAddr, claims.Subject())
+			return errors.ErrForbidden
+		}
+	}
+
+	err = service.verifyOidcSecondFactor(ctx, state, login, user.Id, totpCode)
+	if err != nil {
+		logger.Context(ctx).Infof("Failed two-factor authentication confirmation for user %d, token %s, from remote %s", user.Id, tokenKey, remoteAddr)
+		return err
+	}
+	return service.confirmToken(ctx, user, remoteAddr, tokenKey)
+}
+
+// verifyOidcSecondFactor verifies the two-factor code of the user authenticated by the identity provider.
+// Authorization code cannot be exchanged again, so if the code is missing or invalid, the verified login
+// is kept pending for a limited number of attempts and the client can retry with the same state.
+func (service *AuthService) verifyOidcSecondFactor(ctx context.Context, state string, login *oidcLogin, userId int, code string) error {
+	err := service.verifySecondFactor(ctx, userId, code)
+	if err == nil {
+		return nil
+	}
+	login.userId = userId
+	if !IsTwoFactorRequired(err) {
+		if !errors.Is(err, errors.ErrForbidden) {
+			return err
+		}
+		login.attempts++
+		if login.attempts >= oidcSecondFactorAttempts {
+			logger.Context(ctx).WithField("user", userId).Warnf("too many invalid two-factor codes for oidc login")
+			return err
+		}
+	}
+	service.oidcLogins.SetDefault(state, login)
+	return err
+}
+
+// getOidcUser returns the user linked to the identity. Unlinked identities are linked to the user with
+// the same email or, if auto registration is enabled, to a new user. Admin rights are updated from claims.
+func (service *AuthService) getOidcUser(ctx context.Context, claims oidc.Claims) (*models.User, error) {
+	issuer := config.C.Oidc.Issuer
+	email := claims.Email()
+	var user *models.User
+
+	identity, err := service.db.UserStore.GetUserIdentity(issuer, claims.Subject())
+	if err == nil {
+		user, err = service.db.UserStore.GetUser(identity.UserId)
+		if err != nil {
+			return nil, err
+		}
+		identity.LastLogin = time.Now()
+		identity.Email = email
+		err = service.db.UserStore.UpdateUserIdentityLogin(identity)
+		if err != nil {
+			return nil, err
+		}
+	} else if errors.Is(err, errors.ErrRecordNotFound) {
+		user, err = service.linkOidcUser(ctx, claims)
+		if err != nil {
+			return nil, err
+		}
+		identity = &models.UserIdentity{
+			UserId:    user.Id,
+			Issuer:    issuer,
+			Subject:   claims.Subject(),
+			Email:     email,
+			CreatedAt: time.Now(),
+			LastLogin: time.Now(),
+		}
+		err = service.db.UserStore.AddUserIdentity(identity)
+		if err != nil {
+			return nil, err
+		}
+		logger.Context(ctx).Infof("Linked oidc subject '%s' to user %d", identity.Subject, user.Id)
+	} else {
+		return nil, err
+	}
+
+	if config.C.Oidc.AdminClaim != "" {
+		isAdmin := claims.ContainsAny(config.C.Oidc.AdminClaim, config.C.Oidc.AdminValues)
+		if user.IsAdmin != isAdmin {
+			logger.Context(ctx).Infof("Set user %d administrator: %t from oidc claim '%s'", user.Id, isAdmin, config.C.Oidc.AdminClaim)
+			user.IsAdmin = isAdmin
+			err = service.db.UserStore.Update(user)
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+	return user, nil
+}
+
+// linkOidcUser returns the user with same email as the identity, or creates a new user.
+func (service *AuthService) linkOidcUser(ctx context.Context, claims oidc.Claims) (*models.User, error) {
+	email := claims.Email()
+	if email != "" {
+		user, err := service.db.UserStore.GetUserByEmail(email)
+		if err == nil {
+			return user, nil
+		}
+		if !errors.Is(err, errors.ErrRecordNotFound) {
+			return nil, err
+		}
+	}
+
+	if !config.C.Oidc.AutoRegister {
+		logger.Context(ctx).Infof("oidc login for unknown user '%s', auto registration is disabled", claims.Subject())
+		e := errors.ErrUnauthorized
+		e.ErrMsg = "user does not exist"
+		return nil, e
+	}
+
+	username, err := service.newOidcUsername(claims)
+	if err != nil {
+		return nil, err
+	}
+	user := &models.User{
+		Name:     username,
+		Email:    email,
+		IsActive: true,
+	}
+	user.CreatedAt = time.Now()
+	user.Update()
+	err = service.db.UserStore.AddUser(user)
+	if err != nil {
+		return nil, err
+	}
+	logger.Context(ctx).Infof("Created user %d '%s' on first oidc login", user.Id, user.Name)
+	return user, nil
+}
+
+// newOidcUsername returns a free username from the username claim, email or subject.
+func (service *AuthService) newOidcUsername(claims oidc.Claims) (string, error) {
+	base := claims.String(config.C.Oidc.UsernameClaim)
+	if base == "" {
+		base, _, _ = strings.Cut(claims.Email(), "@")
+	}
+	if base == "" {
+		base = claims.Subject()
+	}
+	base = oidcUsername(base)
+
+	for i := 1; i < 100; i++ {
+		username := base
+		if i > 1 {
+			suffix := strconv.Itoa(i)
+			runes := []rune(base)
+			if len(runes)+len(suffix) > 30 {
+				runes = runes[:30-len(suffix)]
+			}
+			username = string(runes) + suffix
+		}
+		_, err := service.db.UserStore.GetUserByName(username)
+		if errors.Is(err, errors.ErrRecordNotFound) {
+			return username, nil
+		}
+		if err != nil {
+			return "", err
+		}
+	}
+	return "", fmt.Errorf("no free username for '%s'", base)
+}
+
+// oidcUsername converts value to a valid username of 4-30 letters and numbers.
+func oidcUsername(value string) string {
+	name := strings.Map(func(r rune) rune {
+		if unicode.IsLetter(r) || unicode.IsNumber(r) {
+			return r
+		}
+		return -1
+	}, value)
+	if len([]rune(name)) > 30 {
+		name = string([]rune(name)[:30])
+	}
+	for len([]rune(name)) < 4 {
+		name += "0"
+	}
+	return name
+}
@@ -0,0 +1,257 @@
+package services
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/dgrijalva/jwt-go"
+	"github.com/stretchr/testify/assert"
+	"tryffel.net/go/virtualpaper/config"
+	"tryffel.net/go/virtualpaper/errors"
+	"tryffel.net/go/virtualpaper/models"
+	"tryffel.net/go/virtualpaper/services/oidc"
+	"tryffel.net/go/virtualpaper/services/totp"
+	"tryffel.net/go/virtualpaper/storage"
+)
+
+const testTotpSecret = "JBSWY3DPEHPK3PXP"
+
+func newTestOidcAuthService(t *testing.T) (*AuthService, sqlmock.Sqlmock) {
+	db, mock, err := storage.NewMockDatabase(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	config.C = &config.Config{}
+	config.C.Oidc.Enabled = true
+	t.Cleanup(func() { config.C = nil })
+	service := NewAuthService(db)
+	service.oidc = &oidc.Provider{}
+	return service, mock
+}
+
+func expectGetUserTotp(mock sqlmock.Sqlmock, userId int) {
+	mock.ExpectQuery("SELECT \\* FROM user_totp WHERE user_id").WithArgs(userId).
+		WillReturnRows(sqlmock.NewRows([]string{"user_id", "secret", "enabled", "last_used_step"}).
+			AddRow(userId, testTotpSecret, true, 0))
+}
+
+func TestAuthService_OidcLoginSecondFactor(t *testing.T) {
+	service, mock := newTestOidcAuthService(t)
+	ctx := context.Background()
+	// identity has been verified, login is waiting for the two-factor code
+	service.oidcLogins.SetDefault("state-1", &oidcLogin{userId: 1})
+
+	mock.ExpectQuery("FROM users").WithArgs(1).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "name", "active"}).AddRow(1, "user", true))
+	expectGetUserTotp(mock, 1)
+	_, err := service.OidcLogin(ctx, "", "state-1", "", "test", "127.0.0.1")
+	assert.True(t, IsTwoFactorRequired(err), "two-factor code required")
+	assert.True(t, errors.Is(err, errors.ErrUnauthorized))
+	_, found := service.oidcLogins.Get("state-1")
+	assert.True(t, found, "login is kept pending")
+
+	for i := 1; i < oidcSecondFactorAttempts; i++ {
+		expectGetUserTotp(mock, 1)
+		_, err = service.OidcLogin(ctx, "", "state-1", "12345", "test", "127.0.0.1")
+		assert.True(t, errors.Is(err, errors.ErrForbidden), "invalid code")
+		_, found = service.oidcLogins.Get("state-1")
+		assert.True(t, found, "login is kept pending after %d invalid codes", i)
+	}
+
+	code, err := totp.Code(testTotpSecret, totp.Step(time.Now()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectGetUserTotp(mock, 1)
+	mock.ExpectExec("UPDATE user_totp SET last_used_step").WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectQuery("INSERT INTO auth_tokens").WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(5))
+	token, err := service.OidcLogin(ctx, "", "state-1", code, "test", "127.0.0.1")
+	if assert.NoError(t, err) {
+		assert.Equal(t, 1, token.UserId)
+	}
+	_, found = service.oidcLogins.Get("state-1")
+	assert.False(t, found, "state is consumed")
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestAuthService_OidcLoginSecondFactorAttempts(t *testing.T) {
+	service, mock := newTestOidcAuthService(t)
+	ctx := context.Background()
+	service.oidcLogins.SetDefault("state-1", &oidcLogin{userId: 1, attempts: oidcSecondFactorAttempts - 1})
+
+	mock.ExpectQuery("FROM users").WithArgs(1).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "name", "active"}).AddRow(1, "user", true))
+	expectGetUserTotp(mock, 1)
+	_, err := service.OidcLogin(ctx, "", "state-1", "12345", "test", "127.0.0.1")
+	assert.True(t, errors.Is(err, errors.ErrForbidden))
+	_, found := service.oidcLogins.Get("state-1")
+	assert.False(t, found, "login is removed after too many invalid codes")
+
+	_, err = service.OidcLogin(ctx, "", "state-1", "12345", "test", "127.0.0.1")
+	assert.True(t, errors.Is(err, errors.ErrUnauthorized), "login has expired")
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+// newTestOidcProvider starts an identity provider that returns an ID token with claims for any authorization code.
+func newTestOidcProvider(t *testing.T, service *AuthService, claims func() jwt.MapClaims) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var server *httptest.Server
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		_ = json.NewEncoder(w).Encode(map[string]string{
+			"issuer":                 server.URL,
+			"authorization_endpoint": server.URL + "/authorize",
+			"token_endpoint":         server.URL + "/token",
+			"jwks_uri":               server.URL + "/keys",
+		})
+	})
+	mux.HandleFunc("/keys", func(w http.ResponseWriter, r *http.Request) {
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{
+			"keys": []map[string]string{{
+				"kid": "key-1",
+				"kty": "RSA",
+				"n":   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+				"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+			}},
+		})
+	})
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		c := claims()
+		c["iss"] = server.URL
+		token := jwt.NewWithClaims(jwt.SigningMethodRS256, c)
+		token.Header["kid"] = "key-1"
+		raw, err := token.SignedString(key)
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		_ = json.NewEncoder(w).Encode(map[string]string{"id_token": raw})
+	})
+	server = httptest.NewServer(mux)
+	t.Cleanup(server.Close)
+
+	config.C.Oidc.Issuer = server.URL
+	service.oidc, err = oidc.NewProvider(context.Background(), oidc.Config{
+		Issuer:   server.URL,
+		ClientId: "virtualpaper",
+		Scopes:   []string{"openid"},
+	}, server.Client())
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestAuthService_OidcConfirmAuthentication(t *testing.T) {
+	user := &models.User{Id: 1, Name: "user", IsActive: true}
+	validClaims := func() jwt.MapClaims {
+		return jwt.MapClaims{
+			"aud":       "virtualpaper",
+			"sub":       "subject-1",
+			"nonce":     "nonce-1",
+			"exp":       time.Now().Add(time.Minute).Unix(),
+			"auth_time": time.Now().Unix(),
+		}
+	}
+	expectIdentity := func(mock sqlmock.Sqlmock, userId int) {
+		mock.ExpectQuery("SELECT \\* FROM user_identities").WithArgs(sqlmock.AnyArg(), "subject-1").
+			WillReturnRows(sqlmock.NewRows([]string{"id", "user_id", "issuer", "subject"}).AddRow(1, userId, "", "subject-1"))
+	}
+
+	tests := []struct {
+		name   string
+		claims func(c jwt.MapClaims)
+		login  *oidcLogin
+		expect func(mock sqlmock.Sqlmock)
+		ok     bool
+	}{
+		{
+			name: "confirmed",
+			expect: func(mock sqlmock.Sqlmock) {
+				expectIdentity(mock, 1)
+				mock.ExpectQuery("SELECT \\* FROM user_totp").WillReturnRows(sqlmock.NewRows([]string{"user_id"}))
+				mock.ExpectQuery("FROM auth_tokens").WithArgs("token-1").
+					WillReturnRows(sqlmock.NewRows([]string{"id", "user_id", "key"}).AddRow(1, 1, "token-1"))
+				mock.ExpectExec("UPDATE auth_tokens SET last_seen").WillReturnResult(sqlmock.NewResult(0, 1))
+				mock.ExpectExec("UPDATE auth_tokens SET last_confirmed").WillReturnResult(sqlmock.NewResult(0, 1))
+			},
+			ok: true,
+		},
+		{
+			name: "identity of other user",
+			expect: func(mock sqlmock.Sqlmock) {
+				expectIdentity(mock, 2)
+			},
+		},
+		{
+			name: "identity not linked",
+			expect: func(mock sqlmock.Sqlmock) {
+				mock.ExpectQuery("SELECT \\* FROM user_identities").WillReturnRows(sqlmock.NewRows([]string{"id"}))
+			},
+		},
+		{
+			name:   "not re-authenticated",
+			claims: func(c jwt.MapClaims) { c["auth_time"] = time.Now().Add(-time.Hour).Unix() },
+		},
+		{
+			name:   "no auth time",
+			claims: func(c jwt.MapClaims) { delete(c, "auth_time") },
+		},
+		{
+			name:  "other session",
+			login: &oidcLogin{nonce: "nonce-1", createdAt: time.Now(), confirmToken: "token-2"},
+		},
+		{
+			name:  "login instead of confirmation",
+			login: &oidcLogin{nonce: "nonce-1", createdAt: time.Now()},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			service, mock := newTestOidcAuthService(t)
+			newTestOidcProvider(t, service, func() jwt.MapClaims {
+				c := validClaims()
+				if tt.claims != nil {
+					tt.claims(c)
+				}
+				return c
+			})
+			login := tt.login
+			if login == nil {
+				login = &oidcLogin{nonce: "nonce-1", createdAt: time.Now(), confirmToken: "token-1"}
+			}
+			service.oidcLogins.SetDefault("state-1", login)
+			if tt.expect != nil {
+				tt.expect(mock)
+			}
+
+			err := service.OidcConfirmAuthentication(context.Background(), user, "code-1", "state-1", "", "127.0.0.1", "token-1")
+			if tt.ok {
+				assert.NoError(t, err)
+			} else {
+				assert.True(t, errors.Is(err, errors.ErrForbidden), "forbidden, got: %v", err)
+			}
+			_, found := service.oidcLogins.Get("state-1")
+			assert.False(t, found, "state is consumed")
+			assert.NoError(t, mock.ExpectationsWereMet())
+		})
+	}
+}
+
+func TestAuthService_OidcLoginWithConfirmation(t *testing.T) {
+	service, _ := newTestOidcAuthService(t)
+	service.oidcLogins.SetDefault("state-1", &oidcLogin{nonce: "nonce-1", createdAt: time.Now(), confirmToken: "token-1"})
+	_, err := service.OidcLogin(context.Background(), "code-1", "state-1", "", "test", "127.0.0.1")
+	assert.True(t, errors.Is(err, errors.ErrUnauthorized), "confirmation state cannot be used to log in")
+}
@@ -0,0 +1,217 @@
+package services
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base32"
+	"fmt"
+	"strings"
+	"time"
+
+	"tryffel.net/go/virtualpaper/errors"
+	"tryffel.net/go/virtualpaper/models"
+	"tryffel.net/go/virtualpaper/services/totp"
+	"tryffel.net/go/virtualpaper/util/logger"
+)
+
+const (
+	totpIssuer        = "Virtualpaper"
+	recoveryCodeCount = 10
+
+	// TwoFactorRequiredMsg is the error message when user has to provide two-factor code.
+	TwoFactorRequiredMsg = "two-factor code required"
+)
+
+// IsTwoFactorRequired returns true if authentication failed only because of missing two-factor code.
+func IsTwoFactorRequired(err error) bool {
+	appErr := errors.Error{}
+	return errors.As(err, &appErr) && appErr.ErrMsg == TwoFactorRequiredMsg
+}
+
+type TwoFactorStatus struct {
+	Enabled bool
+	// RecoveryCodes is the number of unused recovery codes.
+	RecoveryCodes int
+}
+
+type TotpEnrollment struct {
+	Secret string
+	// Url is the otpauth url for authenticator apps.
+	Url string
+}
+
+func (service *AuthService) GetTwoFactorStatus(ctx context.Context, userId int) (*TwoFactorStatus, error) {
+	status := &TwoFactorStatus{}
+	userTotp, err := service.db.AuthStore.GetUserTotp(userId)
+	if errors.Is(err, errors.ErrRecordNotFound) {
+		return status, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	status.Enabled = userTotp.Enabled
+	if status.Enabled {
+		status.RecoveryCodes, err = service.db.AuthStore.CountRecoveryCodes(userId)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return status, nil
+}
+
+// StartTotpEnrollment creates a new totp secret for the user. Totp is enabled after user
+// has verified the secret with EnableTotp.
+func (service *AuthService) StartTotpEnrollment(ctx context.Context, user *models.User) (*TotpEnrollment, error) {
+	status, err := service.GetTwoFactorStatus(ctx, user.Id)
+	if err != nil {
+		return nil, err
+	}
+	if status.Enabled {
+		e := errors.ErrInvalid
+		e.ErrMsg = "two-factor authentication is already enabled"
+		return nil, e
+	}
+
+	secret, err := totp.NewSecret()
+	if err != nil {
+		return nil, fmt.Errorf("generate totp secret: %v", err)
+	}
+	err = service.db.AuthStore.SaveUserTotp(&models.UserTotp{UserId: user.Id, Secret: secret})
+	if err != nil {
+		return nil, err
+	}
+	return &TotpEnrollment{
+		Secret: secret,
+		Url:    totp.Url(totpIssuer, user.Name, secret),
+	}, nil
+}
+
+// EnableTotp enables two-factor authentication after verifying the code of the new secret.
+// It returns new recovery codes, which are not stored in plain text.
+func (service *AuthService) EnableTotp(ctx context.Context, userId int, code string) ([]string, error) {
+	userTotp, err := service.db.AuthStore.GetUserTotp(userId)
+	if err != nil {
+		if errors.Is(err, errors.ErrRecordNotFound) {
+			e := errors.ErrInvalid
+			e.ErrMsg = "two-factor enrollment has not been started"
+			return nil, e
+		}
+		return nil, err
+	}
+	if userTotp.Enabled {
+		e := errors.ErrInvalid
+		e.ErrMsg = "two-factor authentication is already enabled"
+		return nil, e
+	}
+
+	step, ok := totp.Validate(userTotp.Secret, code, time.Now())
+	if !ok {
+		e := errors.ErrInvalid
+		e.ErrMsg = "invalid code"
+		return nil, e
+	}
+	userTotp.Enabled = true
+	userTotp.LastUsedStep = step
+	err = service.db.AuthStore.SaveUserTotp(userTotp)
+	if err != nil {
+		return nil, err
+	}
+	logger.Context(ctx).WithField("user", userId).Infof("enabled two-factor authentication")
+	return service.RegenerateRecoveryCodes(ctx, userId)
+}
+
+// DisableTotp disables two-factor authentication and removes recovery codes.
+func (service *AuthService) DisableTotp(ctx context.Context, userId int) error {
+	err := service.db.AuthStore.DeleteUserTotp(userId)
+	if err != nil {
+		return err
+	}
+	logger.Context(ctx).WithField("user", userId).Infof("disabled two-factor authentication")
+	return nil
+}
+
+// RegenerateRecoveryCodes replaces user's recovery codes and returns the new codes.
+func (service *AuthService) RegenerateRecoveryCodes(ctx context.Context, userId int) ([]string, error) {
+	status, err := service.GetTwoFactorStatus(ctx, userId)
+	if err != nil {
+		return nil, err
+	}
+	if !status.Enabled {
+		e := errors.ErrInvalid
+		e.ErrMsg = "two-factor authentication is not enabled"
+		return nil, e
+	}
+
+	codes := make([]string, recoveryCodeCount)
+	hashes := make([]string, recoveryCodeCount)
+	for i := range codes {
+		codes[i], err = newRecoveryCode()
+		if err != nil {
+			return nil, fmt.Errorf("generate recovery code: %v", err)
+		}
+		hashes[i] = models.HashRecoveryCode(codes[i])
+	}
+	err = service.db.AuthStore.ReplaceRecoveryCodes(userId, hashes)
+	if err != nil {
+		return nil, err
+	}
+	return codes, nil
+}
+
+// verifySecondFactor checks totp or recovery code, if user has two-factor authentication enabled.
+// Each code is accepted only once.
+func (service *AuthService) verifySecondFactor(ctx context.Context, userId int, code string) error {
+	userTotp, err := service.db.AuthStore.GetUserTotp(userId)
+	if errors.Is(err, errors.ErrRecordNotFound) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if !userTotp.Enabled {
+		return nil
+	}
+
+	code = strings.TrimSpace(code)
+	if code == "" {
+		e := errors.ErrForbidden
+		e.ErrMsg = TwoFactorRequiredMsg
+		return e
+	}
+
+	ok := false
+	if step, valid := totp.Validate(userTotp.Secret, code, time.Now()); valid {
+		ok, err = service.db.AuthStore.UseTotpStep(userId, step)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			logger.Context(ctx).WithField("user", userId).Warnf("reused totp code")
+		}
+	} else if len(code) > totp.Digits {
+		ok, err = service.db.AuthStore.UseRecoveryCode(userId, models.HashRecoveryCode(code))
+		if err != nil {
+			return err
+		}
+		if ok {
+			logger.Context(ctx).WithField("user", userId).Warnf("authenticated with recovery code")
+		}
+	}
+	if !ok {
+		e := errors.ErrForbidden
+		e.ErrMsg = "invalid two-factor code"
+		return e
+	}
+	return nil
+}
+
+// newRecoveryCode returns random code in format xxxxx-xxxxx.
+func newRecoveryCode() (string, error) {
+	data := make([]byte, 8)
+	_, err := rand.Read(data)
+	if err != nil {
+		return "", err
+	}
+	code := strings.ToLower(base32.StdEncoding.EncodeToString(data))[:10]
+	return code[:5] + "-" + code[5:], nil
+}
@@ -0,0 +1,61 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+
+	"tryffel.net/go/virtualpaper/errors"
+	"tryffel.net/go/virtualpaper/models"
+	"tryffel.net/go/virtualpaper/services/process"
+	"tryffel.net/go/virtualpaper/util/logger"
+)
+
+// importMailAttachments uploads supported attachments of the email document as new documents and links them
+// to the email. Attachments that already exist are linked as well. File is the local copy of the email.
+func (service *DocumentService) importMailAttachments(ctx context.Context, doc *models.Document, file string) error {
+	msg, err := process.ParseMailFile(doc.Mimetype, file)
+	if err != nil {
+		return fmt.Errorf("parse email: %v", err)
+	}
+
+	description := fmt.Sprintf("Attachment of email '%s'", msg.Subject)
+	links := make([]string, 0, len(msg.Attachments))
+	isLinked := map[string]bool{doc.Id: true}
+	for i, attachment := range msg.Attachments {
+		filename := attachment.Filename
+		if filename == "" {
+			filename = fmt.Sprintf("attachment %d", i+1)
+		}
+		mimetype := process.MimeTypeFromName(filename)
+		if mimetype == "" {
+			mimetype = attachment.Mimetype
+		}
+
+		child, err := service.UploadFile(ctx, &UploadedFile{
+			UserId:       doc.UserId,
+			Filename:     filename,
+			Mimetype:     mimetype,
+			Size:         int64(len(attachment.Data)),
+			File:         io.NopCloser(bytes.NewReader(attachment.Data)),
+			Description:  description,
+			OcrLanguages: doc.OcrLanguages,
+		})
+		if errors.Is(err, errors.ErrInvalid) {
+			logger.Context(ctx).Infof("skip unsupported email attachment '%s'", filename)
+			continue
+		}
+		if err != nil && !errors.Is(err, errors.ErrAlreadyExists) {
+			return fmt.Errorf("upload attachment '%s': %v", filename, err)
+		}
+		if !isLinked[child.Id] {
+			isLinked[child.Id] = true
+			links = append(links, child.Id)
+		}
+	}
+	if len(links) == 0 {
+		return nil
+	}
+	return service.db.MetadataStore.UpdateLinkedDocuments(service.db, doc.UserId, doc.Id, links)
+}
@@ -0,0 +1,131 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"tryffel.net/go/virtualpaper/config"
+	"tryffel.net/go/virtualpaper/errors"
+	"tryffel.net/go/virtualpaper/models"
+	"tryffel.net/go/virtualpaper/util/logger"
+)
+
+const maxShareLinkDuration = time.Hour * 24 * 365
+
+type NewShareLink struct {
+	Name         string
+	ExpiresAt    time.Time
+	Password     string
+	AllowContent bool
+}
+
+// CreateShareLink creates a public link to the document. It returns the link and its token,
+// which is not stored and cannot be retrieved later.
+func (service *DocumentService) CreateShareLink(ctx context.Context, userId int, docId string, req *NewShareLink) (*models.DocumentShareLink, string, error) {
+	if !req.ExpiresAt.After(time.Now()) {
+		e := errors.ErrInvalid
+		e.ErrMsg = "expiration time must be in the future"
+		return nil, "", e
+	}
+	if req.ExpiresAt.After(time.Now().Add(maxShareLinkDuration)) {
+		e := errors.ErrInvalid
+		e.ErrMsg = "share link can be valid for a year at most"
+		return nil, "", e
+	}
+	doc, err := service.db.DocumentStore.GetDocument(service.db, docId)
+	if err != nil {
+		return nil, "", err
+	}
+	if doc.DeletedAt.Valid {
+		return nil, "", errors.ErrRecordNotFound
+	}
+
+	token, err := config.RandomStringCrypt(40)
+	if err != nil {
+		return nil, "", fmt.Errorf("generate token: %v", err)
+	}
+	link := &models.DocumentShareLink{
+		DocumentId:   docId,
+		UserId:       userId,
+		Name:         req.Name,
+		Hash:         models.HashShareLinkToken(token),
+		AllowContent: req.AllowContent,
+		ExpiresAt:    req.ExpiresAt,
+	}
+	err = link.SetPassword(req.Password)
+	if err != nil {
+		return nil, "", fmt.Errorf("hash password: %v", err)
+	}
+	err = service.db.DocumentStore.AddShareLink(service.db, link)
+	if err != nil {
+		return nil, "", err
+	}
+	logger.Context(ctx).WithField("user", userId).WithField("documentId", docId).
+		Infof("created share link %d, expires at %s", link.Id, link.ExpiresAt)
+	return link, token, nil
+}
+
+func (service *DocumentService) GetShareLinks(ctx context.Context, docId string) (*[]models.DocumentShareLink, error) {
+	return service.db.DocumentStore.GetShareLinks(service.db, docId)
+}
+
+// RevokeShareLink disables the link. Revoked links are kept for the access history.
+func (service *DocumentService) RevokeShareLink(ctx context.Context, userId int, docId string, id int) error {
+	err := service.db.DocumentStore.RevokeShareLink(service.db, userId, docId, id)
+	if err != nil {
+		return err
+	}
+	logger.Context(ctx).WithField("user", userId).WithField("documentId", docId).Infof("revoked share link %d", id)
+	return nil
+}
+
+// GetShareLinkAccess returns the access history of the document's share link.
+func (service *DocumentService) GetShareLinkAccess(ctx context.Context, docId string, id int) (*[]models.DocumentShareLinkAccess, error) {
+	_, err := service.db.DocumentStore.GetShareLink(service.db, docId, id)
+	if err != nil {
+		return nil, err
+	}
+	return service.db.DocumentStore.GetShareLinkAccess(service.db, id)
+}
+
+// GetSharedDocument returns active share link and its document by the link token.
+func (service *DocumentService) GetSharedDocument(ctx context.Context, token string) (*models.DocumentShareLink, *models.Document, error) {
+	notFound := errors.ErrRecordNotFound
+	notFound.ErrMsg = "link does not exist or has expired"
+
+	link, err := service.db.DocumentStore.GetShareLinkByHash(service.db, models.HashShareLinkToken(token))
+	if err != nil {
+		if errors.Is(err, errors.ErrRecordNotFound) {
+			return nil, nil, notFound
+		}
+		return nil, nil, err
+	}
+	if !link.IsActive() {
+		return nil, nil, notFound
+	}
+	doc, err := service.db.DocumentStore.GetDocument(service.db, link.DocumentId)
+	if err != nil {
+		return nil, nil, err
+	}
+	if doc.DeletedAt.Valid {
+		return nil, nil, notFound
+	}
+	return link, doc, nil
+}
+
+// RecordShareLinkAccess adds an audit record of the share link usage. Views, downloads and content views
+// are counted as accesses.
+func (service *DocumentService) RecordShareLinkAccess(ctx context.Context, link *models.DocumentShareLink, action, ipAddr, userAgent string) {
+	count := action == models.ShareLinkAccessView || action == models.ShareLinkAccessDownload ||
+		action == models.ShareLinkAccessContent
+	err := service.db.DocumentStore.AddShareLinkAccess(service.db, &models.DocumentShareLinkAccess{
+		LinkId:    link.Id,
+		Action:    action,
+		IpAddr:    ipAddr,
+		UserAgent: userAgent,
+	}, count)
+	if err != nil {
+		logger.Context(ctx).Errorf("record share link %d access: %v", link.Id, err)
+	}
+}
@@ -0,0 +1,470 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+
+	"tryffel.net/go/virtualpaper/config"
+	"tryffel.net/go/virtualpaper/errors"
+	"tryffel.net/go/virtualpaper/models"
+	"tryffel.net/go/virtualpaper/services/process"
+	"tryffel.net/go/virtualpaper/storage"
+	"tryffel.net/go/virtualpaper/storage/blob"
+	"tryffel.net/go/virtualpaper/util/logger"
+)
+
+// SplitDocumentRequest describes how document is split. Either Ranges, SplitAtBlankPages or SeparatorText
+// must be set.
+type SplitDocumentRequest struct {
+	// Ranges are the pages of each new document.
+	Ranges []models.PageRange
+	// SplitAtBlankPages splits document at blank pages. Blank pages are not included in new documents.
+	SplitAtBlankPages bool
+	// SeparatorText splits document at pages that contain the text. Separator pages are not included
+	// in new documents. Requires document to be processed.
+	SeparatorText string
+	// DeleteOriginal moves the original document to trash once it has been split.
+	DeleteOriginal bool
+}
+
+// MergeDocumentsRequest describes documents that are merged into a new document.
+type MergeDocumentsRequest struct {
+	// DocumentIds are the documents to merge, in the order of pages in new document.
+	DocumentIds []string
+	// Name of the new document. Defaults to the name of the first document.
+	Name string
+	// DeleteOriginals moves the merged documents to trash.
+	DeleteOriginals bool
+}
+
+// SplitDocument creates a new document of each page range of the pdf document. Metadata, properties
+// and links of the document are copied to the new documents, which are then processed as new documents.
+func (service *DocumentService) SplitDocument(ctx context.Context, userId int, docId string, req *SplitDocumentRequest) ([]*models.Document, error) {
+	doc, err := service.db.DocumentStore.GetDocument(service.db, docId)
+	if err != nil {
+		return nil, err
+	}
+	if doc.DeletedAt.Valid {
+		return nil, errors.ErrRecordNotFound
+	}
+	if doc.Mimetype != "application/pdf" {
+		e := errors.ErrInvalid
+		e.ErrMsg = "only pdf documents can be split"
+		return nil, e
+	}
+
+	dir, err := splitTempDir()
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(dir)
+
+	key, err := service.splitSourceKey(ctx, docId, req)
+	if err != nil {
+		return nil, err
+	}
+	input, cleanup, err := blob.LocalFile(ctx, service.db.Files, key, path.Join(dir, "original.pdf"))
+	if err != nil {
+		return nil, fmt.Errorf("get document file: %v", err)
+	}
+	defer cleanup()
+
+	ranges := req.Ranges
+	pageCount := doc.PageCount
+	if req.SplitAtBlankPages || req.SeparatorText != "" {
+		var separators []int
+		if req.SplitAtBlankPages {
+			pageCount, separators, err = process.BlankPages(ctx, input, path.Join(dir, "pages"))
+			if err != nil {
+				return nil, fmt.Errorf("detect blank pages: %v", err)
+			}
+		} else {
+			pageCount, separators, err = service.separatorPages(docId, req.SeparatorText)
+			if err != nil {
+				return nil, err
+			}
+		}
+		if len(separators) == 0 {
+			e := errors.ErrInvalid
+			e.ErrMsg = "no separator pages found"
+			return nil, e
+		}
+		ranges = models.PageRangesBetween(pageCount, separators)
+	}
+	err = models.ValidatePageRanges(ranges, pageCount)
+	if err != nil {
+		return nil, err
+	}
+
+	logger.Context(ctx).WithField("documentId", docId).WithField("user", userId).Infof("split document to %d documents", len(ranges))
+	files, err := process.SplitPdf(ctx, input, path.Join(dir, "parts"), ranges)
+	if err != nil {
+		return nil, fmt.Errorf("split pdf: %v", err)
+	}
+
+	docs := make([]*models.Document, 0, len(files))
+	newIds := make([]string, 0, len(files))
+	for i, file := range files {
+		newDoc := &models.Document{
+			UserId:       userId,
+			Name:         fmt.Sprintf("%s (pages %s)", doc.Name, ranges[i]),
+			Description:  doc.Description,
+			Filename:     fmt.Sprintf("%s-%s.pdf", strings.TrimSuffix(doc.Filename, path.Ext(doc.Filename)), ranges[i]),
+			Mimetype:     doc.Mimetype,
+			Date:         doc.Date,
+			Lang:         doc.Lang,
+			OcrLanguages: doc.OcrLanguages,
+		}
+		history := fmt.Sprintf("%s: pages %s", doc.Id, ranges[i])
+		err = service.createDerivedDocument(ctx, userId, newDoc, file, []*models.Document{doc}, !req.DeleteOriginal,
+			models.DocumentHistoryActionSplit, history)
+		if err != nil {
+			return docs, fmt.Errorf("create document of pages %s: %v", ranges[i], err)
+		}
+		docs = append(docs, newDoc)
+		newIds = append(newIds, newDoc.Id)
+	}
+
+	err = storage.AddDocumentHistoryAction(service.db, service.db.PropertyStore.GetSq(), []models.DocumentHistory{{
+		DocumentId: doc.Id, Action: models.DocumentHistoryActionSplit, OldValue: "", NewValue: strings.Join(newIds, ","),
+	}}, userId)
+	if err != nil {
+		return docs, err
+	}
+	if req.DeleteOriginal {
+		err = service.DeleteDocument(ctx, doc.Id, userId)
+	}
+	return docs, err
+}
+
+// splitSourceKey returns the file to split. Page count and page texts of a preprocessed document are those of
+// the preprocessed file, which may have blank pages removed, so page ranges and separator pages refer to it.
+// Blank pages are detected from the file that is split, which then is the original file.
+func (service *DocumentService) splitSourceKey(ctx context.Context, docId string, req *SplitDocumentRequest) (string, error) {
+	if req.SplitAtBlankPages {
+		return storage.DocumentKey(docId), nil
+	}
+	key := storage.PreprocessedKey(docId)
+	_, err := service.db.Files.Stat(ctx, key)
+	if errors.Is(err, os.ErrNotExist) {
+		return storage.DocumentKey(docId), nil
+	}
+	if err != nil {
+		return "", fmt.Errorf("get preprocessed file: %v", err)
+	}
+	return key, nil
+}
+
+// MergeDocuments combines pages of the pdf documents to a new document. Metadata, properties and links
+// of the documents are copied to the new document, which is then processed as a new document.
+func (service *DocumentService) MergeDocuments(ctx context.Context, userId int, req *MergeDocumentsRequest) (*models.Document, error) {
+	e := errors.ErrInvalid
+	if len(req.DocumentIds) < 2 {
+		e.ErrMsg = "at least two documents are required"
+		return nil, e
+	}
+	unique := make(map[string]bool, len(req.DocumentIds))
+	for _, v := range req.DocumentIds {
+		if unique[v] {
+			e.ErrMsg = fmt.Sprintf("document %s is listed more than once", v)
+			return nil, e
+		}
+		unique[v] = true
+	}
+
+	found, err := service.db.DocumentStore.GetDocumentsById(service.db, userId, req.DocumentIds)
+	if err != nil {
+		return nil, err
+	}
+	docsById := make(map[string]*models.Document, len(*found))
+	for i, v := range *found {
+		docsById[v.Id] = &(*found)[i]
+	}
+	docs := make([]*models.Document, len(req.DocumentIds))
+	for i, id := range req.DocumentIds {
+		doc, ok := docsById[id]
+		if !ok || doc.DeletedAt.Valid {
+			return nil, errors.ErrRecordNotFound
+		}
+		if doc.Mimetype != "application/pdf" {
+			e.ErrMsg = "only pdf documents can be merged"
+			return nil, e
+		}
+		docs[i] = doc
+	}
+
+	dir, err := splitTempDir()
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(dir)
+
+	files := make([]string, len(docs))
+	for i, doc := range docs {
+		file, cleanup, err := blob.LocalFile(ctx, service.db.Files, storage.DocumentKey(doc.Id), path.Join(dir, fmt.Sprintf("%d.pdf", i)))
+		if err != nil {
+			return nil, fmt.Errorf("get document file: %v", err)
+		}
+		defer cleanup()
+		files[i] = file
+	}
+
+	logger.Context(ctx).WithField("user", userId).Infof("merge %d documents", len(docs))
+	output := path.Join(dir, "merged.pdf")
+	err = process.MergePdf(ctx, files, output)
+	if err != nil {
+		return nil, fmt.Errorf("merge pdf: %v", err)
+	}
+
+	first := docs[0]
+	newDoc := &models.Document{
+		UserId:       userId,
+		Name:         req.Name,
+		Description:  first.Description,
+		Filename:     strings.TrimSuffix(first.Filename, path.Ext(first.Filename)) + "-merged.pdf",
+		Mimetype:     first.Mimetype,
+		Date:         first.Date,
+		Lang:         first.Lang,
+		OcrLanguages: first.OcrLanguages,
+	}
+	if newDoc.Name == "" {
+		newDoc.Name = first.Name
+	}
+	err = service.createDerivedDocument(ctx, userId, newDoc, output, docs, !req.DeleteOriginals,
+		models.DocumentHistoryActionMerge, strings.Join(req.DocumentIds, ","))
+	if err != nil {
+		return nil, err
+	}
+
+	history := make([]models.DocumentHistory, len(docs))
+	for i, doc := range docs {
+		history[i] = models.DocumentHistory{
+			DocumentId: doc.Id, Action: models.DocumentHistoryActionMerge, OldValue: "", NewValue: newDoc.Id,
+		}
+	}
+	err = storage.AddDocumentHistoryAction(service.db, service.db.PropertyStore.GetSq(), history, userId)
+	if err != nil {
+		return newDoc, err
+	}
+	if req.DeleteOriginals {
+		for _, doc := range docs {
+			err = service.DeleteDocument(ctx, doc.Id, userId)
+			if err != nil {
+				return newDoc, err
+			}
+		}
+	}
+	return newDoc, nil
+}
+
+// SplitDocumentAtPages splits document to new documents at separator pages, which are not included
+// in the new documents. Pages refer to the preprocessed file, if document has one. Original document is moved to trash.
+func (service *DocumentService) SplitDocumentAtPages(ctx context.Context, userId int, docId string, pageCount int, separators []int) error {
+	_, err := service.SplitDocument(ctx, userId, docId, &SplitDocumentRequest{
+		Ranges:         models.PageRangesBetween(pageCount, separators),
+		DeleteOriginal: true,
+	})
+	return err
+}
+
+// separatorPages returns the number of pages in document and the pages that contain text.
+func (service *DocumentService) separatorPages(docId string, text string) (int, []int, error) {
+	pages, err := service.db.DocumentStore.GetDocumentPages(service.db, docId)
+	if err != nil {
+		return 0, nil, err
+	}
+	if len(pages) == 0 {
+		e := errors.ErrInvalid
+		e.ErrMsg = "document has no extracted pages, process document before splitting"
+		return 0, nil, e
+	}
+	text = strings.ToLower(text)
+	separators := make([]int, 0)
+	pageCount := 0
+	for _, v := range pages {
+		if strings.Contains(strings.ToLower(v.Content), text) {
+			separators = append(separators, v.Page)
+		}
+		if v.Page > pageCount {
+			pageCount = v.Page
+		}
+	}
+	return pageCount, separators, nil
+}
+
+// createDerivedDocument creates a new document of file, which was created from the source documents.
+// Metadata and properties of the sources are copied to the document, and it is linked to the same documents
+// as sources. If linkSources, the document is also linked to the sources. Unique properties are not copied.
+// The document is then scheduled for processing.
+func (service *DocumentService) createDerivedDocument(ctx context.Context, userId int, doc *models.Document, file string,
+	sources []*models.Document, linkSources bool, action string, historyValue string) error {
+	stat, err := os.Stat(file)
+	if err != nil {
+		return fmt.Errorf("stat file: %v", err)
+	}
+	doc.Size = stat.Size()
+	doc.Hash, err = process.GetHash(file)
+	if err != nil {
+		return fmt.Errorf("get hash for file: %v", err)
+	}
+	existingDoc, err := service.db.DocumentStore.GetByHash(userId, doc.Hash)
+	if err != nil && !errors.Is(err, errors.ErrRecordNotFound) {
+		return fmt.Errorf("get existing document by hash: %v", err)
+	}
+	if existingDoc != nil && existingDoc.Id != "" {
+		e := errors.ErrAlreadyExists
+		e.ErrMsg = fmt.Sprintf("identical document already exists: %s", existingDoc.Id)
+		return e
+	}
+
+	err = service.db.DocumentStore.Create(service.db, doc)
+	if err != nil {
+		return err
+	}
+	err = service.copyToDerivedDocument(ctx, userId, doc, file, sources, action, historyValue)
+	if err != nil {
+		// remove partially created document, else retrying would fail as a duplicate
+		service.removeDerivedDocument(ctx, doc.Id)
+		return err
+	}
+
+	isSource := make(map[string]bool, len(sources))
+	for _, source := range sources {
+		isSource[source.Id] = true
+	}
+	links := make([]string, 0)
+	isLinked := make(map[string]bool)
+	for _, source := range sources {
+		if linkSources && !isLinked[source.Id] {
+			isLinked[source.Id] = true
+			links = append(links, source.Id)
+		}
+		linked, err := service.db.MetadataStore.GetLinkedDocuments(userId, source.Id)
+		if err != nil {
+			return fmt.Errorf("get linked documents: %v", err)
+		}
+		for _, v := range linked {
+			if !isLinked[v.DocumentId] && !isSource[v.DocumentId] {
+				isLinked[v.DocumentId] = true
+				links = append(links, v.DocumentId)
+			}
+		}
+	}
+	if len(links) > 0 {
+		err = service.db.MetadataStore.UpdateLinkedDocuments(service.db, userId, doc.Id, links)
+		if err != nil {
+			return fmt.Errorf("copy linked documents: %v", err)
+		}
+	}
+
+	err = service.db.JobStore.ProcessDocumentAllSteps(doc.Id, models.RuleTriggerCreate)
+	if err != nil {
+		return fmt.Errorf("add process steps for new document: %v", err)
+	}
+	service.webhooks.Emit(ctx, models.WebhookEventDocumentCreated, doc.Id)
+	return service.process.AddDocumentForProcessing(doc.Id)
+}
+
+// copyToDerivedDocument stores file of the derived document and copies metadata and properties of the sources to it.
+func (service *DocumentService) copyToDerivedDocument(ctx context.Context, userId int, doc *models.Document, file string,
+	sources []*models.Document, action string, historyValue string) error {
+	err := blob.PutFile(ctx, service.db.Files, storage.DocumentKey(doc.Id), file)
+	if err != nil {
+		return fmt.Errorf("store document file: %v", err)
+	}
+
+	tx, err := storage.NewTx(service.db, ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Close()
+
+	history := []models.DocumentHistory{{DocumentId: doc.Id, Action: action, OldValue: historyValue, NewValue: ""}}
+	metadata := make([]models.Metadata, 0)
+	hasMetadata := make(map[[2]int]bool)
+	hasProperty := make(map[string]bool)
+	properties := make(map[int]*models.Property)
+	for _, source := range sources {
+		sourceMetadata, err := service.db.MetadataStore.GetDocumentMetadata(tx, userId, source.Id)
+		if err != nil {
+			return fmt.Errorf("get metadata: %v", err)
+		}
+		for _, v := range *sourceMetadata {
+			if !hasMetadata[[2]int{v.KeyId, v.ValueId}] {
+				hasMetadata[[2]int{v.KeyId, v.ValueId}] = true
+				metadata = append(metadata, v)
+			}
+		}
+
+		sourceProperties, err := service.db.PropertyStore.GetDocumentProperties(tx, source.Id)
+		if err != nil {
+			return fmt.Errorf("get properties: %v", err)
+		}
+		for _, v := range *sourceProperties {
+			key := fmt.Sprintf("%d:%s", v.Property, v.Value)
+			if hasProperty[key] {
+				continue
+			}
+			property, ok := properties[v.Property]
+			if !ok {
+				property, err = service.db.PropertyStore.GetProperty(tx, v.Property)
+				if err != nil {
+					return fmt.Errorf("get property %d: %v", v.Property, err)
+				}
+				properties[v.Property] = property
+			}
+			if property.Unique {
+				continue
+			}
+			err = service.db.PropertyStore.AddDocumentProperty(tx, property, doc.Id, v.Value, v.Description, v.GeneratedBy, false)
+			if err != nil {
+				return err
+			}
+			hasProperty[key] = true
+			history = append(history, models.DocumentHistory{
+				DocumentId: doc.Id, Action: models.DocumentHistoryActionPropertyAdd, OldValue: "", NewValue: strconv.Itoa(v.Property),
+			})
+		}
+	}
+	if len(metadata) > 0 {
+		err = service.db.MetadataStore.UpdateDocumentKeyValues(tx, userId, doc.Id, metadata)
+		if err != nil {
+			return fmt.Errorf("copy metadata: %v", err)
+		}
+	}
+	err = storage.AddDocumentHistoryAction(tx, service.db.PropertyStore.GetSq(), history, userId)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// removeDerivedDocument deletes document and its files after creating the document failed.
+func (service *DocumentService) removeDerivedDocument(ctx context.Context, docId string) {
+	err := process.DeleteDocument(ctx, service.db.Files, docId)
+	if err != nil {
+		logger.Context(ctx).Errorf("delete files of partially created document %s: %v", docId, err)
+	}
+	err = service.db.DocumentStore.DeleteDocument(docId)
+	if err != nil {
+		logger.Context(ctx).Errorf("delete partially created document %s: %v", docId, err)
+	}
+}
+
+// splitTempDir creates a temporary directory for splitting and merging files.
+func splitTempDir() (string, error) {
+	name, err := config.RandomString(10)
+	if err != nil {
+		return "", fmt.Errorf("generate temporary directory name: %v", err)
+	}
+	dir := storage.TempFilePath(name)
+	err = os.MkdirAll(dir, 0700)
+	if err != nil {
+		return "", fmt.Errorf("create temporary directory: %v", err)
+	}
+	return dir, nil
+}
@@ -0,0 +1,85 @@
+package services
+
+import (
+	"context"
+	"os"
+	"path"
+	"strings"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"tryffel.net/go/virtualpaper/errors"
+	"tryffel.net/go/virtualpaper/models"
+	"tryffel.net/go/virtualpaper/storage"
+	"tryffel.net/go/virtualpaper/storage/blob"
+)
+
+func TestDocumentService_splitSourceKey(t *testing.T) {
+	files := blob.NewLocalStore(t.TempDir())
+	service := &DocumentService{db: &storage.Database{Files: files}}
+	ctx := context.Background()
+	docId := "0a1b2c3d-4e5f-6a7b-8c9d-0e1f2a3b4c5d"
+
+	key, err := service.splitSourceKey(ctx, docId, &SplitDocumentRequest{SeparatorText: "separator"})
+	if err != nil {
+		t.Fatalf("splitSourceKey() error = %v", err)
+	}
+	if key != storage.DocumentKey(docId) {
+		t.Errorf("splitSourceKey() without preprocessed file = %s, want original", key)
+	}
+
+	// pages of a preprocessed document are numbered by the preprocessed file, which has blank pages removed
+	err = files.Put(ctx, storage.PreprocessedKey(docId), strings.NewReader("pdf"), 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, err = service.splitSourceKey(ctx, docId, &SplitDocumentRequest{SeparatorText: "separator"})
+	if err != nil {
+		t.Fatalf("splitSourceKey() error = %v", err)
+	}
+	if key != storage.PreprocessedKey(docId) {
+		t.Errorf("splitSourceKey() with separator text = %s, want preprocessed file", key)
+	}
+	key, _ = service.splitSourceKey(ctx, docId, &SplitDocumentRequest{Ranges: nil})
+	if key != storage.PreprocessedKey(docId) {
+		t.Errorf("splitSourceKey() with ranges = %s, want preprocessed file", key)
+	}
+
+	// blank pages are detected from the split file itself
+	key, _ = service.splitSourceKey(ctx, docId, &SplitDocumentRequest{SplitAtBlankPages: true})
+	if key != storage.DocumentKey(docId) {
+		t.Errorf("splitSourceKey() with blank pages = %s, want original", key)
+	}
+}
+
+func TestDocumentService_createDerivedDocumentFails(t *testing.T) {
+	service, mock, files := newTestVersionService(t)
+	ctx := context.Background()
+	file := path.Join(t.TempDir(), "part.pdf")
+	err := os.WriteFile(file, []byte("pages 1-2"), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expectGetByHash(mock, testFileHash(t, "pages 1-2"), "")
+	mock.ExpectQuery("INSERT INTO documents").WillReturnRows(sqlmock.NewRows([]string{"id"}))
+	mock.ExpectExec("INSERT INTO document_history").WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectBegin()
+	mock.ExpectQuery("FROM documents d").WillReturnError(errors.New("connection lost"))
+	mock.ExpectRollback()
+	mock.ExpectExec("DELETE FROM documents WHERE id").WillReturnResult(sqlmock.NewResult(0, 1))
+
+	doc := &models.Document{UserId: 1, Name: "invoice (pages 1-2)", Mimetype: "application/pdf"}
+	err = service.createDerivedDocument(ctx, 1, doc, file, []*models.Document{testVersionDocument("")}, true,
+		models.DocumentHistoryActionSplit, testVersionDocId+": pages 1-2")
+	if err == nil {
+		t.Fatalf("createDerivedDocument() succeeded, want error")
+	}
+	_, err = files.Stat(ctx, storage.DocumentKey(doc.Id))
+	if !errors.Is(err, blob.ErrNotFound) {
+		t.Errorf("file of failed document exists: %v", err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,221 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strconv"
+
+	"tryffel.net/go/virtualpaper/config"
+	"tryffel.net/go/virtualpaper/errors"
+	"tryffel.net/go/virtualpaper/models"
+	"tryffel.net/go/virtualpaper/services/process"
+	"tryffel.net/go/virtualpaper/storage"
+	"tryffel.net/go/virtualpaper/storage/blob"
+	"tryffel.net/go/virtualpaper/util/logger"
+)
+
+func (service *DocumentService) GetVersions(ctx context.Context, docId string) (*[]models.DocumentVersion, error) {
+	return service.db.DocumentStore.GetDocumentVersions(service.db, docId)
+}
+
+// DocumentVersionFile returns file for previous version of the document.
+func (service *DocumentService) DocumentVersionFile(ctx context.Context, docId string, version int) (*DocumentFile, error) {
+	docVersion, err := service.db.DocumentStore.GetDocumentVersion(service.db, docId, version)
+	if err != nil {
+		return nil, err
+	}
+
+	key := storage.DocumentVersionKey(docId, docVersion.Version)
+	stat, err := service.db.Files.Stat(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	file, err := service.db.Files.Get(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	return &DocumentFile{
+		File:     file,
+		Size:     stat.Size,
+		Mimetype: docVersion.Mimetype,
+	}, nil
+}
+
+// UploadNewVersion replaces document file with a new file. Previous file is stored as a new version
+// and the document is processed again. Metadata, properties, sharing and links are kept as they are.
+func (service *DocumentService) UploadNewVersion(ctx context.Context, userId int, docId string, file *UploadedFile) (*models.Document, error) {
+	doc, err := service.db.DocumentStore.GetDocument(service.db, docId)
+	if err != nil {
+		return nil, err
+	}
+
+	if !process.MimeTypeIsSupported(file.Mimetype, file.Filename) {
+		e := errors.ErrInvalid
+		e.ErrMsg = fmt.Sprintf("unsupported file type: %v", file.Filename)
+		return nil, e
+	}
+	return service.uploadNewVersion(ctx, userId, doc, file)
+}
+
+// uploadNewVersion replaces file of doc with the uploaded file, unless user already has a document
+// with the same file.
+func (service *DocumentService) uploadNewVersion(ctx context.Context, userId int, doc *models.Document, file *UploadedFile) (*models.Document, error) {
+	tempHash, err := config.RandomString(10)
+	if err != nil {
+		logger.Context(ctx).Errorf("generate temporary hash for document: %v", err)
+		return nil, errors.ErrInternalError
+	}
+	tempFileName := storage.TempFilePath(tempHash)
+	err = writeTempFile(ctx, tempFileName, file)
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(tempFileName)
+
+	hash, err := process.GetHash(tempFileName)
+	if err != nil {
+		return nil, fmt.Errorf("get hash for temp file: %v", err)
+	}
+	if hash == doc.Hash {
+		e := errors.ErrAlreadyExists
+		e.ErrMsg = "file is identical to current version"
+		return nil, e
+	}
+	err = service.checkDuplicateFile(doc.UserId, hash)
+	if err != nil {
+		return nil, err
+	}
+
+	logger.Context(ctx).WithField("documentId", doc.Id).WithField("user", userId).Info("upload new document version")
+	putFile := func(key string) error {
+		return blob.PutFile(ctx, service.db.Files, key, tempFileName)
+	}
+	err = service.replaceDocumentFile(ctx, userId, doc, putFile, models.DocumentVersion{
+		Filename: file.Filename,
+		Hash:     hash,
+		Mimetype: file.Mimetype,
+		Size:     file.Size,
+	}, models.DocumentHistoryActionNewVersion)
+	if err != nil {
+		return nil, err
+	}
+	return doc, nil
+}
+
+// RestoreVersion replaces document file with a previous version. Current file is stored as a new version,
+// so restoring can be reverted.
+func (service *DocumentService) RestoreVersion(ctx context.Context, userId int, docId string, version int) (*models.Document, error) {
+	doc, err := service.db.DocumentStore.GetDocument(service.db, docId)
+	if err != nil {
+		return nil, err
+	}
+	docVersion, err := service.db.DocumentStore.GetDocumentVersion(service.db, docId, version)
+	if err != nil {
+		return nil, err
+	}
+	if docVersion.Hash == doc.Hash {
+		e := errors.ErrAlreadyExists
+		e.ErrMsg = "version is identical to current version"
+		return nil, e
+	}
+	err = service.checkDuplicateFile(doc.UserId, docVersion.Hash)
+	if err != nil {
+		return nil, err
+	}
+
+	logger.Context(ctx).WithField("documentId", docId).WithField("user", userId).Infof("restore document version %d", version)
+	putFile := func(key string) error {
+		return blob.Copy(ctx, service.db.Files, storage.DocumentVersionKey(docId, version), key)
+	}
+	err = service.replaceDocumentFile(ctx, userId, doc, putFile, *docVersion, models.DocumentHistoryActionRestoreVersion)
+	if err != nil {
+		return nil, err
+	}
+	return doc, nil
+}
+
+// checkDuplicateFile returns ErrAlreadyExists if user already has a document with the same file,
+// same as when uploading a new document.
+func (service *DocumentService) checkDuplicateFile(userId int, hash string) error {
+	existingDoc, err := service.db.DocumentStore.GetByHash(userId, hash)
+	if err != nil {
+		return fmt.Errorf("get existing document by hash: %v", err)
+	}
+	if existingDoc.Id != "" {
+		e := errors.ErrAlreadyExists
+		e.ErrMsg = fmt.Sprintf("file is identical to document '%s'", existingDoc.Name)
+		return e
+	}
+	return nil
+}
+
+// replaceDocumentFile archives current document file as a new version and replaces it with file written by putFile.
+// Document is then scheduled for processing.
+func (service *DocumentService) replaceDocumentFile(ctx context.Context, userId int, doc *models.Document,
+	putFile func(key string) error, newFile models.DocumentVersion, action string) error {
+	tx, err := storage.NewTx(service.db, ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Close()
+
+	archived := &models.DocumentVersion{
+		DocumentId: doc.Id,
+		Filename:   doc.Filename,
+		Hash:       doc.Hash,
+		Mimetype:   doc.Mimetype,
+		Size:       doc.Size,
+	}
+	newValue := newFile.Filename
+	if action == models.DocumentHistoryActionRestoreVersion {
+		newValue = strconv.Itoa(newFile.Version)
+	}
+	err = service.db.DocumentStore.AddDocumentVersion(tx, userId, archived, action, newValue)
+	if err != nil {
+		return err
+	}
+
+	doc.Filename = newFile.Filename
+	doc.Hash = newFile.Hash
+	doc.Mimetype = newFile.Mimetype
+	doc.Size = newFile.Size
+	err = service.db.DocumentStore.Update(tx, userId, doc)
+	if err != nil {
+		return err
+	}
+
+	currentKey := storage.DocumentKey(doc.Id)
+	archivedKey := storage.DocumentVersionKey(doc.Id, archived.Version)
+	err = blob.Move(ctx, service.db.Files, currentKey, archivedKey)
+	if err != nil {
+		return fmt.Errorf("move current file to version %d: %v", archived.Version, err)
+	}
+
+	err = putFile(currentKey)
+	if err != nil {
+		logger.Context(ctx).Errorf("replace document file: %v", err)
+		restoreErr := blob.Move(ctx, service.db.Files, archivedKey, currentKey)
+		if restoreErr != nil {
+			logger.Context(ctx).Errorf("restore original document file %s: %v", doc.Id, restoreErr)
+		}
+		return fmt.Errorf("replace document file: %v", err)
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		logger.Context(ctx).Errorf("commit new document version, restore original file: %v", err)
+		restoreErr := blob.Move(ctx, service.db.Files, archivedKey, currentKey)
+		if restoreErr != nil {
+			logger.Context(ctx).Errorf("restore original document file %s: %v", doc.Id, restoreErr)
+		}
+		return err
+	}
+
+	err = service.db.JobStore.ProcessDocumentAllSteps(doc.Id, models.RuleTriggerUpdate)
+	if err != nil {
+		return fmt.Errorf("add process steps for document: %v", err)
+	}
+	service.webhooks.Emit(ctx, models.WebhookEventDocumentUpdated, doc.Id)
+	return service.process.AddDocumentForProcessing(doc.Id)
+}
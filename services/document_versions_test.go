@@ -0,0 +1,272 @@
+package services
+
+import (
+	"context"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"tryffel.net/go/virtualpaper/config"
+	"tryffel.net/go/virtualpaper/errors"
+	"tryffel.net/go/virtualpaper/models"
+	"tryffel.net/go/virtualpaper/services/process"
+	"tryffel.net/go/virtualpaper/storage"
+	"tryffel.net/go/virtualpaper/storage/blob"
+)
+
+const testVersionDocId = "0a1b2c3d-4e5f-6a7b-8c9d-0e1f2a3b4c5d"
+
+func newTestVersionService(t *testing.T) (*DocumentService, sqlmock.Sqlmock, blob.Store) {
+	config.C = &config.Config{Processing: config.Processing{TmpDir: t.TempDir()}}
+	t.Cleanup(func() { config.C = nil })
+	db, mock, err := storage.NewMockDatabase(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	db.Files = blob.NewLocalStore(t.TempDir())
+	service := &DocumentService{db: db, process: &process.Manager{}}
+	return service, mock, db.Files
+}
+
+func putTestFile(t *testing.T, files blob.Store, key, content string) {
+	t.Helper()
+	err := files.Put(context.Background(), key, strings.NewReader(content), int64(len(content)))
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func readTestFile(t *testing.T, files blob.Store, key string) string {
+	t.Helper()
+	reader, err := files.Get(context.Background(), key)
+	if err != nil {
+		t.Fatalf("get %s: %v", key, err)
+	}
+	defer reader.Close()
+	data, err := io.ReadAll(reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
+
+func testFileHash(t *testing.T, content string) string {
+	t.Helper()
+	hash, err := process.GetReaderHash(strings.NewReader(content))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return hash
+}
+
+func testVersionDocument(hash string) *models.Document {
+	return &models.Document{Id: testVersionDocId, UserId: 1, Name: "invoice", Filename: "invoice.pdf",
+		Hash: hash, Mimetype: "application/pdf", Size: 8}
+}
+
+func expectGetTestDocument(mock sqlmock.Sqlmock, hash string) {
+	mock.ExpectQuery("SELECT \\* FROM documents WHERE id = \\$1").WithArgs(testVersionDocId).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "user_id", "name", "filename", "hash", "mimetype", "size"}).
+			AddRow(testVersionDocId, 1, "invoice", "invoice.pdf", hash, "application/pdf", 8))
+}
+
+func expectGetByHash(mock sqlmock.Sqlmock, hash string, existingId string) {
+	rows := sqlmock.NewRows([]string{"id", "user_id", "name"})
+	if existingId != "" {
+		rows.AddRow(existingId, 1, "other invoice")
+	}
+	mock.ExpectQuery("SELECT \\* FROM documents WHERE hash = \\$1 AND user_id = \\$2").WithArgs(hash, 1).
+		WillReturnRows(rows)
+}
+
+// expectReplaceFile expects the queries of replaceDocumentFile until commit. Current file is archived as version.
+func expectReplaceFile(mock sqlmock.Sqlmock, oldHash string, version int) {
+	mock.ExpectBegin()
+	mock.ExpectQuery("INSERT INTO document_versions").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "version", "created_at"}).AddRow(version, version, time.Now()))
+	mock.ExpectExec("INSERT INTO document_history").WillReturnResult(sqlmock.NewResult(0, 1))
+	expectGetTestDocument(mock, oldHash)
+	mock.ExpectExec("UPDATE documents SET").WillReturnResult(sqlmock.NewResult(0, 1))
+}
+
+func uploadedTestFile(content string) *UploadedFile {
+	return &UploadedFile{
+		UserId:   1,
+		Filename: "invoice-v2.pdf",
+		Mimetype: "application/pdf",
+		Size:     int64(len(content)),
+		File:     io.NopCloser(strings.NewReader(content)),
+	}
+}
+
+func TestDocumentService_uploadNewVersion(t *testing.T) {
+	ctx := context.Background()
+	oldHash := testFileHash(t, "original")
+
+	t.Run("new version", func(t *testing.T) {
+		service, mock, files := newTestVersionService(t)
+		putTestFile(t, files, storage.DocumentKey(testVersionDocId), "original")
+		newHash := testFileHash(t, "updated")
+
+		expectGetByHash(mock, newHash, "")
+		expectReplaceFile(mock, oldHash, 1)
+		mock.ExpectCommit()
+		mock.ExpectExec("INSERT INTO process_queue").WillReturnResult(sqlmock.NewResult(0, 1))
+
+		doc, err := service.uploadNewVersion(ctx, 1, testVersionDocument(oldHash), uploadedTestFile("updated"))
+		if err != nil {
+			t.Fatalf("uploadNewVersion() error = %v", err)
+		}
+		if doc.Hash != newHash || doc.Filename != "invoice-v2.pdf" {
+			t.Errorf("uploadNewVersion() document = %s, %s", doc.Filename, doc.Hash)
+		}
+		if got := readTestFile(t, files, storage.DocumentKey(testVersionDocId)); got != "updated" {
+			t.Errorf("current file = %s, want updated", got)
+		}
+		if got := readTestFile(t, files, storage.DocumentVersionKey(testVersionDocId, 1)); got != "original" {
+			t.Errorf("version 1 file = %s, want original", got)
+		}
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Error(err)
+		}
+	})
+
+	t.Run("identical to current file", func(t *testing.T) {
+		service, mock, files := newTestVersionService(t)
+		putTestFile(t, files, storage.DocumentKey(testVersionDocId), "original")
+
+		_, err := service.uploadNewVersion(ctx, 1, testVersionDocument(oldHash), uploadedTestFile("original"))
+		if !errors.Is(err, errors.ErrAlreadyExists) {
+			t.Errorf("uploadNewVersion() error = %v, want ErrAlreadyExists", err)
+		}
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Error(err)
+		}
+	})
+
+	t.Run("identical to other document", func(t *testing.T) {
+		service, mock, files := newTestVersionService(t)
+		putTestFile(t, files, storage.DocumentKey(testVersionDocId), "original")
+		expectGetByHash(mock, testFileHash(t, "other"), "1a1b2c3d-4e5f-6a7b-8c9d-0e1f2a3b4c5d")
+
+		_, err := service.uploadNewVersion(ctx, 1, testVersionDocument(oldHash), uploadedTestFile("other"))
+		if !errors.Is(err, errors.ErrAlreadyExists) {
+			t.Errorf("uploadNewVersion() error = %v, want ErrAlreadyExists", err)
+		}
+		if got := readTestFile(t, files, storage.DocumentKey(testVersionDocId)); got != "original" {
+			t.Errorf("current file = %s, want original", got)
+		}
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Error(err)
+		}
+	})
+
+	t.Run("rollback when commit fails", func(t *testing.T) {
+		service, mock, files := newTestVersionService(t)
+		putTestFile(t, files, storage.DocumentKey(testVersionDocId), "original")
+		newHash := testFileHash(t, "updated")
+
+		expectGetByHash(mock, newHash, "")
+		expectReplaceFile(mock, oldHash, 1)
+		mock.ExpectCommit().WillReturnError(errors.New("connection lost"))
+
+		_, err := service.uploadNewVersion(ctx, 1, testVersionDocument(oldHash), uploadedTestFile("updated"))
+		if err == nil {
+			t.Fatalf("uploadNewVersion() succeeded, want error")
+		}
+		if got := readTestFile(t, files, storage.DocumentKey(testVersionDocId)); got != "original" {
+			t.Errorf("current file after rollback = %s, want original", got)
+		}
+		_, err = files.Stat(ctx, storage.DocumentVersionKey(testVersionDocId, 1))
+		if !errors.Is(err, blob.ErrNotFound) {
+			t.Errorf("version file exists after rollback: %v", err)
+		}
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Error(err)
+		}
+	})
+}
+
+func TestDocumentService_RestoreVersion(t *testing.T) {
+	ctx := context.Background()
+	currentHash := testFileHash(t, "current")
+	versionHash := testFileHash(t, "version 1")
+
+	expectGetVersion := func(mock sqlmock.Sqlmock, hash string) {
+		mock.ExpectQuery("SELECT (.+) FROM document_versions dv").WithArgs(testVersionDocId, 1).
+			WillReturnRows(sqlmock.NewRows([]string{"id", "document_id", "version", "filename", "hash", "mimetype", "size"}).
+				AddRow(1, testVersionDocId, 1, "invoice.pdf", hash, "application/pdf", 9))
+	}
+
+	t.Run("restore", func(t *testing.T) {
+		service, mock, files := newTestVersionService(t)
+		putTestFile(t, files, storage.DocumentKey(testVersionDocId), "current")
+		putTestFile(t, files, storage.DocumentVersionKey(testVersionDocId, 1), "version 1")
+
+		expectGetTestDocument(mock, currentHash)
+		expectGetVersion(mock, versionHash)
+		expectGetByHash(mock, versionHash, "")
+		expectReplaceFile(mock, currentHash, 2)
+		mock.ExpectCommit()
+		mock.ExpectExec("INSERT INTO process_queue").WillReturnResult(sqlmock.NewResult(0, 1))
+
+		doc, err := service.RestoreVersion(ctx, 1, testVersionDocId, 1)
+		if err != nil {
+			t.Fatalf("RestoreVersion() error = %v", err)
+		}
+		if doc.Hash != versionHash {
+			t.Errorf("RestoreVersion() hash = %s, want hash of version 1", doc.Hash)
+		}
+		if got := readTestFile(t, files, storage.DocumentKey(testVersionDocId)); got != "version 1" {
+			t.Errorf("current file = %s, want version 1", got)
+		}
+		if got := readTestFile(t, files, storage.DocumentVersionKey(testVersionDocId, 2)); got != "current" {
+			t.Errorf("version 2 file = %s, want current", got)
+		}
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Error(err)
+		}
+	})
+
+	t.Run("identical to other document", func(t *testing.T) {
+		service, mock, files := newTestVersionService(t)
+		putTestFile(t, files, storage.DocumentKey(testVersionDocId), "current")
+		putTestFile(t, files, storage.DocumentVersionKey(testVersionDocId, 1), "version 1")
+
+		expectGetTestDocument(mock, currentHash)
+		expectGetVersion(mock, versionHash)
+		expectGetByHash(mock, versionHash, "1a1b2c3d-4e5f-6a7b-8c9d-0e1f2a3b4c5d")
+
+		_, err := service.RestoreVersion(ctx, 1, testVersionDocId, 1)
+		if !errors.Is(err, errors.ErrAlreadyExists) {
+			t.Errorf("RestoreVersion() error = %v, want ErrAlreadyExists", err)
+		}
+		if got := readTestFile(t, files, storage.DocumentKey(testVersionDocId)); got != "current" {
+			t.Errorf("current file = %s, want current", got)
+		}
+	})
+
+	t.Run("rollback when file is missing", func(t *testing.T) {
+		service, mock, files := newTestVersionService(t)
+		putTestFile(t, files, storage.DocumentKey(testVersionDocId), "current")
+
+		expectGetTestDocument(mock, currentHash)
+		expectGetVersion(mock, versionHash)
+		expectGetByHash(mock, versionHash, "")
+		expectReplaceFile(mock, currentHash, 2)
+		mock.ExpectRollback()
+
+		_, err := service.RestoreVersion(ctx, 1, testVersionDocId, 1)
+		if err == nil {
+			t.Fatalf("RestoreVersion() succeeded, want error")
+		}
+		if got := readTestFile(t, files, storage.DocumentKey(testVersionDocId)); got != "current" {
+			t.Errorf("current file after rollback = %s, want current", got)
+		}
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Error(err)
+		}
+	})
+}
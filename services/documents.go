@@ -14,7 +14,9 @@ import (
 	"tryffel.net/go/virtualpaper/models/aggregates"
 	"tryffel.net/go/virtualpaper/services/process"
 	"tryffel.net/go/virtualpaper/services/search"
+	"tryffel.net/go/virtualpaper/services/webhook"
 	"tryffel.net/go/virtualpaper/storage"
+	"tryffel.net/go/virtualpaper/storage/blob"
 	"tryffel.net/go/virtualpaper/util/logger"
 )
 
@@ -24,19 +26,25 @@ type UploadedFile struct {
 	Mimetype string
 	Size     int64
 	File     io.ReadCloser
+	// Description is optional initial description for the document
+	Description string
+	// OcrLanguages optionally overrides the languages used for OCR
+	OcrLanguages models.OcrLanguages
 }
 
 type DocumentService struct {
-	db      *storage.Database
-	search  *search.Engine
-	process *process.Manager
+	db       *storage.Database
+	search   *search.Engine
+	process  *process.Manager
+	webhooks *webhook.Dispatcher
 }
 
-func NewDocumentService(db *storage.Database, search *search.Engine, manager *process.Manager) *DocumentService {
+func NewDocumentService(db *storage.Database, search *search.Engine, manager *process.Manager, webhooks *webhook.Dispatcher) *DocumentService {
 	return &DocumentService{
-		db:      db,
-		search:  search,
-		process: manager,
+		db:       db,
+		search:   search,
+		process:  manager,
+		webhooks: webhooks,
 	}
 }
 
@@ -119,15 +127,22 @@ func (service *DocumentService) UploadFile(ctx context.Context, file *UploadedFi
 	}
 
 	document := &models.Document{
-		Id:       "",
-		UserId:   file.UserId,
-		Name:     file.Filename,
-		Content:  "",
-		Filename: file.Filename,
-		Hash:     tempHash,
-		Mimetype: file.Mimetype,
-		Size:     file.Size,
-		Date:     time.Now(),
+		Id:           "",
+		UserId:       file.UserId,
+		Name:         file.Filename,
+		Description:  file.Description,
+		Content:      "",
+		Filename:     file.Filename,
+		Hash:         tempHash,
+		Mimetype:     file.Mimetype,
+		Size:         file.Size,
+		Date:         time.Now(),
+		OcrLanguages: file.OcrLanguages,
+	}
+
+	err = document.OcrLanguages.Validate()
+	if err != nil {
+		return nil, err
 	}
 
 	if !process.MimeTypeIsSupported(file.Mimetype, file.Filename) {
@@ -137,26 +152,10 @@ func (service *DocumentService) UploadFile(ctx context.Context, file *UploadedFi
 	}
 
 	tempFileName := storage.TempFilePath(tempHash)
-	inputFile, err := os.OpenFile(tempFileName, os.O_CREATE|os.O_WRONLY, os.ModePerm)
+	err = writeTempFile(ctx, tempFileName, file)
 	if err != nil {
-		logger.Context(ctx).Errorf("open new file for saving upload: %v", err)
-		//respError(resp, fmt.Errorf("open new file for saving upload: %v", err), handler)
 		return nil, err
 	}
-	n, err := inputFile.ReadFrom(file.File)
-	if err != nil {
-		return nil, fmt.Errorf("write uploaded file to disk: %v", err)
-	}
-
-	if n != file.Size {
-		logger.Context(ctx).Warnf("did not fully read file: %d, got: %d", file.Size, n)
-	}
-
-	err = inputFile.Close()
-	if err != nil {
-		return nil, fmt.Errorf("close file: %v", err)
-	}
-
 	hash, err := process.GetHash(tempFileName)
 	if err != nil {
 		return nil, fmt.Errorf("get hash for temp file: %v", err)
@@ -186,50 +185,103 @@ func (service *DocumentService) UploadFile(ctx context.Context, file *UploadedFi
 		return nil, err
 	}
 
-	newFile := storage.DocumentPath(document.Id)
-	err = storage.CreateDocumentDir(document.Id)
+	err = blob.PutFile(ctx, service.db.Files, storage.DocumentKey(document.Id), tempFileName)
 	if err != nil {
-		return nil, fmt.Errorf("create directory for doc: %v", err)
+		return nil, fmt.Errorf("store document file: %v", err)
 	}
-
-	err = storage.MoveFile(tempFileName, newFile)
+	if document.IsEmail() {
+		err = service.importMailAttachments(ctx, document, tempFileName)
+		if err != nil {
+			// email itself is stored, missing attachments can be uploaded manually
+			logger.Context(ctx).Errorf("import email attachments of document %s: %v", document.Id, err)
+		}
+	}
+	err = os.Remove(tempFileName)
 	if err != nil {
-		return nil, fmt.Errorf("rename temp file by document id: %v", err)
+		logger.Context(ctx).Errorf("remove temp file: %v", err)
 	}
 
 	err = service.db.JobStore.ProcessDocumentAllSteps(document.Id, models.RuleTriggerCreate)
 	if err != nil {
 		return nil, fmt.Errorf("add process steps for new document: %v", err)
 	}
+	service.webhooks.Emit(ctx, models.WebhookEventDocumentCreated, document.Id)
 	err = service.process.AddDocumentForProcessing(document.Id)
 	return document, err
 }
 
+// writeTempFile writes uploaded file to given temporary file.
+func writeTempFile(ctx context.Context, tempFileName string, file *UploadedFile) error {
+	inputFile, err := os.OpenFile(tempFileName, os.O_CREATE|os.O_WRONLY, os.ModePerm)
+	if err != nil {
+		logger.Context(ctx).Errorf("open new file for saving upload: %v", err)
+		//respError(resp, fmt.Errorf("open new file for saving upload: %v", err), handler)
+		return err
+	}
+	n, err := inputFile.ReadFrom(file.File)
+	if err != nil {
+		return fmt.Errorf("write uploaded file to disk: %v", err)
+	}
+
+	if n != file.Size {
+		logger.Context(ctx).Warnf("did not fully read file: %d, got: %d", file.Size, n)
+	}
+
+	err = inputFile.Close()
+	if err != nil {
+		return fmt.Errorf("close file: %v", err)
+	}
+	return nil
+}
+
 type DocumentFile struct {
 	File     io.ReadCloser
 	Size     int64
 	Mimetype string
 }
 
-func (service *DocumentService) DocumentFile(docId string) (*DocumentFile, error) {
+const (
+	DocumentFileOriginal   = "original"
+	DocumentFileSearchable = "searchable"
+)
+
+// DocumentFile returns the document file. Variant is either DocumentFileOriginal or DocumentFileSearchable,
+// latter being the generated pdf with OCR text layer.
+func (service *DocumentService) DocumentFile(docId string, variant string) (*DocumentFile, error) {
+	if variant != DocumentFileOriginal && variant != DocumentFileSearchable {
+		e := errors.ErrInvalid
+		e.ErrMsg = fmt.Sprintf("invalid variant: '%s'", variant)
+		return nil, e
+	}
 	doc, err := service.db.DocumentStore.GetDocument(service.db, docId)
 	if err != nil {
 		return nil, err
 	}
 
-	filePath := storage.DocumentPath(doc.Id)
-	file, err := os.Open(filePath)
+	key := storage.DocumentKey(doc.Id)
+	mimetype := doc.Mimetype
+	if variant == DocumentFileSearchable {
+		key = storage.SearchablePdfKey(doc.Id)
+		mimetype = "application/pdf"
+	}
+	stat, err := service.db.Files.Stat(context.Background(), key)
+	if variant == DocumentFileSearchable && errors.Is(err, os.ErrNotExist) {
+		e := errors.ErrRecordNotFound
+		e.ErrMsg = "searchable pdf not available"
+		return nil, e
+	}
+	if err != nil {
+		return nil, err
+	}
+	file, err := service.db.Files.Get(context.Background(), key)
 	if err != nil {
 		return nil, err
 	}
-
-	stat, err := file.Stat()
-	size := stat.Size()
 
 	return &DocumentFile{
 		File:     file,
-		Size:     size,
-		Mimetype: doc.Mimetype,
+		Size:     stat.Size,
+		Mimetype: mimetype,
 	}, nil
 }
 
@@ -239,19 +291,54 @@ func (service *DocumentService) GetPreview(ctx context.Context, docId string) (i
 		return nil, 0, err
 	}
 
-	filePath := storage.PreviewPath(doc.Id)
-	file, err := os.Open(filePath)
+	key := storage.PreviewKey(doc.Id)
+	stat, err := service.db.Files.Stat(ctx, key)
 	if err != nil {
-		if errors.Is(err, os.ErrNotExist) {
-			return nil, 0, err
-		}
 		return nil, 0, err
 	}
-	stat, err := file.Stat()
+	file, err := service.db.Files.Get(ctx, key)
 	if err != nil {
 		return nil, 0, err
 	}
-	return file, int(stat.Size()), nil
+	return file, int(stat.Size), nil
+}
+
+// GetPageCount returns the number of pages in document file.
+func (service *DocumentService) GetPageCount(ctx context.Context, docId string) (int, error) {
+	doc, err := service.db.DocumentStore.GetDocument(service.db, docId)
+	if err != nil {
+		return 0, err
+	}
+	return doc.PageCount, nil
+}
+
+// GetPagePreview returns preview of a single page. Pages start from 1.
+func (service *DocumentService) GetPagePreview(ctx context.Context, docId string, page int, size models.PreviewSize) (io.ReadCloser, int, error) {
+	if size.Height() == 0 {
+		e := errors.ErrInvalid
+		e.ErrMsg = fmt.Sprintf("invalid preview size: '%s'", size)
+		return nil, 0, e
+	}
+	doc, err := service.db.DocumentStore.GetDocument(service.db, docId)
+	if err != nil {
+		return nil, 0, err
+	}
+	if page < 1 || page > doc.PageCount {
+		e := errors.ErrRecordNotFound
+		e.ErrMsg = fmt.Sprintf("page %d not found", page)
+		return nil, 0, e
+	}
+
+	key := storage.PagePreviewKey(doc.Id, page, size.String())
+	stat, err := service.db.Files.Stat(ctx, key)
+	if err != nil {
+		return nil, 0, err
+	}
+	file, err := service.db.Files.Get(ctx, key)
+	if err != nil {
+		return nil, 0, err
+	}
+	return file, int(stat.Size), nil
 }
 
 func (service *DocumentService) FlushDeletedDocument(ctx context.Context, docId string) error {
@@ -264,7 +351,7 @@ func (service *DocumentService) FlushDeletedDocument(ctx context.Context, docId
 	}
 	document.Update()
 
-	err = process.DeleteDocument(docId)
+	err = process.DeleteDocument(ctx, service.db.Files, docId)
 	if err != nil {
 		return fmt.Errorf("delete file: %v", err)
 	}
@@ -301,6 +388,7 @@ func (service *DocumentService) DeleteDocument(ctx context.Context, docId string
 	if err != nil {
 		return fmt.Errorf("delete document from search index: %v", err)
 	}
+	service.webhooks.Emit(ctx, models.WebhookEventDocumentDeleted, docId)
 	return nil
 }
 
@@ -403,7 +491,14 @@ func (service *DocumentService) BulkEditDocuments(ctx context.Context, req *aggr
 		}
 	}
 	service.process.PullDocumentsToProcess()
-	return tx.Commit()
+	err = tx.Commit()
+	if err != nil {
+		return err
+	}
+	for _, docId := range req.Documents {
+		service.webhooks.Emit(ctx, models.WebhookEventDocumentUpdated, docId)
+	}
+	return nil
 }
 
 func (service *DocumentService) UpdateDocument(ctx context.Context, userId int, docId string, updated *aggregates.DocumentUpdate) (*models.Document, error) {
@@ -473,7 +568,12 @@ func (service *DocumentService) UpdateDocument(ctx context.Context, userId int,
 	if err != nil {
 		return doc, fmt.Errorf("flush document processing: %v", err)
 	}
-	return doc, tx.Commit()
+	err = tx.Commit()
+	if err != nil {
+		return doc, err
+	}
+	service.webhooks.Emit(ctx, models.WebhookEventDocumentUpdated, doc.Id)
+	return doc, nil
 }
 
 func (service *DocumentService) UpdateSharing(ctx context.Context, docId string, sharing *aggregates.DocumentUpdateSharingRequest) error {
@@ -508,11 +608,24 @@ func (service *DocumentService) UpdateSharing(ctx context.Context, docId string,
 	if err != nil {
 		return fmt.Errorf("add document processing: %v", err)
 	}
+	service.webhooks.Emit(ctx, models.WebhookEventDocumentShared, docId)
 	return nil
 }
 
-func (service *DocumentService) RequestProcessing(ctx context.Context, userId int, docId string) error {
-	steps := append(process.RequiredProcessingSteps(models.ProcessRules), models.ProcessRules)
+// RequestProcessing schedules document for processing. If ocrLanguages is not nil, it replaces the document's
+// OCR languages and document content is extracted again.
+func (service *DocumentService) RequestProcessing(ctx context.Context, userId int, docId string, ocrLanguages models.OcrLanguages) error {
+	steps := requestedProcessingSteps(ocrLanguages != nil)
+	if ocrLanguages != nil {
+		err := ocrLanguages.Validate()
+		if err != nil {
+			return err
+		}
+		err = service.db.DocumentStore.SetOcrLanguages(service.db, docId, ocrLanguages)
+		if err != nil {
+			return err
+		}
+	}
 	err := service.db.JobStore.ForceProcessingDocument(service.db, docId, steps)
 	if err != nil {
 		return err
@@ -530,6 +643,40 @@ func (service *DocumentService) RequestProcessing(ctx context.Context, userId in
 	return nil
 }
 
+// requestedProcessingSteps returns the steps to run when user requests processing. Rules are always applied.
+// If content is extracted again, language is detected again and the steps depending on content are run too.
+func requestedProcessingSteps(extractContent bool) []models.ProcessStep {
+	steps := append(process.RequiredProcessingSteps(models.ProcessRules), models.ProcessRules)
+	if extractContent {
+		steps = append(steps, models.ProcessParseContent, models.ProcessDetectLanguage)
+		steps = append(steps, process.RequiredProcessingSteps(models.ProcessParseContent)...)
+		steps = append(steps, process.RequiredProcessingSteps(models.ProcessDetectLanguage)...)
+	}
+
+	unique := make([]models.ProcessStep, 0, len(steps))
+	found := make(map[models.ProcessStep]bool, len(steps))
+	for _, v := range steps {
+		if !found[v] {
+			found[v] = true
+			unique = append(unique, v)
+		}
+	}
+	return unique
+}
+
+// CancelProcessing removes document's pending processing steps and stops processing the document,
+// if it is currently being processed.
+func (service *DocumentService) CancelProcessing(ctx context.Context, docId string) error {
+	err := service.db.JobStore.CancelDocumentProcessing(docId)
+	if err != nil {
+		return err
+	}
+	if service.process.CancelDocumentProcessing(docId) {
+		logger.Context(ctx).Infof("stopped processing document %s", docId)
+	}
+	return nil
+}
+
 func (service *DocumentService) GetHistory(ctx context.Context, userId int, docId string) (*[]models.DocumentHistory, error) {
 	return service.db.DocumentStore.GetDocumentHistory(userId, docId)
 }
@@ -745,7 +892,7 @@ func (service *DocumentService) updateDocumentProperties(tx storage.SqlExecer, u
 				v.Value = propCandidate.Value
 				v.Description = propCandidate.Description
 			}
-			err = service.db.PropertyStore.AddDocumentProperty(tx, prop, docId, v.Value, v.Description, true)
+			err = service.db.PropertyStore.AddDocumentProperty(tx, prop, docId, v.Value, v.Description, "", true)
 			if err != nil {
 				return err
 			}
@@ -0,0 +1,39 @@
+package services
+
+import (
+	"reflect"
+	"sort"
+	"testing"
+
+	"tryffel.net/go/virtualpaper/models"
+)
+
+func Test_requestedProcessingSteps(t *testing.T) {
+	tests := []struct {
+		name           string
+		extractContent bool
+		want           []models.ProcessStep
+	}{
+		{
+			name: "rules",
+			want: []models.ProcessStep{models.ProcessRules, models.ProcessFts},
+		},
+		{
+			name:           "extract content",
+			extractContent: true,
+			want: []models.ProcessStep{models.ProcessParseContent, models.ProcessSearchablePdf,
+				models.ProcessDetectLanguage, models.ProcessExtractFields, models.ProcessRules, models.ProcessFts},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := requestedProcessingSteps(tt.extractContent)
+			sort.Slice(got, func(i, j int) bool {
+				return models.ProcessStepsOrder[got[i]] < models.ProcessStepsOrder[got[j]]
+			})
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("requestedProcessingSteps() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
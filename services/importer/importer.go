@@ -0,0 +1,305 @@
+/*
+ * Virtualpaper is a service to manage users paper documents in virtual format.
+ * Copyright (C) 2024  Tero Vierimaa
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+// Package importer watches directories and imports new files as documents.
+package importer
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"tryffel.net/go/virtualpaper/errors"
+	"tryffel.net/go/virtualpaper/models"
+	"tryffel.net/go/virtualpaper/services"
+	"tryffel.net/go/virtualpaper/services/process"
+	"tryffel.net/go/virtualpaper/storage"
+	"tryffel.net/go/virtualpaper/util/logger"
+)
+
+const (
+	// DirDone is the subdirectory where successfully imported files are moved.
+	DirDone = "done"
+	// DirFailed is the subdirectory where rejected files are moved.
+	DirFailed = "failed"
+
+	reasonFileSuffix = ".log"
+)
+
+// Uploader imports a single file as a new document.
+type Uploader interface {
+	UploadFile(ctx context.Context, file *services.UploadedFile) (*models.Document, error)
+}
+
+// Directory is a watched directory that belongs to a user.
+type Directory struct {
+	UserId int
+	Path   string
+}
+
+// fileState is the last observed state of a file waiting to be imported.
+type fileState struct {
+	size    int64
+	modTime time.Time
+	since   time.Time
+}
+
+// Importer periodically scans directories and imports files that have stopped changing.
+// Files are moved out of the watched directory after importing, and the document hash prevents
+// importing the same file twice if the server is restarted in the middle of importing a file.
+type Importer struct {
+	uploader   Uploader
+	dirs       []Directory
+	interval   time.Duration
+	settleTime time.Duration
+
+	lock    *sync.Mutex
+	pending map[string]*fileState
+	stop    chan bool
+	done    chan bool
+}
+
+func NewImporter(uploader Uploader, dirs []Directory, interval, settleTime time.Duration) *Importer {
+	return &Importer{
+		uploader:   uploader,
+		dirs:       dirs,
+		interval:   interval,
+		settleTime: settleTime,
+		lock:       &sync.Mutex{},
+		pending:    map[string]*fileState{},
+	}
+}
+
+// DirectoriesFromConfig resolves configured username -> directory mapping to directories.
+func DirectoriesFromConfig(db *storage.Database, conf map[string]string) ([]Directory, error) {
+	dirs := make([]Directory, 0, len(conf))
+	if len(conf) == 0 {
+		return dirs, nil
+	}
+
+	users, err := db.UserStore.GetUsers()
+	if err != nil {
+		return dirs, fmt.Errorf("get users: %v", err)
+	}
+
+	for username, dir := range conf {
+		found := false
+		for _, user := range *users {
+			if strings.EqualFold(user.Name, username) {
+				dirs = append(dirs, Directory{UserId: user.Id, Path: dir})
+				found = true
+				break
+			}
+		}
+		if !found {
+			return dirs, fmt.Errorf("import directory %s: user '%s' not found", dir, username)
+		}
+	}
+	return dirs, nil
+}
+
+// Start starts scanning directories in background. Start does nothing if there are no directories.
+func (i *Importer) Start() {
+	if len(i.dirs) == 0 {
+		return
+	}
+
+	i.lock.Lock()
+	defer i.lock.Unlock()
+	if i.stop != nil {
+		return
+	}
+
+	for _, dir := range i.dirs {
+		logrus.Infof("watch import directory %s for user %d", dir.Path, dir.UserId)
+	}
+
+	i.stop = make(chan bool)
+	i.done = make(chan bool)
+	go i.run(i.stop, i.done)
+}
+
+// Stop stops the background scanning and waits for the current scan to finish.
+func (i *Importer) Stop() {
+	i.lock.Lock()
+	stop, done := i.stop, i.done
+	i.stop = nil
+	i.done = nil
+	i.lock.Unlock()
+
+	if stop == nil {
+		return
+	}
+	logrus.Info("stop directory importer")
+	close(stop)
+	<-done
+}
+
+func (i *Importer) run(stop, done chan bool) {
+	defer close(done)
+	ticker := time.NewTicker(i.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			i.Poll(logger.ContextWithTaskId(context.Background(), ""))
+		}
+	}
+}
+
+// Poll scans all directories once and imports files that are ready.
+func (i *Importer) Poll(ctx context.Context) {
+	seen := map[string]bool{}
+	for _, dir := range i.dirs {
+		files, err := i.readyFiles(ctx, dir, seen)
+		if err != nil {
+			logger.Context(ctx).Errorf("scan import directory %s: %v", dir.Path, err)
+			continue
+		}
+		for _, file := range files {
+			i.importFile(ctx, dir, file)
+		}
+	}
+
+	i.lock.Lock()
+	for name := range i.pending {
+		if !seen[name] {
+			delete(i.pending, name)
+		}
+	}
+	i.lock.Unlock()
+}
+
+// readyFiles returns files that have not changed since the previous scan for at least settleTime.
+func (i *Importer) readyFiles(ctx context.Context, dir Directory, seen map[string]bool) ([]string, error) {
+	for _, sub := range []string{DirDone, DirFailed} {
+		err := os.MkdirAll(filepath.Join(dir.Path, sub), os.ModePerm)
+		if err != nil {
+			return nil, fmt.Errorf("create directory: %v", err)
+		}
+	}
+
+	entries, err := os.ReadDir(dir.Path)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	ready := make([]string, 0)
+
+	i.lock.Lock()
+	defer i.lock.Unlock()
+	for _, entry := range entries {
+		// skip subdirectories and hidden files, which are usually partial uploads
+		if !entry.Type().IsRegular() || strings.HasPrefix(entry.Name(), ".") {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			logger.Context(ctx).Warnf("stat import file %s: %v", entry.Name(), err)
+			continue
+		}
+
+		fileName := filepath.Join(dir.Path, entry.Name())
+		seen[fileName] = true
+		state := i.pending[fileName]
+		if state == nil || state.size != info.Size() || !state.modTime.Equal(info.ModTime()) {
+			i.pending[fileName] = &fileState{size: info.Size(), modTime: info.ModTime(), since: now}
+			continue
+		}
+		if now.Sub(state.since) >= i.settleTime {
+			ready = append(ready, fileName)
+			delete(i.pending, fileName)
+		}
+	}
+	return ready, nil
+}
+
+func (i *Importer) importFile(ctx context.Context, dir Directory, fileName string) {
+	name := filepath.Base(fileName)
+	log := logger.Context(ctx).WithField("user", dir.UserId).WithField("file", fileName)
+	log.Info("import file from directory")
+
+	doc, err := i.uploadFile(ctx, dir.UserId, fileName)
+	if err == nil {
+		log.WithField("documentId", doc.Id).Info("file imported")
+		i.moveFile(ctx, fileName, filepath.Join(dir.Path, DirDone, name), fmt.Sprintf("imported as document %s", doc.Id))
+		return
+	}
+
+	var reason string
+	if errors.Is(err, errors.ErrAlreadyExists) && doc != nil {
+		reason = fmt.Sprintf("document already exists: %s", doc.Id)
+	} else if e, ok := err.(errors.Error); ok {
+		reason = e.ErrMsg
+	} else {
+		reason = err.Error()
+	}
+	log.Warnf("import file failed: %s", reason)
+	i.moveFile(ctx, fileName, filepath.Join(dir.Path, DirFailed, name), reason)
+}
+
+func (i *Importer) uploadFile(ctx context.Context, userId int, fileName string) (*models.Document, error) {
+	file, err := os.Open(fileName)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	stat, err := file.Stat()
+	if err != nil {
+		return nil, err
+	}
+
+	name := filepath.Base(fileName)
+	return i.uploader.UploadFile(ctx, &services.UploadedFile{
+		UserId:   userId,
+		Filename: name,
+		Mimetype: process.MimeTypeFromName(name),
+		Size:     stat.Size(),
+		File:     file,
+	})
+}
+
+// moveFile moves the file to target and stores the reason next to it. If target already exists,
+// the file is renamed with a timestamp prefix.
+func (i *Importer) moveFile(ctx context.Context, from, to, reason string) {
+	if _, err := os.Stat(to); err == nil {
+		to = filepath.Join(filepath.Dir(to), fmt.Sprintf("%d-%s", time.Now().UnixNano(), filepath.Base(to)))
+	}
+
+	err := storage.MoveFile(from, to)
+	if err != nil {
+		logger.Context(ctx).Errorf("move imported file %s to %s: %v", from, to, err)
+		return
+	}
+
+	content := fmt.Sprintf("%s: %s\n", time.Now().Format(time.RFC3339), reason)
+	err = os.WriteFile(to+reasonFileSuffix, []byte(content), 0644)
+	if err != nil {
+		logger.Context(ctx).Errorf("write import reason for %s: %v", to, err)
+	}
+}
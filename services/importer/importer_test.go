@@ -0,0 +1,167 @@
+/*
+ * Virtualpaper is a service to manage users paper documents in virtual format.
+ * Copyright (C) 2024  Tero Vierimaa
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package importer
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"tryffel.net/go/virtualpaper/errors"
+	"tryffel.net/go/virtualpaper/models"
+	"tryffel.net/go/virtualpaper/services"
+)
+
+// mockUploader stores documents by content, like DocumentService deduplicates them by hash.
+type mockUploader struct {
+	documents map[string]*models.Document
+	uploads   int
+}
+
+func (m *mockUploader) UploadFile(ctx context.Context, file *services.UploadedFile) (*models.Document, error) {
+	if !strings.HasSuffix(file.Filename, ".pdf") {
+		e := errors.ErrInvalid
+		e.ErrMsg = "unsupported file type: " + file.Filename
+		return nil, e
+	}
+	data, err := io.ReadAll(file.File)
+	if err != nil {
+		return nil, err
+	}
+	if doc, ok := m.documents[string(data)]; ok {
+		return doc, errors.ErrAlreadyExists
+	}
+	m.uploads += 1
+	doc := &models.Document{Id: fmt.Sprintf("doc-%d", m.uploads), UserId: file.UserId, Filename: file.Filename,
+		Description: file.Description}
+	m.documents[string(data)] = doc
+	return doc, nil
+}
+
+func writeFile(t *testing.T, name, content string) {
+	if err := os.WriteFile(name, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func assertExists(t *testing.T, name string) {
+	if _, err := os.Stat(name); err != nil {
+		t.Errorf("expected file %s to exist: %v", name, err)
+	}
+}
+
+func assertNotExists(t *testing.T, name string) {
+	if _, err := os.Stat(name); err == nil {
+		t.Errorf("expected file %s not to exist", name)
+	}
+}
+
+func TestImporter_Poll(t *testing.T) {
+	dir := t.TempDir()
+	uploader := &mockUploader{documents: map[string]*models.Document{}}
+	importer := NewImporter(uploader, []Directory{{UserId: 5, Path: dir}}, time.Second, 0)
+	ctx := context.Background()
+
+	writeFile(t, filepath.Join(dir, "invoice.pdf"), "invoice")
+	writeFile(t, filepath.Join(dir, "notes.exe"), "binary")
+	writeFile(t, filepath.Join(dir, ".partial.pdf"), "partial")
+
+	// first scan only records files
+	importer.Poll(ctx)
+	if uploader.uploads != 0 {
+		t.Fatalf("files imported before they were stable: %d", uploader.uploads)
+	}
+	assertExists(t, filepath.Join(dir, "invoice.pdf"))
+
+	importer.Poll(ctx)
+	if uploader.uploads != 1 {
+		t.Fatalf("expected 1 upload, got %d", uploader.uploads)
+	}
+
+	assertNotExists(t, filepath.Join(dir, "invoice.pdf"))
+	assertExists(t, filepath.Join(dir, DirDone, "invoice.pdf"))
+	assertExists(t, filepath.Join(dir, DirFailed, "notes.exe"))
+	assertExists(t, filepath.Join(dir, ".partial.pdf"))
+
+	reason, err := os.ReadFile(filepath.Join(dir, DirDone, "invoice.pdf"+reasonFileSuffix))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(reason), "doc-1") {
+		t.Errorf("reason does not contain document id: %s", reason)
+	}
+	reason, err = os.ReadFile(filepath.Join(dir, DirFailed, "notes.exe"+reasonFileSuffix))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(reason), "unsupported file type") {
+		t.Errorf("invalid reason for unsupported file: %s", reason)
+	}
+
+	// same file again, e.g. after restart, must not create a new document
+	writeFile(t, filepath.Join(dir, "invoice.pdf"), "invoice")
+	importer.Poll(ctx)
+	importer.Poll(ctx)
+	if uploader.uploads != 1 {
+		t.Errorf("duplicate file imported, uploads: %d", uploader.uploads)
+	}
+	assertNotExists(t, filepath.Join(dir, "invoice.pdf"))
+	assertExists(t, filepath.Join(dir, DirFailed, "invoice.pdf"))
+}
+
+func TestImporter_PollChangingFile(t *testing.T) {
+	dir := t.TempDir()
+	uploader := &mockUploader{documents: map[string]*models.Document{}}
+	importer := NewImporter(uploader, []Directory{{UserId: 5, Path: dir}}, time.Second, 0)
+	ctx := context.Background()
+	fileName := filepath.Join(dir, "scan.pdf")
+
+	writeFile(t, fileName, "page 1")
+	importer.Poll(ctx)
+	writeFile(t, fileName, "page 1, page 2")
+	importer.Poll(ctx)
+	if uploader.uploads != 0 {
+		t.Fatalf("file was imported while it was still being written")
+	}
+	importer.Poll(ctx)
+	if uploader.uploads != 1 {
+		t.Fatalf("expected 1 upload, got %d", uploader.uploads)
+	}
+	assertExists(t, filepath.Join(dir, DirDone, "scan.pdf"))
+}
+
+func TestImporter_PollSettleTime(t *testing.T) {
+	dir := t.TempDir()
+	uploader := &mockUploader{documents: map[string]*models.Document{}}
+	importer := NewImporter(uploader, []Directory{{UserId: 5, Path: dir}}, time.Second, time.Hour)
+	ctx := context.Background()
+
+	writeFile(t, filepath.Join(dir, "scan.pdf"), "content")
+	importer.Poll(ctx)
+	importer.Poll(ctx)
+	if uploader.uploads != 0 {
+		t.Errorf("file was imported before settle time")
+	}
+	assertExists(t, filepath.Join(dir, "scan.pdf"))
+}
@@ -0,0 +1,272 @@
+/*
+ * Virtualpaper is a service to manage users paper documents in virtual format.
+ * Copyright (C) 2024  Tero Vierimaa
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package importer
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/emersion/go-imap"
+	"github.com/emersion/go-imap/client"
+	"tryffel.net/go/virtualpaper/config"
+	"tryffel.net/go/virtualpaper/errors"
+	"tryffel.net/go/virtualpaper/services"
+	"tryffel.net/go/virtualpaper/services/process"
+	"tryffel.net/go/virtualpaper/storage"
+	"tryffel.net/go/virtualpaper/util/logger"
+)
+
+// MailAccount is an IMAP account that is polled for new documents.
+type MailAccount struct {
+	UserId int
+	config.MailImportAccount
+}
+
+// MailImporter imports supported attachments from unseen IMAP messages.
+// Processed messages are either marked as seen or moved to a separate folder.
+type MailImporter struct {
+	uploader Uploader
+	accounts []MailAccount
+}
+
+func NewMailImporter(uploader Uploader, accounts []MailAccount) *MailImporter {
+	return &MailImporter{
+		uploader: uploader,
+		accounts: accounts,
+	}
+}
+
+// MailAccountsFromConfig resolves users for configured mail accounts.
+func MailAccountsFromConfig(db *storage.Database, conf []config.MailImportAccount) ([]MailAccount, error) {
+	accounts := make([]MailAccount, 0, len(conf))
+	if len(conf) == 0 {
+		return accounts, nil
+	}
+
+	users, err := db.UserStore.GetUsers()
+	if err != nil {
+		return accounts, fmt.Errorf("get users: %v", err)
+	}
+
+	for _, account := range conf {
+		found := false
+		for _, user := range *users {
+			if strings.EqualFold(user.Name, account.User) {
+				accounts = append(accounts, MailAccount{UserId: user.Id, MailImportAccount: account})
+				found = true
+				break
+			}
+		}
+		if !found {
+			return accounts, fmt.Errorf("mail import account %s: user '%s' not found", account.Username, account.User)
+		}
+	}
+	return accounts, nil
+}
+
+// HasAccounts returns true if there are any accounts to poll.
+func (m *MailImporter) HasAccounts() bool {
+	return len(m.accounts) > 0
+}
+
+// Poll checks all accounts once. Errors are logged and polling continues with next account.
+// Returns number of documents imported.
+func (m *MailImporter) Poll(ctx context.Context) int {
+	imported := 0
+	for _, account := range m.accounts {
+		count, err := m.pollAccount(ctx, &account)
+		imported += count
+		if err != nil {
+			logger.Context(ctx).WithField("user", account.UserId).WithField("host", account.Host).
+				Errorf("import mail: %v", err)
+		}
+	}
+	return imported
+}
+
+func (m *MailImporter) pollAccount(ctx context.Context, account *MailAccount) (int, error) {
+	addr := fmt.Sprintf("%s:%d", account.Host, account.Port)
+	var c *client.Client
+	var err error
+	if account.NoTLS {
+		c, err = client.Dial(addr)
+	} else {
+		c, err = client.DialTLS(addr, &tls.Config{ServerName: account.Host})
+	}
+	if err != nil {
+		return 0, fmt.Errorf("connect: %v", err)
+	}
+	defer c.Logout()
+	c.Timeout = time.Second * 30
+
+	err = c.Login(account.Username, account.Password)
+	if err != nil {
+		return 0, fmt.Errorf("login: %v", err)
+	}
+
+	imported := 0
+	for _, folder := range account.Folders {
+		count, err := m.pollFolder(ctx, c, account, folder)
+		imported += count
+		if err != nil {
+			return imported, fmt.Errorf("folder %s: %v", folder, err)
+		}
+	}
+	return imported, nil
+}
+
+func (m *MailImporter) pollFolder(ctx context.Context, c *client.Client, account *MailAccount, folder string) (int, error) {
+	_, err := c.Select(folder, false)
+	if err != nil {
+		return 0, fmt.Errorf("select: %v", err)
+	}
+
+	criteria := imap.NewSearchCriteria()
+	criteria.WithoutFlags = []string{imap.SeenFlag, imap.DeletedFlag}
+	uids, err := c.UidSearch(criteria)
+	if err != nil {
+		return 0, fmt.Errorf("search: %v", err)
+	}
+	if len(uids) == 0 {
+		return 0, nil
+	}
+
+	logger.Context(ctx).WithField("user", account.UserId).Infof("found %d new messages in %s", len(uids), folder)
+
+	imported := 0
+	for _, uid := range uids {
+		count, err := m.importMessage(ctx, c, account, uid)
+		imported += count
+		if err != nil {
+			// leave the message unseen and retry on next poll
+			logger.Context(ctx).WithField("user", account.UserId).Errorf("import message %d from %s: %v", uid, folder, err)
+			continue
+		}
+
+		err = m.markProcessed(c, account, uid)
+		if err != nil {
+			return imported, fmt.Errorf("mark message %d processed: %v", uid, err)
+		}
+	}
+	return imported, nil
+}
+
+func (m *MailImporter) importMessage(ctx context.Context, c *client.Client, account *MailAccount, uid uint32) (int, error) {
+	seqSet := new(imap.SeqSet)
+	seqSet.AddNum(uid)
+	section := &imap.BodySectionName{Peek: true}
+
+	messages := make(chan *imap.Message, 1)
+	err := c.UidFetch(seqSet, []imap.FetchItem{section.FetchItem()}, messages)
+	if err != nil {
+		return 0, fmt.Errorf("fetch: %v", err)
+	}
+
+	msg := <-messages
+	if msg == nil {
+		return 0, fmt.Errorf("message not found")
+	}
+	body := msg.GetBody(section)
+	if body == nil {
+		return 0, fmt.Errorf("empty message body")
+	}
+
+	parsed, err := process.ParseMailMessage(body)
+	if err != nil {
+		return 0, err
+	}
+
+	description := mailDescription(parsed)
+	imported := 0
+	for _, attachment := range parsed.Attachments {
+		// unsupported attachments are rejected by uploader with ErrInvalid
+		mimetype := process.MimeTypeFromName(attachment.Filename)
+		if mimetype == "" {
+			mimetype = attachment.Mimetype
+		}
+
+		doc, err := m.uploader.UploadFile(ctx, &services.UploadedFile{
+			UserId:      account.UserId,
+			Filename:    attachment.Filename,
+			Mimetype:    mimetype,
+			Size:        int64(len(attachment.Data)),
+			File:        io.NopCloser(bytes.NewReader(attachment.Data)),
+			Description: description,
+		})
+		if err != nil {
+			if errors.Is(err, errors.ErrAlreadyExists) || errors.Is(err, errors.ErrInvalid) {
+				logger.Context(ctx).Infof("skip mail attachment '%s': %v", attachment.Filename, err)
+				continue
+			}
+			return imported, fmt.Errorf("upload attachment '%s': %v", attachment.Filename, err)
+		}
+		logger.Context(ctx).WithField("user", account.UserId).WithField("documentId", doc.Id).
+			Infof("imported mail attachment '%s'", attachment.Filename)
+		imported += 1
+	}
+	return imported, nil
+}
+
+func (m *MailImporter) markProcessed(c *client.Client, account *MailAccount, uid uint32) error {
+	seqSet := new(imap.SeqSet)
+	seqSet.AddNum(uid)
+
+	err := c.UidStore(seqSet, imap.FormatFlagsOp(imap.AddFlags, true), []interface{}{imap.SeenFlag}, nil)
+	if err != nil {
+		return err
+	}
+	if account.ProcessedFolder == "" {
+		return nil
+	}
+
+	err = c.UidMove(seqSet, account.ProcessedFolder)
+	if err == nil {
+		return nil
+	}
+
+	// some servers advertise MOVE without actually supporting it, fallback to copy & delete
+	err = c.UidCopy(seqSet, account.ProcessedFolder)
+	if err != nil {
+		return fmt.Errorf("copy: %v", err)
+	}
+	err = c.UidStore(seqSet, imap.FormatFlagsOp(imap.AddFlags, true), []interface{}{imap.DeletedFlag}, nil)
+	if err != nil {
+		return fmt.Errorf("mark deleted: %v", err)
+	}
+	return c.Expunge(nil)
+}
+
+func mailDescription(msg *process.MailMessage) string {
+	lines := []string{}
+	if msg.Subject != "" {
+		lines = append(lines, "Subject: "+msg.Subject)
+	}
+	if msg.From != "" {
+		lines = append(lines, "From: "+msg.From)
+	}
+	if !msg.Date.IsZero() {
+		lines = append(lines, "Date: "+msg.Date.Format(time.RFC1123Z))
+	}
+	return strings.Join(lines, "\n")
+}
@@ -0,0 +1,205 @@
+/*
+ * Virtualpaper is a service to manage users paper documents in virtual format.
+ * Copyright (C) 2024  Tero Vierimaa
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package importer
+
+import (
+	"bytes"
+	"context"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/emersion/go-imap"
+	"github.com/emersion/go-imap/backend/memory"
+	"github.com/emersion/go-imap/client"
+	"github.com/emersion/go-imap/server"
+	"tryffel.net/go/virtualpaper/config"
+	"tryffel.net/go/virtualpaper/models"
+)
+
+const testMailWithAttachments = "From: Billing <billing@example.com>\r\n" +
+	"To: user@example.com\r\n" +
+	"Subject: Invoice 1234\r\n" +
+	"Date: Mon, 02 Jan 2023 10:00:00 +0000\r\n" +
+	"MIME-Version: 1.0\r\n" +
+	"Content-Type: multipart/mixed; boundary=\"boundary\"\r\n" +
+	"\r\n" +
+	"--boundary\r\n" +
+	"Content-Type: text/plain\r\n" +
+	"\r\n" +
+	"Invoice attached.\r\n" +
+	"--boundary\r\n" +
+	"Content-Type: application/pdf; name=\"invoice.pdf\"\r\n" +
+	"Content-Disposition: attachment; filename=\"invoice.pdf\"\r\n" +
+	"Content-Transfer-Encoding: base64\r\n" +
+	"\r\n" +
+	"JVBERi0xLjQgaW52b2ljZQ==\r\n" +
+	"--boundary\r\n" +
+	"Content-Type: application/octet-stream; name=\"data.bin\"\r\n" +
+	"Content-Disposition: attachment; filename=\"data.bin\"\r\n" +
+	"\r\n" +
+	"binary\r\n" +
+	"--boundary--\r\n"
+
+const testMailWithoutAttachments = "From: friend@example.com\r\n" +
+	"To: user@example.com\r\n" +
+	"Subject: Hello\r\n" +
+	"Content-Type: text/plain\r\n" +
+	"\r\n" +
+	"Hi there\r\n"
+
+// startImapServer starts in-memory imap server. The server has single user 'username' with
+// password 'password'.
+func startImapServer(t *testing.T) string {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := server.New(memory.New())
+	s.AllowInsecureAuth = true
+	go s.Serve(listener)
+	t.Cleanup(func() { s.Close() })
+	return listener.Addr().String()
+}
+
+func imapClient(t *testing.T, addr string) *client.Client {
+	c, err := client.Dial(addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = c.Login("username", "password"); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { c.Logout() })
+	return c
+}
+
+func testMailAccount(t *testing.T, addr string, processedFolder string) MailAccount {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	portNum, _ := net.LookupPort("tcp", port)
+	return MailAccount{
+		UserId: 5,
+		MailImportAccount: config.MailImportAccount{
+			Host:            host,
+			Port:            portNum,
+			Username:        "username",
+			Password:        "password",
+			NoTLS:           true,
+			Folders:         []string{"INBOX"},
+			ProcessedFolder: processedFolder,
+		},
+	}
+}
+
+func folderFlags(t *testing.T, c *client.Client, folder string) [][]string {
+	status, err := c.Select(folder, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status.Messages == 0 {
+		return [][]string{}
+	}
+	seqSet := new(imap.SeqSet)
+	seqSet.AddRange(1, status.Messages)
+	messages := make(chan *imap.Message, status.Messages)
+	if err = c.Fetch(seqSet, []imap.FetchItem{imap.FetchFlags}, messages); err != nil {
+		t.Fatal(err)
+	}
+	flags := [][]string{}
+	for msg := range messages {
+		flags = append(flags, msg.Flags)
+	}
+	return flags
+}
+
+func TestMailImporter_Poll(t *testing.T) {
+	addr := startImapServer(t)
+	c := imapClient(t, addr)
+	for _, msg := range []string{testMailWithAttachments, testMailWithoutAttachments} {
+		if err := c.Append("INBOX", nil, time.Now(), bytes.NewBufferString(msg)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	uploader := &mockUploader{documents: map[string]*models.Document{}}
+	importer := NewMailImporter(uploader, []MailAccount{testMailAccount(t, addr, "")})
+
+	imported := importer.Poll(context.Background())
+	if imported != 1 {
+		t.Fatalf("expected 1 imported document, got %d", imported)
+	}
+
+	doc := uploader.documents["%PDF-1.4 invoice"]
+	if doc == nil {
+		t.Fatalf("attachment not uploaded, got: %v", uploader.documents)
+	}
+	if doc.Filename != "invoice.pdf" || doc.UserId != 5 {
+		t.Errorf("invalid document: %v", doc)
+	}
+	for _, want := range []string{"Subject: Invoice 1234", "From: Billing <billing@example.com>", "Date: Mon, 02 Jan 2023"} {
+		if !strings.Contains(doc.Description, want) {
+			t.Errorf("description '%s' does not contain '%s'", doc.Description, want)
+		}
+	}
+
+	// all messages are marked as seen, including the message that existed already
+	for _, flags := range folderFlags(t, c, "INBOX") {
+		found := false
+		for _, flag := range flags {
+			found = found || flag == imap.SeenFlag
+		}
+		if !found {
+			t.Errorf("message not marked as seen: %v", flags)
+		}
+	}
+
+	// second poll must not import anything
+	if imported = importer.Poll(context.Background()); imported != 0 {
+		t.Errorf("messages imported twice: %d", imported)
+	}
+}
+
+func TestMailImporter_PollMoveProcessed(t *testing.T) {
+	addr := startImapServer(t)
+	c := imapClient(t, addr)
+	if err := c.Create("Processed"); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Append("INBOX", nil, time.Now(), bytes.NewBufferString(testMailWithAttachments)); err != nil {
+		t.Fatal(err)
+	}
+
+	uploader := &mockUploader{documents: map[string]*models.Document{}}
+	importer := NewMailImporter(uploader, []MailAccount{testMailAccount(t, addr, "Processed")})
+	if imported := importer.Poll(context.Background()); imported != 1 {
+		t.Fatalf("expected 1 imported document, got %d", imported)
+	}
+
+	// memory backend contains one seen message by default
+	if got := len(folderFlags(t, c, "INBOX")); got != 1 {
+		t.Errorf("expected 1 message left in INBOX, got %d", got)
+	}
+	if got := len(folderFlags(t, c, "Processed")); got != 1 {
+		t.Errorf("expected 1 message in processed folder, got %d", got)
+	}
+}
@@ -0,0 +1,461 @@
+/*
+ * Virtualpaper is a service to manage users paper documents in virtual format.
+ * Copyright (C) 2024  Tero Vierimaa
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+// Package oidc implements the OpenID Connect authorization code flow with PKCE for single sign-on.
+// Provider endpoints are resolved with OpenID Connect discovery and ID tokens are verified
+// with the keys published by the provider.
+package oidc
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+)
+
+const (
+	requestTimeout = time.Second * 10
+	// allowed clock difference to the provider when validating token times
+	clockSkew = time.Minute
+	// keys are not re-fetched more often than this when token has unknown key id
+	keyRefreshInterval = time.Minute
+	maxResponseSize    = 1024 * 1024
+)
+
+// Config contains the client settings registered at the provider.
+type Config struct {
+	Issuer       string
+	ClientId     string
+	ClientSecret string
+	RedirectUrl  string
+	Scopes       []string
+}
+
+type discovery struct {
+	Issuer                string   `json:"issuer"`
+	AuthorizationEndpoint string   `json:"authorization_endpoint"`
+	TokenEndpoint         string   `json:"token_endpoint"`
+	JwksUri               string   `json:"jwks_uri"`
+	TokenAuthMethods      []string `json:"token_endpoint_auth_methods_supported"`
+}
+
+// Provider is an OpenID Connect provider.
+type Provider struct {
+	config    Config
+	client    *http.Client
+	discovery discovery
+
+	lock        *sync.Mutex
+	keys        map[string]crypto.PublicKey
+	keysFetched time.Time
+}
+
+// NewProvider fetches the discovery document of the issuer.
+// If client is nil, default client with timeout is used.
+func NewProvider(ctx context.Context, config Config, client *http.Client) (*Provider, error) {
+	if client == nil {
+		client = &http.Client{Timeout: requestTimeout}
+	}
+	p := &Provider{
+		config: config,
+		client: client,
+		lock:   &sync.Mutex{},
+		keys:   map[string]crypto.PublicKey{},
+	}
+
+	wellKnown := strings.TrimSuffix(config.Issuer, "/") + "/.well-known/openid-configuration"
+	err := p.getJson(ctx, wellKnown, &p.discovery)
+	if err != nil {
+		return nil, fmt.Errorf("get discovery document: %v", err)
+	}
+	if p.discovery.Issuer != config.Issuer {
+		return nil, fmt.Errorf("issuer mismatch, configured '%s', provider reported '%s'", config.Issuer, p.discovery.Issuer)
+	}
+	if p.discovery.AuthorizationEndpoint == "" || p.discovery.TokenEndpoint == "" || p.discovery.JwksUri == "" {
+		return nil, fmt.Errorf("discovery document is missing endpoints")
+	}
+	return p, nil
+}
+
+// AuthCodeUrl returns the url to redirect user to for authentication.
+// State and nonce are returned back to the client and must be verified, codeVerifier is needed in Exchange.
+func (p *Provider) AuthCodeUrl(state, nonce, codeVerifier string) string {
+	return p.authCodeUrl(state, nonce, codeVerifier, url.Values{})
+}
+
+// ReauthenticateUrl is like AuthCodeUrl, but asks the provider to authenticate user again even if
+// user has an active session at the provider. Provider reports the time of authentication in claim auth_time.
+func (p *Provider) ReauthenticateUrl(state, nonce, codeVerifier string) string {
+	params := url.Values{}
+	params.Set("prompt", "login")
+	params.Set("max_age", "0")
+	return p.authCodeUrl(state, nonce, codeVerifier, params)
+}
+
+func (p *Provider) authCodeUrl(state, nonce, codeVerifier string, params url.Values) string {
+	challenge := sha256.Sum256([]byte(codeVerifier))
+	params.Set("response_type", "code")
+	params.Set("client_id", p.config.ClientId)
+	params.Set("redirect_uri", p.config.RedirectUrl)
+	params.Set("scope", strings.Join(p.config.Scopes, " "))
+	params.Set("state", state)
+	params.Set("nonce", nonce)
+	params.Set("code_challenge", base64.RawURLEncoding.EncodeToString(challenge[:]))
+	params.Set("code_challenge_method", "S256")
+
+	separator := "?"
+	if strings.Contains(p.discovery.AuthorizationEndpoint, "?") {
+		separator = "&"
+	}
+	return p.discovery.AuthorizationEndpoint + separator + params.Encode()
+}
+
+// Exchange exchanges authorization code for tokens and returns the raw ID token.
+func (p *Provider) Exchange(ctx context.Context, code, codeVerifier string) (string, error) {
+	form := url.Values{}
+	form.Set("grant_type", "authorization_code")
+	form.Set("code", code)
+	form.Set("redirect_uri", p.config.RedirectUrl)
+	form.Set("code_verifier", codeVerifier)
+
+	// client_secret_basic is the default unless provider only supports client_secret_post
+	basicAuth := true
+	if len(p.discovery.TokenAuthMethods) > 0 {
+		basicAuth = false
+		for _, v := range p.discovery.TokenAuthMethods {
+			if v == "client_secret_basic" {
+				basicAuth = true
+			}
+		}
+	}
+	if !basicAuth {
+		form.Set("client_id", p.config.ClientId)
+		form.Set("client_secret", p.config.ClientSecret)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, p.discovery.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", fmt.Errorf("create request: %v", err)
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	if basicAuth {
+		req.SetBasicAuth(url.QueryEscape(p.config.ClientId), url.QueryEscape(p.config.ClientSecret))
+	}
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("token request: %v", err)
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxResponseSize))
+	if err != nil {
+		return "", fmt.Errorf("read token response: %v", err)
+	}
+
+	tokens := &struct {
+		IdToken          string `json:"id_token"`
+		Error            string `json:"error"`
+		ErrorDescription string `json:"error_description"`
+	}{}
+	err = json.Unmarshal(body, tokens)
+	if resp.StatusCode != http.StatusOK {
+		if err == nil && tokens.Error != "" {
+			return "", fmt.Errorf("token request: %s: %s", tokens.Error, tokens.ErrorDescription)
+		}
+		return "", fmt.Errorf("token request: status %d", resp.StatusCode)
+	}
+	if err != nil {
+		return "", fmt.Errorf("parse token response: %v", err)
+	}
+	if tokens.IdToken == "" {
+		return "", fmt.Errorf("token response does not contain id_token")
+	}
+	return tokens.IdToken, nil
+}
+
+// VerifyIdToken verifies the signature, issuer, audience, expiration and nonce of the ID token
+// and returns its claims.
+func (p *Provider) VerifyIdToken(ctx context.Context, rawIdToken, nonce string) (Claims, error) {
+	claims := Claims{}
+	_, err := jwt.ParseWithClaims(rawIdToken, &claims, func(token *jwt.Token) (interface{}, error) {
+		switch token.Method.(type) {
+		case *jwt.SigningMethodRSA, *jwt.SigningMethodECDSA:
+		default:
+			return nil, fmt.Errorf("unsupported signing method %s", token.Method.Alg())
+		}
+		kid, _ := token.Header["kid"].(string)
+		return p.getKey(ctx, kid)
+	})
+	if err != nil {
+		return nil, fmt.Errorf("invalid id token: %v", err)
+	}
+
+	if claims.String("iss") != p.config.Issuer {
+		return nil, fmt.Errorf("invalid id token: issuer '%s'", claims.String("iss"))
+	}
+	audienceOk := false
+	for _, v := range claims.Strings("aud") {
+		if v == p.config.ClientId {
+			audienceOk = true
+		}
+	}
+	if !audienceOk {
+		return nil, fmt.Errorf("invalid id token: client is not in audience")
+	}
+	if claims.String("nonce") != nonce {
+		return nil, fmt.Errorf("invalid id token: nonce mismatch")
+	}
+	if claims.Subject() == "" {
+		return nil, fmt.Errorf("invalid id token: no subject")
+	}
+	return claims, nil
+}
+
+// getKey returns the signing key with given id. Keys are re-fetched if key is not found.
+func (p *Provider) getKey(ctx context.Context, kid string) (crypto.PublicKey, error) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	key := p.findKey(kid)
+	if key != nil {
+		return key, nil
+	}
+	if time.Since(p.keysFetched) < keyRefreshInterval {
+		return nil, fmt.Errorf("signing key '%s' not found", kid)
+	}
+
+	keys, err := p.fetchKeys(ctx)
+	p.keysFetched = time.Now()
+	if err != nil {
+		return nil, fmt.Errorf("get signing keys: %v", err)
+	}
+	p.keys = keys
+	key = p.findKey(kid)
+	if key == nil {
+		return nil, fmt.Errorf("signing key '%s' not found", kid)
+	}
+	return key, nil
+}
+
+// findKey returns the key by id. If token has no key id, the only key is accepted.
+func (p *Provider) findKey(kid string) crypto.PublicKey {
+	if kid == "" && len(p.keys) == 1 {
+		for _, v := range p.keys {
+			return v
+		}
+	}
+	return p.keys[kid]
+}
+
+type jsonWebKey struct {
+	Kid string `json:"kid"`
+	Kty string `json:"kty"`
+	Use string `json:"use"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+func (p *Provider) fetchKeys(ctx context.Context) (map[string]crypto.PublicKey, error) {
+	jwks := &struct {
+		Keys []jsonWebKey `json:"keys"`
+	}{}
+	err := p.getJson(ctx, p.discovery.JwksUri, jwks)
+	if err != nil {
+		return nil, err
+	}
+
+	keys := map[string]crypto.PublicKey{}
+	for _, v := range jwks.Keys {
+		if v.Use != "" && v.Use != "sig" {
+			continue
+		}
+		key, err := v.publicKey()
+		if err != nil {
+			// skip unsupported key types
+			continue
+		}
+		keys[v.Kid] = key
+	}
+	return keys, nil
+}
+
+func (k *jsonWebKey) publicKey() (crypto.PublicKey, error) {
+	switch k.Kty {
+	case "RSA":
+		n, err := decodeBigInt(k.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := decodeBigInt(k.E)
+		if err != nil {
+			return nil, err
+		}
+		return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+	case "EC":
+		var curve elliptic.Curve
+		switch k.Crv {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		case "P-521":
+			curve = elliptic.P521()
+		default:
+			return nil, fmt.Errorf("unsupported curve %s", k.Crv)
+		}
+		x, err := decodeBigInt(k.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := decodeBigInt(k.Y)
+		if err != nil {
+			return nil, err
+		}
+		return &ecdsa.PublicKey{Curve: curve, X: x, Y: y}, nil
+	}
+	return nil, fmt.Errorf("unsupported key type %s", k.Kty)
+}
+
+func decodeBigInt(value string) (*big.Int, error) {
+	data, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(value, "="))
+	if err != nil {
+		return nil, err
+	}
+	return new(big.Int).SetBytes(data), nil
+}
+
+func (p *Provider) getJson(ctx context.Context, url string, target interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return fmt.Errorf("create request: %v", err)
+	}
+	req.Header.Set("Accept", "application/json")
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("status %d", resp.StatusCode)
+	}
+	return json.NewDecoder(io.LimitReader(resp.Body, maxResponseSize)).Decode(target)
+}
+
+// RandomString returns url-safe random string that is suitable for state, nonce and code verifier.
+func RandomString() (string, error) {
+	data := make([]byte, 32)
+	_, err := rand.Read(data)
+	if err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(data), nil
+}
+
+// Claims are the claims of an ID token.
+type Claims map[string]interface{}
+
+// Valid validates token times. It is called when parsing the token.
+func (c Claims) Valid() error {
+	now := time.Now()
+	exp, ok := c["exp"].(float64)
+	if !ok {
+		return fmt.Errorf("token has no expiration")
+	}
+	if now.Add(-clockSkew).After(time.Unix(int64(exp), 0)) {
+		return fmt.Errorf("token is expired")
+	}
+	if nbf, ok := c["nbf"].(float64); ok && now.Add(clockSkew).Before(time.Unix(int64(nbf), 0)) {
+		return fmt.Errorf("token is not valid yet")
+	}
+	return nil
+}
+
+// String returns claim value if it is a string.
+func (c Claims) String(key string) string {
+	value, _ := c[key].(string)
+	return value
+}
+
+// Strings returns claim value, that is either a string or a list of strings.
+func (c Claims) Strings(key string) []string {
+	switch value := c[key].(type) {
+	case string:
+		return []string{value}
+	case []interface{}:
+		values := make([]string, 0, len(value))
+		for _, v := range value {
+			if s, ok := v.(string); ok {
+				values = append(values, s)
+			}
+		}
+		return values
+	}
+	return nil
+}
+
+func (c Claims) Subject() string {
+	return c.String("sub")
+}
+
+// AuthTime returns the time when user authenticated at the provider, or zero time if it is not known.
+func (c Claims) AuthTime() time.Time {
+	authTime, ok := c["auth_time"].(float64)
+	if !ok {
+		return time.Time{}
+	}
+	return time.Unix(int64(authTime), 0)
+}
+
+// Email returns email of the user, if provider has verified it.
+func (c Claims) Email() string {
+	if verified, ok := c["email_verified"].(bool); ok && !verified {
+		return ""
+	}
+	return c.String("email")
+}
+
+// ContainsAny returns true if claim is, or contains, any of the values.
+func (c Claims) ContainsAny(key string, values []string) bool {
+	for _, claim := range c.Strings(key) {
+		for _, v := range values {
+			if claim == v {
+				return true
+			}
+		}
+	}
+	return false
+}
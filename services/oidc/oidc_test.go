@@ -0,0 +1,208 @@
+/*
+ * Virtualpaper is a service to manage users paper documents in virtual format.
+ * Copyright (C) 2024  Tero Vierimaa
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package oidc
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+	"github.com/stretchr/testify/assert"
+)
+
+// mockProvider is a minimal OpenID Connect provider that issues ID tokens for a single authorization code.
+type mockProvider struct {
+	server *httptest.Server
+	key    *rsa.PrivateKey
+	// claims of the next ID token
+	claims jwt.MapClaims
+	// code challenge of the authorization request
+	challenge string
+}
+
+func newMockProvider(t *testing.T) *mockProvider {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := &mockProvider{key: key}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{
+			"issuer":                 m.server.URL,
+			"authorization_endpoint": m.server.URL + "/authorize",
+			"token_endpoint":         m.server.URL + "/token",
+			"jwks_uri":               m.server.URL + "/keys",
+		})
+	})
+	mux.HandleFunc("/keys", func(w http.ResponseWriter, r *http.Request) {
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{
+			"keys": []map[string]string{{
+				"kid": "key-1",
+				"kty": "RSA",
+				"use": "sig",
+				"n":   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+				"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+			}},
+		})
+	})
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		clientId, secret, ok := r.BasicAuth()
+		verifier := sha256.Sum256([]byte(r.FormValue("code_verifier")))
+		if !ok || clientId != "virtualpaper" || secret != "secret" || r.FormValue("code") != "code-1" ||
+			base64.RawURLEncoding.EncodeToString(verifier[:]) != m.challenge {
+			w.WriteHeader(http.StatusBadRequest)
+			_, _ = w.Write([]byte(`{"error":"invalid_grant"}`))
+			return
+		}
+		_ = json.NewEncoder(w).Encode(map[string]string{"id_token": m.signToken(t, m.claims)})
+	})
+	m.server = httptest.NewServer(mux)
+	return m
+}
+
+func (m *mockProvider) signToken(t *testing.T, claims jwt.MapClaims) string {
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+	token.Header["kid"] = "key-1"
+	raw, err := token.SignedString(m.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return raw
+}
+
+func TestProvider(t *testing.T) {
+	mock := newMockProvider(t)
+	defer mock.server.Close()
+	ctx := context.Background()
+
+	provider, err := NewProvider(ctx, Config{
+		Issuer:       mock.server.URL,
+		ClientId:     "virtualpaper",
+		ClientSecret: "secret",
+		RedirectUrl:  "https://virtualpaper.example.com/#/login/oidc",
+		Scopes:       []string{"openid", "email"},
+	}, mock.server.Client())
+	if err != nil {
+		t.Fatalf("NewProvider(): %v", err)
+	}
+
+	authUrl, err := url.Parse(provider.AuthCodeUrl("state-1", "nonce-1", "verifier-1"))
+	assert.NoError(t, err)
+	assert.Equal(t, "/authorize", authUrl.Path)
+	assert.Equal(t, "state-1", authUrl.Query().Get("state"))
+	assert.Equal(t, "nonce-1", authUrl.Query().Get("nonce"))
+	assert.Equal(t, "openid email", authUrl.Query().Get("scope"))
+	assert.Equal(t, "", authUrl.Query().Get("prompt"))
+	mock.challenge = authUrl.Query().Get("code_challenge")
+
+	reauthUrl, err := url.Parse(provider.ReauthenticateUrl("state-2", "nonce-2", "verifier-1"))
+	assert.NoError(t, err)
+	assert.Equal(t, "state-2", reauthUrl.Query().Get("state"))
+	assert.Equal(t, "login", reauthUrl.Query().Get("prompt"))
+	assert.Equal(t, "0", reauthUrl.Query().Get("max_age"))
+	assert.Equal(t, mock.challenge, reauthUrl.Query().Get("code_challenge"))
+
+	validClaims := func() jwt.MapClaims {
+		return jwt.MapClaims{
+			"iss":            mock.server.URL,
+			"aud":            []string{"virtualpaper", "other"},
+			"sub":            "user-1",
+			"nonce":          "nonce-1",
+			"email":          "user@example.com",
+			"email_verified": true,
+			"groups":         []string{"staff", "virtualpaper-admins"},
+			"exp":            time.Now().Add(time.Minute).Unix(),
+			"iat":            time.Now().Unix(),
+		}
+	}
+	mock.claims = validClaims()
+
+	_, err = provider.Exchange(ctx, "code-1", "wrong-verifier")
+	assert.Error(t, err)
+	rawIdToken, err := provider.Exchange(ctx, "code-1", "verifier-1")
+	assert.NoError(t, err)
+
+	claims, err := provider.VerifyIdToken(ctx, rawIdToken, "nonce-1")
+	if err != nil {
+		t.Fatalf("VerifyIdToken(): %v", err)
+	}
+	assert.Equal(t, "user-1", claims.Subject())
+	assert.True(t, claims.AuthTime().IsZero(), "no auth_time")
+	assert.Equal(t, "user@example.com", claims.Email())
+	assert.True(t, claims.ContainsAny("groups", []string{"virtualpaper-admins"}))
+	assert.False(t, claims.ContainsAny("groups", []string{"admins"}))
+
+	_, err = provider.VerifyIdToken(ctx, rawIdToken, "nonce-2")
+	assert.Error(t, err, "nonce mismatch")
+
+	invalid := map[string]func(c jwt.MapClaims){
+		"wrong audience": func(c jwt.MapClaims) { c["aud"] = "other" },
+		"wrong issuer":   func(c jwt.MapClaims) { c["iss"] = "https://id.example.com" },
+		"expired":        func(c jwt.MapClaims) { c["exp"] = time.Now().Add(-time.Hour).Unix() },
+		"no subject":     func(c jwt.MapClaims) { delete(c, "sub") },
+	}
+	for name, modify := range invalid {
+		t.Run(name, func(t *testing.T) {
+			c := validClaims()
+			modify(c)
+			_, err := provider.VerifyIdToken(ctx, mock.signToken(t, c), "nonce-1")
+			assert.Error(t, err)
+		})
+	}
+
+	t.Run("unknown key", func(t *testing.T) {
+		otherKey, err := rsa.GenerateKey(rand.Reader, 2048)
+		if err != nil {
+			t.Fatal(err)
+		}
+		raw, err := jwt.NewWithClaims(jwt.SigningMethodRS256, validClaims()).SignedString(otherKey)
+		assert.NoError(t, err)
+		_, err = provider.VerifyIdToken(ctx, raw, "nonce-1")
+		assert.Error(t, err)
+	})
+
+	t.Run("auth time", func(t *testing.T) {
+		c := validClaims()
+		authTime := time.Now().Add(-time.Minute).Truncate(time.Second)
+		c["auth_time"] = authTime.Unix()
+		claims, err := provider.VerifyIdToken(ctx, mock.signToken(t, c), "nonce-1")
+		assert.NoError(t, err)
+		assert.True(t, authTime.Equal(claims.AuthTime()))
+	})
+
+	t.Run("unverified email", func(t *testing.T) {
+		c := validClaims()
+		c["email_verified"] = false
+		claims, err := provider.VerifyIdToken(ctx, mock.signToken(t, c), "nonce-1")
+		assert.NoError(t, err)
+		assert.Equal(t, "", claims.Email())
+	})
+}
@@ -0,0 +1,275 @@
+package process
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/xml"
+	"fmt"
+	"os"
+	"os/exec"
+	"path"
+	"time"
+
+	"tryffel.net/go/virtualpaper/config"
+	"tryffel.net/go/virtualpaper/errors"
+	"tryffel.net/go/virtualpaper/models"
+	"tryffel.net/go/virtualpaper/storage"
+	log "tryffel.net/go/virtualpaper/util/logger"
+)
+
+// DocumentSplitter splits document to new documents at separator pages and moves the original document to trash.
+type DocumentSplitter interface {
+	SplitDocumentAtPages(ctx context.Context, userId int, docId string, pageCount int, separators []int) error
+}
+
+// errDocumentSplit is returned when document was split at separator pages and moved to trash.
+// Processing of the document must not continue.
+var errDocumentSplit = errors.New("document was split")
+
+// barcode is a barcode or QR code detected from a page. Pages start from 1.
+type barcode struct {
+	Type string
+	Data string
+	Page int
+}
+
+// detectBarcodes reads barcodes and QR codes from the pages and stores them as values of the barcode property.
+// If a separator barcode is configured and found, pdf document is split at the separator pages
+// and errDocumentSplit is returned.
+func (fp *fileProcessor) detectBarcodes(ctx context.Context) error {
+	process := &models.ProcessItem{
+		DocumentId: fp.document.Id,
+		Action:     models.ProcessBarcodes,
+		CreatedAt:  time.Now(),
+	}
+
+	if !config.C.Processing.Barcodes || !(fp.document.IsPdf() || fp.document.IsImage()) {
+		err := fp.db.JobStore.MarkProcessingDone(process, true)
+		if err != nil {
+			return fmt.Errorf("mark process complete: %v", err)
+		}
+		return nil
+	}
+
+	err := fp.ensureFileOpen()
+	if err != nil {
+		return fmt.Errorf("open file: %v", err)
+	}
+
+	job, err := fp.db.JobStore.StartProcessItem(process, "detect barcodes")
+	if err != nil {
+		return fmt.Errorf("persist process item: %v", err)
+	}
+	defer fp.completeProcessingStep(process, job)
+
+	dir := storage.TempFilePath(fp.document.Id) + "-barcodes"
+	defer os.RemoveAll(dir)
+
+	codes, pageCount, err := fp.readPageBarcodes(ctx, dir)
+	if err != nil {
+		job.Status = models.JobFailure
+		job.Message += "; " + err.Error()
+		return fmt.Errorf("read barcodes: %v", err)
+	}
+	separators := separatorBarcodePages(codes, config.C.Processing.BarcodeSeparator)
+	job.Message += fmt.Sprintf("; found %d barcodes", len(codes))
+
+	err = fp.saveBarcodes(ctx, codes)
+	if err != nil {
+		job.Status = models.JobFailure
+		job.Message += "; " + err.Error()
+		return fmt.Errorf("save barcodes: %v", err)
+	}
+
+	if len(separators) > 0 && fp.document.IsPdf() && fp.splitter != nil {
+		job.Message += fmt.Sprintf("; split at pages %v", separators)
+		err = fp.splitter.SplitDocumentAtPages(ctx, fp.document.UserId, fp.document.Id, pageCount, separators)
+		if err != nil {
+			// keep processing the document as it is
+			log.Context(ctx).Warnf("split document at separator pages: %v", err)
+			job.Message += "; split failed: " + err.Error()
+		} else {
+			// original document was moved to trash, new documents are processed separately
+			err = fp.db.JobStore.CancelDocumentProcessing(fp.document.Id)
+			if err != nil {
+				log.Context(ctx).Errorf("remove remaining steps of split document: %v", err)
+			}
+			job.Status = models.JobFinished
+			return errDocumentSplit
+		}
+	}
+	job.Status = models.JobFinished
+	return nil
+}
+
+// readPageBarcodes reads barcodes from the preprocessed file if document has one, else from the original file.
+// Splitting uses the same file, so that separator pages and page count refer to the pages that are split.
+func (fp *fileProcessor) readPageBarcodes(ctx context.Context, dir string) ([]barcode, int, error) {
+	file, cleanup, err := fp.processingFile(ctx)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer cleanup()
+	return readBarcodes(ctx, file, dir)
+}
+
+// saveBarcodes replaces document's values of the barcode property with codes. Separator barcodes are not stored.
+func (fp *fileProcessor) saveBarcodes(ctx context.Context, codes []barcode) error {
+	tx, err := storage.NewTx(fp.db, ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Close()
+
+	if len(codes) == 0 {
+		_, err = fp.db.PropertyStore.GetPropertyByName(tx, fp.document.UserId, config.C.Processing.BarcodeProperty)
+		if errors.Is(err, errors.ErrRecordNotFound) {
+			return nil
+		}
+	}
+	field := fieldProperty{Name: config.C.Processing.BarcodeProperty, Type: models.TextProperty}
+	property, err := fp.getOrCreateProperty(tx, field)
+	if err != nil {
+		return fmt.Errorf("get barcode property: %v", err)
+	}
+	if !field.accepts(property) {
+		log.Context(ctx).Warnf("barcode property '%s' has type %s, cannot store barcodes to it", property.Name, property.Type)
+		return nil
+	}
+
+	values := make([]models.DocumentProperty, 0, len(codes))
+	for _, v := range codes {
+		if v.Data == config.C.Processing.BarcodeSeparator {
+			continue
+		}
+		values = append(values, models.DocumentProperty{Value: v.Data, Description: barcodeDescription(v)})
+	}
+	err = fp.replacePropertyValues(tx, property, values, models.ProcessBarcodes)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// barcodeDescription describes the type and page of the barcode.
+func barcodeDescription(code barcode) string {
+	return fmt.Sprintf("%s, page %d", code.Type, code.Page)
+}
+
+// separatorBarcodePages returns pages that contain a barcode with separator payload.
+func separatorBarcodePages(codes []barcode, separator string) []int {
+	pages := make([]int, 0)
+	if separator == "" {
+		return pages
+	}
+	for _, v := range codes {
+		if v.Data == separator && (len(pages) == 0 || pages[len(pages)-1] != v.Page) {
+			pages = append(pages, v.Page)
+		}
+	}
+	return pages
+}
+
+// readBarcodes renders pages of the file to dir and reads barcodes from them with zbarimg.
+// It returns the barcodes ordered by page and the number of pages.
+func readBarcodes(ctx context.Context, rawFile string, dir string) ([]barcode, int, error) {
+	err := os.MkdirAll(dir, 0700)
+	if err != nil {
+		return nil, 0, fmt.Errorf("create tmp dir: %v", err)
+	}
+	err = generatePicture(ctx, rawFile, path.Join(dir, "preview.png"))
+	if err != nil {
+		return nil, 0, fmt.Errorf("generate pictures from pages: %v", err)
+	}
+	images, err := ocrPageImages(dir)
+	if err != nil {
+		return nil, 0, fmt.Errorf("find page images: %v", err)
+	}
+
+	output, err := callZbarimg(ctx, images...)
+	if err != nil {
+		return nil, 0, err
+	}
+	codes, err := parseZbarOutput(output, images)
+	return codes, len(images), err
+}
+
+// zbarimg exits with status 4 if no barcodes were found.
+const zbarimgNoBarcodes = 4
+
+func callZbarimg(ctx context.Context, images ...string) ([]byte, error) {
+	if config.C.Processing.ZbarimgBin == "" {
+		return nil, errors.New("no zbarimg binary set")
+	}
+	stdout := &bytes.Buffer{}
+	stderr := &bytes.Buffer{}
+
+	args := append([]string{"--xml", "-q"}, images...)
+	log.Context(ctx).Debugf("call zbarimg: %s, %v", config.C.Processing.ZbarimgBin, args)
+	cmd := exec.CommandContext(ctx, config.C.Processing.ZbarimgBin, args...)
+	cmd.Stdout = stdout
+	cmd.Stderr = stderr
+	err := cmd.Run()
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) && exitErr.ExitCode() == zbarimgNoBarcodes {
+		err = nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("run zbarimg: %v, stderr: %s", err, stderr.String())
+	}
+	return stdout.Bytes(), nil
+}
+
+type zbarOutput struct {
+	Sources []struct {
+		Href    string `xml:"href,attr"`
+		Indexes []struct {
+			Symbols []struct {
+				Type string `xml:"type,attr"`
+				Data struct {
+					Format string `xml:"format,attr"`
+					Value  string `xml:",chardata"`
+				} `xml:"data"`
+			} `xml:"symbol"`
+		} `xml:"index"`
+	} `xml:"source"`
+}
+
+// parseZbarOutput parses xml output of zbarimg. Images are the files passed to zbarimg, ordered by page.
+func parseZbarOutput(output []byte, images []string) ([]barcode, error) {
+	codes := make([]barcode, 0)
+	if len(bytes.TrimSpace(output)) == 0 {
+		return codes, nil
+	}
+	data := &zbarOutput{}
+	err := xml.Unmarshal(output, data)
+	if err != nil {
+		return nil, fmt.Errorf("parse zbarimg output: %v", err)
+	}
+
+	pages := make(map[string]int, len(images))
+	for i, v := range images {
+		pages[v] = i + 1
+	}
+	for _, source := range data.Sources {
+		page, ok := pages[source.Href]
+		if !ok {
+			return nil, fmt.Errorf("unknown image in zbarimg output: %s", source.Href)
+		}
+		for _, index := range source.Indexes {
+			for _, symbol := range index.Symbols {
+				value := symbol.Data.Value
+				if symbol.Data.Format == "base64" {
+					decoded, err := base64.StdEncoding.DecodeString(value)
+					if err != nil {
+						return nil, fmt.Errorf("decode barcode data: %v", err)
+					}
+					value = string(decoded)
+				}
+				codes = append(codes, barcode{Type: symbol.Type, Data: value, Page: page})
+			}
+		}
+	}
+	return codes, nil
+}
@@ -0,0 +1,130 @@
+package process
+
+import (
+	"context"
+	"os"
+	"path"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"tryffel.net/go/virtualpaper/config"
+	"tryffel.net/go/virtualpaper/models"
+	"tryffel.net/go/virtualpaper/storage"
+	"tryffel.net/go/virtualpaper/storage/blob"
+)
+
+func Test_parseZbarOutput(t *testing.T) {
+	output := `<barcodes xmlns='http://zbar.sourceforge.net/2008/barcode'>
+<source href='/tmp/doc/preview-0.png'>
+</source>
+<source href='/tmp/doc/preview-1.png'>
+<index num='0'>
+<symbol type='QR-Code' quality='1' orientation='UP'><polygon points='+10,10 +10,90 +90,90 +90,10'/><data><![CDATA[VP-SEPARATOR]]></data></symbol>
+<symbol type='EAN-13' quality='1' orientation='UP'><data><![CDATA[6410405082657]]></data></symbol>
+</index>
+</source>
+<source href='/tmp/doc/preview-2.png'>
+<index num='0'>
+<symbol type='QR-Code' quality='1'><data format='base64' length='5'><![CDATA[aGVsbG8=]]></data></symbol>
+</index>
+</source>
+</barcodes>`
+	images := []string{"/tmp/doc/preview-0.png", "/tmp/doc/preview-1.png", "/tmp/doc/preview-2.png"}
+
+	codes, err := parseZbarOutput([]byte(output), images)
+	assert.NoError(t, err)
+	assert.Equal(t, []barcode{
+		{Type: "QR-Code", Data: "VP-SEPARATOR", Page: 2},
+		{Type: "EAN-13", Data: "6410405082657", Page: 2},
+		{Type: "QR-Code", Data: "hello", Page: 3},
+	}, codes)
+
+	codes, err = parseZbarOutput([]byte(""), images)
+	assert.NoError(t, err)
+	assert.Len(t, codes, 0)
+
+	_, err = parseZbarOutput([]byte(output), images[:1])
+	assert.Error(t, err)
+}
+
+func Test_separatorBarcodePages(t *testing.T) {
+	codes := []barcode{
+		{Type: "QR-Code", Data: "VP-SEPARATOR", Page: 2},
+		{Type: "QR-Code", Data: "VP-SEPARATOR", Page: 2},
+		{Type: "EAN-13", Data: "6410405082657", Page: 3},
+		{Type: "QR-Code", Data: "VP-SEPARATOR", Page: 5},
+	}
+	assert.Equal(t, []int{2, 5}, separatorBarcodePages(codes, "VP-SEPARATOR"))
+	assert.Equal(t, []int{}, separatorBarcodePages(codes, ""))
+	assert.Equal(t, []int{}, separatorBarcodePages(codes, "other"))
+}
+
+// fakeBarcodeTools writes imagick that renders as many pages as the input file says and zbarimg that finds
+// a separator barcode on the last page.
+func fakeBarcodeTools(t *testing.T, dir string) (string, string) {
+	imagick := path.Join(dir, "convert")
+	content := "#!/bin/sh\nn=$(cat \"$3\")\ni=0\nwhile [ $i -lt $n ]; do touch \"$(dirname \"$6\")/preview-$i.png\"; i=$((i+1)); done\n"
+	err := os.WriteFile(imagick, []byte(content), 0700)
+	if err != nil {
+		t.Fatalf("write fake imagick: %v", err)
+	}
+	zbarimg := path.Join(dir, "zbarimg")
+	content = "#!/bin/sh\nshift 2\nfor img in \"$@\"; do last=\"$img\"; done\necho \"<barcodes>\"\n" +
+		"for img in \"$@\"; do echo \"<source href='$img'>\"; if [ \"$img\" = \"$last\" ]; then " +
+		"echo \"<index num='0'><symbol type='QR-Code'><data><![CDATA[VP-SEPARATOR]]></data></symbol></index>\"; fi; " +
+		"echo \"</source>\"; done\necho \"</barcodes>\"\n"
+	err = os.WriteFile(zbarimg, []byte(content), 0700)
+	if err != nil {
+		t.Fatalf("write fake zbarimg: %v", err)
+	}
+	return imagick, zbarimg
+}
+
+func Test_fileProcessor_readPageBarcodes(t *testing.T) {
+	dir := t.TempDir()
+	imagick, zbarimg := fakeBarcodeTools(t, dir)
+	config.C = &config.Config{Processing: config.Processing{TmpDir: dir, ImagickBin: imagick, ZbarimgBin: zbarimg}}
+	defer func() { config.C = nil }()
+
+	tests := []struct {
+		name         string
+		preprocessed string
+		wantPages    int
+	}{
+		{name: "original file", wantPages: 3},
+		// preprocessing removed a blank page, separator is on the last page of the preprocessed file
+		{name: "preprocessed file", preprocessed: "2", wantPages: 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, _, err := storage.NewMockDatabase(nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			db.Files = blob.NewLocalStore(t.TempDir())
+			ctx := context.Background()
+			err = db.Files.Put(ctx, storage.DocumentKey("doc"), strings.NewReader("3"), 1)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tt.preprocessed != "" {
+				err = db.Files.Put(ctx, storage.PreprocessedKey("doc"), strings.NewReader(tt.preprocessed), 1)
+				if err != nil {
+					t.Fatal(err)
+				}
+			}
+			fp := &fileProcessor{Task: &Task{db: db}, document: &models.Document{Id: "doc"}}
+			defer func() {
+				if fp.rawFile != nil {
+					fp.rawFile.Close()
+				}
+			}()
+
+			codes, pageCount, err := fp.readPageBarcodes(ctx, path.Join(t.TempDir(), "barcodes"))
+			assert.NoError(t, err)
+			assert.Equal(t, tt.wantPages, pageCount)
+			assert.Equal(t, []int{tt.wantPages}, separatorBarcodePages(codes, "VP-SEPARATOR"))
+		})
+	}
+}
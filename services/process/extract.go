@@ -7,7 +7,9 @@ import (
 	"os"
 	"strings"
 	"time"
+	"tryffel.net/go/virtualpaper/config"
 	"tryffel.net/go/virtualpaper/models"
+	"tryffel.net/go/virtualpaper/storage"
 )
 
 func (fp *fileProcessor) parseContent(ctx context.Context) error {
@@ -23,6 +25,8 @@ func (fp *fileProcessor) parseContent(ctx context.Context) error {
 		return fp.extractPdf(ctx, file)
 	} else if fp.document.IsImage() {
 		return fp.extractImage(ctx, file)
+	} else if fp.document.IsEmail() {
+		return fp.extractEmail(ctx, file)
 	} else if fp.usePandoc && isPandocMimetype(fp.document.Mimetype) {
 		return fp.extractPandoc(ctx, file)
 	} else {
@@ -49,11 +53,13 @@ func (fp *fileProcessor) extractPdf(ctx context.Context, file *os.File) error {
 	defer fp.completeProcessingStep(process, job)
 
 	var text string
+	var pages []string
 	useOcr := false
 
 	if fp.usePdfToText {
 		fp.Info("Attempt to parse document content with pdftotext")
 		text, err = getPdfToText(file, fp.document.Id)
+		pages = splitPdfToTextPages(text)
 		if err != nil {
 			if err.Error() == "empty" {
 				fp.Info("document has no plain text, try ocr")
@@ -69,22 +75,29 @@ func (fp *fileProcessor) extractPdf(ctx context.Context, file *os.File) error {
 	}
 
 	if useOcr {
-		text, err = runOcr(ctx, file.Name(), fp.document.Id)
+		ocrFile, cleanup, err := fp.processingFile(ctx)
+		if err != nil {
+			job.Message += "; " + err.Error()
+			job.Status = models.JobFailure
+			return err
+		}
+		defer cleanup()
+		searchablePdf := fp.searchablePdfOutput()
+		pages, err = runOcr(ctx, ocrFile, fp.document.Id, fp.ocrLanguages(), config.C.Processing.OcrTwoPass, searchablePdf, fp.ocrProgress(job))
 		if err != nil {
 			job.Message += "; " + err.Error()
 			job.Status = models.JobFailure
 			return fmt.Errorf("parse document content: %v", err)
 		}
+		fp.saveSearchablePdf(ctx, searchablePdf)
+		text = joinOcrPages(pages)
 	}
 
 	if text == "" {
 		fp.Warn("content seems to be empty")
 	}
 
-	text = strings.ToValidUTF8(text, "")
-
-	fp.document.Content = text
-	err = fp.db.DocumentStore.SetDocumentContent(fp.document.Id, text)
+	err = fp.saveContent(ctx, text, pages)
 	if err != nil {
 		job.Message += "; " + "save document content: " + err.Error()
 		job.Status = models.JobFailure
@@ -110,15 +123,23 @@ func (fp *fileProcessor) extractImage(ctx context.Context, file *os.File) error
 
 	defer fp.completeProcessingStep(process, job)
 
-	text, err := runOcr(ctx, file.Name(), fp.document.Id)
+	ocrFile, cleanup, err := fp.processingFile(ctx)
+	if err != nil {
+		job.Message += "; " + err.Error()
+		job.Status = models.JobFailure
+		return err
+	}
+	defer cleanup()
+
+	searchablePdf := fp.searchablePdfOutput()
+	pages, err := runOcr(ctx, ocrFile, fp.document.Id, fp.ocrLanguages(), config.C.Processing.OcrTwoPass, searchablePdf, fp.ocrProgress(job))
 	if err != nil {
 		job.Message += "; " + err.Error()
 		job.Status = models.JobFailure
 		return fmt.Errorf("parse document text: %v", err)
 	} else {
-		text = strings.ToValidUTF8(text, "")
-		fp.document.Content = text
-		err = fp.db.DocumentStore.SetDocumentContent(fp.document.Id, fp.document.Content)
+		fp.saveSearchablePdf(ctx, searchablePdf)
+		err = fp.saveContent(ctx, joinOcrPages(pages), pages)
 		if err != nil {
 			job.Message += "; " + "save document content: " + err.Error()
 			job.Status = models.JobFailure
@@ -151,9 +172,7 @@ func (fp *fileProcessor) extractPandoc(ctx context.Context, file *os.File) error
 		job.Status = models.JobFailure
 		return fmt.Errorf("parse document text: %v", err)
 	} else {
-		text = strings.ToValidUTF8(text, "")
-		fp.document.Content = text
-		err = fp.db.DocumentStore.SetDocumentContent(fp.document.Id, fp.document.Content)
+		err = fp.saveContent(ctx, text, []string{text})
 		if err != nil {
 			job.Message += "; " + "save document content: " + err.Error()
 			job.Status = models.JobFailure
@@ -164,3 +183,132 @@ func (fp *fileProcessor) extractPandoc(ctx context.Context, file *os.File) error
 	}
 	return nil
 }
+
+func (fp *fileProcessor) extractEmail(ctx context.Context, file *os.File) error {
+	process := &models.ProcessItem{
+		DocumentId: fp.document.Id,
+		Action:     models.ProcessParseContent,
+		CreatedAt:  time.Now(),
+	}
+
+	job, err := fp.db.JobStore.StartProcessItem(process, "extract content from email")
+	if err != nil {
+		return fmt.Errorf("start process: %v", err)
+	}
+	defer fp.completeProcessingStep(process, job)
+
+	msg, err := ParseMailFile(fp.document.Mimetype, file.Name())
+	if err != nil {
+		job.Message += "; " + err.Error()
+		job.Status = models.JobFailure
+		return fmt.Errorf("parse email: %v", err)
+	}
+	text := msg.PlainText()
+	err = fp.saveContent(ctx, text, []string{text})
+	if err != nil {
+		job.Message += "; " + "save document content: " + err.Error()
+		job.Status = models.JobFailure
+		return fmt.Errorf("save document content: %v", err)
+	}
+	job.Status = models.JobFinished
+	return nil
+}
+
+// saveSearchablePdf stores searchable pdf that was created with OCR, if any. If the pdf was not created
+// or cannot be stored, searchable pdf step that follows content extraction generates it again.
+func (fp *fileProcessor) saveSearchablePdf(ctx context.Context, file string) {
+	if file == "" {
+		return
+	}
+	if _, err := os.Stat(file); err != nil {
+		fp.Warn("searchable pdf was not created with OCR")
+		return
+	}
+	err := fp.storeSearchablePdf(ctx, file)
+	if err != nil {
+		fp.Warn("%v", err)
+	}
+}
+
+// saveContent saves document content and the text of each page.
+func (fp *fileProcessor) saveContent(ctx context.Context, content string, pages []string) error {
+	content = strings.ToValidUTF8(content, "")
+	for i := range pages {
+		pages[i] = strings.ToValidUTF8(pages[i], "")
+	}
+
+	tx, err := storage.NewTx(fp.db, ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Close()
+
+	err = fp.db.DocumentStore.SetDocumentContent(tx, fp.document.Id, content)
+	if err != nil {
+		return err
+	}
+	err = fp.db.DocumentStore.SetDocumentPages(tx, fp.document.Id, pages)
+	if err != nil {
+		return fmt.Errorf("save pages: %v", err)
+	}
+	err = tx.Commit()
+	if err != nil {
+		return err
+	}
+	fp.document.Content = content
+	return nil
+}
+
+// splitPdfToTextPages splits pdftotext output to pages. Pdftotext separates pages with form feed.
+func splitPdfToTextPages(text string) []string {
+	if text == "" {
+		return []string{}
+	}
+	pages := strings.Split(text, "\f")
+	if len(pages) > 1 && strings.TrimSpace(pages[len(pages)-1]) == "" {
+		// form feed after last page
+		pages = pages[:len(pages)-1]
+	}
+	return pages
+}
+
+// joinOcrPages joins text of pages to document content with page markers.
+func joinOcrPages(pages []string) string {
+	content := ""
+	for i, v := range pages {
+		if i > 0 {
+			content += fmt.Sprintf("\n\n(Page %d)\n\n", i+1)
+		}
+		content += v
+	}
+	return content
+}
+
+// ocrLanguages returns the languages to use for OCR: languages set for the document, or else user's preferred
+// languages, or else all configured languages.
+func (fp *fileProcessor) ocrLanguages() []string {
+	if len(fp.document.OcrLanguages) > 0 {
+		return fp.document.OcrLanguages
+	}
+	langs, err := fp.db.UserStore.GetOcrLanguages(fp.document.UserId)
+	if err != nil {
+		fp.Warn("get user's ocr languages: %v", err)
+	}
+	if len(langs) > 0 {
+		return langs
+	}
+	return config.C.Processing.OcrLanguages
+}
+
+// ocrProgress reports progress of OCR in processing status and in job message.
+func (fp *fileProcessor) ocrProgress(job *models.Job) ocrProgress {
+	message := job.Message
+	return func(done, total int) {
+		fp.setProgress(models.ProcessParseContent, done, total)
+		job.Message = fmt.Sprintf("%s: page %d/%d", message, done, total)
+		err := fp.db.JobStore.UpdateJob(job)
+		if err != nil {
+			fp.Warn("update job progress: %v", err)
+		}
+	}
+}
@@ -0,0 +1,184 @@
+package process
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path"
+	"reflect"
+	"testing"
+	"tryffel.net/go/virtualpaper/config"
+)
+
+func Test_splitPdfToTextPages(t *testing.T) {
+	tests := []struct {
+		name string
+		text string
+		want []string
+	}{
+		{"empty", "", []string{}},
+		{"single page", "page 1\f", []string{"page 1"}},
+		{"pages", "page 1\fpage 2\f\fpage 4\f", []string{"page 1", "page 2", "", "page 4"}},
+		{"no form feed", "text", []string{"text"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := splitPdfToTextPages(tt.text); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("splitPdfToTextPages() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_joinOcrPages(t *testing.T) {
+	got := joinOcrPages([]string{"first", "second", "third"})
+	want := "first\n\n(Page 2)\n\nsecond\n\n(Page 3)\n\nthird"
+	if got != want {
+		t.Errorf("joinOcrPages() = %q, want %q", got, want)
+	}
+}
+
+func Test_ocrPageImages(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{"preview-10.png", "preview-2.png", "preview-0.png", "preview-1.png", "preview-0.png-out.txt"} {
+		err := os.WriteFile(path.Join(dir, name), []byte{}, 0600)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	got, err := ocrPageImages(dir)
+	if err != nil {
+		t.Fatalf("ocrPageImages() error = %v", err)
+	}
+	want := []string{
+		path.Join(dir, "preview-0.png"),
+		path.Join(dir, "preview-1.png"),
+		path.Join(dir, "preview-2.png"),
+		path.Join(dir, "preview-10.png"),
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("ocrPageImages() = %v, want %v", got, want)
+	}
+
+	single := t.TempDir()
+	err = os.WriteFile(path.Join(single, "preview.png"), []byte{}, 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err = ocrPageImages(single)
+	if err != nil {
+		t.Fatalf("ocrPageImages() error = %v", err)
+	}
+	if !reflect.DeepEqual(got, []string{path.Join(single, "preview.png")}) {
+		t.Errorf("ocrPageImages() = %v, want preview.png", got)
+	}
+}
+
+// fakeTesseract writes a script that outputs the input file name as page text,
+// and as pdf content if pdf output is requested.
+func fakeTesseract(t *testing.T, dir string) string {
+	script := path.Join(dir, "tesseract")
+	content := "#!/bin/sh\nsleep 0.05\necho \"text of $(basename \"$1\")\" > \"$2.txt\"\n" +
+		"for arg in \"$@\"; do if [ \"$arg\" = pdf ]; then echo \"pdf of $(basename \"$1\")\" > \"$2.pdf\"; fi; done\n"
+	err := os.WriteFile(script, []byte(content), 0700)
+	if err != nil {
+		t.Fatalf("write fake tesseract: %v", err)
+	}
+	return script
+}
+
+func Test_ocrImages(t *testing.T) {
+	dir := t.TempDir()
+	config.C = &config.Config{Processing: config.Processing{TesseractBin: fakeTesseract(t, dir), OcrWorkers: 3}}
+	defer func() { config.C = nil }()
+
+	images := make([]string, 7)
+	want := make([]string, len(images))
+	for i := range images {
+		images[i] = path.Join(dir, fmt.Sprintf("preview-%d.png", i))
+		want[i] = fmt.Sprintf("text of preview-%d.png\n", i)
+	}
+
+	progress := []int{}
+	pages, err := ocrImages(context.Background(), images, []string{"eng"}, false, func(done, total int) {
+		if total != len(images) {
+			t.Errorf("progress total = %d, want %d", total, len(images))
+		}
+		progress = append(progress, done)
+	})
+	if err != nil {
+		t.Fatalf("ocrImages() error = %v", err)
+	}
+	if !reflect.DeepEqual(pages, want) {
+		t.Errorf("ocrImages() = %v, want %v", pages, want)
+	}
+	if !reflect.DeepEqual(progress, []int{1, 2, 3, 4, 5, 6, 7}) {
+		t.Errorf("ocrImages() progress = %v", progress)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err = ocrImages(ctx, images, []string{"eng"}, false, nil)
+	if err == nil {
+		t.Errorf("ocrImages() with cancelled context, want error")
+	}
+}
+
+func Test_ocrImagesSearchablePdf(t *testing.T) {
+	dir := t.TempDir()
+	config.C = &config.Config{Processing: config.Processing{TesseractBin: fakeTesseract(t, dir), OcrWorkers: 2}}
+	defer func() { config.C = nil }()
+
+	image := path.Join(dir, "preview.png")
+	pages, err := ocrImages(context.Background(), []string{image}, []string{"eng"}, true, nil)
+	if err != nil {
+		t.Fatalf("ocrImages() error = %v", err)
+	}
+	if !reflect.DeepEqual(pages, []string{"text of preview.png\n"}) {
+		t.Errorf("ocrImages() = %v", pages)
+	}
+
+	// text and pdf are created in the same tesseract run
+	output := path.Join(dir, "searchable.pdf")
+	err = combineSearchablePdf(context.Background(), []string{ocrOutputFile(image) + ".pdf"}, output)
+	if err != nil {
+		t.Fatalf("combineSearchablePdf() error = %v", err)
+	}
+	data, err := os.ReadFile(output)
+	if err != nil {
+		t.Fatalf("read searchable pdf: %v", err)
+	}
+	if string(data) != "pdf of preview.png\n" {
+		t.Errorf("searchable pdf = %q", string(data))
+	}
+
+	err = combineSearchablePdf(context.Background(), []string{"a.pdf", "b.pdf"}, output)
+	if err == nil {
+		t.Errorf("combineSearchablePdf() without pdfunite or ghostscript, want error")
+	}
+}
+
+func Test_runOcrSearchablePdfFails(t *testing.T) {
+	dir := t.TempDir()
+	// fake imagick renders two pages next to the output file
+	imagick := path.Join(dir, "convert")
+	err := os.WriteFile(imagick, []byte("#!/bin/sh\nout=$(dirname \"$6\")\ntouch \"$out/preview-0.png\" \"$out/preview-1.png\"\n"), 0700)
+	if err != nil {
+		t.Fatalf("write fake imagick: %v", err)
+	}
+	config.C = &config.Config{Processing: config.Processing{TmpDir: dir, ImagickBin: imagick,
+		TesseractBin: fakeTesseract(t, dir), OcrWorkers: 2}}
+	defer func() { config.C = nil }()
+
+	output := path.Join(dir, "searchable.pdf")
+	pages, err := runOcr(context.Background(), path.Join(dir, "input.pdf"), "doc", []string{"eng"}, false, output, nil)
+	if err != nil {
+		t.Fatalf("runOcr() without pdfunite or ghostscript, error = %v", err)
+	}
+	if !reflect.DeepEqual(pages, []string{"text of preview-0.png\n", "text of preview-1.png\n"}) {
+		t.Errorf("runOcr() = %v", pages)
+	}
+	if _, err := os.Stat(output); !os.IsNotExist(err) {
+		t.Errorf("searchable pdf exists after failure: %v", err)
+	}
+}
@@ -0,0 +1,437 @@
+package process
+
+import (
+	"context"
+	"fmt"
+	"math/big"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"tryffel.net/go/virtualpaper/config"
+	"tryffel.net/go/virtualpaper/errors"
+	"tryffel.net/go/virtualpaper/models"
+	"tryffel.net/go/virtualpaper/storage"
+	log "tryffel.net/go/virtualpaper/util/logger"
+)
+
+// fieldProperty is the property that an extracted field is stored to.
+type fieldProperty struct {
+	Name    string
+	Type    models.PropertyType
+	DateFmt string
+}
+
+// Properties for extracted fields. Properties are created for user if they do not exist.
+var (
+	fieldAmount        = fieldProperty{Name: "amount", Type: models.FloatProperty}
+	fieldCurrency      = fieldProperty{Name: "currency", Type: models.TextProperty}
+	fieldIban          = fieldProperty{Name: "iban", Type: models.TextProperty}
+	fieldBic           = fieldProperty{Name: "bic", Type: models.TextProperty}
+	fieldReference     = fieldProperty{Name: "reference number", Type: models.TextProperty}
+	fieldInvoiceNumber = fieldProperty{Name: "invoice number", Type: models.TextProperty}
+	fieldDueDate       = fieldProperty{Name: "due date", Type: models.DateProperty, DateFmt: "2006-01-02"}
+)
+
+var extractedFieldProperties = []fieldProperty{fieldAmount, fieldCurrency, fieldIban, fieldBic, fieldReference,
+	fieldInvoiceNumber, fieldDueDate}
+
+// extractedFields are structured fields found from document content. Empty fields were not found.
+type extractedFields struct {
+	Amount        float64
+	Currency      string
+	Ibans         []string
+	Bic           string
+	Reference     string
+	InvoiceNumber string
+	DueDate       time.Time
+}
+
+// values returns field values by property. Dates are formatted with dateFmt of the properties.
+func (f *extractedFields) values(dateFmt map[string]string) map[string][]string {
+	values := make(map[string][]string)
+	if f.Currency != "" {
+		values[fieldAmount.Name] = []string{strconv.FormatFloat(f.Amount, 'f', 2, 64)}
+		values[fieldCurrency.Name] = []string{f.Currency}
+	}
+	if len(f.Ibans) > 0 {
+		values[fieldIban.Name] = f.Ibans
+	}
+	if f.Bic != "" {
+		values[fieldBic.Name] = []string{f.Bic}
+	}
+	if f.Reference != "" {
+		values[fieldReference.Name] = []string{f.Reference}
+	}
+	if f.InvoiceNumber != "" {
+		values[fieldInvoiceNumber.Name] = []string{f.InvoiceNumber}
+	}
+	if !f.DueDate.IsZero() {
+		values[fieldDueDate.Name] = []string{f.DueDate.Format(dateFmt[fieldDueDate.Name])}
+	}
+	return values
+}
+
+// extractFields extracts amounts, bank accounts, reference numbers and due dates from content and stores them
+// as document properties. Values of previous extraction are replaced.
+func (fp *fileProcessor) extractFields(ctx context.Context) error {
+	process := &models.ProcessItem{
+		DocumentId: fp.document.Id,
+		Action:     models.ProcessExtractFields,
+		CreatedAt:  time.Now(),
+	}
+
+	if !config.C.Processing.ExtractFields {
+		err := fp.db.JobStore.MarkProcessingDone(process, true)
+		if err != nil {
+			return fmt.Errorf("mark process complete: %v", err)
+		}
+		return nil
+	}
+
+	job, err := fp.db.JobStore.StartProcessItem(process, "extract fields")
+	if err != nil {
+		return fmt.Errorf("persist process item: %v", err)
+	}
+	defer fp.completeProcessingStep(process, job)
+
+	fields := extractFieldsFromText(fp.document.Content)
+	err = fp.saveFields(ctx, fields)
+	if err != nil {
+		job.Status = models.JobFailure
+		job.Message += "; " + err.Error()
+		return fmt.Errorf("save fields: %v", err)
+	}
+	job.Status = models.JobFinished
+	return nil
+}
+
+// extractedDescription is the description of property values that were extracted from content.
+const extractedDescription = "extracted from content"
+
+// accepts returns true if values of the field can be stored to property.
+func (f fieldProperty) accepts(property *models.Property) bool {
+	return property.Type == f.Type || property.Type == models.TextProperty
+}
+
+func (fp *fileProcessor) saveFields(ctx context.Context, fields *extractedFields) error {
+	tx, err := storage.NewTx(fp.db, ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Close()
+
+	properties := make(map[string]*models.Property, len(extractedFieldProperties))
+	dateFmt := make(map[string]string)
+	for _, v := range extractedFieldProperties {
+		property, err := fp.getOrCreateProperty(tx, v)
+		if err != nil {
+			return fmt.Errorf("get property %s: %v", v.Name, err)
+		}
+		if !v.accepts(property) {
+			log.Context(ctx).Warnf("property '%s' has type %s, cannot store extracted %s values to it",
+				property.Name, property.Type, v.Type)
+			continue
+		}
+		properties[v.Name] = property
+		dateFmt[v.Name] = property.DateFmt
+	}
+
+	values := fields.values(dateFmt)
+	for name, property := range properties {
+		newValues := make([]models.DocumentProperty, len(values[name]))
+		for i, v := range values[name] {
+			newValues[i] = models.DocumentProperty{Value: v, Description: extractedDescription}
+		}
+		err = fp.replacePropertyValues(tx, property, newValues, models.ProcessExtractFields)
+		if err != nil {
+			return err
+		}
+	}
+	log.Context(ctx).Infof("extracted %d fields", len(values))
+	return tx.Commit()
+}
+
+// getOrCreateProperty returns user's property with the name of field. Property is created if it does not exist.
+// Existing property may be of different type than the field.
+func (fp *fileProcessor) getOrCreateProperty(tx storage.SqlExecer, field fieldProperty) (*models.Property, error) {
+	property, err := fp.db.PropertyStore.GetPropertyByName(tx, fp.document.UserId, field.Name)
+	if err == nil {
+		if property.Type == models.DateProperty && property.DateFmt == "" {
+			property.DateFmt = fieldDueDate.DateFmt
+		}
+		return property, nil
+	}
+	if !errors.Is(err, errors.ErrRecordNotFound) {
+		return nil, err
+	}
+	property = &models.Property{User: fp.document.UserId, Name: field.Name, Type: field.Type, DateFmt: field.DateFmt}
+	err = fp.db.PropertyStore.AddProperty(tx, property)
+	return property, err
+}
+
+// replacePropertyValues replaces document's values of the property that were generated by step with values.
+// Other values, e.g. values that user added, are kept. Values that are invalid for the property, or
+// not allowed by its exclusive and unique flags are skipped.
+func (fp *fileProcessor) replacePropertyValues(tx storage.SqlExecer, property *models.Property, values []models.DocumentProperty,
+	step models.ProcessStep) error {
+	existing, err := fp.db.PropertyStore.GetDocumentProperties(tx, fp.document.Id)
+	if err != nil {
+		return err
+	}
+	oldValues := make([]int, 0)
+	kept := make([]models.DocumentProperty, 0)
+	for _, v := range *existing {
+		if v.Property != property.Id {
+			continue
+		}
+		if v.GeneratedBy == step {
+			oldValues = append(oldValues, v.Id)
+		} else {
+			kept = append(kept, v)
+		}
+	}
+	if len(oldValues) > 0 {
+		err = fp.db.PropertyStore.DeleteDocumentProperties(tx, fp.document.UserId, fp.document.Id, oldValues)
+		if err != nil {
+			return err
+		}
+	}
+	for _, v := range allowedPropertyValues(property, kept, values) {
+		if property.Unique {
+			exists, err := fp.db.PropertyStore.PropertyValueExists(tx, property.Id, v.Value)
+			if err != nil {
+				return err
+			}
+			if exists {
+				fp.Debug("value of unique property %s already exists, skip", property.Name)
+				continue
+			}
+		}
+		err = fp.db.PropertyStore.AddDocumentProperty(tx, property, fp.document.Id, v.Value, v.Description, step, false)
+		if err != nil {
+			return err
+		}
+		if property.Exclusive {
+			break
+		}
+	}
+	return nil
+}
+
+// allowedPropertyValues returns values that can be added to document that already has the existing values of
+// the property. Invalid and duplicate values are skipped. If exclusive property already has a value, no values
+// are allowed, else only the first of the returned values can be added.
+func allowedPropertyValues(property *models.Property, existing []models.DocumentProperty, values []models.DocumentProperty) []models.DocumentProperty {
+	allowed := make([]models.DocumentProperty, 0, len(values))
+	if property.Exclusive && len(existing) > 0 {
+		return allowed
+	}
+	found := make(map[string]bool, len(existing)+len(values))
+	for _, v := range existing {
+		found[v.Value] = true
+	}
+	for _, v := range values {
+		if found[v.Value] || property.ValidateValue(v.Value) != nil {
+			continue
+		}
+		found[v.Value] = true
+		allowed = append(allowed, v)
+	}
+	return allowed
+}
+
+var (
+	currencies = map[string]string{"€": "EUR", "$": "USD", "£": "GBP", "EUR": "EUR", "USD": "USD", "GBP": "GBP",
+		"SEK": "SEK", "NOK": "NOK", "DKK": "DKK", "CHF": "CHF"}
+
+	amountNumber     = `(\d{1,3}(?:[ .,\x{00a0}]\d{3})+(?:[.,]\d{1,2})?|\d+(?:[.,]\d{1,2})?)`
+	amountCurrency   = `(€|\$|£|EUR|USD|GBP|SEK|NOK|DKK|CHF)`
+	reAmountPrefixed = regexp.MustCompile(amountCurrency + `\s?` + amountNumber + `\b`)
+	reAmountSuffixed = regexp.MustCompile(`\b` + amountNumber + `\s?` + amountCurrency)
+	// amounts on lines with these words are preferred
+	totalKeywords = []string{"total", "amount due", "to pay", "yhteensä", "maksettava", "summa", "gesamt",
+		"summe", "att betala", "å betale"}
+
+	reIban = regexp.MustCompile(`\b[A-Z]{2}\d{2}(?: ?[A-Z0-9]){11,32}`)
+	// ibanLengths are lengths of IBANs in countries, used to trim text that follows IBAN.
+	ibanLengths = map[string]int{"AT": 20, "BE": 16, "CH": 21, "CZ": 24, "DE": 22, "DK": 18, "EE": 20, "ES": 24,
+		"FI": 18, "FR": 27, "GB": 22, "IE": 22, "IT": 27, "LT": 20, "LU": 20, "LV": 21, "NL": 18, "NO": 15, "PL": 28,
+		"PT": 25, "SE": 24}
+
+	reBic           = regexp.MustCompile(`(?i)\b(?:bic|swift)(?:[ /-]?(?:swift|code|bic))?\s*[:.]?\s*([A-Za-z0-9]{8}(?:[A-Za-z0-9]{3})?)\b`)
+	reBicCode       = regexp.MustCompile(`^[A-Z]{6}[A-Z0-9]{2}(?:[A-Z0-9]{3})?$`)
+	reRfReference   = regexp.MustCompile(`\bRF\d{2}(?: ?[A-Z0-9]){1,25}`)
+	reReference     = regexp.MustCompile(`(?i)\b(?:reference(?: number| no\.?)?|ref\.?|viitenumero|viite)\s*[:.]?\s*(\d[\d ]{0,25}\d)\b`)
+	reInvoiceNumber = regexp.MustCompile(`(?i)\b(?:invoice|laskun?|rechnungs?|faktura)\s*(?:number|no\.?|nr\.?|nro\.?|numero|nummer|#)\s*[:.]?\s*([A-Z0-9][A-Z0-9\-/]*\d[A-Z0-9\-/]*)`)
+	reDueDate       = regexp.MustCompile(`(?i)\b(?:due date|date due|payment due|due|eräpäivä|erapaiva|fällig(?:keitsdatum)?|förfallodag|forfallsdato)[^\d\n]{0,20}?` +
+		`(\d{4}-\d{2}-\d{2}|\d{1,2}\.\d{1,2}\.\d{4}|\d{1,2}/\d{1,2}/\d{4}|\d{1,2}\.? [A-Za-z]+ \d{4}|[A-Za-z]+ \d{1,2},? \d{4})`)
+	dueDateLayouts = []string{"2006-01-02", "2.1.2006", "2/1/2006", "1/2/2006", "2. January 2006", "2 January 2006",
+		"2 Jan 2006", "January 2, 2006", "January 2 2006", "Jan 2, 2006", "Jan 2 2006"}
+)
+
+// extractFieldsFromText finds structured fields from text.
+func extractFieldsFromText(text string) *extractedFields {
+	fields := &extractedFields{}
+	fields.Amount, fields.Currency = findAmount(text)
+	fields.Ibans = findIbans(text)
+	if match := reBic.FindStringSubmatch(text); match != nil && reBicCode.MatchString(match[1]) {
+		fields.Bic = match[1]
+	}
+	fields.Reference = findReference(text)
+	if match := reInvoiceNumber.FindStringSubmatch(text); match != nil {
+		fields.InvoiceNumber = match[1]
+	}
+	fields.DueDate = findDueDate(text)
+	return fields
+}
+
+// findAmount returns the total amount of the text and its currency. Amounts on lines that look like totals are
+// preferred, otherwise the largest amount is returned.
+func findAmount(text string) (float64, string) {
+	var amount, totalAmount float64
+	var currency, totalCurrency string
+	for _, line := range strings.Split(text, "\n") {
+		isTotal := false
+		lower := strings.ToLower(line)
+		for _, v := range totalKeywords {
+			if strings.Contains(lower, v) {
+				isTotal = true
+				break
+			}
+		}
+		matches := make([][2]string, 0)
+		for _, v := range reAmountPrefixed.FindAllStringSubmatch(line, -1) {
+			matches = append(matches, [2]string{v[2], v[1]})
+		}
+		for _, v := range reAmountSuffixed.FindAllStringSubmatch(line, -1) {
+			matches = append(matches, [2]string{v[1], v[2]})
+		}
+		for _, v := range matches {
+			value, err := parseAmount(v[0])
+			if err != nil {
+				continue
+			}
+			if value > amount {
+				amount, currency = value, currencies[v[1]]
+			}
+			if isTotal && value > totalAmount {
+				totalAmount, totalCurrency = value, currencies[v[1]]
+			}
+		}
+	}
+	if totalCurrency != "" {
+		return totalAmount, totalCurrency
+	}
+	return amount, currency
+}
+
+// parseAmount parses number with either comma or dot as decimal separator and optional thousands separators.
+func parseAmount(s string) (float64, error) {
+	s = strings.NewReplacer(" ", "", "\u00a0", "").Replace(s)
+	decimals := ""
+	if i := strings.LastIndexAny(s, ".,"); i >= 0 && len(s)-i-1 <= 2 {
+		decimals = s[i+1:]
+		s = s[:i]
+	}
+	s = strings.NewReplacer(".", "", ",", "").Replace(s)
+	if decimals != "" {
+		s += "." + decimals
+	}
+	return strconv.ParseFloat(s, 64)
+}
+
+// findIbans returns valid IBANs in text without spaces.
+func findIbans(text string) []string {
+	ibans := make([]string, 0)
+	for _, v := range reIban.FindAllString(text, -1) {
+		iban := strings.ReplaceAll(v, " ", "")
+		if length, ok := ibanLengths[iban[:2]]; ok {
+			if len(iban) < length || !validMod97(iban[:length]) {
+				continue
+			}
+			iban = iban[:length]
+		} else if strings.HasPrefix(iban, "RF") {
+			// creditor reference
+			continue
+		} else {
+			iban = validMod97Prefix(iban, 15)
+		}
+		if iban != "" && !containsString(ibans, iban) {
+			ibans = append(ibans, iban)
+		}
+	}
+	return ibans
+}
+
+// findReference returns RF creditor reference, or number following a reference keyword.
+func findReference(text string) string {
+	for _, v := range reRfReference.FindAllString(text, -1) {
+		reference := validMod97Prefix(strings.ReplaceAll(v, " ", ""), 5)
+		if reference != "" {
+			return reference
+		}
+	}
+	if match := reReference.FindStringSubmatch(text); match != nil {
+		return strings.ReplaceAll(match[1], " ", "")
+	}
+	return ""
+}
+
+// findDueDate returns the date following a due date keyword.
+func findDueDate(text string) time.Time {
+	for _, match := range reDueDate.FindAllStringSubmatch(text, -1) {
+		for _, layout := range dueDateLayouts {
+			date, err := time.Parse(layout, match[1])
+			if err == nil {
+				return date
+			}
+		}
+	}
+	return time.Time{}
+}
+
+// validMod97Prefix returns the longest prefix of s, at least minLength characters, that passes ISO 7064 mod 97-10
+// check used in IBANs and RF references. Matches may include text following the code, which is trimmed.
+// Returns empty string if none is valid.
+func validMod97Prefix(s string, minLength int) string {
+	for length := len(s); length >= minLength; length-- {
+		if validMod97(s[:length]) {
+			return s[:length]
+		}
+	}
+	return ""
+}
+
+func validMod97(s string) bool {
+	if len(s) < 5 {
+		return false
+	}
+	rearranged := s[4:] + s[:4]
+	digits := strings.Builder{}
+	for _, r := range rearranged {
+		switch {
+		case r >= '0' && r <= '9':
+			digits.WriteRune(r)
+		case r >= 'A' && r <= 'Z':
+			digits.WriteString(strconv.Itoa(int(r-'A') + 10))
+		default:
+			return false
+		}
+	}
+	n, ok := new(big.Int).SetString(digits.String(), 10)
+	if !ok {
+		return false
+	}
+	return new(big.Int).Mod(n, big.NewInt(97)).Int64() == 1
+}
+
+func containsString(values []string, s string) bool {
+	for _, v := range values {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,182 @@
+package process
+
+import (
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+	"tryffel.net/go/virtualpaper/models"
+	"tryffel.net/go/virtualpaper/storage"
+)
+
+func Test_parseAmount(t *testing.T) {
+	tests := []struct {
+		input string
+		want  float64
+	}{
+		{"1234", 1234},
+		{"12,50", 12.5},
+		{"12.5", 12.5},
+		{"1 234,56", 1234.56},
+		{"1,234.56", 1234.56},
+		{"1.234.567,89", 1234567.89},
+		{"1,234", 1234},
+	}
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			got, err := parseAmount(tt.input)
+			assert.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func Test_findAmount(t *testing.T) {
+	text := `Invoice
+Product A 120,00 EUR
+Discount 5,00 EUR
+Total 115,00 EUR
+`
+	amount, currency := findAmount(text)
+	assert.Equal(t, 115.0, amount)
+	assert.Equal(t, "EUR", currency)
+
+	amount, currency = findAmount("Coffee $3.50\nCake $ 4.20\n")
+	assert.Equal(t, 4.2, amount)
+	assert.Equal(t, "USD", currency)
+
+	amount, currency = findAmount("Amount due: £1,200.00")
+	assert.Equal(t, 1200.0, amount)
+	assert.Equal(t, "GBP", currency)
+
+	amount, currency = findAmount("no amounts here 1234")
+	assert.Equal(t, 0.0, amount)
+	assert.Equal(t, "", currency)
+}
+
+func Test_findIbans(t *testing.T) {
+	text := `Pay to: FI21 1234 5600 0007 85
+Alternative account DE89370400440532013000 BIC: NDEAFIHH
+Invalid FI21 1234 5600 0007 86`
+	assert.Equal(t, []string{"FI2112345600000785", "DE89370400440532013000"}, findIbans(text))
+	assert.True(t, validMod97("GB82WEST12345698765432"))
+	assert.False(t, validMod97("GB82WEST12345698765433"))
+}
+
+func Test_extractFieldsFromText(t *testing.T) {
+	text := `Company Oy
+Invoice number: INV-2024-031
+IBAN FI21 1234 5600 0007 85 BIC: NDEAFIHH
+Reference RF18 5390 0754 7034
+Due date: 15.05.2024
+Total 49,90 €`
+	fields := extractFieldsFromText(text)
+	assert.Equal(t, &extractedFields{
+		Amount:        49.90,
+		Currency:      "EUR",
+		Ibans:         []string{"FI2112345600000785"},
+		Bic:           "NDEAFIHH",
+		Reference:     "RF18539007547034",
+		InvoiceNumber: "INV-2024-031",
+		DueDate:       time.Date(2024, 5, 15, 0, 0, 0, 0, time.UTC),
+	}, fields)
+
+	fields = extractFieldsFromText("Laskun nro 1234\nViitenumero: 12345 67890\n")
+	assert.Equal(t, "1234", fields.InvoiceNumber)
+	assert.Equal(t, "1234567890", fields.Reference)
+	assert.Equal(t, map[string][]string{
+		fieldInvoiceNumber.Name: {"1234"},
+		fieldReference.Name:     {"1234567890"},
+	}, fields.values(nil))
+}
+
+func Test_findDueDate(t *testing.T) {
+	tests := []struct {
+		input string
+		want  time.Time
+	}{
+		{"Due date: 15.05.2024", time.Date(2024, 5, 15, 0, 0, 0, 0, time.UTC)},
+		{"Eräpäivä 2024-05-15", time.Date(2024, 5, 15, 0, 0, 0, 0, time.UTC)},
+		{"Due date: May 5, 2024", time.Date(2024, 5, 5, 0, 0, 0, 0, time.UTC)},
+		{"Payment due on 1 June 2024", time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC)},
+		{"Invoice date 15.05.2024", time.Time{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			assert.Equal(t, tt.want, findDueDate(tt.input))
+		})
+	}
+}
+
+func Test_fieldPropertyAccepts(t *testing.T) {
+	assert.True(t, fieldAmount.accepts(&models.Property{Type: models.FloatProperty}))
+	assert.True(t, fieldAmount.accepts(&models.Property{Type: models.TextProperty}))
+	assert.False(t, fieldAmount.accepts(&models.Property{Type: models.IntProperty}))
+	assert.False(t, fieldDueDate.accepts(&models.Property{Type: models.BooleanProperty}))
+	assert.False(t, fieldIban.accepts(&models.Property{Type: models.JsonProperty}))
+}
+
+func Test_allowedPropertyValues(t *testing.T) {
+	extracted := func(values ...string) []models.DocumentProperty {
+		props := make([]models.DocumentProperty, len(values))
+		for i, v := range values {
+			props[i] = models.DocumentProperty{Value: v, Description: extractedDescription}
+		}
+		return props
+	}
+	values := func(props []models.DocumentProperty) []string {
+		out := make([]string, len(props))
+		for i, v := range props {
+			out[i] = v.Value
+		}
+		return out
+	}
+
+	text := &models.Property{Type: models.TextProperty}
+	assert.Equal(t, []string{"FI2112345600000785", "DE89370400440532013000"},
+		values(allowedPropertyValues(text, nil, extracted("FI2112345600000785", "DE89370400440532013000", "FI2112345600000785"))))
+
+	manual := []models.DocumentProperty{{Value: "FI2112345600000785", Description: "added by user"}}
+	assert.Equal(t, []string{"DE89370400440532013000"},
+		values(allowedPropertyValues(text, manual, extracted("FI2112345600000785", "DE89370400440532013000"))))
+
+	exclusive := &models.Property{Type: models.TextProperty, Exclusive: true}
+	assert.Equal(t, []string{"FI2112345600000785", "DE89370400440532013000"},
+		values(allowedPropertyValues(exclusive, nil, extracted("FI2112345600000785", "DE89370400440532013000"))))
+	assert.Empty(t, allowedPropertyValues(exclusive, manual, extracted("DE89370400440532013000")))
+
+	float := &models.Property{Type: models.FloatProperty}
+	assert.Equal(t, []string{"12.50"}, values(allowedPropertyValues(float, nil, extracted("12.50", "abc"))))
+	integer := &models.Property{Type: models.IntProperty}
+	assert.Empty(t, allowedPropertyValues(integer, nil, extracted("12.50")))
+}
+
+func Test_fileProcessor_replacePropertyValues(t *testing.T) {
+	db, mock, err := storage.NewMockDatabase(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fp := &fileProcessor{Task: &Task{db: db}, document: &models.Document{Id: "doc", UserId: 1}}
+	property := &models.Property{Id: 5, User: 1, Name: "iban", Type: models.TextProperty}
+
+	mock.ExpectQuery("FROM document_properties dp").WithArgs("doc").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "document_id", "property_id", "value", "description", "generated_by"}).
+			// user added value that has the same description as extracted values
+			AddRow(1, "doc", 5, "FI2112345600000785", extractedDescription, "").
+			AddRow(2, "doc", 5, "DE89370400440532013000", extractedDescription, models.ProcessExtractFields).
+			AddRow(3, "doc", 5, "GB29NWBK60161331926819", "QR-Code, page 1", models.ProcessBarcodes).
+			AddRow(4, "doc", 6, "12.50", extractedDescription, models.ProcessExtractFields))
+	mock.ExpectExec("DELETE FROM document_properties").WithArgs(2, "doc").WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("INSERT INTO document_properties").
+		WithArgs("doc", 5, 1, "NL91ABNA0417164300", extractedDescription, false, false, false, models.ProcessExtractFields).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+
+	values := []models.DocumentProperty{
+		{Value: "FI2112345600000785", Description: extractedDescription},
+		{Value: "NL91ABNA0417164300", Description: extractedDescription},
+	}
+	err = fp.replacePropertyValues(db, property, values, models.ProcessExtractFields)
+	assert.NoError(t, err)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
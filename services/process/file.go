@@ -4,26 +4,28 @@ import (
 	"context"
 	"fmt"
 	"os"
-	"path"
 	"strings"
 	"time"
 	"tryffel.net/go/virtualpaper/services/search"
 	log "tryffel.net/go/virtualpaper/util/logger"
 
 	"github.com/sirupsen/logrus"
-	"tryffel.net/go/virtualpaper/config"
 	"tryffel.net/go/virtualpaper/errors"
 	"tryffel.net/go/virtualpaper/models"
 	"tryffel.net/go/virtualpaper/storage"
+	"tryffel.net/go/virtualpaper/storage/blob"
 )
 
 type fpConfig struct {
 	id           int
 	db           *storage.Database
 	search       *search.Engine
+	events       EventEmitter
 	usePdfToText bool
 	useOcr       bool
 	usePandoc    bool
+	// searchablePdf enables generating searchable pdf files.
+	searchablePdf bool
 }
 
 type fileProcessor struct {
@@ -31,14 +33,28 @@ type fileProcessor struct {
 	taskId   string
 	document *models.Document
 	input    chan fileOp
+	events   EventEmitter
+	splitter DocumentSplitter
 	file     string
 	rawFile  *os.File
 	tempFile *os.File
+	// fileCleanup removes file if it was downloaded from blob storage
+	fileCleanup func()
 
 	usePdfToText      bool
 	useOcr            bool
 	usePandoc         bool
+	searchablePdf     bool
 	startedProcessing time.Time
+	// searchablePdfCreated is set when searchable pdf was created during content extraction of current document.
+	searchablePdfCreated bool
+
+	// cancel stops processing current document
+	cancel context.CancelFunc
+	// step is the step being executed, and pagesDone / pagesTotal its progress, if step processes pages.
+	step       models.ProcessStep
+	pagesDone  int
+	pagesTotal int
 
 	logger *logrus.Logger
 	strId  string
@@ -49,10 +65,12 @@ func newFileProcessor(conf *fpConfig) *fileProcessor {
 		Task:  newTask(conf.id, conf.db, conf.search),
 		input: make(chan fileOp, taskQueueSize),
 
-		usePdfToText: conf.usePdfToText,
-		useOcr:       conf.useOcr,
-		usePandoc:    conf.usePandoc,
-		strId:        fmt.Sprintf("%d", conf.id),
+		events:        conf.events,
+		usePdfToText:  conf.usePdfToText,
+		useOcr:        conf.useOcr,
+		usePandoc:     conf.usePandoc,
+		searchablePdf: conf.searchablePdf,
+		strId:         fmt.Sprintf("%d", conf.id),
 	}
 	fp.idle = true
 	fp.runFunc = fp.waitEvent
@@ -109,6 +127,34 @@ func (fp *fileProcessor) GetDocumentBeingProcessed() (bool, string) {
 	return true, doc.Id
 }
 
+// setProgress sets the current processing step and its progress in pages.
+func (fp *fileProcessor) setProgress(step models.ProcessStep, pagesDone, pagesTotal int) {
+	fp.lock.Lock()
+	defer fp.lock.Unlock()
+	fp.step = step
+	fp.pagesDone = pagesDone
+	fp.pagesTotal = pagesTotal
+}
+
+// GetProgress returns the current processing step and its progress in pages.
+func (fp *fileProcessor) GetProgress() (models.ProcessStep, int, int) {
+	fp.lock.RLock()
+	defer fp.lock.RUnlock()
+	return fp.step, fp.pagesDone, fp.pagesTotal
+}
+
+// cancelDocument stops processing document if it is currently being processed. Returns true if
+// processing was stopped.
+func (fp *fileProcessor) cancelDocument(docId string) bool {
+	fp.lock.RLock()
+	defer fp.lock.RUnlock()
+	if fp.document == nil || fp.document.Id != docId || fp.cancel == nil {
+		return false
+	}
+	fp.cancel()
+	return true
+}
+
 func (fp *fileProcessor) ProcessingDurationMs() int {
 	if fp.startedProcessing.IsZero() {
 		return 0
@@ -168,9 +214,22 @@ func (fp *fileProcessor) recoverPanic() {
 	}
 }
 
-// cancel ongoing processing, in case of errors.
+// cancelReasonUser is the reason for cancelling when processing was stopped by user.
+const cancelReasonUser = "cancelled by user"
+
+// cancel ongoing processing, in case of errors or if requested by user.
 // without cancel processing probably gets stuck in the same processing step.
+// On errors, the document is marked as failed.
 func (fp *fileProcessor) cancelDocumentProcessing(ctx context.Context, reason string) error {
+	if fp.document != nil && reason == cancelReasonUser {
+		log.Context(ctx).WithField("documentId", fp.document.Id).Info("processing document cancelled")
+		err := fp.db.JobStore.CancelDocumentProcessing(fp.document.Id)
+		if err != nil {
+			return fmt.Errorf("cancel document processing: %v", err)
+		}
+		fp.document = nil
+		return nil
+	}
 	if fp.document != nil {
 		log.Context(ctx).WithField("documentId", fp.document.Id).Warning("cancel processing document due to errors")
 		err := fp.db.JobStore.CancelDocumentProcessing(fp.document.Id)
@@ -212,15 +271,13 @@ func (fp *fileProcessor) process(op fileOp) {
 		return
 	}
 	fp.document = doc
-	fp.file = storage.DocumentPath(op.docId)
 
 	fp.startedProcessing = time.Now()
 	fp.processDocument()
 	fp.startedProcessing = time.Time{}
 }
 
-// re-calculate hash. If it differs from current document.Hash, update document record and rename file to new hash,
-// if different.
+// re-calculate hash. If it differs from current document.Hash, update document record.
 func (fp *fileProcessor) updateHash(ctx context.Context, doc *models.Document) error {
 	process := &models.ProcessItem{
 		DocumentId: fp.document.Id,
@@ -246,7 +303,7 @@ func (fp *fileProcessor) updateHash(ctx context.Context, doc *models.Document) e
 	}
 
 	if hash != doc.Hash {
-		log.Info(ctx, "rename file", map[string]interface{}{"old-name": doc.Hash, "new-name": hash})
+		log.Info(ctx, "update hash", map[string]interface{}{"old-hash": doc.Hash, "new-hash": hash})
 	} else {
 		log.Info(ctx, "hash not changed", map[string]interface{}{"name": doc.Hash})
 		job.Status = models.JobFinished
@@ -254,13 +311,6 @@ func (fp *fileProcessor) updateHash(ctx context.Context, doc *models.Document) e
 		return nil
 	}
 
-	oldName := fp.rawFile.Name()
-	err = os.Rename(oldName, path.Join(config.C.Processing.DocumentsDir, hash))
-	if err != nil {
-		job.Status = models.JobFailure
-		return fmt.Errorf("rename file (doc %s) by old hash: %v", fp.document.Id, err)
-	}
-
 	fp.document.Hash = hash
 	err = fp.db.DocumentStore.Update(fp.db, storage.UserIdInternal, fp.document)
 	if err != nil {
@@ -277,6 +327,15 @@ func (fp *fileProcessor) ensureFileOpen() error {
 		return nil
 	}
 	var err error
+	if fp.file == "" {
+		// external programs need a local file, download it if blob storage is not local
+		fp.file, fp.fileCleanup, err = blob.LocalFile(context.Background(), fp.db.Files,
+			storage.DocumentKey(fp.document.Id), storage.TempFilePath(fp.document.Id+"-file"))
+		if err != nil {
+			fp.file = ""
+			return fmt.Errorf("get file: %v", err)
+		}
+	}
 	fp.rawFile, err = os.OpenFile(fp.file, os.O_RDONLY, os.ModePerm)
 	if err != nil {
 		return fmt.Errorf("open file: %v", err)
@@ -284,7 +343,35 @@ func (fp *fileProcessor) ensureFileOpen() error {
 	return nil
 }
 
-func (fp fileProcessor) ensureFileOpenAndLogFailure() error {
+// processingFile returns local path of the file to generate previews and OCR from: the preprocessed file if
+// document has one, else the original file. Cleanup must be called when file is not needed anymore.
+func (fp *fileProcessor) processingFile(ctx context.Context) (string, func(), error) {
+	key := storage.PreprocessedKey(fp.document.Id)
+	_, err := fp.db.Files.Stat(ctx, key)
+	if errors.Is(err, os.ErrNotExist) {
+		err = fp.ensureFileOpen()
+		if err != nil {
+			return "", nil, err
+		}
+		return fp.rawFile.Name(), func() {}, nil
+	}
+	if err != nil {
+		return "", nil, fmt.Errorf("get preprocessed file: %v", err)
+	}
+	return blob.LocalFile(ctx, fp.db.Files, key, storage.TempFilePath(fp.document.Id+"-preprocessed"))
+}
+
+// hasTextLayer returns true if document is a pdf that already contains text, i.e. it is not a scanned document.
+// File must be open.
+func (fp *fileProcessor) hasTextLayer() bool {
+	if !fp.document.IsPdf() || !fp.usePdfToText {
+		return false
+	}
+	text, err := getPdfToText(fp.rawFile, fp.document.Id)
+	return err == nil && strings.TrimSpace(text) != ""
+}
+
+func (fp *fileProcessor) ensureFileOpenAndLogFailure() error {
 	err := fp.ensureFileOpen()
 	if err != nil {
 		fp.Error("open file: %v", err)
@@ -311,6 +398,12 @@ func (fp *fileProcessor) indexSearchContent(ctx context.Context) error {
 
 	defer fp.completeProcessingStep(process, job)
 
+	if fp.document.DeletedAt.Valid {
+		// document was moved to trash during processing, e.g. by a rule, and it must not be indexed again.
+		log.Context(ctx).Info("Document is deleted, skip indexing")
+		return nil
+	}
+
 	if len(fp.document.Tags) == 0 {
 		tags, err := fp.db.MetadataStore.GetDocumentTags(fp.document.UserId, fp.document.Id)
 		if err != nil {
@@ -22,8 +22,12 @@ import (
 	"context"
 	"fmt"
 	"github.com/sirupsen/logrus"
+	"os"
 	"os/exec"
+	"path"
 	"regexp"
+	"strconv"
+	"strings"
 	"tryffel.net/go/virtualpaper/config"
 	log "tryffel.net/go/virtualpaper/util/logger"
 )
@@ -55,27 +59,44 @@ func GetImagickVersion() string {
 	return ver
 }
 
-func callImagick(args ...string) error {
+// callImagick runs imagick with given args. Imagick is killed if ctx is cancelled.
+func callImagick(ctx context.Context, args ...string) error {
 	stdout := &bytes.Buffer{}
 	stderr := &bytes.Buffer{}
 
-	logrus.Debugf("call imagick: %s, %v", config.C.Processing.ImagickBin, args)
-	cmd := exec.Command(config.C.Processing.ImagickBin, args...)
+	log.Context(ctx).Debugf("call imagick: %s, %v", config.C.Processing.ImagickBin, args)
+	cmd := exec.CommandContext(ctx, config.C.Processing.ImagickBin, args...)
 	cmd.Stdout = stdout
 	cmd.Stderr = stderr
 	err := cmd.Run()
 	stdErr := stderr.String()
 	if stdErr != "" {
-		logrus.Warningf("Imagemagick failed, stderr: %v", err)
+		log.Context(ctx).Warningf("Imagemagick failed, stderr: %v", err)
 		return err
 	}
 	if err != nil {
-		logrus.Warningf("run %v: %v", args, err)
+		log.Context(ctx).Warningf("run %v: %v", args, err)
 		return fmt.Errorf("execute convert: %v", err)
 	}
 	return nil
 }
 
+// callImagickOutput runs imagick and returns its output, e.g. values printed with '-print'.
+func callImagickOutput(ctx context.Context, args ...string) (string, error) {
+	stdout := &bytes.Buffer{}
+	stderr := &bytes.Buffer{}
+
+	log.Context(ctx).Debugf("call imagick: %s, %v", config.C.Processing.ImagickBin, args)
+	cmd := exec.CommandContext(ctx, config.C.Processing.ImagickBin, args...)
+	cmd.Stdout = stdout
+	cmd.Stderr = stderr
+	err := cmd.Run()
+	if err != nil {
+		return "", fmt.Errorf("execute convert: %v, stderr: %s", err, stderr.String())
+	}
+	return stdout.String(), nil
+}
+
 func generateThumbnail(ctx context.Context, rawFile string, previewFile string, page int, size int, mimetype string) error {
 	if mimetype == "text/plain" {
 		return generateThumbnailPlainText(rawFile, previewFile, size)
@@ -91,7 +112,58 @@ func generateThumbnail(ctx context.Context, rawFile string, previewFile string,
 	}
 
 	log.Context(ctx).Infof("call imagick: '%s'", args)
-	return callImagick(args...)
+	return callImagick(ctx, args...)
+}
+
+// generatePagePreviews renders all pages of the file with given height to outputDir.
+// It returns the rendered files ordered by page.
+func generatePagePreviews(ctx context.Context, rawFile string, outputDir string, size int, mimetype string) ([]string, error) {
+	if mimetype == "text/plain" {
+		output := path.Join(outputDir, "page-0.png")
+		return []string{output}, generateThumbnailPlainText(rawFile, output, size)
+	}
+
+	args := []string{
+		"-thumbnail", fmt.Sprintf("x%d", size),
+		"-background", "white",
+		"-colorspace", "RGB",
+		rawFile,
+		path.Join(outputDir, "page-%d.png"),
+	}
+
+	log.Context(ctx).Infof("call imagick: '%s'", args)
+	err := callImagick(ctx, args...)
+	if err != nil {
+		return nil, err
+	}
+	return pagePreviewFiles(outputDir)
+}
+
+// pagePreviewFiles returns files named 'page-<n>.png' in dir, ordered by page.
+func pagePreviewFiles(dir string) ([]string, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	pages := make(map[int]string, len(entries))
+	for _, v := range entries {
+		name := strings.TrimSuffix(strings.TrimPrefix(v.Name(), "page-"), ".png")
+		page, err := strconv.Atoi(name)
+		if err != nil || v.IsDir() {
+			continue
+		}
+		pages[page] = path.Join(dir, v.Name())
+	}
+
+	files := make([]string, 0, len(pages))
+	for i := 0; i < len(pages); i++ {
+		file, ok := pages[i]
+		if !ok {
+			return nil, fmt.Errorf("preview for page %d not found", i+1)
+		}
+		files = append(files, file)
+	}
+	return files, nil
 }
 
 func generatePicture(ctx context.Context, rawFile string, pictureFile string) error {
@@ -102,5 +174,5 @@ func generatePicture(ctx context.Context, rawFile string, pictureFile string) er
 		pictureFile,
 	}
 	log.Context(ctx).Infof("call imageick: '%s'", args)
-	return callImagick(args...)
+	return callImagick(ctx, args...)
 }
@@ -242,3 +242,74 @@ var supportedLinguaLanguages = map[lingua.Language]string{
 	lingua.Yoruba:      "yo",
 	lingua.Zulu:        "zu",
 }
+
+// tesseractLanguages maps detected languages to tesseract language codes.
+var tesseractLanguages = map[string]string{
+	"af": "afr",
+	"sq": "sqi",
+	"ar": "ara",
+	"hy": "hye",
+	"az": "aze",
+	"eu": "eus",
+	"be": "bel",
+	"bn": "ben",
+	"nb": "nor",
+	"bs": "bos",
+	"bg": "bul",
+	"ca": "cat",
+	"zh": "chi_sim",
+	"hr": "hrv",
+	"cs": "ces",
+	"da": "dan",
+	"nl": "nld",
+	"en": "eng",
+	"eo": "epo",
+	"et": "est",
+	"fi": "fin",
+	"fr": "fra",
+	"ka": "kat",
+	"de": "deu",
+	"el": "ell",
+	"gu": "guj",
+	"he": "heb",
+	"hi": "hin",
+	"hu": "hun",
+	"is": "isl",
+	"id": "ind",
+	"ga": "gle",
+	"it": "ita",
+	"ja": "jpn",
+	"kk": "kaz",
+	"ko": "kor",
+	"la": "lat",
+	"lv": "lav",
+	"lt": "lit",
+	"mk": "mkd",
+	"ms": "msa",
+	"mi": "mri",
+	"mr": "mar",
+	"mn": "mon",
+	"nn": "nor",
+	"fa": "fas",
+	"pl": "pol",
+	"pt": "por",
+	"pa": "pan",
+	"rm": "ron",
+	"ru": "rus",
+	"sr": "srp",
+	"sk": "slk",
+	"sl": "slv",
+	"es": "spa",
+	"sw": "swa",
+	"sv": "swe",
+	"tl": "tgl",
+	"ta": "tam",
+	"te": "tel",
+	"th": "tha",
+	"tr": "tur",
+	"uk": "ukr",
+	"ur": "urd",
+	"vi": "vie",
+	"cy": "cym",
+	"yo": "yor",
+}
@@ -22,3 +22,16 @@ func TestGetLanguage(t *testing.T) {
 		}
 	}
 }
+
+func TestDetectOcrLanguage(t *testing.T) {
+	initLanguageDetector()
+	ctx := log.ContextWithTaskId(context.Background(), "test-task")
+	text := "a much longer Variant OF TEXTs are represented here"
+
+	if lang := detectOcrLanguage(ctx, text, []string{"fin", "eng"}); lang != "eng" {
+		t.Errorf("detect ocr language, got '%s', want: 'eng'", lang)
+	}
+	if lang := detectOcrLanguage(ctx, text, []string{"fin", "deu"}); lang != "" {
+		t.Errorf("detect ocr language not in languages, got '%s', want: ''", lang)
+	}
+}
@@ -0,0 +1,256 @@
+/*
+ * Virtualpaper is a service to manage users paper documents in virtual format.
+ * Copyright (C) 2024  Tero Vierimaa
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package process
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"html"
+	"io"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net/mail"
+	"os"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// MailMessage is a parsed rfc822 message.
+type MailMessage struct {
+	Subject string
+	From    string
+	To      string
+	Date    time.Time
+	// Text is the plain text body, if any
+	Text string
+	// Html is the html body, if any
+	Html        string
+	Attachments []MailAttachment
+}
+
+// MailAttachment is a single file attached to a message.
+type MailAttachment struct {
+	Filename string
+	Mimetype string
+	Data     []byte
+}
+
+var mailWordDecoder = &mime.WordDecoder{}
+
+// ParseMailMessage parses rfc822 message, decoding body and attachments.
+func ParseMailMessage(r io.Reader) (*MailMessage, error) {
+	msg, err := mail.ReadMessage(r)
+	if err != nil {
+		return nil, fmt.Errorf("read message: %v", err)
+	}
+
+	parsed := &MailMessage{
+		Subject:     decodeMailHeader(msg.Header.Get("Subject")),
+		From:        decodeMailHeader(msg.Header.Get("From")),
+		To:          decodeMailHeader(msg.Header.Get("To")),
+		Attachments: []MailAttachment{},
+	}
+	parsed.Date, _ = msg.Header.Date()
+
+	err = parsed.parsePart(msg.Header, msg.Body)
+	return parsed, err
+}
+
+func (m *MailMessage) parsePart(header mail.Header, body io.Reader) error {
+	contentType := header.Get("Content-Type")
+	if contentType == "" {
+		contentType = "text/plain"
+	}
+	mediaType, params, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		mediaType = "application/octet-stream"
+	}
+
+	if strings.HasPrefix(mediaType, "multipart/") {
+		reader := multipart.NewReader(body, params["boundary"])
+		for {
+			part, err := reader.NextRawPart()
+			if err == io.EOF {
+				return nil
+			}
+			if err != nil {
+				return fmt.Errorf("read multipart: %v", err)
+			}
+			err = m.parsePart(mail.Header(part.Header), part)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	data, err := io.ReadAll(decodeTransferEncoding(header.Get("Content-Transfer-Encoding"), body))
+	if err != nil {
+		return fmt.Errorf("decode message part: %v", err)
+	}
+
+	filename := ""
+	disposition, dispositionParams, err := mime.ParseMediaType(header.Get("Content-Disposition"))
+	if err == nil {
+		filename = dispositionParams["filename"]
+	}
+	if filename == "" {
+		filename = params["name"]
+	}
+	filename = decodeMailHeader(filename)
+
+	if disposition == "attachment" || filename != "" {
+		m.Attachments = append(m.Attachments, MailAttachment{
+			Filename: filename,
+			Mimetype: mediaType,
+			Data:     data,
+		})
+		return nil
+	}
+
+	switch mediaType {
+	case "text/plain":
+		if m.Text == "" {
+			m.Text = string(data)
+		}
+	case "text/html":
+		if m.Html == "" {
+			m.Html = string(data)
+		}
+	case "message/rfc822":
+		m.Attachments = append(m.Attachments, MailAttachment{
+			Filename: "message.eml",
+			Mimetype: mediaType,
+			Data:     data,
+		})
+	}
+	return nil
+}
+
+func decodeTransferEncoding(encoding string, r io.Reader) io.Reader {
+	switch strings.ToLower(strings.TrimSpace(encoding)) {
+	case "base64":
+		return base64.NewDecoder(base64.StdEncoding, r)
+	case "quoted-printable":
+		return quotedprintable.NewReader(r)
+	default:
+		return r
+	}
+}
+
+func decodeMailHeader(value string) string {
+	decoded, err := mailWordDecoder.DecodeHeader(value)
+	if err != nil {
+		return value
+	}
+	return decoded
+}
+
+const (
+	// MimetypeEml is the mimetype of rfc822 messages, .eml files.
+	MimetypeEml = "message/rfc822"
+	// MimetypeMsg is the mimetype of Outlook .msg files.
+	MimetypeMsg = "application/vnd.ms-outlook"
+)
+
+// IsMailMimetype returns true if mimetype is either .eml or .msg message.
+func IsMailMimetype(mimetype string) bool {
+	return mimetype == MimetypeEml || mimetype == MimetypeMsg
+}
+
+var reMailHeaderLine = regexp.MustCompile(`^[A-Za-z0-9-]+:`)
+
+// DetectMailMimetype returns the mail mimetype of the file contents, or empty string if data does not look like
+// a message. Data can be the beginning of the file.
+func DetectMailMimetype(data []byte) string {
+	if bytes.HasPrefix(data, cfbSignature) {
+		return MimetypeMsg
+	}
+	if bytes.IndexByte(data, 0) < 0 && reMailHeaderLine.Match(data) {
+		return MimetypeEml
+	}
+	return ""
+}
+
+// ParseMailFile parses .eml or .msg file.
+func ParseMailFile(mimetype string, file string) (*MailMessage, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, fmt.Errorf("read file: %v", err)
+	}
+	if mimetype == MimetypeMsg {
+		return ParseOutlookMessage(data)
+	}
+	return ParseMailMessage(bytes.NewReader(data))
+}
+
+// PlainText returns the headers and the body of the message as text. If message has no plain text body,
+// text is extracted from html body.
+func (m *MailMessage) PlainText() string {
+	lines := []string{}
+	if m.Subject != "" {
+		lines = append(lines, "Subject: "+m.Subject)
+	}
+	if m.From != "" {
+		lines = append(lines, "From: "+m.From)
+	}
+	if m.To != "" {
+		lines = append(lines, "To: "+m.To)
+	}
+	if !m.Date.IsZero() {
+		lines = append(lines, "Date: "+m.Date.Format(time.RFC1123Z))
+	}
+	if len(m.Attachments) > 0 {
+		names := make([]string, len(m.Attachments))
+		for i, v := range m.Attachments {
+			names[i] = v.Filename
+		}
+		lines = append(lines, "Attachments: "+strings.Join(names, ", "))
+	}
+
+	body := m.Text
+	if strings.TrimSpace(body) == "" {
+		body = htmlToText(m.Html)
+	}
+	body = strings.ReplaceAll(body, "\r\n", "\n")
+	return strings.Join(lines, "\n") + "\n\n" + strings.TrimSpace(body)
+}
+
+var (
+	reHtmlIgnored    = regexp.MustCompile(`(?is)<(head|style|script)[^>]*>.*?</(head|style|script)>`)
+	reHtmlLineBreaks = regexp.MustCompile(`(?i)<(br|/p|/div|/tr|/h[1-6]|/li)[^>]*>`)
+	reHtmlTags       = regexp.MustCompile(`(?s)<[^>]*>`)
+	reBlankLines     = regexp.MustCompile(`\n\s*\n\s*\n+`)
+)
+
+// htmlToText returns text content of html, keeping line breaks of block elements.
+func htmlToText(text string) string {
+	text = reHtmlIgnored.ReplaceAllString(text, "")
+	text = strings.NewReplacer("\r", "", "\n", " ").Replace(text)
+	text = reHtmlLineBreaks.ReplaceAllString(text, "\n")
+	text = reHtmlTags.ReplaceAllString(text, "")
+	text = html.UnescapeString(text)
+	lines := strings.Split(text, "\n")
+	for i, v := range lines {
+		lines[i] = strings.Join(strings.Fields(v), " ")
+	}
+	return strings.TrimSpace(reBlankLines.ReplaceAllString(strings.Join(lines, "\n"), "\n\n"))
+}
@@ -1,6 +1,7 @@
 package process
 
 import (
+	"context"
 	"errors"
 	"sync"
 	"time"
@@ -28,6 +29,7 @@ type Manager struct {
 	reportChan chan TaskReport
 	db         *storage.Database
 	search     *search.Engine
+	events     EventEmitter
 
 	tasks    []*fileProcessor
 	numtasks int
@@ -36,12 +38,19 @@ type Manager struct {
 	runFunctimer   *time.Timer
 }
 
-func NewManager(database *storage.Database, search *search.Engine) (*Manager, error) {
+// EventEmitter is notified of document events, e.g. to send them to webhooks.
+type EventEmitter interface {
+	Emit(ctx context.Context, event string, docId string)
+}
+
+// NewManager creates new manager. Events can be nil.
+func NewManager(database *storage.Database, search *search.Engine, events EventEmitter) (*Manager, error) {
 	manager := &Manager{
 		lock:           &sync.RWMutex{},
 		reportChan:     make(chan TaskReport, 10),
 		db:             database,
 		search:         search,
+		events:         events,
 		checkJobstimer: time.NewTimer(idleCheckDocumentsForProcessingSec),
 		runFunctimer:   time.NewTimer(time.Millisecond * 100),
 	}
@@ -72,9 +81,12 @@ func NewManager(database *storage.Database, search *search.Engine) (*Manager, er
 			id:           i,
 			db:           database,
 			search:       search,
+			events:       events,
 			usePdfToText: usePdfToText,
 			useOcr:       useOcr,
 			usePandoc:    usePandoc,
+
+			searchablePdf: config.C.Processing.SearchablePdf,
 		}
 		manager.tasks[i] = newFileProcessor(conf)
 	}
@@ -179,6 +191,11 @@ type QueueStatus struct {
 	ProcessingDocumentId string `json:"processing_document_id"`
 	Running              bool   `json:"task_running"`
 	DurationMs           int    `json:"duration_ms"`
+	// ProcessingStep is the step being executed.
+	ProcessingStep string `json:"processing_step"`
+	// PagesDone and PagesTotal report progress of steps that process each page, e.g. OCR.
+	PagesDone  int `json:"pages_done"`
+	PagesTotal int `json:"pages_total"`
 }
 
 func (m *Manager) ProcessingStatus() []QueueStatus {
@@ -190,10 +207,34 @@ func (m *Manager) ProcessingStatus() []QueueStatus {
 		status[i].ProcessingOngoing, status[i].ProcessingDocumentId = v.GetDocumentBeingProcessed()
 		status[i].Running = v.isRunning()
 		status[i].DurationMs = v.ProcessingDurationMs()
+		step, pagesDone, pagesTotal := v.GetProgress()
+		status[i].ProcessingStep = step.String()
+		status[i].PagesDone = pagesDone
+		status[i].PagesTotal = pagesTotal
 	}
 	return status
 }
 
+// SetDocumentSplitter sets splitter that splits documents at separator barcodes. It must be set before
+// starting the manager.
+func (m *Manager) SetDocumentSplitter(splitter DocumentSplitter) {
+	for _, v := range m.tasks {
+		v.splitter = splitter
+	}
+}
+
+// CancelDocumentProcessing stops processing document if it is currently being processed.
+// Remaining processing steps of the document are removed from the queue.
+// Returns true if document was being processed.
+func (m *Manager) CancelDocumentProcessing(docId string) bool {
+	for _, v := range m.tasks {
+		if v.cancelDocument(docId) {
+			return true
+		}
+	}
+	return false
+}
+
 // AddDocumentForProcessing marks document as available for processing.
 func (m *Manager) AddDocumentForProcessing(docId string) error {
 	if !m.QueueFull() {
@@ -64,7 +64,10 @@ func buildMimeDataMapping() {
 	jpg := file{"image/jpg", "jpg", "Image"}
 	jpeg := file{"image/jpeg", "jpeg", "Image"}
 
-	supportedTypes = append(supportedTypes, pdf, png, jpg, jpeg)
+	eml := file{MimetypeEml, "eml", "Email message"}
+	msg := file{MimetypeMsg, "msg", "Outlook message"}
+
+	supportedTypes = append(supportedTypes, pdf, png, jpg, jpeg, eml, msg)
 
 	if GetPandocInstalled() {
 		csv := file{"text/csv", "csv", "Csv"}
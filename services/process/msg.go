@@ -0,0 +1,429 @@
+package process
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+	"unicode/utf16"
+
+	"tryffel.net/go/virtualpaper/errors"
+)
+
+// Outlook .msg files are OLE2 compound files. Message properties are stored as streams named
+// '__substg1.0_<property id><property type>' and attachments as storages named '__attach_version1.0_#<n>'.
+
+var cfbSignature = []byte{0xD0, 0xCF, 0x11, 0xE0, 0xA1, 0xB1, 0x1A, 0xE1}
+
+const (
+	cfbEndOfChain = 0xFFFFFFFE
+	cfbFreeSector = 0xFFFFFFFF
+	cfbNoStream   = 0xFFFFFFFF
+
+	cfbTypeStorage = 1
+	cfbTypeStream  = 2
+
+	cfbHeaderDifatEntries = 109
+	cfbDirEntrySize       = 128
+)
+
+// cfbEntry is a directory entry, either a storage or a stream.
+type cfbEntry struct {
+	Name  string
+	Type  byte
+	Left  uint32
+	Right uint32
+	Child uint32
+	Start uint32
+	Size  uint64
+}
+
+// cfbFile is a minimal read-only compound file binary reader.
+type cfbFile struct {
+	data           []byte
+	sectorSize     int
+	miniSectorSize int
+	miniCutoff     uint64
+	fat            []uint32
+	miniFat        []uint32
+	miniStream     []byte
+	entries        []cfbEntry
+}
+
+func readCfbFile(data []byte) (*cfbFile, error) {
+	if len(data) < 512 || !bytes.Equal(data[:8], cfbSignature) {
+		return nil, errors.New("not a compound file")
+	}
+	le := binary.LittleEndian
+	sectorShift := le.Uint16(data[0x1E:])
+	if sectorShift != 9 && sectorShift != 12 {
+		return nil, fmt.Errorf("invalid sector size: %d", sectorShift)
+	}
+	miniSectorShift := le.Uint16(data[0x20:])
+	if miniSectorShift != 6 {
+		return nil, fmt.Errorf("invalid mini sector size: %d", miniSectorShift)
+	}
+	f := &cfbFile{
+		data:           data,
+		sectorSize:     1 << sectorShift,
+		miniSectorSize: 1 << miniSectorShift,
+		miniCutoff:     uint64(le.Uint32(data[0x38:])),
+	}
+	fatCount := int(le.Uint32(data[0x2C:]))
+	dirStart := le.Uint32(data[0x30:])
+	miniFatStart := le.Uint32(data[0x3C:])
+	difatSector := le.Uint32(data[0x44:])
+	difatCount := int(le.Uint32(data[0x48:]))
+
+	// header takes the first sector
+	sectorCount := len(data)/f.sectorSize - 1
+	if fatCount > sectorCount || difatCount > sectorCount {
+		return nil, errors.New("file is truncated")
+	}
+
+	fatSectors := make([]uint32, 0, fatCount)
+	for i := 0; i < cfbHeaderDifatEntries; i++ {
+		if v := le.Uint32(data[0x4C+i*4:]); v != cfbFreeSector {
+			fatSectors = append(fatSectors, v)
+		}
+	}
+	perSector := f.sectorSize / 4
+	for i := 0; i < difatCount && difatSector < cfbEndOfChain; i++ {
+		sector, err := f.sector(difatSector)
+		if err != nil {
+			return nil, fmt.Errorf("read difat: %v", err)
+		}
+		for j := 0; j < perSector-1; j++ {
+			if v := le.Uint32(sector[j*4:]); v != cfbFreeSector {
+				fatSectors = append(fatSectors, v)
+			}
+		}
+		difatSector = le.Uint32(sector[(perSector-1)*4:])
+	}
+	if len(fatSectors) > fatCount {
+		fatSectors = fatSectors[:fatCount]
+	}
+	for _, v := range fatSectors {
+		sector, err := f.sector(v)
+		if err != nil {
+			return nil, fmt.Errorf("read fat: %v", err)
+		}
+		f.fat = append(f.fat, bytesToUint32s(sector)...)
+	}
+
+	dir, err := f.readChain(dirStart, f.fat, f.sector)
+	if err != nil {
+		return nil, fmt.Errorf("read directory: %v", err)
+	}
+	for offset := 0; offset+cfbDirEntrySize <= len(dir); offset += cfbDirEntrySize {
+		f.entries = append(f.entries, parseCfbEntry(dir[offset:offset+cfbDirEntrySize], sectorShift == 9))
+	}
+	if len(f.entries) == 0 {
+		return nil, errors.New("no root entry")
+	}
+
+	root := f.entries[0]
+	if root.Size > 0 {
+		f.miniStream, err = f.readChain(root.Start, f.fat, f.sector)
+		if err != nil {
+			return nil, fmt.Errorf("read mini stream: %v", err)
+		}
+	}
+	if miniFatStart < cfbEndOfChain {
+		miniFat, err := f.readChain(miniFatStart, f.fat, f.sector)
+		if err != nil {
+			return nil, fmt.Errorf("read mini fat: %v", err)
+		}
+		f.miniFat = bytesToUint32s(miniFat)
+	}
+	return f, nil
+}
+
+func parseCfbEntry(data []byte, version3 bool) cfbEntry {
+	le := binary.LittleEndian
+	nameLength := int(le.Uint16(data[0x40:]))
+	if nameLength > 64 {
+		nameLength = 64
+	}
+	name := make([]uint16, 0, 32)
+	// length includes the terminating null character
+	for i := 0; i+2 <= nameLength-2; i += 2 {
+		name = append(name, le.Uint16(data[i:]))
+	}
+	entry := cfbEntry{
+		Name:  string(utf16.Decode(name)),
+		Type:  data[0x42],
+		Left:  le.Uint32(data[0x44:]),
+		Right: le.Uint32(data[0x48:]),
+		Child: le.Uint32(data[0x4C:]),
+		Start: le.Uint32(data[0x74:]),
+		Size:  le.Uint64(data[0x78:]),
+	}
+	if version3 {
+		// high bits of size may contain garbage in version 3 files
+		entry.Size &= 0xFFFFFFFF
+	}
+	return entry
+}
+
+// sector returns the sector n. Sector that is not fully in the file is an error.
+func (f *cfbFile) sector(n uint32) ([]byte, error) {
+	offset := (int(n) + 1) * f.sectorSize
+	if offset+f.sectorSize > len(f.data) {
+		return nil, fmt.Errorf("sector %d out of range", n)
+	}
+	return f.data[offset : offset+f.sectorSize], nil
+}
+
+func (f *cfbFile) miniSector(n uint32) ([]byte, error) {
+	offset := int(n) * f.miniSectorSize
+	if offset+f.miniSectorSize > len(f.miniStream) {
+		return nil, fmt.Errorf("mini sector %d out of range", n)
+	}
+	return f.miniStream[offset : offset+f.miniSectorSize], nil
+}
+
+// readChain reads sectors starting from start, following the allocation table.
+func (f *cfbFile) readChain(start uint32, table []uint32, read func(uint32) ([]byte, error)) ([]byte, error) {
+	data := make([]byte, 0)
+	visited := make(map[uint32]bool)
+	for n := start; n < cfbEndOfChain; {
+		if int(n) >= len(table) || visited[n] {
+			return nil, fmt.Errorf("invalid sector chain at %d", n)
+		}
+		visited[n] = true
+		sector, err := read(n)
+		if err != nil {
+			return nil, err
+		}
+		data = append(data, sector...)
+		n = table[n]
+	}
+	return data, nil
+}
+
+// stream returns the contents of stream entry.
+func (f *cfbFile) stream(entry cfbEntry) ([]byte, error) {
+	if entry.Size == 0 {
+		return []byte{}, nil
+	}
+	var data []byte
+	var err error
+	if entry.Size < f.miniCutoff {
+		data, err = f.readChain(entry.Start, f.miniFat, f.miniSector)
+	} else {
+		data, err = f.readChain(entry.Start, f.fat, f.sector)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if uint64(len(data)) < entry.Size {
+		return nil, fmt.Errorf("stream '%s' is truncated", entry.Name)
+	}
+	return data[:entry.Size], nil
+}
+
+// children returns the indexes of the entries in storage.
+func (f *cfbFile) children(storage int) []int {
+	children := make([]int, 0)
+	visited := make(map[uint32]bool)
+	stack := []uint32{f.entries[storage].Child}
+	for len(stack) > 0 {
+		i := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		if i == cfbNoStream || int(i) >= len(f.entries) || visited[i] {
+			continue
+		}
+		visited[i] = true
+		children = append(children, int(i))
+		stack = append(stack, f.entries[i].Left, f.entries[i].Right)
+	}
+	return children
+}
+
+func bytesToUint32s(data []byte) []uint32 {
+	values := make([]uint32, len(data)/4)
+	for i := range values {
+		values[i] = binary.LittleEndian.Uint32(data[i*4:])
+	}
+	return values
+}
+
+// msg property ids and types
+const (
+	msgPropertySubject        = "0037"
+	msgPropertySenderName     = "0C1A"
+	msgPropertySenderEmail    = "0C1F"
+	msgPropertySenderSmtp     = "5D01"
+	msgPropertyDisplayTo      = "0E04"
+	msgPropertyBody           = "1000"
+	msgPropertyHtml           = "1013"
+	msgPropertyAttachData     = "3701"
+	msgPropertyAttachFilename = "3704"
+	msgPropertyAttachLongName = "3707"
+	msgPropertyAttachMimetype = "370E"
+
+	msgTypeUnicode = "001F"
+	msgTypeString  = "001E"
+	msgTypeBinary  = "0102"
+
+	msgPropertyClientSubmitTime    = 0x0039
+	msgPropertyMessageDeliveryTime = 0x0E06
+	msgTypeTime                    = 0x0040
+
+	msgStreamPrefix     = "__substg1.0_"
+	msgAttachmentPrefix = "__attach_version1.0_#"
+	msgPropertiesStream = "__properties_version1.0"
+	// size of the header in properties stream of the top level message
+	msgPropertiesHeaderSize = 32
+)
+
+// msgStorage is a storage of msg file, containing property streams and attachment storages.
+type msgStorage struct {
+	file     *cfbFile
+	streams  map[string]cfbEntry
+	storages map[string]int
+}
+
+func newMsgStorage(file *cfbFile, storage int) *msgStorage {
+	s := &msgStorage{file: file, streams: map[string]cfbEntry{}, storages: map[string]int{}}
+	for _, i := range file.children(storage) {
+		entry := file.entries[i]
+		switch entry.Type {
+		case cfbTypeStream:
+			// property ids are hex, which may be in either case
+			s.streams[strings.ToUpper(entry.Name)] = entry
+		case cfbTypeStorage:
+			s.storages[entry.Name] = i
+		}
+	}
+	return s
+}
+
+func (s *msgStorage) binary(property string) []byte {
+	entry, ok := s.streams[strings.ToUpper(msgStreamPrefix+property+msgTypeBinary)]
+	if !ok {
+		return nil
+	}
+	data, err := s.file.stream(entry)
+	if err != nil {
+		return nil
+	}
+	return data
+}
+
+func (s *msgStorage) string(property string) string {
+	if entry, ok := s.streams[strings.ToUpper(msgStreamPrefix+property+msgTypeUnicode)]; ok {
+		data, err := s.file.stream(entry)
+		if err == nil {
+			return strings.TrimRight(decodeUtf16(data), "\x00")
+		}
+	}
+	if entry, ok := s.streams[strings.ToUpper(msgStreamPrefix+property+msgTypeString)]; ok {
+		data, err := s.file.stream(entry)
+		if err == nil {
+			return strings.TrimRight(string(data), "\x00")
+		}
+	}
+	return ""
+}
+
+// time returns the first of the time properties that is set in properties stream.
+func (s *msgStorage) time(properties ...uint16) time.Time {
+	entry, ok := s.streams[strings.ToUpper(msgPropertiesStream)]
+	if !ok {
+		return time.Time{}
+	}
+	data, err := s.file.stream(entry)
+	if err != nil {
+		return time.Time{}
+	}
+	values := make(map[uint16]uint64)
+	for offset := msgPropertiesHeaderSize; offset+16 <= len(data); offset += 16 {
+		tag := binary.LittleEndian.Uint32(data[offset:])
+		if tag&0xFFFF == msgTypeTime {
+			values[uint16(tag>>16)] = binary.LittleEndian.Uint64(data[offset+8:])
+		}
+	}
+	for _, v := range properties {
+		if value, ok := values[v]; ok && value > 0 {
+			return filetimeToTime(value)
+		}
+	}
+	return time.Time{}
+}
+
+// ParseOutlookMessage parses Outlook .msg file. Embedded messages are not included in attachments.
+func ParseOutlookMessage(data []byte) (*MailMessage, error) {
+	file, err := readCfbFile(data)
+	if err != nil {
+		return nil, fmt.Errorf("read msg file: %v", err)
+	}
+	root := newMsgStorage(file, 0)
+
+	parsed := &MailMessage{
+		Subject:     root.string(msgPropertySubject),
+		To:          root.string(msgPropertyDisplayTo),
+		Date:        root.time(msgPropertyClientSubmitTime, msgPropertyMessageDeliveryTime),
+		Text:        root.string(msgPropertyBody),
+		Html:        root.string(msgPropertyHtml),
+		Attachments: []MailAttachment{},
+	}
+	if parsed.Html == "" {
+		parsed.Html = string(root.binary(msgPropertyHtml))
+	}
+	senderEmail := root.string(msgPropertySenderSmtp)
+	if senderEmail == "" {
+		senderEmail = root.string(msgPropertySenderEmail)
+	}
+	parsed.From = root.string(msgPropertySenderName)
+	if senderEmail != "" && senderEmail != parsed.From {
+		parsed.From = strings.TrimSpace(fmt.Sprintf("%s <%s>", parsed.From, senderEmail))
+	}
+
+	names := make([]string, 0, len(root.storages))
+	for name := range root.storages {
+		if strings.HasPrefix(strings.ToLower(name), msgAttachmentPrefix) {
+			names = append(names, name)
+		}
+	}
+	// attachment storages are numbered in hex with fixed width
+	sort.Strings(names)
+	for _, name := range names {
+		attachment := newMsgStorage(file, root.storages[name])
+		data := attachment.binary(msgPropertyAttachData)
+		if data == nil {
+			continue
+		}
+		filename := attachment.string(msgPropertyAttachLongName)
+		if filename == "" {
+			filename = attachment.string(msgPropertyAttachFilename)
+		}
+		mimetype := attachment.string(msgPropertyAttachMimetype)
+		if mimetype == "" {
+			mimetype = "application/octet-stream"
+		}
+		parsed.Attachments = append(parsed.Attachments, MailAttachment{
+			Filename: filename,
+			Mimetype: mimetype,
+			Data:     data,
+		})
+	}
+	return parsed, nil
+}
+
+func decodeUtf16(data []byte) string {
+	values := make([]uint16, len(data)/2)
+	for i := range values {
+		values[i] = binary.LittleEndian.Uint16(data[i*2:])
+	}
+	return string(utf16.Decode(values))
+}
+
+// filetimeToTime converts windows FILETIME, 100-nanosecond intervals since 1601-01-01, to time.
+func filetimeToTime(filetime uint64) time.Time {
+	const unixEpoch = 116444736000000000
+	return time.Unix(0, (int64(filetime)-unixEpoch)*100).UTC()
+}
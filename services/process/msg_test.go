@@ -0,0 +1,242 @@
+package process
+
+import (
+	"encoding/binary"
+	"math/rand"
+	"testing"
+	"time"
+	"unicode/utf16"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type testCfbEntry struct {
+	name   string
+	typ    byte
+	parent int
+	data   []byte
+}
+
+// buildTestCfbFile builds version 3 compound file with given entries. First entry is the root.
+// Mini stream is not used, all streams are stored in regular sectors.
+func buildTestCfbFile(entries []testCfbEntry) []byte {
+	const sectorSize = 512
+	le := binary.LittleEndian
+	dirSectors := (len(entries)*cfbDirEntrySize + sectorSize - 1) / sectorSize
+
+	fat := make([]uint32, sectorSize/4)
+	for i := range fat {
+		fat[i] = cfbFreeSector
+	}
+	fat[0] = 0xFFFFFFFD
+	chain := func(start, count int) {
+		for i := start; i < start+count; i++ {
+			fat[i] = uint32(i + 1)
+		}
+		fat[start+count-1] = cfbEndOfChain
+	}
+	chain(1, dirSectors)
+
+	next := 1 + dirSectors
+	starts := make([]uint32, len(entries))
+	sectors := make([]byte, 0)
+	for i, v := range entries {
+		starts[i] = cfbEndOfChain
+		if v.typ != cfbTypeStream || len(v.data) == 0 {
+			continue
+		}
+		count := (len(v.data) + sectorSize - 1) / sectorSize
+		starts[i] = uint32(next)
+		chain(next, count)
+		data := make([]byte, count*sectorSize)
+		copy(data, v.data)
+		sectors = append(sectors, data...)
+		next += count
+	}
+
+	// link children of each storage as a chain of right siblings
+	child := make([]uint32, len(entries))
+	right := make([]uint32, len(entries))
+	last := make(map[int]int)
+	for i := range entries {
+		child[i], right[i] = cfbNoStream, cfbNoStream
+	}
+	for i := 1; i < len(entries); i++ {
+		parent := entries[i].parent
+		if prev, ok := last[parent]; ok {
+			right[prev] = uint32(i)
+		} else {
+			child[parent] = uint32(i)
+		}
+		last[parent] = i
+	}
+
+	dir := make([]byte, dirSectors*sectorSize)
+	for i, v := range entries {
+		entry := dir[i*cfbDirEntrySize:]
+		name := utf16.Encode([]rune(v.name))
+		for j, c := range name {
+			le.PutUint16(entry[j*2:], c)
+		}
+		le.PutUint16(entry[0x40:], uint16(len(name)*2+2))
+		entry[0x42] = v.typ
+		le.PutUint32(entry[0x44:], cfbNoStream)
+		le.PutUint32(entry[0x48:], right[i])
+		le.PutUint32(entry[0x4C:], child[i])
+		le.PutUint32(entry[0x74:], starts[i])
+		le.PutUint64(entry[0x78:], uint64(len(v.data)))
+	}
+
+	header := make([]byte, sectorSize)
+	copy(header, cfbSignature)
+	le.PutUint16(header[0x18:], 0x3E)
+	le.PutUint16(header[0x1A:], 3)
+	le.PutUint16(header[0x1C:], 0xFFFE)
+	le.PutUint16(header[0x1E:], 9)
+	le.PutUint16(header[0x20:], 6)
+	le.PutUint32(header[0x2C:], 1)
+	le.PutUint32(header[0x30:], 1)
+	le.PutUint32(header[0x3C:], cfbEndOfChain)
+	le.PutUint32(header[0x44:], cfbEndOfChain)
+	for i := 0; i < cfbHeaderDifatEntries; i++ {
+		le.PutUint32(header[0x4C+i*4:], cfbFreeSector)
+	}
+	le.PutUint32(header[0x4C:], 0)
+
+	file := append(header, make([]byte, sectorSize)...)
+	for i, v := range fat {
+		le.PutUint32(file[sectorSize+i*4:], v)
+	}
+	file = append(file, dir...)
+	return append(file, sectors...)
+}
+
+func testUtf16(s string) []byte {
+	data := make([]byte, 0)
+	for _, v := range utf16.Encode([]rune(s)) {
+		data = binary.LittleEndian.AppendUint16(data, v)
+	}
+	return data
+}
+
+func testMsgFile() []byte {
+	return buildTestCfbFile([]testCfbEntry{
+		{name: "Root Entry", typ: 5},
+		{name: "__substg1.0_0037001F", typ: cfbTypeStream, data: testUtf16("Invoice 42")},
+		{name: "__attach_version1.0_#00000000", typ: cfbTypeStorage},
+		{name: "__substg1.0_3707001F", typ: cfbTypeStream, parent: 2, data: testUtf16("invoice.pdf")},
+		{name: "__substg1.0_37010102", typ: cfbTypeStream, parent: 2, data: make([]byte, 1500)},
+	})
+}
+
+func TestParseOutlookMessage_Corrupt(t *testing.T) {
+	valid := testMsgFile()
+	msg, err := ParseOutlookMessage(valid)
+	assert.NoError(t, err)
+	assert.Len(t, msg.Attachments, 1)
+
+	// truncated files must not panic
+	for size := 0; size < len(valid); size++ {
+		_, _ = ParseOutlookMessage(valid[:size])
+	}
+
+	// difat sector that is only partly in the file
+	difat := append([]byte{}, valid[:512]...)
+	binary.LittleEndian.PutUint32(difat[0x44:], 0)
+	binary.LittleEndian.PutUint32(difat[0x48:], 1)
+	difat = append(difat, make([]byte, 100)...)
+	_, err = ParseOutlookMessage(difat)
+	assert.Error(t, err)
+
+	invalidMiniSector := append([]byte{}, valid...)
+	binary.LittleEndian.PutUint16(invalidMiniSector[0x20:], 40)
+	_, err = ParseOutlookMessage(invalidMiniSector)
+	assert.Error(t, err)
+
+	// random garbage after a valid header, and random bytes in a valid file
+	random := rand.New(rand.NewSource(1))
+	for i := 0; i < 500; i++ {
+		garbage := append([]byte{}, valid[:512]...)
+		garbage = append(garbage, make([]byte, random.Intn(4096))...)
+		random.Read(garbage[512:])
+		_, _ = ParseOutlookMessage(garbage)
+
+		corrupted := append([]byte{}, valid...)
+		for j := 0; j < 10; j++ {
+			corrupted[random.Intn(len(corrupted))] = byte(random.Intn(256))
+		}
+		_, _ = ParseOutlookMessage(corrupted)
+	}
+}
+
+func FuzzParseOutlookMessage(f *testing.F) {
+	valid := testMsgFile()
+	f.Add(valid)
+	f.Add(valid[:600])
+	f.Add(valid[:1024])
+	f.Add(append(append([]byte{}, cfbSignature...), make([]byte, 600)...))
+	f.Fuzz(func(t *testing.T, data []byte) {
+		_, _ = ParseOutlookMessage(data)
+	})
+}
+
+func TestParseOutlookMessage(t *testing.T) {
+	date := time.Date(2024, 5, 15, 10, 30, 0, 0, time.UTC)
+	properties := make([]byte, msgPropertiesHeaderSize+16)
+	binary.LittleEndian.PutUint32(properties[msgPropertiesHeaderSize:], msgPropertyClientSubmitTime<<16|msgTypeTime)
+	binary.LittleEndian.PutUint64(properties[msgPropertiesHeaderSize+8:], uint64(date.UnixNano()/100)+116444736000000000)
+
+	data := buildTestCfbFile([]testCfbEntry{
+		{name: "Root Entry", typ: 5},
+		{name: "__substg1.0_0037001F", typ: cfbTypeStream, data: testUtf16("Invoice 42")},
+		{name: "__substg1.0_0C1A001F", typ: cfbTypeStream, data: testUtf16("Alice")},
+		{name: "__substg1.0_5D01001F", typ: cfbTypeStream, data: testUtf16("alice@example.com")},
+		{name: "__substg1.0_0E04001F", typ: cfbTypeStream, data: testUtf16("Bob")},
+		{name: "__substg1.0_1000001E", typ: cfbTypeStream, data: []byte("Hello,\r\nplease find the invoice attached.\x00")},
+		{name: "__properties_version1.0", typ: cfbTypeStream, data: properties},
+		{name: "__attach_version1.0_#00000000", typ: cfbTypeStorage},
+		{name: "__substg1.0_3707001F", typ: cfbTypeStream, parent: 7, data: testUtf16("invoice.pdf")},
+		{name: "__substg1.0_370E001F", typ: cfbTypeStream, parent: 7, data: testUtf16("application/pdf")},
+		{name: "__substg1.0_37010102", typ: cfbTypeStream, parent: 7, data: make([]byte, 1500)},
+		// embedded message is skipped
+		{name: "__attach_version1.0_#00000001", typ: cfbTypeStorage},
+		{name: "__substg1.0_3701000D", typ: cfbTypeStorage, parent: 11},
+	})
+
+	msg, err := ParseOutlookMessage(data)
+	assert.NoError(t, err)
+	assert.Equal(t, "Invoice 42", msg.Subject)
+	assert.Equal(t, "Alice <alice@example.com>", msg.From)
+	assert.Equal(t, "Bob", msg.To)
+	assert.Equal(t, date, msg.Date)
+	assert.Equal(t, "Hello,\r\nplease find the invoice attached.", msg.Text)
+	assert.Equal(t, []MailAttachment{{Filename: "invoice.pdf", Mimetype: "application/pdf", Data: make([]byte, 1500)}},
+		msg.Attachments)
+
+	_, err = ParseOutlookMessage([]byte("not a msg file"))
+	assert.Error(t, err)
+}
+
+func TestDetectMailMimetype(t *testing.T) {
+	assert.Equal(t, MimetypeMsg, DetectMailMimetype(append(cfbSignature, 0, 0, 0)))
+	assert.Equal(t, MimetypeEml, DetectMailMimetype([]byte("Received: from mail.example.com\r\nSubject: test\r\n")))
+	assert.Equal(t, "", DetectMailMimetype([]byte("just some text")))
+	assert.Equal(t, "", DetectMailMimetype([]byte("%PDF-1.4\x00")))
+}
+
+func TestMailMessage_PlainText(t *testing.T) {
+	msg := &MailMessage{
+		Subject:     "Invoice 42",
+		From:        "alice@example.com",
+		Date:        time.Date(2024, 5, 15, 10, 30, 0, 0, time.UTC),
+		Html:        "<html><head><style>p {}</style></head><body><p>Hello&nbsp;Bob,</p><p>invoice   is <b>attached</b>.</p></body></html>",
+		Attachments: []MailAttachment{{Filename: "invoice.pdf"}},
+	}
+	assert.Equal(t, `Subject: Invoice 42
+From: alice@example.com
+Date: Wed, 15 May 2024 10:30:00 +0000
+Attachments: invoice.pdf
+
+Hello Bob,
+invoice is attached.`, msg.PlainText())
+}
@@ -20,15 +20,20 @@ package process
 
 import (
 	"bytes"
+	"context"
 	"errors"
 	"fmt"
 	"github.com/sirupsen/logrus"
 	"io/ioutil"
 	"os"
 	"os/exec"
+	"path"
+	"strconv"
 	"strings"
 	"tryffel.net/go/virtualpaper/config"
+	"tryffel.net/go/virtualpaper/models"
 	"tryffel.net/go/virtualpaper/storage"
+	log "tryffel.net/go/virtualpaper/util/logger"
 )
 
 // test pdftotext command exists
@@ -121,3 +126,61 @@ func removeTempData(path string) {
 		}
 	}
 }
+
+// SplitPdf extracts each range of pages of the pdf to a new file in dir.
+// Files are returned in the same order as ranges.
+func SplitPdf(ctx context.Context, input string, dir string, ranges []models.PageRange) ([]string, error) {
+	if config.C.Processing.PdfSeparateBin == "" {
+		return nil, errors.New("no pdfseparate binary set")
+	}
+	err := os.MkdirAll(dir, 0700)
+	if err != nil {
+		return nil, fmt.Errorf("create tmp dir: %v", err)
+	}
+
+	files := make([]string, len(ranges))
+	for i, pages := range ranges {
+		pattern := path.Join(dir, fmt.Sprintf("part-%d-page-%%d.pdf", i+1))
+		err = callPoppler(ctx, config.C.Processing.PdfSeparateBin,
+			"-f", strconv.Itoa(pages.From), "-l", strconv.Itoa(pages.To), input, pattern)
+		if err != nil {
+			return nil, fmt.Errorf("separate pages %s: %v", pages, err)
+		}
+
+		pageFiles := make([]string, 0, pages.Pages())
+		for page := pages.From; page <= pages.To; page++ {
+			pageFiles = append(pageFiles, fmt.Sprintf(pattern, page))
+		}
+		files[i] = path.Join(dir, fmt.Sprintf("part-%d.pdf", i+1))
+		if len(pageFiles) == 1 {
+			err = os.Rename(pageFiles[0], files[i])
+		} else {
+			err = MergePdf(ctx, pageFiles, files[i])
+		}
+		if err != nil {
+			return nil, fmt.Errorf("combine pages %s: %v", pages, err)
+		}
+	}
+	return files, nil
+}
+
+// MergePdf combines pages of the pdf files to output in the given order.
+func MergePdf(ctx context.Context, inputs []string, output string) error {
+	if config.C.Processing.PdfUniteBin == "" {
+		return errors.New("no pdfunite binary set")
+	}
+	return callPoppler(ctx, config.C.Processing.PdfUniteBin, append(inputs, output)...)
+}
+
+func callPoppler(ctx context.Context, bin string, args ...string) error {
+	stderr := &bytes.Buffer{}
+
+	log.Context(ctx).Debugf("call %s: %v", bin, args)
+	cmd := exec.CommandContext(ctx, bin, args...)
+	cmd.Stderr = stderr
+	err := cmd.Run()
+	if err != nil {
+		return fmt.Errorf("run %s: %v, stderr: %s", path.Base(bin), err, stderr.String())
+	}
+	return nil
+}
@@ -0,0 +1,318 @@
+package process
+
+import (
+	"context"
+	"fmt"
+	"image"
+	"image/color"
+	"math"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"tryffel.net/go/virtualpaper/config"
+	"tryffel.net/go/virtualpaper/models"
+	"tryffel.net/go/virtualpaper/storage"
+	"tryffel.net/go/virtualpaper/storage/blob"
+	log "tryffel.net/go/virtualpaper/util/logger"
+)
+
+// minOrientationConfidence is the minimum confidence of tesseract's orientation detection to rotate the page.
+const minOrientationConfidence = 2.0
+
+// minSkewDegrees is the minimum skew angle that is reported as a change.
+const minSkewDegrees = 0.1
+
+// pageCorrection describes changes made to a single page when preprocessing.
+type pageCorrection struct {
+	// Page number, starting from 1
+	Page int
+	// Rotation in degrees clockwise
+	Rotation int
+	// Skew is the angle the page was deskewed by
+	Skew float64
+	// Blank is set if page was removed
+	Blank bool
+	// Ink is the ratio of dark pixels in the page
+	Ink float64
+}
+
+func (p pageCorrection) changed() bool {
+	return p.Blank || p.Rotation != 0 || math.Abs(p.Skew) >= minSkewDegrees
+}
+
+func (p pageCorrection) String() string {
+	if p.Blank {
+		return fmt.Sprintf("page %d: removed blank page (ink %.2f%%)", p.Page, p.Ink*100)
+	}
+	changes := make([]string, 0, 2)
+	if p.Rotation != 0 {
+		changes = append(changes, fmt.Sprintf("rotated %d°", p.Rotation))
+	}
+	if math.Abs(p.Skew) >= minSkewDegrees {
+		changes = append(changes, fmt.Sprintf("deskewed %.2f°", p.Skew))
+	}
+	if len(changes) == 0 {
+		return fmt.Sprintf("page %d: no changes", p.Page)
+	}
+	return fmt.Sprintf("page %d: %s", p.Page, strings.Join(changes, ", "))
+}
+
+// preprocess rotates and deskews pages of scanned documents and optionally removes blank pages.
+// If any page was changed, the corrected file is stored and used for previews and OCR instead of the original file.
+func (fp *fileProcessor) preprocess(ctx context.Context) error {
+	process := &models.ProcessItem{
+		DocumentId: fp.document.Id,
+		Action:     models.ProcessPreprocess,
+		CreatedAt:  time.Now(),
+	}
+	key := storage.PreprocessedKey(fp.document.Id)
+
+	err := fp.ensureFileOpen()
+	if err != nil {
+		return fmt.Errorf("open file: %v", err)
+	}
+
+	if !config.C.Processing.Preprocess || !(fp.document.IsPdf() || fp.document.IsImage()) || fp.hasTextLayer() {
+		err = fp.db.Files.Delete(ctx, key)
+		if err != nil {
+			return fmt.Errorf("remove old preprocessed file: %v", err)
+		}
+		err = fp.db.JobStore.MarkProcessingDone(process, true)
+		if err != nil {
+			return fmt.Errorf("mark process complete: %v", err)
+		}
+		return nil
+	}
+
+	job, err := fp.db.JobStore.StartProcessItem(process, "preprocess pages")
+	if err != nil {
+		return fmt.Errorf("persist process item: %v", err)
+	}
+	defer fp.completeProcessingStep(process, job)
+
+	dir := storage.TempFilePath(fp.document.Id) + "-preprocess"
+	defer os.RemoveAll(dir)
+
+	output, corrections, err := preprocessPages(ctx, fp.rawFile.Name(), dir)
+	if err != nil {
+		job.Status = models.JobFailure
+		job.Message += "; " + err.Error()
+		return fmt.Errorf("preprocess pages: %v", err)
+	}
+	for _, v := range corrections {
+		job.Message += "; " + v.String()
+	}
+
+	if output == "" {
+		job.Message += "; no changes"
+		err = fp.db.Files.Delete(ctx, key)
+	} else {
+		err = blob.PutFile(ctx, fp.db.Files, key, output)
+	}
+	if err != nil {
+		job.Status = models.JobFailure
+		job.Message += "; " + err.Error()
+		return fmt.Errorf("store preprocessed file: %v", err)
+	}
+	job.Status = models.JobFinished
+	return nil
+}
+
+// preprocessPages renders pages of the file, rotates and deskews them and removes blank pages, if enabled.
+// If any page was changed, the pages are combined to a pdf in dir, which is returned. Else output is empty.
+func preprocessPages(ctx context.Context, rawFile string, dir string) (string, []pageCorrection, error) {
+	err := os.MkdirAll(dir, 0700)
+	if err != nil {
+		return "", nil, fmt.Errorf("create tmp dir: %v", err)
+	}
+
+	err = generatePicture(ctx, rawFile, path.Join(dir, "preview.png"))
+	if err != nil {
+		return "", nil, fmt.Errorf("generate pictures from pages: %v", err)
+	}
+	images, err := ocrPageImages(dir)
+	if err != nil {
+		return "", nil, fmt.Errorf("find page images: %v", err)
+	}
+
+	corrections := make([]pageCorrection, 0, len(images))
+	pages := make([]string, 0, len(images))
+	for i, image := range images {
+		if ctx.Err() != nil {
+			return "", nil, ctx.Err()
+		}
+		correction := pageCorrection{Page: i + 1}
+
+		if config.C.Processing.RemoveBlankPages {
+			ink, err := inkRatio(image)
+			if err != nil {
+				log.Context(ctx).Warnf("calculate ink of page %d: %v", i+1, err)
+			} else if ink < config.C.Processing.BlankPageThreshold {
+				correction.Blank = true
+				correction.Ink = ink
+				corrections = append(corrections, correction)
+				continue
+			}
+		}
+
+		correction.Rotation = detectOrientation(ctx, image)
+		correction.Skew, err = correctPage(ctx, image, correction.Rotation)
+		if err != nil {
+			return "", nil, fmt.Errorf("correct page %d: %v", i+1, err)
+		}
+		pages = append(pages, image)
+		if correction.changed() {
+			corrections = append(corrections, correction)
+		}
+	}
+
+	if len(pages) == 0 {
+		log.Context(ctx).Warnf("all pages seem blank, keep document as is")
+		return "", nil, nil
+	}
+	if len(corrections) == 0 {
+		return "", corrections, nil
+	}
+
+	output := path.Join(dir, "preprocessed.pdf")
+	err = callImagick(ctx, append(pages, output)...)
+	if err != nil {
+		return "", nil, fmt.Errorf("combine pages: %v", err)
+	}
+	return output, corrections, nil
+}
+
+// detectOrientation returns rotation in degrees clockwise required to make the page upright.
+// If orientation cannot be detected reliably, 0 is returned.
+func detectOrientation(ctx context.Context, image string) int {
+	out, err := callTesseract(ctx, image, "stdout", "--psm", "0")
+	if err != nil {
+		log.Context(ctx).Infof("detect orientation of %s: %v", image, err)
+		return 0
+	}
+	rotation, confidence, err := parseOrientation(out)
+	if err != nil {
+		log.Context(ctx).Infof("parse orientation of %s: %v", image, err)
+		return 0
+	}
+	if confidence < minOrientationConfidence {
+		return 0
+	}
+	return rotation
+}
+
+// parseOrientation parses rotation and its confidence from tesseract's orientation and script detection output.
+func parseOrientation(output string) (int, float64, error) {
+	rotation := -1
+	confidence := 0.0
+	for _, line := range strings.Split(output, "\n") {
+		key, value, found := strings.Cut(line, ":")
+		if !found {
+			continue
+		}
+		value = strings.TrimSpace(value)
+		var err error
+		switch strings.TrimSpace(key) {
+		case "Rotate":
+			rotation, err = strconv.Atoi(value)
+		case "Orientation confidence":
+			confidence, err = strconv.ParseFloat(value, 64)
+		}
+		if err != nil {
+			return 0, 0, fmt.Errorf("invalid value '%s': %v", line, err)
+		}
+	}
+	if rotation == -1 {
+		return 0, 0, fmt.Errorf("rotation not found")
+	}
+	return rotation % 360, confidence, nil
+}
+
+// correctPage deskews and rotates the image in place. It returns the angle the image was deskewed by.
+func correctPage(ctx context.Context, image string, rotation int) (float64, error) {
+	args := []string{
+		image,
+		"-deskew", "40%",
+		"-print", "%[deskew:angle]\n",
+	}
+	if rotation != 0 {
+		args = append(args, "-rotate", strconv.Itoa(rotation))
+	}
+	args = append(args, "+repage", image)
+
+	out, err := callImagickOutput(ctx, args...)
+	if err != nil {
+		return 0, err
+	}
+	skew, err := strconv.ParseFloat(strings.TrimSpace(out), 64)
+	if err != nil {
+		log.Context(ctx).Warnf("parse deskew angle '%s': %v", out, err)
+		return 0, nil
+	}
+	return skew, nil
+}
+
+// inkRatio returns the ratio of dark pixels in the image. Margins of the page are ignored, since scanners
+// often leave dark edges.
+func inkRatio(file string) (float64, error) {
+	fd, err := os.Open(file)
+	if err != nil {
+		return 0, err
+	}
+	defer fd.Close()
+	img, _, err := image.Decode(fd)
+	if err != nil {
+		return 0, fmt.Errorf("decode image: %v", err)
+	}
+
+	bounds := img.Bounds()
+	marginX := bounds.Dx() / 20
+	marginY := bounds.Dy() / 20
+	dark := 0
+	total := 0
+	// every other pixel is enough to detect blank pages
+	for y := bounds.Min.Y + marginY; y < bounds.Max.Y-marginY; y += 2 {
+		for x := bounds.Min.X + marginX; x < bounds.Max.X-marginX; x += 2 {
+			gray := color.GrayModel.Convert(img.At(x, y)).(color.Gray)
+			if gray.Y < 128 {
+				dark += 1
+			}
+			total += 1
+		}
+	}
+	if total == 0 {
+		return 0, nil
+	}
+	return float64(dark) / float64(total), nil
+}
+
+// blankPageDetectionHeight is the height of pages rendered for detecting blank pages.
+const blankPageDetectionHeight = 600
+
+// BlankPages renders pages of the pdf to dir and returns the number of pages and the pages
+// that are blank. Pages start from 1.
+func BlankPages(ctx context.Context, input string, dir string) (int, []int, error) {
+	err := os.MkdirAll(dir, 0700)
+	if err != nil {
+		return 0, nil, fmt.Errorf("create tmp dir: %v", err)
+	}
+	images, err := generatePagePreviews(ctx, input, dir, blankPageDetectionHeight, "application/pdf")
+	if err != nil {
+		return 0, nil, fmt.Errorf("render pages: %v", err)
+	}
+
+	blank := make([]int, 0)
+	for i, image := range images {
+		ink, err := inkRatio(image)
+		if err != nil {
+			return 0, nil, fmt.Errorf("calculate ink of page %d: %v", i+1, err)
+		}
+		if ink < config.C.Processing.BlankPageThreshold {
+			blank = append(blank, i+1)
+		}
+	}
+	return len(images), blank, nil
+}
@@ -0,0 +1,128 @@
+package process
+
+import (
+	"image"
+	"image/color"
+	"image/png"
+	"os"
+	"path"
+	"testing"
+)
+
+func Test_parseOrientation(t *testing.T) {
+	tests := []struct {
+		name           string
+		output         string
+		wantRotation   int
+		wantConfidence float64
+		wantErr        bool
+	}{
+		{
+			name: "rotated",
+			output: `Page number: 0
+Orientation in degrees: 270
+Rotate: 90
+Orientation confidence: 21.06
+Script: Latin
+Script confidence: 4.49
+`,
+			wantRotation:   90,
+			wantConfidence: 21.06,
+		},
+		{
+			name:           "upright",
+			output:         "Orientation in degrees: 0\nRotate: 0\nOrientation confidence: 5.00\n",
+			wantRotation:   0,
+			wantConfidence: 5,
+		},
+		{"empty", "", 0, 0, true},
+		{"invalid rotation", "Rotate: abc\n", 0, 0, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rotation, confidence, err := parseOrientation(tt.output)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseOrientation() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if rotation != tt.wantRotation {
+				t.Errorf("parseOrientation() rotation = %v, want %v", rotation, tt.wantRotation)
+			}
+			if confidence != tt.wantConfidence {
+				t.Errorf("parseOrientation() confidence = %v, want %v", confidence, tt.wantConfidence)
+			}
+		})
+	}
+}
+
+// writePage writes a white 200x200 page with a black square of given size in the middle
+// and black edges that are ignored as margins.
+func writePage(t *testing.T, file string, square int) {
+	img := image.NewGray(image.Rect(0, 0, 200, 200))
+	for y := 0; y < 200; y++ {
+		for x := 0; x < 200; x++ {
+			c := color.Gray{Y: 255}
+			inSquare := x >= 100-square/2 && x < 100+square/2 && y >= 100-square/2 && y < 100+square/2
+			if inSquare || x < 5 || y < 5 {
+				c = color.Gray{Y: 0}
+			}
+			img.SetGray(x, y, c)
+		}
+	}
+	fd, err := os.Create(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fd.Close()
+	err = png.Encode(fd, img)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func Test_inkRatio(t *testing.T) {
+	dir := t.TempDir()
+	blank := path.Join(dir, "blank.png")
+	text := path.Join(dir, "text.png")
+	writePage(t, blank, 0)
+	writePage(t, text, 60)
+
+	ink, err := inkRatio(blank)
+	if err != nil {
+		t.Fatalf("inkRatio() error = %v", err)
+	}
+	if ink != 0 {
+		t.Errorf("inkRatio() of blank page = %v, want 0", ink)
+	}
+
+	ink, err = inkRatio(text)
+	if err != nil {
+		t.Fatalf("inkRatio() error = %v", err)
+	}
+	// 60x60 square inside 180x180 area
+	if ink < 0.1 || ink > 0.12 {
+		t.Errorf("inkRatio() of page = %v, want ~0.11", ink)
+	}
+}
+
+func Test_pageCorrection_String(t *testing.T) {
+	tests := []struct {
+		correction pageCorrection
+		want       string
+		changed    bool
+	}{
+		{pageCorrection{Page: 1}, "page 1: no changes", false},
+		{pageCorrection{Page: 1, Skew: 0.05}, "page 1: no changes", false},
+		{pageCorrection{Page: 2, Rotation: 90, Skew: -1.234}, "page 2: rotated 90°, deskewed -1.23°", true},
+		{pageCorrection{Page: 3, Blank: true, Ink: 0.001}, "page 3: removed blank page (ink 0.10%)", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.want, func(t *testing.T) {
+			if got := tt.correction.String(); got != tt.want {
+				t.Errorf("String() = %v, want %v", got, tt.want)
+			}
+			if got := tt.correction.changed(); got != tt.changed {
+				t.Errorf("changed() = %v, want %v", got, tt.changed)
+			}
+		})
+	}
+}
@@ -20,7 +20,7 @@ func (fp *fileProcessor) completeProcessingStep(process *models.ProcessItem, job
 	// if further steps do not absolutely require running this step.
 	removeStep := job.Status == models.JobFinished
 	switch process.Action {
-	case models.ProcessThumbnail, models.ProcessDetectLanguage, models.ProcessRules, models.ProcessFts:
+	case models.ProcessPreprocess, models.ProcessThumbnail, models.ProcessBarcodes, models.ProcessSearchablePdf, models.ProcessDetectLanguage, models.ProcessExtractFields, models.ProcessRules, models.ProcessFts:
 		removeStep = true
 	}
 
@@ -60,6 +60,10 @@ func (fp *fileProcessor) cleanup() {
 		}
 		fp.tempFile = nil
 	}
+	if fp.fileCleanup != nil {
+		fp.fileCleanup()
+		fp.fileCleanup = nil
+	}
 
 	if fp.document != nil {
 		tmpDir := storage.TempFilePath(fp.document.Hash)
@@ -74,11 +78,23 @@ func (fp *fileProcessor) cleanup() {
 
 	fp.document = nil
 	fp.file = ""
+	fp.searchablePdfCreated = false
 	fp.lock.Lock()
 	fp.idle = true
+	fp.cancel = nil
+	fp.step = ""
+	fp.pagesDone = 0
+	fp.pagesTotal = 0
 	fp.lock.Unlock()
 }
 
+// emit notifies event emitter, if any, of an event for current document.
+func (fp *fileProcessor) emit(ctx context.Context, event string) {
+	if fp.events != nil {
+		fp.events.Emit(ctx, event, fp.document.Id)
+	}
+}
+
 /* New implementation, used when document is sent using the API */
 func (fp *fileProcessor) processDocument() {
 	fp.Info("Start processing file")
@@ -97,20 +113,42 @@ func (fp *fileProcessor) processDocument() {
 		return nil
 	}
 
+	docCtx, cancel := context.WithCancel(context.Background())
+	fp.lock.Lock()
+	fp.cancel = cancel
+	fp.lock.Unlock()
+
+	defer cancel()
 	defer fp.cleanup()
-	for {
+	defer func() {
+		if docCtx.Err() == nil {
+			return
+		}
+		err := fp.cancelDocumentProcessing(log.ContextWithTaskId(docCtx, fp.taskId), cancelReasonUser)
+		if err != nil {
+			fp.Error("cancel document processing: %v", err)
+		}
+	}()
+
+	stepsExecuted := 0
+	for docCtx.Err() == nil {
 		fp.taskId, _ = uuid.GenerateUUID()
-		ctx := log.ContextWithTaskId(context.Background(), fp.taskId)
+		ctx := log.ContextWithTaskId(docCtx, fp.taskId)
 		step, err := fp.db.JobStore.GetNextStepForDocument(fp.document.Id)
 		if err != nil {
 			if errors.Is(err, errors.ErrRecordNotFound) {
 				// all steps executed
+				if stepsExecuted > 0 {
+					fp.emit(ctx, models.WebhookEventDocumentProcessed)
+				}
 			} else {
 				logrus.Errorf("get next processing step for document %s: %v", fp.document.Id, err)
 			}
 			break
 		}
 		fp.Info("run step %s", step.Action)
+		fp.setProgress(step.Action, 0, 0)
+		stepsExecuted += 1
 
 		switch step.Action {
 		case models.ProcessHash:
@@ -127,6 +165,20 @@ func (fp *fileProcessor) processDocument() {
 				log.Errorf(ctx, "update hash %v", err)
 				return
 			}
+		case models.ProcessPreprocess:
+			err = fp.ensureFileOpenAndLogFailure()
+			if err != nil {
+				err = fp.cancelDocumentProcessing(ctx, "file not found")
+				if err != nil {
+					logrus.Errorf("cancel document processing: %v", err)
+				}
+				return
+			}
+			err := fp.preprocess(ctx)
+			if err != nil {
+				log.Errorf(ctx, "preprocess: %v", err)
+				return
+			}
 		case models.ProcessThumbnail:
 			err = fp.ensureFileOpenAndLogFailure()
 			if err != nil {
@@ -141,6 +193,25 @@ func (fp *fileProcessor) processDocument() {
 				logrus.Errorf("generate thumbnail: %v", err)
 				return
 			}
+		case models.ProcessBarcodes:
+			err = fp.ensureFileOpenAndLogFailure()
+			if err != nil {
+				err = fp.cancelDocumentProcessing(ctx, "file not found")
+				if err != nil {
+					logrus.Errorf("cancel document processing: %v", err)
+				}
+				return
+			}
+			err := fp.detectBarcodes(ctx)
+			if errors.Is(err, errDocumentSplit) {
+				// document is in trash, it is not processed further
+				fp.Info("document was split at separator pages, stop processing")
+				return
+			}
+			if err != nil {
+				log.Errorf(ctx, "detect barcodes: %v", err)
+				return
+			}
 		case models.ProcessParseContent:
 			err = fp.ensureFileOpenAndLogFailure()
 			if err != nil {
@@ -156,6 +227,12 @@ func (fp *fileProcessor) processDocument() {
 				log.Errorf(ctx, "parse content: %v", err)
 				return
 			}
+		case models.ProcessSearchablePdf:
+			err = fp.generateSearchablePdf(ctx)
+			if err != nil {
+				log.Errorf(ctx, "generate searchable pdf: %v", err)
+				return
+			}
 		case models.ProcessDetectLanguage:
 			err := refreshDocument()
 			if err != nil {
@@ -167,6 +244,17 @@ func (fp *fileProcessor) processDocument() {
 				log.Errorf(ctx, "detect language: %v", err)
 				return
 			}
+		case models.ProcessExtractFields:
+			err := refreshDocument()
+			if err != nil {
+				log.Errorf(ctx, "refresh document: %v", err)
+				return
+			}
+			err = fp.extractFields(ctx)
+			if err != nil {
+				log.Errorf(ctx, "extract fields: %v", err)
+				return
+			}
 		case models.ProcessRules:
 			err := refreshDocument()
 			if err != nil {
@@ -224,6 +312,18 @@ func (fp *fileProcessor) runRules(ctx context.Context, trigger models.RuleTrigge
 		return nil
 	}
 
+	// rule conditions may match properties and sharing, which are not loaded with the document
+	properties, err := fp.db.PropertyStore.GetDocumentProperties(fp.db, fp.document.Id)
+	if err != nil {
+		return fmt.Errorf("get document properties: %v", err)
+	}
+	fp.document.Properties = *properties
+	shares, err := fp.db.DocumentStore.GetSharedUsers(fp.db, fp.document.Id)
+	if err != nil {
+		return fmt.Errorf("get document shares: %v", err)
+	}
+	fp.document.Shares = len(*shares)
+
 	metadataValues, err := fp.db.MetadataStore.GetUserValuesWithMatching(fp.document.UserId)
 	if err != nil {
 		logrus.Errorf("get metadata values with matching for user %d: %v", fp.document.UserId, err)
@@ -238,6 +338,7 @@ func (fp *fileProcessor) runRules(ctx context.Context, trigger models.RuleTrigge
 		WithField("trigger", trigger).
 		Infof("Run user rules for document")
 
+	effects := ruleEffects{}
 	for i, rule := range rules {
 		logrus.Debugf("(%d.) run user rule %d", i, rule.Id)
 
@@ -251,7 +352,7 @@ func (fp *fileProcessor) runRules(ctx context.Context, trigger models.RuleTrigge
 			continue
 		}
 
-		runner := NewDocumentRule(fp.document, rule)
+		runner := NewDocumentRule(fp.document, rule, fp.search)
 		match, err := runner.Match()
 		if err != nil {
 			logrus.Errorf("match rule (%d): %v", rule.Id, err)
@@ -265,6 +366,7 @@ func (fp *fileProcessor) runRules(ctx context.Context, trigger models.RuleTrigge
 			if err != nil {
 				logrus.Errorf("rule (%d) actions: %v", rule.Id, err)
 			}
+			effects.merge(runner.effects)
 		}
 	}
 
@@ -303,5 +405,137 @@ func (fp *fileProcessor) runRules(ctx context.Context, trigger models.RuleTrigge
 			fp.document.Metadata = *newMetadata
 		}
 	}
-	return tx.Commit()
+
+	if !effects.isEmpty() {
+		err = fp.applyRuleEffects(tx, &effects)
+		if err != nil {
+			return fmt.Errorf("save rule actions: %v", err)
+		}
+	}
+	err = tx.Commit()
+	if err != nil {
+		return err
+	}
+
+	if len(effects.shares) > 0 {
+		fp.emit(ctx, models.WebhookEventDocumentShared)
+	}
+	if effects.delete {
+		if fp.search != nil {
+			err = fp.search.DeleteDocument(fp.document.Id, fp.document.UserId)
+			if err != nil {
+				logrus.Errorf("delete document (%s) from search index after rules: %v", fp.document.Id, err)
+			}
+		}
+		fp.emit(ctx, models.WebhookEventDocumentDeleted)
+	}
+	return nil
+}
+
+// applyRuleEffects saves the changes rules made to other records than the document.
+func (fp *fileProcessor) applyRuleEffects(tx storage.SqlExecer, effects *ruleEffects) error {
+	docId := fp.document.Id
+	if len(effects.shares) > 0 {
+		existing, err := fp.db.DocumentStore.GetSharedUsers(tx, docId)
+		if err != nil {
+			return fmt.Errorf("get shared users: %v", err)
+		}
+		shares := make([]models.UpdateUserSharing, 0, len(*existing)+len(effects.shares))
+		index := make(map[int]int)
+		for _, v := range *existing {
+			index[v.UserId] = len(shares)
+			shares = append(shares, models.UpdateUserSharing{UserId: v.UserId, Permissions: v.Permissions})
+		}
+		for _, v := range effects.shares {
+			if i, ok := index[v.UserId]; ok {
+				shares[i].Permissions = v.Permissions
+			} else {
+				index[v.UserId] = len(shares)
+				shares = append(shares, v)
+			}
+		}
+		err = fp.db.DocumentStore.UpdateSharing(tx, docId, &shares)
+		if err != nil {
+			return fmt.Errorf("update sharing: %v", err)
+		}
+	}
+
+	if len(effects.properties) > 0 {
+		existing, err := fp.db.PropertyStore.GetDocumentProperties(tx, docId)
+		if err != nil {
+			return fmt.Errorf("get document properties: %v", err)
+		}
+		hasProperty := func(id int) bool {
+			for _, v := range *existing {
+				if v.Property == id {
+					return true
+				}
+			}
+			return false
+		}
+
+		for _, v := range effects.properties {
+			property, err := fp.db.PropertyStore.GetProperty(tx, v.Property)
+			if err != nil {
+				return fmt.Errorf("get property %d: %v", v.Property, err)
+			}
+			if property.Exclusive && hasProperty(property.Id) {
+				logrus.Debugf("document %s already has exclusive property %d, skip adding", docId, property.Id)
+				continue
+			}
+			value := v.Value
+			description := "added by rule"
+			if property.IsGenerated() {
+				generated, err := property.Generate()
+				if err != nil {
+					return fmt.Errorf("generate property %d: %v", property.Id, err)
+				}
+				value = generated.Value
+				description = generated.Description
+			} else {
+				err = property.ValidateValue(value)
+				if err != nil {
+					return fmt.Errorf("property %d: %v", property.Id, err)
+				}
+			}
+			err = fp.db.PropertyStore.AddDocumentProperty(tx, property, docId, value, description, "", property.IsGenerated())
+			if err != nil {
+				return err
+			}
+			*existing = append(*existing, models.DocumentProperty{Document: docId, Property: property.Id, Value: value})
+		}
+	}
+
+	if len(effects.links) > 0 {
+		existing, err := fp.db.MetadataStore.GetLinkedDocuments(fp.document.UserId, docId)
+		if err != nil {
+			return fmt.Errorf("get linked documents: %v", err)
+		}
+		links := make([]string, 0, len(existing)+len(effects.links))
+		found := map[string]bool{docId: true}
+		for _, v := range existing {
+			if !found[v.DocumentId] {
+				found[v.DocumentId] = true
+				links = append(links, v.DocumentId)
+			}
+		}
+		for _, v := range effects.links {
+			if !found[v] {
+				found[v] = true
+				links = append(links, v)
+			}
+		}
+		err = fp.db.MetadataStore.UpdateLinkedDocuments(tx, fp.document.UserId, docId, links)
+		if err != nil {
+			return fmt.Errorf("update linked documents: %v", err)
+		}
+	}
+
+	if effects.delete {
+		err := fp.db.DocumentStore.MarkDocumentDeleted(tx, fp.document.UserId, docId)
+		if err != nil {
+			return fmt.Errorf("mark document deleted: %v", err)
+		}
+	}
+	return nil
 }
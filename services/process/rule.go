@@ -20,29 +20,69 @@ package process
 
 import (
 	"fmt"
+	"path"
 	"regexp"
 	"strconv"
 	"strings"
 	"time"
 
 	"github.com/sirupsen/logrus"
+	"tryffel.net/go/virtualpaper/config"
 	"tryffel.net/go/virtualpaper/errors"
 	"tryffel.net/go/virtualpaper/models"
+	"tryffel.net/go/virtualpaper/services/search"
+	"tryffel.net/go/virtualpaper/storage"
 )
 
 type DocumentRule struct {
 	Rule     *models.Rule
 	Document *models.Document
 	date     time.Time
+	// search is used to find documents to link. If nil, linking documents is skipped.
+	search *search.Engine
+	// effects contain changes that are not stored in the document itself.
+	effects ruleEffects
+}
+
+// ruleEffects are changes that rule actions make to other records than the document. They are saved
+// after running all rules.
+type ruleEffects struct {
+	shares     []models.UpdateUserSharing
+	properties []models.DocumentProperty
+	links      []string
+	delete     bool
+}
+
+func (r *ruleEffects) merge(other ruleEffects) {
+	r.shares = append(r.shares, other.shares...)
+	r.properties = append(r.properties, other.properties...)
+	r.links = append(r.links, other.links...)
+	r.delete = r.delete || other.delete
+}
+
+func (r *ruleEffects) isEmpty() bool {
+	return len(r.shares) == 0 && len(r.properties) == 0 && len(r.links) == 0 && !r.delete
 }
 
 type RuleTestConditionResult struct {
 	ConditionId   int    `json:"condition_id"`
+	GroupId       int    `json:"group_id"`
 	ConditionType string `json:"condition_type"`
 	Matched       bool   `json:"matched"`
 	Skipped       bool   `json:"skipped"`
 }
 
+// RuleTestGroupResult is the result of a condition group. Root group has depth 1 and parent id 0.
+type RuleTestGroupResult struct {
+	GroupId  int    `json:"group_id"`
+	ParentId int    `json:"parent_id"`
+	Depth    int    `json:"depth"`
+	Mode     string `json:"mode"`
+	Inverted bool   `json:"inverted"`
+	Matched  bool   `json:"matched"`
+	Skipped  bool   `json:"skipped"`
+}
+
 type RuleTestAction struct {
 	ActionId   int    `json:"action_id"`
 	ActionType string `json:"action_type"`
@@ -51,6 +91,7 @@ type RuleTestAction struct {
 
 type RuleTestResult struct {
 	Conditions      []RuleTestConditionResult `json:"conditions"`
+	Groups          []RuleTestGroupResult     `json:"groups"`
 	Actions         []RuleTestAction          `json:"actions"`
 	RuleId          int                       `json:"rule_id"`
 	Match           bool                      `json:"matched"`
@@ -63,66 +104,192 @@ type RuleTestResult struct {
 	ActionOutput    [][]string                `json:"action_output"`
 }
 
-func NewDocumentRule(document *models.Document, rule *models.Rule) DocumentRule {
+func NewDocumentRule(document *models.Document, rule *models.Rule, search *search.Engine) DocumentRule {
 	return DocumentRule{
 		Rule:     rule,
 		Document: document,
+		search:   search,
 	}
 }
 
 func (d *DocumentRule) Match() (bool, error) {
-	hasMatch := false
-
 	logrus.Debugf("match document: %s, rule: %d", d.Document.Id, d.Rule.Id)
-	for i, condition := range d.Rule.Conditions {
+	ok, _, err := d.matchGroup(d.Rule.ConditionTree(), 1, nil)
+	return ok, err
+}
+
+// matchTrace records the evaluation of the condition tree when testing a rule.
+type matchTrace struct {
+	logger *logrus.Logger
+	log    logFunc
+	// conditionDone is called after each condition in the tree has been evaluated or skipped.
+	conditionDone func(condition *models.RuleCondition, matched, skipped bool)
+	// groupDone is called after each group in the tree has been evaluated or skipped.
+	groupDone func(group *models.RuleConditionGroup, matched, skipped bool)
+}
+
+func (t *matchTrace) infof(format string, args ...interface{}) {
+	if t == nil {
+		logrus.Debugf(format, args...)
+		return
+	}
+	t.logger.Infof(format, args...)
+}
+
+func (t *matchTrace) output(format string, args ...interface{}) {
+	if t != nil {
+		t.log(format, args...)
+	}
+}
+
+func (t *matchTrace) conditionEvaluated(condition *models.RuleCondition, matched, skipped bool) {
+	if t != nil {
+		t.conditionDone(condition, matched, skipped)
+	}
+}
+
+func (t *matchTrace) groupEvaluated(group *models.RuleConditionGroup, matched, skipped bool) {
+	if t != nil {
+		t.groupDone(group, matched, skipped)
+	}
+}
+
+// matchGroup evaluates the condition group and its subgroups. Group in 'match all' mode stops at first
+// condition or subgroup that doesn't match, and group in 'match any' mode stops at first match.
+// Disabled conditions and groups that have no enabled conditions are ignored. Evaluated is false
+// if there was nothing to evaluate in the group, in which case the group doesn't match.
+func (d *DocumentRule) matchGroup(group *models.RuleConditionGroup, depth int, trace *matchTrace) (matched bool, evaluated bool, err error) {
+	matchAny := group.Mode == models.RuleMatchAny
+	done := false
+
+	for i, condition := range group.Conditions {
+		if done {
+			trace.conditionEvaluated(condition, false, true)
+			continue
+		}
 		if !condition.Enabled {
-			logrus.Debugf("rule %d - condition: %d (id:%d), %s is disabled", d.Rule.Id, i+1, condition.Id, condition.ConditionType)
+			trace.infof("rule %d - condition: %d (id:%d), %s is disabled", d.Rule.Id, i+1, condition.Id, condition.ConditionType)
+			trace.output("condition disabled")
+			trace.conditionEvaluated(condition, false, true)
 			continue
 		}
+		trace.infof("evaluate rule %d - condition: %d (id:%d), %s", d.Rule.Id, i+1, condition.Id, condition.ConditionType)
+		ok, err := d.matchCondition(condition, trace)
+		if err != nil {
+			return false, false, err
+		}
+		trace.conditionEvaluated(condition, ok, false)
+		evaluated = true
+		matched = ok
+		if ok == matchAny {
+			done = true
+		}
+	}
 
-		logrus.Debugf("evaluate rule %d - condition: %d (id:%d), %s", d.Rule.Id, i+1, condition.Id, condition.ConditionType)
-		condText := string(condition.ConditionType)
-		var ok = false
-		var err error
-		if strings.HasPrefix(condText, "name") {
-			ok, err = d.matchText(condition, d.Document.Name)
-		} else if strings.HasPrefix(condText, "description") {
-			ok, err = d.matchText(condition, d.Document.Description)
-		} else if strings.HasPrefix(condText, "content") {
-			ok, err = d.matchText(condition, d.Document.Content)
-		} else if strings.HasPrefix(condText, "date") {
-			ok, err = d.extractDates(condition, time.Now(), nil)
-		} else if strings.HasPrefix(condText, "metadata_count") {
-			ok, err = d.hasMetadataCount(condition)
-		} else if condition.ConditionType == models.RuleConditionMetadataHasKey {
-			ok = d.hasMetadataKey(condition)
-		} else if condition.ConditionType == models.RuleConditionMetadataHasKeyValue {
-			ok = d.hasMetadataKeyValue(condition)
-		} else {
-			err := errors.ErrInternalError
-			err.ErrMsg = "unknown condition type: " + condText
-			return false, err
+	for _, subgroup := range group.Groups {
+		if done {
+			d.skipGroup(subgroup, trace)
+			continue
 		}
+		ok, subEvaluated, err := d.matchGroup(subgroup, depth+1, trace)
 		if err != nil {
-			return false, fmt.Errorf("evaluate condition: %v", err)
+			return false, false, err
+		}
+		if !subEvaluated {
+			continue
 		}
+		evaluated = true
+		matched = ok
+		if ok == matchAny {
+			done = true
+		}
+	}
 
-		if condition.Inverted {
-			ok = !ok
+	if !evaluated {
+		trace.infof("rule %d - condition group (depth %d) has no enabled conditions", d.Rule.Id, depth)
+		trace.groupEvaluated(group, false, true)
+		return false, false, nil
+	}
+	if done {
+		if matchAny {
+			trace.infof("condition group (depth %d) matches and mode is set to 'match any', skip rest conditions", depth)
+		} else {
+			trace.infof("condition group (depth %d) didn't match and mode is set to 'match all', skip rest conditions", depth)
 		}
+	}
+	if group.Inverted {
+		trace.infof("invert condition group (depth %d) matched: %t -> %t", depth, matched, !matched)
+		matched = !matched
+	}
+	trace.groupEvaluated(group, matched, false)
+	return matched, true, nil
+}
 
+// skipGroup marks group and its conditions skipped.
+func (d *DocumentRule) skipGroup(group *models.RuleConditionGroup, trace *matchTrace) {
+	for _, condition := range group.Conditions {
+		trace.conditionEvaluated(condition, false, true)
+	}
+	for _, subgroup := range group.Groups {
+		d.skipGroup(subgroup, trace)
+	}
+	trace.groupEvaluated(group, false, true)
+}
+
+// matchCondition evaluates single condition, including inverting the result.
+func (d *DocumentRule) matchCondition(condition *models.RuleCondition, trace *matchTrace) (bool, error) {
+	var logger *logrus.Logger
+	var log logFunc
+	if trace != nil {
+		logger = trace.logger
+		log = trace.log
+	}
+
+	condText := string(condition.ConditionType)
+	var ok = false
+	var err error
+	if strings.HasPrefix(condText, "name") {
+		ok, err = d.matchText(condition, d.Document.Name)
+	} else if strings.HasPrefix(condText, "description") {
+		ok, err = d.matchText(condition, d.Document.Description)
+	} else if strings.HasPrefix(condText, "content") {
+		ok, err = d.matchText(condition, d.Document.Content)
+	} else if strings.HasPrefix(condText, "date") {
+		ok, err = d.extractDates(condition, time.Now(), logger)
 		if ok {
-			hasMatch = true
-		} else if d.Rule.Mode == models.RuleMatchAll {
-			return false, nil
+			y, m, d := d.date.Date()
+			trace.infof("found date %d-%d-%d", y, m, d)
+			trace.output("found date %d-%d-%d", y, m, d)
 		}
+	} else if strings.HasPrefix(condText, "metadata_count") {
+		ok, err = d.hasMetadataCount(condition)
+	} else if condition.ConditionType == models.RuleConditionMetadataHasKey {
+		ok = d.hasMetadataKey(condition)
+	} else if condition.ConditionType == models.RuleConditionMetadataHasKeyValue {
+		ok = d.hasMetadataKeyValue(condition)
+	} else if condition.ConditionType.IsAttributeCondition() {
+		ok, err = d.matchAttribute(condition, log)
+	} else {
+		err := errors.ErrInternalError
+		err.ErrMsg = "unknown condition type: " + condText
+		return false, err
+	}
+	if err != nil {
+		return false, fmt.Errorf("evaluate condition: %v", err)
+	}
 
-		if hasMatch && d.Rule.Mode == models.RuleMatchAny {
-			// already found a match, skip rest of the conditions
-			break
-		}
+	if condition.Inverted {
+		trace.output("invert condition matched: %t -> %t", ok, !ok)
+		ok = !ok
+	}
+	if ok {
+		trace.infof("condition (id %d) matched", condition.Id)
+		trace.output("condition matched")
+	} else {
+		trace.infof("condition (id %d) didn't match", condition.Id)
+		trace.output("condition didn't match")
 	}
-	return hasMatch, nil
+	return ok, nil
 }
 
 type formatter struct{}
@@ -200,6 +367,188 @@ func (d *DocumentRule) hasMetadataCount(condition *models.RuleCondition) (bool,
 	}
 }
 
+// matchAttribute matches conditions for document properties, mimetype, size, language, filename and sharing.
+func (d *DocumentRule) matchAttribute(condition *models.RuleCondition, log logFunc) (bool, error) {
+	if condition.ConditionType.IsPropertyCondition() {
+		return d.matchProperty(condition, log)
+	}
+
+	switch condition.ConditionType {
+	case models.RuleConditionMimetypeIs:
+		if log != nil {
+			log("document mimetype: %s", d.Document.Mimetype)
+		}
+		mimetype := strings.ToLower(d.Document.Mimetype)
+		value := strings.ToLower(condition.Value)
+		if strings.HasSuffix(value, "/*") {
+			return strings.HasPrefix(mimetype, strings.TrimSuffix(value, "*")), nil
+		}
+		return mimetype == value, nil
+	case models.RuleConditionSizeLessThan, models.RuleConditionSizeMoreThan:
+		limit, err := models.ParseFileSize(condition.Value)
+		if err != nil {
+			e := errors.ErrInvalid
+			e.ErrMsg = err.Error()
+			return false, e
+		}
+		if log != nil {
+			log("document size: %s", models.GetPrettySize(d.Document.Size))
+		}
+		if condition.ConditionType == models.RuleConditionSizeLessThan {
+			return d.Document.Size < limit, nil
+		}
+		return d.Document.Size > limit, nil
+	case models.RuleConditionLangIs:
+		if log != nil {
+			log("document language: %s", d.Document.Lang)
+		}
+		return strings.EqualFold(d.Document.Lang.String(), condition.Value), nil
+	case models.RuleConditionFilenameMatches:
+		if log != nil {
+			log("document filename: %s", d.Document.Filename)
+		}
+		filename := d.Document.Filename
+		pattern := condition.Value
+		if condition.CaseInsensitive {
+			filename = strings.ToLower(filename)
+			pattern = strings.ToLower(pattern)
+		}
+		if condition.IsRegex {
+			return matchTextByRegex(pattern, filename)
+		}
+		return path.Match(pattern, filename)
+	case models.RuleConditionIsShared:
+		if log != nil {
+			log("document is shared with %d users", d.Document.Shares)
+		}
+		return d.Document.Shares > 0, nil
+	case models.RuleConditionBarcodeIs, models.RuleConditionBarcodeContains:
+		return d.matchBarcode(condition, log)
+	default:
+		err := errors.ErrInternalError
+		err.ErrMsg = fmt.Sprintf("unknown condition type: %s", condition.ConditionType)
+		return false, err
+	}
+}
+
+// matchBarcode matches barcodes of the document, which are stored as values of the barcode property.
+// Document matches if any of the barcodes match.
+func (d *DocumentRule) matchBarcode(condition *models.RuleCondition, log logFunc) (bool, error) {
+	values := make([]string, 0, 2)
+	for _, v := range d.Document.Properties {
+		if v.PropertyName == config.C.Processing.BarcodeProperty {
+			values = append(values, v.Value)
+		}
+	}
+	if log != nil {
+		log("document barcodes: %v", values)
+	}
+
+	pattern := condition.Value
+	if condition.CaseInsensitive {
+		pattern = strings.ToLower(pattern)
+	}
+	for _, v := range values {
+		if condition.CaseInsensitive {
+			v = strings.ToLower(v)
+		}
+		var ok bool
+		var err error
+		if condition.IsRegex {
+			ok, err = matchTextByRegex(pattern, v)
+		} else if condition.ConditionType == models.RuleConditionBarcodeIs {
+			ok = v == pattern
+		} else {
+			ok = strings.Contains(v, pattern)
+		}
+		if err != nil {
+			return false, err
+		}
+		if ok {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// matchProperty matches document property values. Document matches if any of its values for the property match.
+// Values are compared according to the property type: numbers as numbers and dates with the property's date format.
+func (d *DocumentRule) matchProperty(condition *models.RuleCondition, log logFunc) (bool, error) {
+	values := make([]string, 0, 2)
+	for _, v := range d.Document.Properties {
+		if v.Property == int(condition.PropertyId) {
+			values = append(values, v.Value)
+		}
+	}
+	if log != nil {
+		if len(values) == 0 {
+			log(`document does not have property "%s"`, condition.PropertyName)
+		} else {
+			log(`document property "%s" values: %v`, condition.PropertyName, values)
+		}
+	}
+
+	switch condition.ConditionType {
+	case models.RuleConditionPropertyExists:
+		return len(values) > 0, nil
+	case models.RuleConditionPropertyIs:
+		for _, v := range values {
+			if v == condition.Value || (condition.CaseInsensitive && strings.EqualFold(v, condition.Value)) {
+				return true, nil
+			}
+		}
+		return false, nil
+	case models.RuleConditionPropertyLessThan, models.RuleConditionPropertyMoreThan:
+		limit, err := d.propertyNumericValue(condition, condition.Value)
+		if err != nil {
+			e := errors.ErrInvalid
+			e.ErrMsg = fmt.Sprintf("invalid value to compare: %v", err)
+			return false, e
+		}
+		for _, v := range values {
+			value, err := d.propertyNumericValue(condition, v)
+			if err != nil {
+				if log != nil {
+					log(`skip invalid value "%s": %v`, v, err)
+				}
+				continue
+			}
+			if condition.ConditionType == models.RuleConditionPropertyLessThan && value < limit {
+				return true, nil
+			}
+			if condition.ConditionType == models.RuleConditionPropertyMoreThan && value > limit {
+				return true, nil
+			}
+		}
+		return false, nil
+	default:
+		err := errors.ErrInternalError
+		err.ErrMsg = fmt.Sprintf("unknown condition type: %s", condition.ConditionType)
+		return false, err
+	}
+}
+
+// propertyNumericValue converts property value to a number that can be compared.
+func (d *DocumentRule) propertyNumericValue(condition *models.RuleCondition, value string) (float64, error) {
+	switch models.PropertyType(condition.PropertyType) {
+	case models.IntProperty, models.FloatProperty:
+		return strconv.ParseFloat(strings.TrimSpace(value), 64)
+	case models.CounterProperty:
+		// counter values may have a prefix
+		return strconv.ParseFloat(strings.TrimLeftFunc(value, func(r rune) bool {
+			return r < '0' || r > '9'
+		}), 64)
+	case models.DateProperty:
+		date, err := time.Parse(condition.PropertyDateFmt.String(), value)
+		if err != nil {
+			return 0, err
+		}
+		return float64(date.Unix()), nil
+	default:
+		return 0, fmt.Errorf("property type '%s' cannot be compared", condition.PropertyType)
+	}
+}
+
 // Try to extract all dates from the document.
 // In case there are multiple dates found, prioritice:
 // 1. a future date that has most matches
@@ -325,6 +674,18 @@ func (d *DocumentRule) runAction(action *models.RuleAction, log logFunc) error {
 		removeMetadata(d.Document, int(action.MetadataKey), int(action.MetadataValue), log)
 	case models.RuleActionSetDate:
 		actionError = d.setDate(action, log)
+	case models.RuleActionShare:
+		actionError = d.share(action, log)
+	case models.RuleActionSetFavorite:
+		actionError = d.setFavorite(action, log)
+	case models.RuleActionAddProperty:
+		actionError = d.addProperty(action, log)
+	case models.RuleActionLinkDocuments:
+		actionError = d.linkDocuments(action, log)
+	case models.RuleActionSetLanguage:
+		actionError = d.setLanguage(action, log)
+	case models.RuleActionDelete:
+		actionError = d.delete(action, log)
 	default:
 		e := errors.ErrInternalError
 		e.ErrMsg = fmt.Sprintf("unknown action type: %v", action.Action)
@@ -461,6 +822,99 @@ func (d *DocumentRule) setDate(action *models.RuleAction, log logFunc) error {
 	return nil
 }
 
+func (d *DocumentRule) share(action *models.RuleAction, log logFunc) error {
+	if int(action.ShareUserId) == d.Document.UserId {
+		e := errors.ErrInvalid
+		e.ErrMsg = "cannot share with self"
+		return e
+	}
+	d.effects.shares = append(d.effects.shares, models.UpdateUserSharing{
+		UserId:      int(action.ShareUserId),
+		Permissions: action.Permissions,
+	})
+	if log != nil {
+		log(`share document with user "%s" (read: %t, write: %t, delete: %t)`, action.ShareUserName,
+			action.Permissions.Read, action.Permissions.Write, action.Permissions.Delete)
+	}
+	return nil
+}
+
+func (d *DocumentRule) setFavorite(action *models.RuleAction, log logFunc) error {
+	if log != nil {
+		log("set favorite: %t -> %t", d.Document.Favorite, action.Favorite())
+	}
+	d.Document.Favorite = action.Favorite()
+	return nil
+}
+
+func (d *DocumentRule) addProperty(action *models.RuleAction, log logFunc) error {
+	d.effects.properties = append(d.effects.properties, models.DocumentProperty{
+		Document:     d.Document.Id,
+		Property:     int(action.PropertyId),
+		PropertyName: action.PropertyName.String(),
+		Value:        action.Value,
+	})
+	if log != nil {
+		log(`add property "%s" with value "%s" (generated properties get their value when saved)`,
+			action.PropertyName, action.Value)
+	}
+	return nil
+}
+
+// maxLinkedDocuments is the max number of documents a single rule action links.
+const maxLinkedDocuments = 50
+
+func (d *DocumentRule) linkDocuments(action *models.RuleAction, log logFunc) error {
+	if d.search == nil {
+		if log != nil {
+			log("search engine not available (skip linking)")
+		}
+		return nil
+	}
+	docs, _, err := d.search.SearchDocuments(d.Document.UserId, action.Value, storage.SortKey{},
+		storage.Paging{Offset: 0, Limit: maxLinkedDocuments})
+	if err != nil {
+		return fmt.Errorf("search documents to link: %v", err)
+	}
+	linked := 0
+	for _, v := range docs {
+		// only owned documents can be linked
+		if v.Id == d.Document.Id || v.UserId != d.Document.UserId {
+			continue
+		}
+		d.effects.links = append(d.effects.links, v.Id)
+		linked += 1
+		if log != nil {
+			log(`link document "%s" (%s)`, v.Name, v.Id)
+		}
+	}
+	if log != nil && linked == 0 {
+		log("no documents to link")
+	}
+	return nil
+}
+
+func (d *DocumentRule) setLanguage(action *models.RuleAction, log logFunc) error {
+	if SupportedLanguages[action.Value] == "" {
+		e := errors.ErrInvalid
+		e.ErrMsg = fmt.Sprintf("unsupported language: %s", action.Value)
+		return e
+	}
+	if log != nil {
+		log(`set language: "%s" -> "%s"`, d.Document.Lang, action.Value)
+	}
+	d.Document.Lang = models.Lang(action.Value)
+	return nil
+}
+
+func (d *DocumentRule) delete(action *models.RuleAction, log logFunc) error {
+	d.effects.delete = true
+	if log != nil {
+		log("move document to trash")
+	}
+	return nil
+}
+
 func matchTextAllowTypo(match, text string, matchPrefix, matchIs bool) (bool, error) {
 	// max typos affect greatly the number of false positives, so try to be conservative with them..
 	maxTypos := 0
@@ -6,6 +6,7 @@ import (
 	"testing"
 	"time"
 
+	"tryffel.net/go/virtualpaper/config"
 	"tryffel.net/go/virtualpaper/models"
 )
 
@@ -117,7 +118,7 @@ func TestDocumentRule_matchText(t *testing.T) {
 			},
 		},
 	}
-	dc := NewDocumentRule(doc, rule)
+	dc := NewDocumentRule(doc, rule, nil)
 	got, err := dc.Match()
 	if err != nil {
 		t.Errorf("matchText() error = %v", err)
@@ -206,7 +207,7 @@ func TestDocumentRule_RunActions(t *testing.T) {
 	}
 
 	wantName := "test, suffix"
-	dc := NewDocumentRule(doc, rule)
+	dc := NewDocumentRule(doc, rule, nil)
 	dc.date = time.Unix(1627620345, 0)
 	err := dc.RunActions()
 	if err != nil {
@@ -232,6 +233,270 @@ func TestDocumentRule_RunActions(t *testing.T) {
 
 }
 
+func TestDocumentRule_RunActions_effects(t *testing.T) {
+	doc := &models.Document{
+		Id:     "1234",
+		UserId: 1,
+		Name:   "test document",
+		Lang:   "en",
+	}
+
+	rule := &models.Rule{
+		Name: "test rule",
+		Mode: models.RuleMatchAll,
+		Actions: []*models.RuleAction{
+			{
+				Enabled:     true,
+				Action:      models.RuleActionShare,
+				ShareUserId: 2,
+				Permissions: models.Permissions{Read: true, Write: true},
+			},
+			{
+				Enabled: true,
+				Action:  models.RuleActionSetFavorite,
+				Value:   "true",
+			},
+			{
+				Enabled:    true,
+				Action:     models.RuleActionAddProperty,
+				PropertyId: 5,
+				Value:      "abc",
+			},
+			{
+				Enabled: true,
+				Action:  models.RuleActionSetLanguage,
+				Value:   "fi",
+			},
+			{
+				Enabled: true,
+				Action:  models.RuleActionLinkDocuments,
+				Value:   "invoice",
+			},
+			{
+				Enabled: false,
+				Action:  models.RuleActionDelete,
+			},
+		},
+	}
+
+	dc := NewDocumentRule(doc, rule, nil)
+	err := dc.RunActions()
+	if err != nil {
+		t.Errorf("runActions() error = %v", err)
+		return
+	}
+
+	if !doc.Favorite {
+		t.Errorf("runActions(), favorite not set")
+	}
+	if doc.Lang != "fi" {
+		t.Errorf("runActions(), want lang fi, got %s", doc.Lang)
+	}
+
+	wantShares := []models.UpdateUserSharing{{UserId: 2, Permissions: models.Permissions{Read: true, Write: true}}}
+	if !reflect.DeepEqual(dc.effects.shares, wantShares) {
+		t.Errorf("runActions(), shares = %v, want %v", dc.effects.shares, wantShares)
+	}
+	if len(dc.effects.properties) != 1 || dc.effects.properties[0].Property != 5 || dc.effects.properties[0].Value != "abc" {
+		t.Errorf("runActions(), invalid properties: %v", dc.effects.properties)
+	}
+	if len(dc.effects.links) != 0 {
+		t.Errorf("runActions(), links without search engine: %v", dc.effects.links)
+	}
+	if dc.effects.delete {
+		t.Errorf("runActions(), disabled delete action was run")
+	}
+
+	rule.Actions = []*models.RuleAction{{Enabled: true, Action: models.RuleActionShare, ShareUserId: 1}}
+	dc = NewDocumentRule(doc, rule, nil)
+	if err := dc.RunActions(); err == nil {
+		t.Errorf("runActions(), sharing with owner should fail")
+	}
+
+	rule.Actions = []*models.RuleAction{{Enabled: true, Action: models.RuleActionSetLanguage, Value: "xx"}}
+	dc = NewDocumentRule(doc, rule, nil)
+	if err := dc.RunActions(); err == nil {
+		t.Errorf("runActions(), unsupported language should fail")
+	}
+}
+
+func TestDocumentRule_matchAttribute(t *testing.T) {
+	config.C = &config.Config{Processing: config.Processing{BarcodeProperty: "barcode"}}
+	defer func() { config.C = nil }()
+
+	doc := &models.Document{
+		Id:       "1234",
+		UserId:   1,
+		Filename: "Invoice-2023.pdf",
+		Mimetype: "application/pdf",
+		Size:     2 * 1024 * 1024,
+		Lang:     "en",
+		Shares:   1,
+		Properties: []models.DocumentProperty{
+			{Property: 1, Value: "42"},
+			{Property: 2, Value: "2023-05-01"},
+			{Property: 3, Value: "INV-120"},
+			{Property: 4, PropertyName: "barcode", Value: "RF18 5390 0754 7034"},
+		},
+	}
+
+	tests := []struct {
+		name      string
+		condition models.RuleCondition
+		want      bool
+		wantErr   bool
+	}{
+		{"mimetype", models.RuleCondition{ConditionType: models.RuleConditionMimetypeIs, Value: "application/pdf"}, true, false},
+		{"mimetype wildcard", models.RuleCondition{ConditionType: models.RuleConditionMimetypeIs, Value: "image/*"}, false, false},
+		{"size more than", models.RuleCondition{ConditionType: models.RuleConditionSizeMoreThan, Value: "1MB"}, true, false},
+		{"size less than", models.RuleCondition{ConditionType: models.RuleConditionSizeLessThan, Value: "1MB"}, false, false},
+		{"language", models.RuleCondition{ConditionType: models.RuleConditionLangIs, Value: "en"}, true, false},
+		{"filename glob", models.RuleCondition{ConditionType: models.RuleConditionFilenameMatches, Value: "invoice-*.pdf", CaseInsensitive: true}, true, false},
+		{"filename glob case", models.RuleCondition{ConditionType: models.RuleConditionFilenameMatches, Value: "invoice-*.pdf"}, false, false},
+		{"filename regex", models.RuleCondition{ConditionType: models.RuleConditionFilenameMatches, Value: "\\d{4}", IsRegex: true}, true, false},
+		{"shared", models.RuleCondition{ConditionType: models.RuleConditionIsShared}, true, false},
+		{"property exists", models.RuleCondition{ConditionType: models.RuleConditionPropertyExists, PropertyId: 1}, true, false},
+		{"property missing", models.RuleCondition{ConditionType: models.RuleConditionPropertyExists, PropertyId: 5}, false, false},
+		{"property is", models.RuleCondition{ConditionType: models.RuleConditionPropertyIs, PropertyId: 1, Value: "42"}, true, false},
+		{"property int more than", models.RuleCondition{ConditionType: models.RuleConditionPropertyMoreThan, PropertyId: 1,
+			PropertyType: models.Text(models.IntProperty), Value: "40"}, true, false},
+		{"property int less than", models.RuleCondition{ConditionType: models.RuleConditionPropertyLessThan, PropertyId: 1,
+			PropertyType: models.Text(models.IntProperty), Value: "40"}, false, false},
+		{"property date", models.RuleCondition{ConditionType: models.RuleConditionPropertyLessThan, PropertyId: 2,
+			PropertyType: models.Text(models.DateProperty), PropertyDateFmt: "2006-01-02", Value: "2023-06-01"}, true, false},
+		{"property counter", models.RuleCondition{ConditionType: models.RuleConditionPropertyMoreThan, PropertyId: 3,
+			PropertyType: models.Text(models.CounterProperty), Value: "100"}, true, false},
+		{"property text comparison", models.RuleCondition{ConditionType: models.RuleConditionPropertyMoreThan, PropertyId: 3,
+			PropertyType: models.Text(models.TextProperty), Value: "100"}, false, true},
+		{"barcode is", models.RuleCondition{ConditionType: models.RuleConditionBarcodeIs, Value: "RF18 5390 0754 7034"}, true, false},
+		{"barcode is partial", models.RuleCondition{ConditionType: models.RuleConditionBarcodeIs, Value: "RF18"}, false, false},
+		{"barcode contains", models.RuleCondition{ConditionType: models.RuleConditionBarcodeContains, Value: "rf18", CaseInsensitive: true}, true, false},
+		{"barcode regex", models.RuleCondition{ConditionType: models.RuleConditionBarcodeContains, Value: "^RF\\d{2}", IsRegex: true}, true, false},
+		{"barcode not property", models.RuleCondition{ConditionType: models.RuleConditionBarcodeContains, Value: "INV"}, false, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := NewDocumentRule(doc, &models.Rule{}, nil)
+			got, err := d.matchAttribute(&tt.condition, nil)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("matchAttribute() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if got != tt.want {
+				t.Errorf("matchAttribute() got = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDocumentRule_Match_conditionGroups(t *testing.T) {
+	doc := &models.Document{
+		Id:       "1234",
+		UserId:   1,
+		Name:     "invoice",
+		Filename: "invoice.pdf",
+		Mimetype: "application/pdf",
+		Lang:     "en",
+	}
+
+	lang := func(value string, enabled bool) *models.RuleCondition {
+		return &models.RuleCondition{Enabled: enabled, ConditionType: models.RuleConditionLangIs, Value: value}
+	}
+	group := func(mode models.RuleConditionMatchType, inverted bool, conditions []*models.RuleCondition,
+		groups ...*models.RuleConditionGroup) *models.RuleConditionGroup {
+		return &models.RuleConditionGroup{Mode: mode, Inverted: inverted, Conditions: conditions, Groups: groups}
+	}
+	matchAll, matchAny := models.RuleMatchAll, models.RuleMatchAny
+
+	tests := []struct {
+		name string
+		root *models.RuleConditionGroup
+		want bool
+	}{
+		{"empty root", group(matchAll, false, nil), false},
+		{"only disabled", group(matchAny, false, []*models.RuleCondition{lang("en", false)}), false},
+		{"all", group(matchAll, false, []*models.RuleCondition{lang("en", true), lang("en", true)}), true},
+		{"all with nested any", group(matchAll, false, []*models.RuleCondition{lang("en", true)},
+			group(matchAny, false, []*models.RuleCondition{lang("fi", true), lang("en", true)})), true},
+		{"all with failing nested", group(matchAll, false, []*models.RuleCondition{lang("en", true)},
+			group(matchAll, false, []*models.RuleCondition{lang("fi", true), lang("en", true)})), false},
+		{"any with nested match", group(matchAny, false, []*models.RuleCondition{lang("fi", true)},
+			group(matchAll, false, []*models.RuleCondition{lang("en", true)})), true},
+		{"not", group(matchAll, true, []*models.RuleCondition{lang("fi", true)}), true},
+		{"nested not", group(matchAll, false, []*models.RuleCondition{lang("en", true)},
+			group(matchAny, true, []*models.RuleCondition{lang("fi", true), lang("sv", true)})), true},
+		{"nested not fails", group(matchAll, false, []*models.RuleCondition{lang("en", true)},
+			group(matchAny, true, []*models.RuleCondition{lang("fi", true), lang("en", true)})), false},
+		{"disabled nested group is ignored", group(matchAll, false, []*models.RuleCondition{lang("en", true)},
+			group(matchAll, false, []*models.RuleCondition{lang("fi", false)})), true},
+		{"deep", group(matchAny, false, nil,
+			group(matchAll, false, nil,
+				group(matchAny, true, nil,
+					group(matchAll, false, []*models.RuleCondition{lang("fi", true)})))), true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rule := &models.Rule{Id: 1}
+			rule.SetConditionTree(tt.root)
+			d := NewDocumentRule(doc, rule, nil)
+			got, err := d.Match()
+			if err != nil {
+				t.Errorf("Match() error = %v", err)
+				return
+			}
+			if got != tt.want {
+				t.Errorf("Match() got = %v, want %v", got, tt.want)
+			}
+
+			result := d.MatchTest()
+			if result.Match != tt.want {
+				t.Errorf("MatchTest() got = %v, want %v", result.Match, tt.want)
+			}
+			if len(result.Groups) != len(tt.root.AllGroups()) {
+				t.Errorf("MatchTest() groups = %d, want %d", len(result.Groups), len(tt.root.AllGroups()))
+			}
+		})
+	}
+}
+
+func TestDocumentRule_MatchTest_conditionGroups(t *testing.T) {
+	doc := &models.Document{Id: "1234", UserId: 1, Lang: "en"}
+	root := &models.RuleConditionGroup{
+		Id:   1,
+		Mode: models.RuleMatchAny,
+		Conditions: []*models.RuleCondition{
+			{Id: 1, GroupId: 1, Enabled: true, ConditionType: models.RuleConditionLangIs, Value: "en"},
+		},
+		Groups: []*models.RuleConditionGroup{
+			{Id: 2, ParentId: 1, Mode: models.RuleMatchAll, Inverted: true, Conditions: []*models.RuleCondition{
+				{Id: 2, GroupId: 2, Enabled: true, ConditionType: models.RuleConditionLangIs, Value: "fi"},
+			}},
+		},
+	}
+	rule := &models.Rule{Id: 1}
+	rule.SetConditionTree(root)
+	d := NewDocumentRule(doc, rule, nil)
+	result := d.MatchTest()
+
+	if !result.Match {
+		t.Errorf("MatchTest() expected match")
+	}
+	want := []RuleTestConditionResult{
+		{ConditionId: 1, GroupId: 1, ConditionType: "lang_is", Matched: true},
+		{ConditionId: 2, GroupId: 2, ConditionType: "lang_is", Skipped: true},
+	}
+	if !reflect.DeepEqual(result.Conditions, want) {
+		t.Errorf("MatchTest() conditions = %v, want %v", result.Conditions, want)
+	}
+	wantGroups := []RuleTestGroupResult{
+		{GroupId: 1, Depth: 1, Mode: "match_any", Matched: true},
+		{GroupId: 2, ParentId: 1, Depth: 2, Mode: "match_all", Inverted: true, Skipped: true},
+	}
+	if !reflect.DeepEqual(result.Groups, wantGroups) {
+		t.Errorf("MatchTest() groups = %v, want %v", result.Groups, wantGroups)
+	}
+}
+
 func TestDocumentRule_matchTextByDistance(t *testing.T) {
 	type args struct {
 		match       string
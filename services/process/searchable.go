@@ -0,0 +1,163 @@
+package process
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"time"
+
+	"tryffel.net/go/virtualpaper/config"
+	"tryffel.net/go/virtualpaper/models"
+	"tryffel.net/go/virtualpaper/storage"
+	"tryffel.net/go/virtualpaper/storage/blob"
+	log "tryffel.net/go/virtualpaper/util/logger"
+)
+
+// generateSearchablePdf creates a pdf with invisible OCR text layer for scanned pdf files and images
+// and stores it next to the original file. Documents that already contain text are skipped.
+func (fp *fileProcessor) generateSearchablePdf(ctx context.Context) error {
+	process := &models.ProcessItem{
+		DocumentId: fp.document.Id,
+		Action:     models.ProcessSearchablePdf,
+		CreatedAt:  time.Now(),
+	}
+
+	if !fp.searchablePdf || !(fp.document.IsPdf() || fp.document.IsImage()) {
+		return fp.skipSearchablePdf(ctx, process)
+	}
+
+	if fp.searchablePdfCreated {
+		// created during content extraction
+		err := fp.db.JobStore.MarkProcessingDone(process, true)
+		if err != nil {
+			return fmt.Errorf("mark process complete: %v", err)
+		}
+		return nil
+	}
+
+	err := fp.ensureFileOpen()
+	if err != nil {
+		return fmt.Errorf("open file: %v", err)
+	}
+
+	if fp.hasTextLayer() {
+		fp.Info("document already has text, skip generating searchable pdf")
+		return fp.skipSearchablePdf(ctx, process)
+	}
+
+	// content was not extracted with OCR in this run, run OCR only for the pdf.
+	job, err := fp.db.JobStore.StartProcessItem(process, "generate searchable pdf")
+	if err != nil {
+		return fmt.Errorf("persist process item: %v", err)
+	}
+	defer fp.completeProcessingStep(process, job)
+
+	file, cleanup, err := fp.processingFile(ctx)
+	if err != nil {
+		job.Status = models.JobFailure
+		job.Message += "; " + err.Error()
+		return err
+	}
+	defer cleanup()
+
+	output := fp.searchablePdfOutput()
+	_, err = runOcr(ctx, file, fp.document.Id, fp.ocrLanguages(), false, output, nil)
+	if err != nil {
+		job.Status = models.JobFailure
+		job.Message += "; " + err.Error()
+		return fmt.Errorf("create searchable pdf: %v", err)
+	}
+
+	err = fp.storeSearchablePdf(ctx, output)
+	if err != nil {
+		job.Status = models.JobFailure
+		job.Message += "; " + err.Error()
+		return err
+	}
+	job.Status = models.JobFinished
+	return nil
+}
+
+// searchablePdfOutput returns the local path to create the searchable pdf to, or empty if
+// searchable pdf is not generated for the document.
+func (fp *fileProcessor) searchablePdfOutput() string {
+	if !fp.searchablePdf || !(fp.document.IsPdf() || fp.document.IsImage()) {
+		return ""
+	}
+	return storage.TempFilePath(fp.document.Id) + "-searchable.pdf"
+}
+
+// storeSearchablePdf moves the local searchable pdf to file storage.
+func (fp *fileProcessor) storeSearchablePdf(ctx context.Context, file string) error {
+	defer os.Remove(file)
+	err := blob.PutFile(ctx, fp.db.Files, storage.SearchablePdfKey(fp.document.Id), file)
+	if err != nil {
+		return fmt.Errorf("store searchable pdf: %v", err)
+	}
+	fp.searchablePdfCreated = true
+	return nil
+}
+
+// skipSearchablePdf completes the step without generating searchable pdf. Searchable pdf of previous file,
+// if any, is removed.
+func (fp *fileProcessor) skipSearchablePdf(ctx context.Context, process *models.ProcessItem) error {
+	err := fp.db.Files.Delete(ctx, storage.SearchablePdfKey(fp.document.Id))
+	if err != nil {
+		return fmt.Errorf("remove old searchable pdf: %v", err)
+	}
+	err = fp.db.JobStore.MarkProcessingDone(process, true)
+	if err != nil {
+		return fmt.Errorf("mark process complete: %v", err)
+	}
+	return nil
+}
+
+// combineSearchablePdf combines pdf pages that tesseract created to a single pdf. If ghostscript is configured,
+// the pdf is converted to PDF/A. Else pages are merged with pdfunite.
+func combineSearchablePdf(ctx context.Context, pages []string, output string) error {
+	if len(pages) == 0 {
+		return fmt.Errorf("no pages found")
+	}
+	log.Context(ctx).Infof("create searchable pdf of %d pages", len(pages))
+	if config.C.Processing.GhostscriptBin != "" {
+		err := convertToPdfA(ctx, pages, output)
+		if err != nil {
+			return fmt.Errorf("convert to PDF/A: %v", err)
+		}
+		return nil
+	}
+	if len(pages) == 1 {
+		return os.Rename(pages[0], output)
+	}
+	if config.C.Processing.PdfUniteBin == "" {
+		return fmt.Errorf("pdfunite or ghostscript is required for searchable pdf of multiple pages")
+	}
+	return MergePdf(ctx, pages, output)
+}
+
+// convertToPdfA combines input pdfs to a PDF/A-2b file with ghostscript.
+func convertToPdfA(ctx context.Context, inputs []string, output string) error {
+	args := []string{
+		"-dPDFA=2",
+		"-dBATCH",
+		"-dNOPAUSE",
+		"-dNOOUTERSAVE",
+		"-dPDFACompatibilityPolicy=1",
+		"-sColorConversionStrategy=RGB",
+		"-sDEVICE=pdfwrite",
+		"-o", output,
+	}
+	args = append(args, inputs...)
+
+	stderr := &bytes.Buffer{}
+	log.Context(ctx).Infof("call ghostscript: '%s'", args)
+	cmd := exec.CommandContext(ctx, config.C.Processing.GhostscriptBin, args...)
+	cmd.Stderr = stderr
+	err := cmd.Run()
+	if err != nil {
+		return fmt.Errorf("run ghostscript: %v, stderr: %s", err, stderr.String())
+	}
+	return nil
+}
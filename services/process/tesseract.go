@@ -22,12 +22,13 @@ import (
 	"bytes"
 	"context"
 	"fmt"
-	"io"
 	"os"
 	"os/exec"
 	"path"
-	"path/filepath"
+	"sort"
+	"strconv"
 	"strings"
+	"sync"
 	"time"
 	log "tryffel.net/go/virtualpaper/util/logger"
 
@@ -36,15 +37,24 @@ import (
 	"tryffel.net/go/virtualpaper/storage"
 )
 
-func runOcr(ctx context.Context, inputImage, id string) (string, error) {
+// ocrProgress is called after each page is processed with OCR.
+type ocrProgress func(done, total int)
+
+// runOcr extracts text of each page in the file with tesseract using given languages.
+// If twoPass is set and there are multiple languages, language of the text is detected after first pass
+// and pages are extracted again with only the detected language.
+// If pdfOutput is not empty, tesseract also renders each page to pdf with a text layer during the same run
+// and the pages are combined to a searchable pdf in pdfOutput. Failing to create the pdf is logged and does
+// not fail OCR, in which case pdfOutput does not exist.
+// Progress, if not nil, is called after each page. OCR is stopped if ctx is cancelled.
+func runOcr(ctx context.Context, inputImage, id string, languages []string, twoPass bool, pdfOutput string, progress ocrProgress) ([]string, error) {
 
 	var err error
-	var text string
 
 	dir := storage.TempFilePath(id)
 	err = os.Mkdir(dir, os.ModePerm|os.ModeDir)
 	if err != nil {
-		return text, fmt.Errorf("create tmp dir: %v", err)
+		return nil, fmt.Errorf("create tmp dir: %v", err)
 	}
 	defer os.RemoveAll(dir)
 
@@ -54,71 +64,193 @@ func runOcr(ctx context.Context, inputImage, id string) (string, error) {
 	imageFile := path.Join(dir, "preview.png")
 	err = generatePicture(ctx, inputImage, imageFile)
 	if err != nil {
-		return text, fmt.Errorf("generate pictures from pdf pages: %v", err)
+		return nil, fmt.Errorf("generate pictures from pdf pages: %v", err)
 	}
 
-	pages := &[]string{}
-
-	languageParam := strings.Join(config.C.Processing.OcrLanguages, "+")
+	images, err := ocrPageImages(dir)
+	if err != nil {
+		return nil, fmt.Errorf("find page images: %v", err)
+	}
 
-	walkFunc := func(fileName string, info os.FileInfo, err error) error {
-		if info.Name() == id {
-			// root fileName
-			return nil
+	pdf := pdfOutput != ""
+	pages, err := ocrImages(ctx, images, languages, pdf, progress)
+	if err != nil {
+		return nil, err
+	}
+	if twoPass && len(languages) > 1 {
+		lang := detectOcrLanguage(ctx, strings.Join(pages, "\n"), languages)
+		if lang == "" {
+			log.Context(ctx).Infof("could not detect language, keep OCR result of languages %v", languages)
+		} else {
+			log.Context(ctx).Infof("detected language '%s', run OCR again", lang)
+			pages, err = ocrImages(ctx, images, []string{lang}, pdf, progress)
+			if err != nil {
+				return nil, err
+			}
 		}
-		start := time.Now()
-		log.Context(ctx).Infof("OCR file %s", fileName)
+	}
+	if !pdf {
+		return pages, nil
+	}
+
+	pagePdfs := make([]string, len(images))
+	for i, v := range images {
+		pagePdfs[i] = ocrOutputFile(v) + ".pdf"
+	}
+	// text is still usable without the searchable pdf
+	err = combineSearchablePdf(ctx, pagePdfs, pdfOutput)
+	if err != nil {
+		log.Context(ctx).Warnf("create searchable pdf: %v", err)
+		os.Remove(pdfOutput)
+	}
+	return pages, nil
+}
 
-		outputFile := fileName + "-out"
+// ocrImages extracts text of each image with tesseract. Images are processed concurrently by
+// config.C.Processing.OcrWorkers workers, and the pages are returned in the same order as images.
+// If pdf is set, each page is also rendered to pdf with a text layer, see ocrOutputFile.
+func ocrImages(ctx context.Context, images []string, languages []string, pdf bool, progress ocrProgress) ([]string, error) {
+	languageParam := strings.Join(languages, "+")
+	pages := make([]string, len(images))
 
-		args := []string{
-			fileName,
-			outputFile,
-			"-l",
-			languageParam,
-		}
+	workers := config.C.Processing.OcrWorkers
+	if workers < 1 {
+		workers = 1
+	}
+	log.Context(ctx).Infof("OCR %d pages with %d workers", len(images), workers)
 
-		_, err = callTesseract(args...)
-		if err != nil {
-			logrus.Errorf("call tesseract: %s -  %v", args, err)
-		}
+	lock := &sync.Mutex{}
+	wg := &sync.WaitGroup{}
+	sem := make(chan struct{}, workers)
+	pagesDone := 0
 
-		output, err := os.Open(outputFile + ".txt")
-		if err != nil {
-			logrus.Errorf("read output file %s: %v", outputFile, err)
+	for i, fileName := range images {
+		select {
+		case sem <- struct{}{}:
+		case <-ctx.Done():
+		}
+		if ctx.Err() != nil {
+			break
 		}
 
-		pageText, err := io.ReadAll(output)
-		err = output.Close()
-		if err != nil {
-			logrus.Errorf("close output file %s: %v", outputFile, err)
+		wg.Add(1)
+		go func(i int, fileName string) {
+			defer wg.Done()
+			defer func() { <-sem }()
+			pages[i] = ocrImage(ctx, fileName, languageParam, pdf)
 
-		}
+			lock.Lock()
+			defer lock.Unlock()
+			pagesDone += 1
+			if progress != nil {
+				progress(pagesDone, len(images))
+			}
+		}(i, fileName)
+	}
+	wg.Wait()
+
+	if ctx.Err() != nil {
+		return nil, fmt.Errorf("ocr stopped: %v", ctx.Err())
+	}
+	return pages, nil
+}
+
+// ocrImage extracts text of single image with tesseract. Errors are logged and empty text returned.
+// If pdf is set, tesseract outputs also a pdf of the page in the same run.
+func ocrImage(ctx context.Context, fileName string, languageParam string, pdf bool) string {
+	start := time.Now()
+	log.Context(ctx).Infof("OCR file %s", fileName)
+
+	outputFile := ocrOutputFile(fileName)
 
-		took := time.Now().Sub(start)
-		log.Context(ctx).Infof("Extracted %s, took %.2f s, content length: %d", fileName, took.Seconds(), len(pageText))
-		*pages = append(*pages, string(pageText))
-		return nil
+	args := []string{
+		fileName,
+		outputFile,
+		"-l",
+		languageParam,
 	}
+	if pdf {
+		args = append(args, "txt", "pdf")
+	}
+
+	_, err := callTesseract(ctx, args...)
+	if err != nil {
+		logrus.Errorf("call tesseract: %s -  %v", args, err)
+	}
+
+	pageText, err := os.ReadFile(outputFile + ".txt")
+	if err != nil {
+		logrus.Errorf("read output file %s: %v", outputFile, err)
+	}
+
+	took := time.Now().Sub(start)
+	log.Context(ctx).Infof("Extracted %s, took %.2f s, content length: %d", fileName, took.Seconds(), len(pageText))
+	return string(pageText)
+}
 
-	err = filepath.Walk(dir, walkFunc)
+// ocrOutputFile returns the base name of tesseract output files for the image,
+// which tesseract appends with '.txt' or '.pdf'.
+func ocrOutputFile(image string) string {
+	return image + "-out"
+}
 
+// detectOcrLanguage detects language of the text and returns the matching tesseract language
+// if it is one of the languages. Else returns empty string.
+func detectOcrLanguage(ctx context.Context, text string, languages []string) string {
+	lang, err := detectLanguage(ctx, text)
 	if err != nil {
-		return text, fmt.Errorf("ocr file: %v", err)
+		log.Context(ctx).Warnf("detect language: %v", err)
+		return ""
+	}
+	tesseractLang := tesseractLanguages[lang]
+	for _, v := range languages {
+		if tesseractLang != "" && v == tesseractLang {
+			return tesseractLang
+		}
 	}
+	return ""
+}
 
-	content := ""
-	for i, v := range *pages {
-		if i > 0 {
-			content += fmt.Sprintf("\n\n(Page %d)\n\n", i)
+// ocrPageImages returns images generated by generatePicture ordered by page. Imagemagick names pages
+// 'preview-<n>.png', or 'preview.png' if there is only one page.
+func ocrPageImages(dir string) ([]string, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	type pageImage struct {
+		page int
+		file string
+	}
+	images := make([]pageImage, 0, len(entries))
+	for _, v := range entries {
+		name := v.Name()
+		if v.IsDir() || !strings.HasPrefix(name, "preview") || !strings.HasSuffix(name, ".png") {
+			continue
+		}
+		page := 0
+		number := strings.TrimSuffix(strings.TrimPrefix(name, "preview-"), ".png")
+		if number != "preview" {
+			page, err = strconv.Atoi(number)
+			if err != nil {
+				continue
+			}
 		}
-		content += v
+		images = append(images, pageImage{page: page, file: path.Join(dir, name)})
+	}
+	sort.Slice(images, func(i, j int) bool {
+		return images[i].page < images[j].page
+	})
+
+	files := make([]string, len(images))
+	for i, v := range images {
+		files[i] = v.file
 	}
-	return content, err
+	return files, nil
 }
 
 func GetTesseractVersion() string {
-	out, err := callTesseract("--version")
+	out, err := callTesseract(context.Background(), "--version")
 	if err != nil {
 		logrus.Error(err)
 	}
@@ -130,12 +262,12 @@ func GetTesseractVersion() string {
 	return splits[0]
 }
 
-func callTesseract(args ...string) (string, error) {
+func callTesseract(ctx context.Context, args ...string) (string, error) {
 	stdout := &bytes.Buffer{}
 	stderr := &bytes.Buffer{}
 
 	logrus.Debugf("call tesseract: %s, %v", config.C.Processing.TesseractBin, args)
-	cmd := exec.Command(config.C.Processing.TesseractBin, args...)
+	cmd := exec.CommandContext(ctx, config.C.Processing.TesseractBin, args...)
 	cmd.Stdout = stdout
 	cmd.Stderr = stderr
 	err := cmd.Run()
@@ -4,7 +4,6 @@ import (
 	"bytes"
 	"fmt"
 	"github.com/sirupsen/logrus"
-	"strings"
 	"time"
 	"tryffel.net/go/virtualpaper/errors"
 	"tryffel.net/go/virtualpaper/models"
@@ -24,22 +23,49 @@ func (d *DocumentRule) MatchTest() *RuleTestResult {
 
 	}
 
-	hasMatch := false
+	tree := d.Rule.ConditionTree()
+	conditions := tree.AllConditions()
+	groups := tree.AllGroups()
 
 	result := &RuleTestResult{
 		StartedAt:       int(time.Now().UnixNano() / 1000000),
 		RuleId:          d.Rule.Id,
-		Conditions:      make([]RuleTestConditionResult, len(d.Rule.Conditions)),
+		Conditions:      make([]RuleTestConditionResult, len(conditions)),
+		Groups:          make([]RuleTestGroupResult, len(groups)),
 		Actions:         make([]RuleTestAction, len(d.Rule.Actions)),
-		ConditionOutput: [][]string{},
+		ConditionOutput: make([][]string, len(conditions)),
 		ActionOutput:    [][]string{},
 	}
 
-	for i, v := range d.Rule.Conditions {
+	conditionIndex := make(map[*models.RuleCondition]int, len(conditions))
+	for i, v := range conditions {
+		conditionIndex[v] = i
 		result.Conditions[i].ConditionId = v.Id
+		result.Conditions[i].GroupId = int(v.GroupId)
 		result.Conditions[i].ConditionType = v.ConditionType.String()
 		result.Conditions[i].Skipped = true
+		result.ConditionOutput[i] = []string{}
 	}
+
+	groupIndex := make(map[*models.RuleConditionGroup]int, len(groups))
+	var setGroups func(group *models.RuleConditionGroup, depth int)
+	setGroups = func(group *models.RuleConditionGroup, depth int) {
+		i := len(groupIndex)
+		groupIndex[group] = i
+		result.Groups[i] = RuleTestGroupResult{
+			GroupId:  group.Id,
+			ParentId: int(group.ParentId),
+			Depth:    depth,
+			Mode:     group.Mode.String(),
+			Inverted: group.Inverted,
+			Skipped:  true,
+		}
+		for _, v := range group.Groups {
+			setGroups(v, depth+1)
+		}
+	}
+	setGroups(tree, 1)
+
 	for i, v := range d.Rule.Actions {
 		result.Actions[i].Skipped = !v.Enabled
 		result.Actions[i].ActionId = v.Id
@@ -47,95 +73,35 @@ func (d *DocumentRule) MatchTest() *RuleTestResult {
 	}
 
 	var conditionResult []string
-	logConditionOut := func(format string, args ...interface{}) {
-		out := fmt.Sprintf(format, args...)
-		conditionResult = append(conditionResult, out)
+	trace := &matchTrace{
+		logger: logger,
+		log: func(format string, args ...interface{}) {
+			out := fmt.Sprintf(format, args...)
+			conditionResult = append(conditionResult, out)
+		},
+		conditionDone: func(condition *models.RuleCondition, matched, skipped bool) {
+			i := conditionIndex[condition]
+			result.Conditions[i].Matched = matched
+			result.Conditions[i].Skipped = skipped
+			if len(conditionResult) > 0 {
+				result.ConditionOutput[i] = conditionResult
+				conditionResult = []string{}
+			}
+		},
+		groupDone: func(group *models.RuleConditionGroup, matched, skipped bool) {
+			i := groupIndex[group]
+			result.Groups[i].Matched = matched
+			result.Groups[i].Skipped = skipped
+		},
 	}
 
 	logger.Infof("Try to match document: %s with rule: '%s' (id: %d)", d.Document.Id, d.Rule.Name, d.Rule.Id)
-	for i, condition := range d.Rule.Conditions {
-		result.Conditions[i].ConditionId = condition.Id
-		doBreak := false
-		if !condition.Enabled {
-			logger.Warnf("condition: %d (id:%d), %s is disabled, skipping condition", condition.Id, i+1, condition.ConditionType)
-			logConditionOut("condition disabled")
-			continue
-		} else {
-			result.Conditions[i].Skipped = false
-			logger.Infof("evaluate condition %d (id:%d), type: '%s'", condition.Id, i+1, condition.ConditionType)
-			condText := string(condition.ConditionType)
-			var ok = false
-			var err error
-			if strings.HasPrefix(condText, "name") {
-				ok, err = d.matchText(condition, d.Document.Name)
-			} else if strings.HasPrefix(condText, "description") {
-				ok, err = d.matchText(condition, d.Document.Description)
-			} else if strings.HasPrefix(condText, "content") {
-				ok, err = d.matchText(condition, d.Document.Content)
-			} else if strings.HasPrefix(condText, "metadata_has_key") {
-				ok = d.hasMetadataKey(condition)
-			} else if strings.HasPrefix(condText, "date") {
-				ok, err = d.extractDates(condition, time.Now(), logger)
-
-				if ok {
-					y, m, d := d.date.Date()
-					logger.Infof("found date %d-%d-%d", y, m, d)
-					logConditionOut("found date %d-%d-%d", y, m, d)
-				}
-
-			} else if strings.HasPrefix(condText, "metadata_count") {
-				ok, err = d.hasMetadataCount(condition)
-			} else if condition.ConditionType == models.RuleConditionMetadataHasKey {
-				ok = d.hasMetadataKey(condition)
-			} else if condition.ConditionType == models.RuleConditionMetadataHasKeyValue {
-				ok = d.hasMetadataKeyValue(condition)
-			} else {
-				err := errors.ErrInternalError
-				err.ErrMsg = "unknown condition type: " + condText
-				result.Error = err.Error()
-				break
-			}
-			if err != nil {
-				e := errors.ErrInternalError
-				e.ErrMsg = fmt.Errorf("evaluate condition: %v", err).Error()
-				result.Error = e.Error()
-				break
-			}
-
-			if condition.Inverted {
-				logConditionOut("invert condition matched: %t -> %t", ok, !ok)
-				ok = !ok
-			}
-
-			if ok {
-				hasMatch = true
-				logger.Infof("condition %d (id %d) matched", i+1, condition.Id)
-				logConditionOut("condition matched")
-				result.Conditions[i].Matched = true
-				if d.Rule.Mode == models.RuleMatchAny {
-					// already found a match, skip rest of the conditions
-					logger.Infof("document matches and mode is set to 'match any', skip rest conditions")
-					logConditionOut("rule mode is set to 'match any', skip rest conditions")
-					doBreak = true
-				}
-
-			} else if d.Rule.Mode == models.RuleMatchAll {
-				logger.Infof("condition %d didn't match, skip rest", condition.Id)
-				logConditionOut("condition didn't match")
-				logConditionOut("rule mode is set to 'match all', stopping execution")
-				hasMatch = false
-				doBreak = true
-			} else {
-				logger.Infof("condition %d didn't match, continuing", condition.Id)
-				logConditionOut("condition didn't match")
-			}
-		}
-
-		result.ConditionOutput = append(result.ConditionOutput, conditionResult)
-		conditionResult = []string{}
-		if doBreak {
-			break
-		}
+	hasMatch, _, err := d.matchGroup(tree, 1, trace)
+	if err != nil {
+		e := errors.ErrInternalError
+		e.ErrMsg = err.Error()
+		result.Error = e.Error()
+		hasMatch = false
 	}
 
 	if hasMatch {
@@ -12,10 +12,12 @@ import (
 	"image/color"
 	"image/png"
 	"os"
+	"path"
 	"strings"
 	"time"
 	"tryffel.net/go/virtualpaper/models"
 	"tryffel.net/go/virtualpaper/storage"
+	"tryffel.net/go/virtualpaper/storage/blob"
 )
 
 func (fp *fileProcessor) generateThumbnail(ctx context.Context) error {
@@ -36,24 +38,124 @@ func (fp *fileProcessor) generateThumbnail(ctx context.Context) error {
 	}
 	defer fp.completeProcessingStep(process, job)
 
-	output := storage.PreviewPath(fp.document.Id)
-	err = storage.CreatePreviewDir(fp.document.Id)
+	output := storage.TempFilePath(fp.document.Id) + ".png"
+	defer os.Remove(output)
+
+	name, cleanup, err := fp.processingFile(ctx)
 	if err != nil {
-		return fmt.Errorf("create thumbnail output dir: %v", err)
+		job.Status = models.JobFailure
+		job.Message += "; " + err.Error()
+		return err
+	}
+	defer cleanup()
+
+	mimetype := fp.document.Mimetype
+	if fp.document.IsEmail() {
+		// render headers and body of the message as plain text
+		name, err = fp.emailTextFile(name)
+		if err != nil {
+			job.Status = models.JobFailure
+			job.Message += "; " + err.Error()
+			return err
+		}
+		defer os.Remove(name)
+		mimetype = "text/plain"
 	}
 
-	name := fp.rawFile.Name()
-	err = generateThumbnail(ctx, name, output, 0, 500, fp.document.Mimetype)
+	err = generateThumbnail(ctx, name, output, 0, 500, mimetype)
 	if err != nil {
 		job.Status = models.JobFailure
 		job.Message += "; " + err.Error()
 		return fmt.Errorf("call imagick: %v", err)
 	}
 
+	err = blob.PutFile(ctx, fp.db.Files, storage.PreviewKey(fp.document.Id), output)
+	if err != nil {
+		job.Status = models.JobFailure
+		job.Message += "; " + err.Error()
+		return fmt.Errorf("store thumbnail: %v", err)
+	}
+
+	pageCount, err := fp.generatePagePreviews(ctx, name, mimetype)
+	if err != nil {
+		job.Status = models.JobFailure
+		job.Message += "; " + err.Error()
+		return fmt.Errorf("generate page previews: %v", err)
+	}
+
+	err = fp.db.DocumentStore.SetPageCount(fp.db, fp.document.Id, pageCount)
+	if err != nil {
+		job.Status = models.JobFailure
+		job.Message += "; " + err.Error()
+		return fmt.Errorf("save page count: %v", err)
+	}
+	fp.document.PageCount = pageCount
+
 	job.Status = models.JobFinished
 	return nil
 }
 
+// generatePagePreviews generates previews of each page in all preview sizes and returns the number of pages.
+// Previews of pages that no longer exist are removed.
+func (fp *fileProcessor) generatePagePreviews(ctx context.Context, rawFile string, mimetype string) (int, error) {
+	dir := storage.TempFilePath(fp.document.Id) + "-pages"
+	defer os.RemoveAll(dir)
+
+	uploaded := map[string]bool{}
+	pageCount := 0
+	for _, size := range models.PreviewSizes {
+		sizeDir := path.Join(dir, size.String())
+		err := os.MkdirAll(sizeDir, 0700)
+		if err != nil {
+			return 0, fmt.Errorf("create temp directory: %v", err)
+		}
+
+		files, err := generatePagePreviews(ctx, rawFile, sizeDir, size.Height(), mimetype)
+		if err != nil {
+			return 0, fmt.Errorf("render %s previews: %v", size, err)
+		}
+		pageCount = len(files)
+
+		for i, file := range files {
+			key := storage.PagePreviewKey(fp.document.Id, i+1, size.String())
+			err = blob.PutFile(ctx, fp.db.Files, key, file)
+			if err != nil {
+				return 0, fmt.Errorf("store preview of page %d: %v", i+1, err)
+			}
+			uploaded[key] = true
+		}
+	}
+
+	existing, err := fp.db.Files.List(ctx, storage.PagePreviewPrefix(fp.document.Id))
+	if err != nil {
+		return 0, fmt.Errorf("list page previews: %v", err)
+	}
+	for _, v := range existing {
+		if uploaded[v.Key] {
+			continue
+		}
+		err = fp.db.Files.Delete(ctx, v.Key)
+		if err != nil {
+			return 0, fmt.Errorf("remove old page preview: %v", err)
+		}
+	}
+	return pageCount, nil
+}
+
+// emailTextFile writes the email message in file as plain text to a temporary file and returns its name.
+func (fp *fileProcessor) emailTextFile(file string) (string, error) {
+	msg, err := ParseMailFile(fp.document.Mimetype, file)
+	if err != nil {
+		return "", fmt.Errorf("parse email: %v", err)
+	}
+	output := storage.TempFilePath(fp.document.Id) + "-email.txt"
+	err = os.WriteFile(output, []byte(msg.PlainText()), 0600)
+	if err != nil {
+		return "", fmt.Errorf("write email text: %v", err)
+	}
+	return output, nil
+}
+
 func generateThumbnailPlainText(rawFile string, previewFile string, size int) error {
 	logrus.Debugf("generate thumbnail for text file")
 
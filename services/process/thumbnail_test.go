@@ -0,0 +1,49 @@
+package process
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"reflect"
+	"testing"
+)
+
+func TestPagePreviewFiles(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{"page-10.png", "page-2.png", "page-0.png", "page-1.png", "other.png"} {
+		err := os.WriteFile(path.Join(dir, name), []byte{}, 0600)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	for i := 3; i < 10; i++ {
+		err := os.WriteFile(path.Join(dir, fmt.Sprintf("page-%d.png", i)), []byte{}, 0600)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	files, err := pagePreviewFiles(dir)
+	if err != nil {
+		t.Fatalf("pagePreviewFiles() error = %v", err)
+	}
+	if len(files) != 11 {
+		t.Fatalf("pagePreviewFiles() got %d files, want 11", len(files))
+	}
+	want := []string{path.Join(dir, "page-0.png"), path.Join(dir, "page-1.png"), path.Join(dir, "page-2.png")}
+	if !reflect.DeepEqual(files[:3], want) {
+		t.Errorf("pagePreviewFiles() = %v, want %v", files[:3], want)
+	}
+	if files[10] != path.Join(dir, "page-10.png") {
+		t.Errorf("pagePreviewFiles() last page = %v, want page-10.png", files[10])
+	}
+
+	err = os.Remove(path.Join(dir, "page-5.png"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = pagePreviewFiles(dir)
+	if err == nil {
+		t.Errorf("pagePreviewFiles() expected error for missing page")
+	}
+}
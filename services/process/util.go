@@ -1,19 +1,25 @@
 package process
 
 import (
+	"context"
 	"crypto/md5"
 	"encoding/hex"
 	"fmt"
 	"github.com/sirupsen/logrus"
 	"io"
 	"os"
-	"tryffel.net/go/virtualpaper/errors"
 	"tryffel.net/go/virtualpaper/models"
 	"tryffel.net/go/virtualpaper/storage"
+	"tryffel.net/go/virtualpaper/storage/blob"
 )
 
 // GetFileHash returns unique hash for file. It uses md5 for hashing.
 func GetFileHash(file *os.File) (string, error) {
+	return GetReaderHash(file)
+}
+
+// GetReaderHash returns unique hash for contents of the reader, see GetFileHash.
+func GetReaderHash(file io.Reader) (string, error) {
 	hash := md5.New()
 
 	_, err := io.Copy(hash, file)
@@ -37,29 +43,52 @@ func GetHash(fileName string) (string, error) {
 	return hash, err
 }
 
-// DeleteDocument deletes original document and its preview file.
-func DeleteDocument(docId string) error {
-	previewPath := storage.PreviewPath(docId)
-	docPath := storage.DocumentPath(docId)
+// DeleteDocument deletes original document, its previous versions and its preview files.
+func DeleteDocument(ctx context.Context, files blob.Store, docId string) error {
+	previewKey := storage.PreviewKey(docId)
+	docKey := storage.DocumentKey(docId)
+	if docKey == "" {
+		return fmt.Errorf("invalid document id: '%s'", docId)
+	}
 
-	logrus.Debugf("delete preview file %s", previewPath)
-	err := os.Remove(previewPath)
+	logrus.Debugf("delete preview file %s", previewKey)
+	err := files.Delete(ctx, previewKey)
 	if err != nil {
-		if errors.Is(err, os.ErrNotExist) {
-			err = nil
-			logrus.Warnf("document %s preview file not found, continuing delete operation...", docId)
-		} else {
-			return fmt.Errorf("remove thumbnail: %v", err)
+		return fmt.Errorf("remove thumbnail: %v", err)
+	}
+	pages, err := files.List(ctx, storage.PagePreviewPrefix(docId))
+	if err != nil {
+		return fmt.Errorf("find page previews: %v", err)
+	}
+	for _, v := range pages {
+		err = files.Delete(ctx, v.Key)
+		if err != nil {
+			return fmt.Errorf("remove page preview: %v", err)
 		}
 	}
-	logrus.Debugf("delete document file %s", previewPath)
-	err = os.Remove(docPath)
+	err = files.Delete(ctx, storage.SearchablePdfKey(docId))
+	if err != nil {
+		return fmt.Errorf("remove searchable pdf: %v", err)
+	}
+	err = files.Delete(ctx, storage.PreprocessedKey(docId))
+	if err != nil {
+		return fmt.Errorf("remove preprocessed file: %v", err)
+	}
+	logrus.Debugf("delete document file %s", docKey)
+	err = files.Delete(ctx, docKey)
 	if err != nil {
-		if errors.Is(err, os.ErrNotExist) {
-			err = nil
-			logrus.Warnf("document %s file not found, continuing delete operation...", docId)
-		} else {
-			return fmt.Errorf("remove document file: %v", err)
+		return fmt.Errorf("remove document file: %v", err)
+	}
+
+	versions, err := files.List(ctx, docKey+".v")
+	if err != nil {
+		return fmt.Errorf("find document versions: %v", err)
+	}
+	for _, v := range versions {
+		logrus.Debugf("delete document version file %s", v.Key)
+		err = files.Delete(ctx, v.Key)
+		if err != nil {
+			return fmt.Errorf("remove document version file: %v", err)
 		}
 	}
 	return nil
@@ -68,9 +97,17 @@ func DeleteDocument(docId string) error {
 // RequiredProcessingSteps returns list of steps that are required to be execute after a given step.
 func RequiredProcessingSteps(startingStep models.ProcessStep) []models.ProcessStep {
 	switch startingStep {
-	case models.ProcessHash, models.ProcessThumbnail, models.ProcessFts:
+	case models.ProcessHash, models.ProcessThumbnail, models.ProcessSearchablePdf, models.ProcessFts:
 		return []models.ProcessStep{}
-	case models.ProcessParseContent, models.ProcessRules, models.ProcessDetectLanguage:
+	case models.ProcessBarcodes, models.ProcessExtractFields:
+		// barcodes and fields are stored as properties, which rules can match
+		return []models.ProcessStep{models.ProcessRules, models.ProcessFts}
+	case models.ProcessPreprocess:
+		// previews and content are generated from the preprocessed file
+		return []models.ProcessStep{models.ProcessThumbnail, models.ProcessParseContent, models.ProcessSearchablePdf, models.ProcessFts}
+	case models.ProcessParseContent:
+		return []models.ProcessStep{models.ProcessSearchablePdf, models.ProcessExtractFields, models.ProcessFts}
+	case models.ProcessRules, models.ProcessDetectLanguage:
 		return []models.ProcessStep{models.ProcessFts}
 	}
 	return []models.ProcessStep{}
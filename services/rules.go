@@ -99,8 +99,18 @@ func (service *RuleService) TestRule(ctx context.Context, userId int, ruleId int
 		return nil, err
 	}
 	doc.Metadata = *metadata
+	properties, err := service.db.PropertyStore.GetDocumentProperties(service.db, docId)
+	if err != nil {
+		return nil, err
+	}
+	doc.Properties = *properties
+	shares, err := service.db.DocumentStore.GetSharedUsers(service.db, docId)
+	if err != nil {
+		return nil, err
+	}
+	doc.Shares = len(*shares)
 	logger.Context(ctx).WithField("user", userId).WithField("documentId", doc.Id).WithField("rule", ruleId).Info("Test rule")
-	processRule := process.NewDocumentRule(doc, rule)
+	processRule := process.NewDocumentRule(doc, rule, service.search)
 	status := processRule.MatchTest()
 
 	logger.Context(ctx).WithField("documentId", doc.Id).WithField("rule", ruleId).Infof("Test rule finished: %v", status.Match)
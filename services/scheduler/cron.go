@@ -1,13 +1,16 @@
 package scheduler
 
 import (
+	"context"
 	"fmt"
 	"github.com/robfig/cron/v3"
 	"github.com/sirupsen/logrus"
 	"time"
 	"tryffel.net/go/virtualpaper/config"
+	"tryffel.net/go/virtualpaper/services/importer"
 	"tryffel.net/go/virtualpaper/services/process"
 	"tryffel.net/go/virtualpaper/storage"
+	"tryffel.net/go/virtualpaper/util/logger"
 )
 
 type CronJobs struct {
@@ -17,6 +20,9 @@ type CronJobs struct {
 	removeExpiredPasswordPresets cron.EntryID
 	removeExpiredAuthTokens      cron.EntryID
 	cleanupDocumenTrashbins      cron.EntryID
+	importMail                   cron.EntryID
+
+	mailImporter *importer.MailImporter
 }
 
 func NewCron(db *storage.Database) (*CronJobs, error) {
@@ -40,6 +46,24 @@ func NewCron(db *storage.Database) (*CronJobs, error) {
 	return cj, nil
 }
 
+// AddMailImport schedules polling mail accounts for new documents.
+func (c *CronJobs) AddMailImport(mailImporter *importer.MailImporter, interval time.Duration) error {
+	if !mailImporter.HasAccounts() {
+		return nil
+	}
+	if config.C.MailImport.Disabled {
+		logrus.Warningf("mail import disabled")
+		return nil
+	}
+	c.mailImporter = mailImporter
+	var err error
+	c.importMail, err = c.c.AddFunc(fmt.Sprintf("@every %s", interval.String()), c.JobImportMail)
+	if err != nil {
+		return fmt.Errorf("create importMail job: %v", err)
+	}
+	return nil
+}
+
 func (c *CronJobs) Start() {
 	if config.C.CronJobs.Disabled {
 		logrus.Warningf("cronjobs disabled, refuse to start jobs")
@@ -140,8 +164,15 @@ func (c *CronJobs) JobCleanupDocumenTrashbins() {
 	logCronOp(action, deletedCount == len(documentsToDelete))
 }
 
+func (c *CronJobs) JobImportMail() {
+	defer c.recover()
+	action := "import documents from mail"
+	count := c.mailImporter.Poll(logger.ContextWithTaskId(context.Background(), ""))
+	logCronOp(action, true).Debugf("imported %d documents", count)
+}
+
 func (c *CronJobs) deleteDocument(docId string) error {
-	err := process.DeleteDocument(docId)
+	err := process.DeleteDocument(context.Background(), c.db.Files, docId)
 	if err != nil {
 		return fmt.Errorf("delete document %s: %v", docId, err)
 	}
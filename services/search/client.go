@@ -102,6 +102,19 @@ func (e *Engine) IndexDocuments(docs *[]models.Document, userId int) error {
 			value := normalizeMetadataValue(v.Value)
 			metadata[metadataI] = key + ":" + value
 		}
+		pages, err := e.db.DocumentStore.GetDocumentPages(e.db, v.Id)
+		if err != nil {
+			return fmt.Errorf("get pages for document: %v", err)
+		}
+		pageContents := make([]string, 0, len(pages))
+		for _, page := range pages {
+			// pages without text are not stored, keep the array index equal to page number - 1
+			for len(pageContents) < page.Page-1 {
+				pageContents = append(pageContents, "")
+			}
+			pageContents = append(pageContents, page.Content)
+		}
+
 		properties := make([]string, len(v.Properties))
 		for propertyI, v := range v.Properties {
 			key := normalizeMetadataKey(v.PropertyName)
@@ -115,6 +128,7 @@ func (e *Engine) IndexDocuments(docs *[]models.Document, userId int) error {
 			"name":        v.Name,
 			"file_name":   v.Filename,
 			"content":     v.Content,
+			"pages":       pageContents,
 			"hash":        v.Hash,
 			"created_at":  v.CreatedAt.Unix(),
 			"updated_at":  v.UpdatedAt.Unix(),
@@ -308,13 +322,36 @@ func (e *Engine) AddIndex() error {
 		if err != nil {
 			logrus.Errorf("meilisearch set sortable attributes: %v", err)
 		}
-		_, err = e.client.Index(index).UpdateSearchableAttributes(fields)
+		searchable := append(*fields, "pages")
+		_, err = e.client.Index(index).UpdateSearchableAttributes(&searchable)
 		if err != nil {
 			logrus.Errorf("meilisearch set searchable attributes: %v", err)
 		}
+	} else {
+		err = e.ensurePagesSearchable(index)
 	}
 	if err != nil {
 		return fmt.Errorf("create index: %v", err)
 	}
 	return nil
 }
+
+// ensurePagesSearchable adds page contents to searchable attributes of index created before documents had pages.
+func (e *Engine) ensurePagesSearchable(index string) error {
+	searchable, err := e.client.Index(index).GetSearchableAttributes()
+	if err != nil {
+		return fmt.Errorf("get searchable attributes: %v", err)
+	}
+	for _, v := range *searchable {
+		if v == "pages" || v == "*" {
+			return nil
+		}
+	}
+	logrus.Infof("add document pages to meilisearch searchable attributes")
+	*searchable = append(*searchable, "pages")
+	_, err = e.client.Index(index).UpdateSearchableAttributes(searchable)
+	if err != nil {
+		return fmt.Errorf("set searchable attributes: %v", err)
+	}
+	return nil
+}
@@ -109,6 +109,7 @@ func (e *Engine) SearchDocuments(userId int, query string, sort storage.SortKey,
 				if content != "" {
 					doc.Content = content
 				}
+				doc.PageMatches = getPageMatches(formattedMap)
 			}
 			docs[i] = doc
 
@@ -120,6 +121,29 @@ func (e *Engine) SearchDocuments(userId int, query string, sort storage.SortKey,
 	return docs, nHits, nil
 }
 
+// pageSnippetLength is the number of words in snippets of matching pages.
+const pageSnippetLength = 30
+
+// highlightPreTag is the default tag that meilisearch uses to highlight matches.
+const highlightPreTag = "<em>"
+
+// getPageMatches returns pages that have highlighted matches in formatted search result.
+func getPageMatches(formatted map[string]interface{}) []models.PageMatch {
+	pages, ok := formatted["pages"].([]interface{})
+	if !ok {
+		return nil
+	}
+	matches := make([]models.PageMatch, 0)
+	for i, v := range pages {
+		snippet, ok := v.(string)
+		if !ok || !strings.Contains(snippet, highlightPreTag) {
+			continue
+		}
+		matches = append(matches, models.PageMatch{Page: i + 1, Snippet: snippet})
+	}
+	return matches
+}
+
 func getString(key string, container map[string]interface{}) string {
 	val, ok := container[key].(string)
 	if !ok {
@@ -357,3 +357,37 @@ func Test_parseFilter(t *testing.T) {
 func timeFromDate(year, month, day int) time.Time {
 	return time.Date(year, time.Month(month), day, 0, 0, 0, 0, time.UTC)
 }
+
+func Test_getPageMatches(t *testing.T) {
+	tests := []struct {
+		name      string
+		formatted map[string]interface{}
+		want      []models.PageMatch
+	}{
+		{
+			name:      "no pages",
+			formatted: map[string]interface{}{"name": "doc"},
+			want:      nil,
+		},
+		{
+			name: "matches",
+			formatted: map[string]interface{}{"pages": []interface{}{
+				"first page",
+				"second <em>invoice</em> page",
+				"",
+				"…total of <em>invoice</em>",
+			}},
+			want: []models.PageMatch{
+				{Page: 2, Snippet: "second <em>invoice</em> page"},
+				{Page: 4, Snippet: "…total of <em>invoice</em>"},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := getPageMatches(tt.formatted); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("getPageMatches() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -169,10 +169,10 @@ func (s *searchQuery) prepareMeiliQuery(userId int, sort storage.SortKey, paging
 	request := &meilisearch.SearchRequest{
 		Offset:                int64(paging.Offset),
 		Limit:                 int64(paging.Limit),
-		AttributesToRetrieve:  []string{"document_id", "name", "content", "description", "date", "mimetype", "lang", "shares", "owner_id", "favorite"},
-		AttributesToCrop:      []string{"content"},
+		AttributesToRetrieve:  []string{"document_id", "name", "content", "pages", "description", "date", "mimetype", "lang", "shares", "owner_id", "favorite"},
+		AttributesToCrop:      []string{"content", fmt.Sprintf("pages:%d", pageSnippetLength)},
 		CropLength:            1000,
-		AttributesToHighlight: []string{"name"},
+		AttributesToHighlight: []string{"name", "pages"},
 		PlaceholderSearch:     false,
 	}
 	filter := strings.TrimSuffix(s.MetadataString, "AND")
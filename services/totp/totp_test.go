@@ -0,0 +1,77 @@
+/*
+ * Virtualpaper is a service to manage users paper documents in virtual format.
+ * Copyright (C) 2024  Tero Vierimaa
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package totp
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// base32 of the RFC 6238 test secret "12345678901234567890"
+const testSecret = "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+
+func TestCode(t *testing.T) {
+	// RFC 6238 appendix B, last 6 digits of SHA1 codes
+	tests := []struct {
+		time int64
+		want string
+	}{
+		{59, "287082"},
+		{1111111109, "081804"},
+		{1111111111, "050471"},
+		{1234567890, "005924"},
+		{2000000000, "279037"},
+	}
+	for _, tt := range tests {
+		got, err := Code(testSecret, Step(time.Unix(tt.time, 0)))
+		assert.NoError(t, err)
+		assert.Equal(t, tt.want, got, "time %d", tt.time)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	now := time.Unix(1111111111, 0)
+	step, ok := Validate(testSecret, "050471", now)
+	assert.True(t, ok)
+	assert.Equal(t, Step(now), step)
+
+	// previous step is accepted
+	step, ok = Validate(testSecret, "050471", now.Add(time.Second*Period))
+	assert.True(t, ok)
+	assert.Equal(t, Step(now), step)
+
+	_, ok = Validate(testSecret, "050471", now.Add(time.Minute*5))
+	assert.False(t, ok)
+	_, ok = Validate(testSecret, "12345", now)
+	assert.False(t, ok)
+
+	secret, err := NewSecret()
+	assert.NoError(t, err)
+	code, err := Code(secret, Step(now))
+	assert.NoError(t, err)
+	_, ok = Validate(secret, code, now)
+	assert.True(t, ok)
+}
+
+func TestUrl(t *testing.T) {
+	assert.Equal(t, "otpauth://totp/Virtualpaper:user%20name?algorithm=SHA1&digits=6&issuer=Virtualpaper&period=30&secret="+testSecret,
+		Url("Virtualpaper", "user name", testSecret))
+}
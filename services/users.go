@@ -34,6 +34,10 @@ func (service *UserService) GetPreferences(ctx context.Context, userId int) (*mo
 	preferences.CreatedAt = user.CreatedAt
 	preferences.UpdatedAt = user.UpdatedAt
 	preferences.Email = user.Email
+	preferences.OcrLanguages, err = service.db.UserStore.GetOcrLanguages(userId)
+	if err != nil {
+		return nil, err
+	}
 	return preferences, nil
 }
 
@@ -48,6 +52,17 @@ func (service *UserService) UpdatePreferences(ctx context.Context, preferences *
 		attributeChanged = true
 	}
 
+	if preferences.OcrLanguages != nil {
+		err = preferences.OcrLanguages.Validate()
+		if err != nil {
+			return err
+		}
+		err = service.db.UserStore.SetPreferenceValue(user.Id, storage.PreferenceOcrLanguages, preferences.OcrLanguages.String())
+		if err != nil {
+			return err
+		}
+	}
+
 	if attributeChanged {
 		user.Update()
 		err = service.db.UserStore.Update(user)
@@ -55,7 +70,7 @@ func (service *UserService) UpdatePreferences(ctx context.Context, preferences *
 			return err
 		}
 	}
-	if !attributeChanged {
+	if !attributeChanged && preferences.OcrLanguages == nil {
 		return errors.ErrAlreadyExists
 	}
 	return nil
@@ -0,0 +1,339 @@
+/*
+ * Virtualpaper is a service to manage users paper documents in virtual format.
+ * Copyright (C) 2024  Tero Vierimaa
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+// Package webhook sends document events to webhooks that users have subscribed.
+// Events are persisted as deliveries, which are sent in background and retried with backoff on failure.
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"tryffel.net/go/virtualpaper/config"
+	"tryffel.net/go/virtualpaper/errors"
+	"tryffel.net/go/virtualpaper/models"
+	"tryffel.net/go/virtualpaper/models/aggregates"
+	"tryffel.net/go/virtualpaper/storage"
+	"tryffel.net/go/virtualpaper/util/logger"
+)
+
+const (
+	// SignatureHeader contains hex-encoded HMAC-SHA256 of the request body, signed with webhook secret,
+	// in format 'sha256=<signature>'.
+	SignatureHeader = "X-Virtualpaper-Signature"
+	EventHeader     = "X-Virtualpaper-Event"
+	DeliveryHeader  = "X-Virtualpaper-Delivery"
+
+	// MaxAttempts is the number of times a delivery is tried before it is marked as failed.
+	MaxAttempts = 6
+
+	retryBaseDelay    = time.Second * 30
+	retryMaxDelay     = time.Hour * 6
+	requestTimeout    = time.Second * 10
+	pollInterval      = time.Second * 30
+	cleanupInterval   = time.Hour
+	deliveryRetention = time.Hour * 24 * 30
+	batchSize         = 20
+	maxErrorLength    = 500
+)
+
+// Payload is the JSON body sent to webhooks.
+type Payload struct {
+	Event string `json:"event"`
+	// Timestamp is the time of the event in unix epoch milliseconds.
+	Timestamp int64                `json:"timestamp"`
+	Document  *aggregates.Document `json:"document"`
+}
+
+// Dispatcher creates deliveries for events and sends them to webhooks.
+type Dispatcher struct {
+	db     *storage.Database
+	client *http.Client
+
+	lock *sync.Mutex
+	wake chan bool
+	stop chan bool
+	done chan bool
+}
+
+func NewDispatcher(db *storage.Database) *Dispatcher {
+	return &Dispatcher{
+		db:     db,
+		client: &http.Client{Timeout: requestTimeout},
+		lock:   &sync.Mutex{},
+		wake:   make(chan bool, 1),
+	}
+}
+
+// Emit creates deliveries for all enabled webhooks of the document owner that subscribe to event.
+// Errors are only logged so that emitting never fails the operation that caused the event.
+// Emit on nil dispatcher does nothing.
+func (d *Dispatcher) Emit(ctx context.Context, event string, docId string) {
+	if d == nil {
+		return
+	}
+	err := d.emit(ctx, event, docId)
+	if err != nil {
+		logger.Context(ctx).WithField("documentId", docId).Errorf("emit webhook event %s: %v", event, err)
+	}
+}
+
+func (d *Dispatcher) emit(ctx context.Context, event string, docId string) error {
+	doc, err := d.db.DocumentStore.GetDocument(d.db, docId)
+	if err != nil {
+		return fmt.Errorf("get document: %v", err)
+	}
+	webhooks, err := d.db.WebhookStore.GetEnabledWebhooks(d.db, doc.UserId)
+	if err != nil {
+		return err
+	}
+	subscribed := make([]models.Webhook, 0, len(*webhooks))
+	for _, v := range *webhooks {
+		if v.Subscribes(event) {
+			subscribed = append(subscribed, v)
+		}
+	}
+	if len(subscribed) == 0 {
+		return nil
+	}
+
+	aggregate, err := d.documentAggregate(doc)
+	if err != nil {
+		return err
+	}
+	payload, err := json.Marshal(&Payload{
+		Event:     event,
+		Timestamp: time.Now().UnixMilli(),
+		Document:  aggregate,
+	})
+	if err != nil {
+		return fmt.Errorf("marshal payload: %v", err)
+	}
+
+	for _, v := range subscribed {
+		err = d.db.WebhookStore.AddDelivery(d.db, &models.WebhookDelivery{
+			WebhookId:  v.Id,
+			Event:      event,
+			DocumentId: docId,
+			Payload:    string(payload),
+		})
+		if err != nil {
+			return fmt.Errorf("add delivery for webhook %d: %v", v.Id, err)
+		}
+	}
+	logger.Context(ctx).WithField("documentId", docId).Debugf("emit webhook event %s to %d webhooks", event, len(subscribed))
+
+	select {
+	case d.wake <- true:
+	default:
+	}
+	return nil
+}
+
+func (d *Dispatcher) documentAggregate(doc *models.Document) (*aggregates.Document, error) {
+	status, err := d.db.JobStore.GetDocumentStatus(doc.Id)
+	if err != nil {
+		return nil, err
+	}
+	properties, err := d.db.PropertyStore.GetDocumentProperties(d.db, doc.Id)
+	if err != nil {
+		return nil, err
+	}
+	doc.Properties = *properties
+	metadata, err := d.db.MetadataStore.GetDocumentMetadata(d.db, doc.UserId, doc.Id)
+	if err != nil {
+		return nil, err
+	}
+	doc.Metadata = *metadata
+	sharedUsers, err := d.db.DocumentStore.GetSharedUsers(d.db, doc.Id)
+	if err != nil {
+		return nil, err
+	}
+	aggregate := aggregates.DocumentToAggregate(doc, sharedUsers)
+	aggregate.Status = status
+	return aggregate, nil
+}
+
+// Start starts sending deliveries in background.
+func (d *Dispatcher) Start() {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	if d.stop != nil {
+		return
+	}
+	d.stop = make(chan bool)
+	d.done = make(chan bool)
+	go d.run(d.stop, d.done)
+}
+
+// Stop stops sending deliveries and waits for the current batch to finish.
+func (d *Dispatcher) Stop() {
+	d.lock.Lock()
+	stop, done := d.stop, d.done
+	d.stop = nil
+	d.done = nil
+	d.lock.Unlock()
+
+	if stop == nil {
+		return
+	}
+	logrus.Info("stop webhook dispatcher")
+	close(stop)
+	<-done
+}
+
+func (d *Dispatcher) run(stop, done chan bool) {
+	defer close(done)
+	ticker := time.NewTicker(pollInterval)
+	defer ticker.Stop()
+	lastCleanup := time.Time{}
+
+	for {
+		ctx := logger.ContextWithTaskId(context.Background(), "")
+		if time.Since(lastCleanup) > cleanupInterval {
+			d.cleanup(ctx)
+			lastCleanup = time.Now()
+		}
+		// continue immediately if there are more deliveries waiting, but do not block stopping for too long
+		for i := 0; i < 10 && d.DeliverDue(ctx) == batchSize; i++ {
+		}
+
+		select {
+		case <-stop:
+			return
+		case <-d.wake:
+		case <-ticker.C:
+		}
+	}
+}
+
+func (d *Dispatcher) cleanup(ctx context.Context) {
+	n, err := d.db.WebhookStore.DeleteOldDeliveries(d.db, time.Now().Add(-deliveryRetention))
+	if err != nil {
+		logger.Context(ctx).Errorf("delete old webhook deliveries: %v", err)
+	} else if n > 0 {
+		logger.Context(ctx).Infof("deleted %d old webhook deliveries", n)
+	}
+}
+
+// DeliverDue sends pending deliveries that are due, and returns the number of deliveries that were tried.
+func (d *Dispatcher) DeliverDue(ctx context.Context) int {
+	deliveries, err := d.db.WebhookStore.GetDueDeliveries(d.db, batchSize)
+	if err != nil {
+		logger.Context(ctx).Errorf("get pending webhook deliveries: %v", err)
+		return 0
+	}
+	for i := range *deliveries {
+		d.deliver(ctx, &(*deliveries)[i])
+	}
+	return len(*deliveries)
+}
+
+func (d *Dispatcher) deliver(ctx context.Context, delivery *models.WebhookDelivery) {
+	webhook, err := d.db.WebhookStore.GetWebhook(d.db, delivery.WebhookId)
+	if err != nil {
+		if !errors.Is(err, errors.ErrRecordNotFound) {
+			logger.Context(ctx).Errorf("get webhook %d: %v", delivery.WebhookId, err)
+			return
+		}
+		webhook = nil
+	}
+
+	delivery.Attempts += 1
+	if webhook == nil || !webhook.Enabled {
+		delivery.Status = models.WebhookDeliveryFailed
+		delivery.Error = "webhook disabled"
+	} else {
+		delivery.ResponseCode, err = Send(ctx, d.client, webhook, delivery)
+		if err == nil {
+			delivery.Status = models.WebhookDeliverySuccess
+			delivery.Error = ""
+		} else {
+			delivery.Error = err.Error()
+			if len(delivery.Error) > maxErrorLength {
+				delivery.Error = delivery.Error[:maxErrorLength]
+			}
+			if delivery.Attempts >= MaxAttempts {
+				delivery.Status = models.WebhookDeliveryFailed
+			} else {
+				delivery.NextAttemptAt = time.Now().Add(RetryDelay(delivery.Attempts))
+			}
+			logger.Context(ctx).WithField("webhook", delivery.WebhookId).
+				Warnf("webhook delivery %d failed (attempt %d): %v", delivery.Id, delivery.Attempts, err)
+		}
+	}
+
+	err = d.db.WebhookStore.UpdateDelivery(d.db, delivery)
+	if err != nil {
+		logger.Context(ctx).Errorf("update webhook delivery %d: %v", delivery.Id, err)
+	}
+}
+
+// Send posts the delivery payload to webhook. Any response other than 2xx is an error.
+func Send(ctx context.Context, client *http.Client, webhook *models.Webhook, delivery *models.WebhookDelivery) (int, error) {
+	body := []byte(delivery.Payload)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, webhook.Url, bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "Virtualpaper/"+config.Version)
+	req.Header.Set(EventHeader, delivery.Event)
+	req.Header.Set(DeliveryHeader, fmt.Sprintf("%d", delivery.Id))
+	req.Header.Set(SignatureHeader, Sign(webhook.Secret, body))
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 1024*64))
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return resp.StatusCode, fmt.Errorf("unexpected response status: %s", resp.Status)
+	}
+	return resp.StatusCode, nil
+}
+
+// Sign returns signature for body in format 'sha256=<hex-encoded HMAC-SHA256>'.
+func Sign(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// RetryDelay returns time to wait after given number of failed attempts.
+func RetryDelay(attempts int) time.Duration {
+	delay := retryBaseDelay
+	for i := 1; i < attempts; i++ {
+		delay *= 2
+		if delay >= retryMaxDelay {
+			return retryMaxDelay
+		}
+	}
+	return delay
+}
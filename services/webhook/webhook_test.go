@@ -0,0 +1,116 @@
+/*
+ * Virtualpaper is a service to manage users paper documents in virtual format.
+ * Copyright (C) 2024  Tero Vierimaa
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package webhook
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"tryffel.net/go/virtualpaper/models"
+)
+
+func TestSend(t *testing.T) {
+	var gotBody []byte
+	var gotHeaders http.Header
+	status := http.StatusOK
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotBody, _ = io.ReadAll(r.Body)
+		gotHeaders = r.Header.Clone()
+		w.WriteHeader(status)
+	}))
+	defer server.Close()
+
+	webhook := &models.Webhook{Id: 1, Url: server.URL, Secret: "secret", Enabled: true}
+	delivery := &models.WebhookDelivery{Id: 10, WebhookId: 1, Event: models.WebhookEventDocumentCreated,
+		Payload: `{"event":"document.created"}`}
+
+	code, err := Send(context.Background(), server.Client(), webhook, delivery)
+	if err != nil {
+		t.Fatalf("Send(): %v", err)
+	}
+	if code != http.StatusOK {
+		t.Errorf("Send() code = %d, want 200", code)
+	}
+	if string(gotBody) != delivery.Payload {
+		t.Errorf("body = %s, want %s", gotBody, delivery.Payload)
+	}
+	if gotHeaders.Get(EventHeader) != models.WebhookEventDocumentCreated {
+		t.Errorf("event header = %s", gotHeaders.Get(EventHeader))
+	}
+	if gotHeaders.Get(DeliveryHeader) != "10" {
+		t.Errorf("delivery header = %s, want 10", gotHeaders.Get(DeliveryHeader))
+	}
+
+	// verify signature the way receivers would
+	mac := hmac.New(sha256.New, []byte("secret"))
+	mac.Write(gotBody)
+	want := "sha256=" + hex.EncodeToString(mac.Sum(nil))
+	if gotHeaders.Get(SignatureHeader) != want {
+		t.Errorf("signature = %s, want %s", gotHeaders.Get(SignatureHeader), want)
+	}
+
+	status = http.StatusInternalServerError
+	code, err = Send(context.Background(), server.Client(), webhook, delivery)
+	if err == nil {
+		t.Errorf("Send() expected error with status 500")
+	}
+	if code != http.StatusInternalServerError {
+		t.Errorf("Send() code = %d, want 500", code)
+	}
+}
+
+func TestRetryDelay(t *testing.T) {
+	tests := []struct {
+		attempts int
+		want     time.Duration
+	}{
+		{attempts: 1, want: time.Second * 30},
+		{attempts: 2, want: time.Minute},
+		{attempts: 3, want: time.Minute * 2},
+		{attempts: 5, want: time.Minute * 8},
+		{attempts: 20, want: time.Hour * 6},
+	}
+	for _, tt := range tests {
+		if got := RetryDelay(tt.attempts); got != tt.want {
+			t.Errorf("RetryDelay(%d) = %v, want %v", tt.attempts, got, tt.want)
+		}
+	}
+}
+
+func TestWebhook_Subscribes(t *testing.T) {
+	all := &models.Webhook{}
+	if !all.Subscribes(models.WebhookEventDocumentDeleted) {
+		t.Errorf("webhook without event filter should subscribe to all events")
+	}
+	filtered := &models.Webhook{}
+	filtered.SetEvents([]string{models.WebhookEventDocumentCreated, models.WebhookEventDocumentProcessed})
+	if !filtered.Subscribes(models.WebhookEventDocumentProcessed) {
+		t.Errorf("webhook should subscribe to document.processed")
+	}
+	if filtered.Subscribes(models.WebhookEventDocumentDeleted) {
+		t.Errorf("webhook should not subscribe to document.deleted")
+	}
+}
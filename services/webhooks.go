@@ -0,0 +1,90 @@
+package services
+
+import (
+	"context"
+	"net/url"
+
+	"tryffel.net/go/virtualpaper/config"
+	"tryffel.net/go/virtualpaper/errors"
+	"tryffel.net/go/virtualpaper/models"
+	"tryffel.net/go/virtualpaper/storage"
+)
+
+type WebhookService struct {
+	db *storage.Database
+}
+
+func NewWebhookService(db *storage.Database) *WebhookService {
+	return &WebhookService{
+		db: db,
+	}
+}
+
+func (s *WebhookService) GetWebhooks(ctx context.Context, userId int) (*[]models.Webhook, error) {
+	return s.db.WebhookStore.GetWebhooks(s.db, userId)
+}
+
+func (s *WebhookService) GetWebhook(ctx context.Context, id int) (*models.Webhook, error) {
+	return s.db.WebhookStore.GetWebhook(s.db, id)
+}
+
+func (s *WebhookService) UserOwnsWebhook(ctx context.Context, userId, id int) (bool, error) {
+	return s.db.WebhookStore.UserOwnsWebhook(s.db, userId, id)
+}
+
+// AddWebhook validates and stores new webhook. If webhook has no secret, a random secret is generated.
+func (s *WebhookService) AddWebhook(ctx context.Context, webhook *models.Webhook) error {
+	err := validateWebhook(webhook)
+	if err != nil {
+		return err
+	}
+	if webhook.Secret == "" {
+		webhook.Secret, err = config.RandomStringCrypt(32)
+		if err != nil {
+			return err
+		}
+	}
+	return s.db.WebhookStore.AddWebhook(s.db, webhook)
+}
+
+// UpdateWebhook updates webhook. Existing secret is kept if webhook has no secret.
+func (s *WebhookService) UpdateWebhook(ctx context.Context, webhook *models.Webhook) error {
+	err := validateWebhook(webhook)
+	if err != nil {
+		return err
+	}
+	existing, err := s.db.WebhookStore.GetWebhook(s.db, webhook.Id)
+	if err != nil {
+		return err
+	}
+	if webhook.Secret == "" {
+		webhook.Secret = existing.Secret
+	}
+	webhook.UserId = existing.UserId
+	webhook.CreatedAt = existing.CreatedAt
+	return s.db.WebhookStore.UpdateWebhook(s.db, webhook)
+}
+
+func (s *WebhookService) DeleteWebhook(ctx context.Context, id int) error {
+	return s.db.WebhookStore.DeleteWebhook(s.db, id)
+}
+
+func (s *WebhookService) GetDeliveries(ctx context.Context, webhookId int, paging storage.Paging) (*[]models.WebhookDelivery, int, error) {
+	return s.db.WebhookStore.GetDeliveries(s.db, webhookId, paging)
+}
+
+func validateWebhook(webhook *models.Webhook) error {
+	validationError := errors.ErrInvalid
+	parsed, err := url.Parse(webhook.Url)
+	if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+		validationError.ErrMsg = "url must be a valid http or https url"
+		return validationError
+	}
+	for _, v := range webhook.EventList() {
+		if !models.IsValidWebhookEvent(v) {
+			validationError.ErrMsg = "unknown event: " + v
+			return validationError
+		}
+	}
+	return nil
+}
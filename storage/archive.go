@@ -0,0 +1,90 @@
+/*
+ * Virtualpaper is a service to manage users paper documents in virtual format.
+ * Copyright (C) 2024  Tero Vierimaa
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package storage
+
+import (
+	"tryffel.net/go/virtualpaper/models"
+)
+
+// GetAllUserDocuments returns all documents owned by the user, including documents in trash bin.
+// Unlike GetDocuments, the result is not paginated.
+func (s *DocumentStore) GetAllUserDocuments(exec SqlExecer, userId int) (*[]models.Document, error) {
+	query := s.sq.Select("*").From("documents").Where("user_id = ?", userId).OrderBy("created_at ASC")
+	data := &[]models.Document{}
+	err := exec.SelectSq(data, query)
+	return data, s.parseError(err, "get all user documents")
+}
+
+// ReplaceDocumentHistory replaces document history with given items, keeping their timestamps and users.
+// Items with UserId 0 are stored without user.
+func (s *DocumentStore) ReplaceDocumentHistory(exec SqlExecer, docId string, items []models.DocumentHistory) error {
+	_, err := exec.ExecSq(s.sq.Delete("document_history").Where("document_id = ?", docId))
+	if err != nil {
+		return s.parseError(err, "delete document history")
+	}
+	if len(items) == 0 {
+		return nil
+	}
+	query := s.sq.Insert("document_history").Columns("document_id", "action", "old_value", "new_value", "user_id", "created_at")
+	for _, v := range items {
+		var user interface{}
+		if v.UserId != 0 {
+			user = v.UserId
+		}
+		query = query.Values(docId, v.Action, v.OldValue, v.NewValue, user, v.CreatedAt)
+	}
+	_, err = exec.ExecSq(query)
+	return s.parseError(err, "import document history")
+}
+
+// GetAllUserKeys returns all metadata keys for the user.
+func (s *MetadataStore) GetAllUserKeys(exec SqlExecer, userId int) (*[]models.MetadataKey, error) {
+	query := s.sq.Select("id", "user_id", "key", "created_at", "comment", "icon", "style").
+		From("metadata_keys").
+		Where("user_id = ?", userId).
+		OrderBy("id ASC")
+	data := &[]models.MetadataKey{}
+	err := exec.SelectSq(data, query)
+	return data, s.parseError(err, "get all user keys")
+}
+
+// GetAllUserValues returns all metadata values for the user.
+func (s *MetadataStore) GetAllUserValues(exec SqlExecer, userId int) (*[]models.MetadataValue, error) {
+	query := s.sq.Select("mv.id as id", "mv.user_id as user_id", "mv.key_id as key_id", "mk.key as key",
+		"mv.value as value", "mv.created_at as created_at", "mv.match_documents as match_documents",
+		"mv.match_type as match_type", "mv.match_filter as match_filter").
+		From("metadata_values mv").
+		LeftJoin("metadata_keys mk ON mv.key_id = mk.id").
+		Where("mv.user_id = ?", userId).
+		OrderBy("mv.id ASC")
+	data := &[]models.MetadataValue{}
+	err := exec.SelectSq(data, query)
+	return data, s.parseError(err, "get all user values")
+}
+
+// GetAllUserProperties returns all properties for the user.
+func (store *PropertyStore) GetAllUserProperties(execer SqlExecer, userId int) (*[]models.Property, error) {
+	query := store.sq.Select("*").From("properties").Where("user_id = ?", userId).OrderBy("id ASC")
+	data := &[]models.Property{}
+	err := execer.SelectSq(data, query)
+	if err != nil {
+		return nil, store.parseError(err, "get all user properties")
+	}
+	return data, nil
+}
@@ -0,0 +1,57 @@
+/*
+ * Virtualpaper is a service to manage users paper documents in virtual format.
+ * Copyright (C) 2024  Tero Vierimaa
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package storage
+
+import (
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"tryffel.net/go/virtualpaper/models"
+)
+
+func TestDocumentStore_ReplaceDocumentHistory(t *testing.T) {
+	db, mock, err := NewMockDatabase(sqlmock.QueryMatcherEqual)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	created := time.Now().Add(-time.Hour)
+	items := []models.DocumentHistory{
+		{DocumentId: "old-id", Action: models.DocumentHistoryActionCreate, NewValue: "doc", UserId: 5, CreatedAt: created},
+		{DocumentId: "old-id", Action: models.DocumentHistoryActionRename, OldValue: "doc", NewValue: "invoice", CreatedAt: created},
+	}
+
+	mock.ExpectExec("DELETE FROM document_history WHERE document_id = $1").
+		WithArgs("new-id").
+		WillReturnResult(sqlmock.NewResult(0, 2))
+	mock.ExpectExec("INSERT INTO document_history (document_id,action,old_value,new_value,user_id,created_at) "+
+		"VALUES ($1,$2,$3,$4,$5,$6),($7,$8,$9,$10,$11,$12)").
+		WithArgs("new-id", models.DocumentHistoryActionCreate, "", "doc", 5, created,
+			"new-id", models.DocumentHistoryActionRename, "doc", "invoice", nil, created).
+		WillReturnResult(sqlmock.NewResult(0, 2))
+
+	err = db.DocumentStore.ReplaceDocumentHistory(db, "new-id", items)
+	if err != nil {
+		t.Error(err)
+	}
+	if err = mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("invalid query: %v", err)
+	}
+}
@@ -160,3 +160,312 @@ func (s *AuthStore) DeleteExpiredAuthTokens() (int, error) {
 	}
 	return int(affected), nil
 }
+
+func (s *AuthStore) InsertApiToken(token *models.ApiToken) error {
+	token.CreatedAt = time.Now()
+	token.UpdatedAt = token.CreatedAt
+	builder := s.sq.Insert("api_tokens").
+		Columns("user_id", "name", "token_hash", "prefix", "scopes", "expires_at", "last_used_at", "last_used_ip", "created_at", "updated_at").
+		Values(token.UserId, token.Name, token.Hash, token.Prefix, token.Scopes, token.ExpiresAt, token.LastUsedAt, token.LastUsedIp, token.CreatedAt, token.UpdatedAt).
+		Suffix("RETURNING id")
+
+	sql, args, err := builder.ToSql()
+	if err != nil {
+		return fmt.Errorf("build sql: %v", err)
+	}
+
+	id := 0
+	err = s.db.Get(&id, sql, args...)
+	if err != nil {
+		return s.parseError(err, "insert api token")
+	}
+	token.Id = id
+	return nil
+}
+
+// GetApiTokenByHash returns api token by the hash of the token.
+func (s *AuthStore) GetApiTokenByHash(hash string) (*models.ApiToken, error) {
+	cacheKey := fmt.Sprintf("apitoken-%s", hash)
+	if cached, found := s.cache.Get(cacheKey); found {
+		if token, ok := cached.(*models.ApiToken); ok {
+			return token, nil
+		}
+		s.cache.Delete(cacheKey)
+	}
+
+	builder := s.sq.Select("*").From("api_tokens").Where("token_hash = ?", hash)
+	sql, args, err := builder.ToSql()
+	if err != nil {
+		return nil, fmt.Errorf("build sql: %v", err)
+	}
+
+	token := &models.ApiToken{}
+	err = s.db.Get(token, sql, args...)
+	if err != nil {
+		return nil, s.parseError(err, "get api token")
+	}
+	s.cache.Set(cacheKey, token, cache.DefaultExpiration)
+	return token, nil
+}
+
+// GetApiTokens returns user's api tokens, latest first.
+func (s *AuthStore) GetApiTokens(userId int) (*[]models.ApiToken, error) {
+	builder := s.sq.Select("*").From("api_tokens").Where("user_id = ?", userId).OrderBy("id DESC")
+	sql, args, err := builder.ToSql()
+	if err != nil {
+		return nil, fmt.Errorf("build sql: %v", err)
+	}
+
+	tokens := &[]models.ApiToken{}
+	err = s.db.Select(tokens, sql, args...)
+	if err != nil {
+		return nil, s.parseError(err, "get api tokens")
+	}
+	return tokens, nil
+}
+
+// UpdateApiTokenLastUsed sets the time and the ip address token was last used from.
+func (s *AuthStore) UpdateApiTokenLastUsed(token *models.ApiToken, lastUsed time.Time, ipAddr string) error {
+	builder := s.sq.Update("api_tokens").
+		Set("last_used_at", lastUsed).
+		Set("last_used_ip", ipAddr).
+		Where("id = ?", token.Id)
+	sql, args, err := builder.ToSql()
+	if err != nil {
+		return fmt.Errorf("build sql: %v", err)
+	}
+
+	_, err = s.db.Exec(sql, args...)
+	if err != nil {
+		return s.parseError(err, "update api token last used")
+	}
+	s.cache.Delete(fmt.Sprintf("apitoken-%s", token.Hash))
+	return nil
+}
+
+// DeleteApiToken deletes user's api token.
+func (s *AuthStore) DeleteApiToken(userId, id int) error {
+	builder := s.sq.Delete("api_tokens").Where("id = ? AND user_id = ?", id, userId).Suffix("RETURNING token_hash")
+	sql, args, err := builder.ToSql()
+	if err != nil {
+		return fmt.Errorf("build sql: %v", err)
+	}
+
+	hash := ""
+	err = s.db.Get(&hash, sql, args...)
+	if err != nil {
+		return s.parseError(err, "delete api token")
+	}
+	s.cache.Delete(fmt.Sprintf("apitoken-%s", hash))
+	return nil
+}
+
+// DeleteUserApiTokens deletes all api tokens of the user. It returns the number of tokens deleted.
+func (s *AuthStore) DeleteUserApiTokens(userId int) (int, error) {
+	builder := s.sq.Delete("api_tokens").Where("user_id = ?", userId).Suffix("RETURNING token_hash")
+	sql, args, err := builder.ToSql()
+	if err != nil {
+		return 0, fmt.Errorf("build sql: %v", err)
+	}
+
+	hashes := make([]string, 0)
+	err = s.db.Select(&hashes, sql, args...)
+	if err != nil {
+		return 0, s.parseError(err, "delete user api tokens")
+	}
+	for _, hash := range hashes {
+		s.cache.Delete(fmt.Sprintf("apitoken-%s", hash))
+	}
+	return len(hashes), nil
+}
+
+// GetUserTokens returns user's active login sessions, most recently seen first.
+func (s *AuthStore) GetUserTokens(userId int) (*[]models.Token, error) {
+	builder := s.sq.Select("id", "user_id", "key", "name", "ip_address", "created_at", "updated_at", "expires_at", "last_seen", "last_confirmed").
+		From("auth_tokens").
+		Where("user_id = ?", userId).
+		Where("(expires_at > now() OR EXTRACT(EPOCH from expires_at) <= 1)").
+		OrderBy("last_seen DESC", "id DESC")
+	sql, args, err := builder.ToSql()
+	if err != nil {
+		return nil, fmt.Errorf("build sql: %v", err)
+	}
+
+	tokens := &[]models.Token{}
+	err = s.db.Select(tokens, sql, args...)
+	if err != nil {
+		return nil, s.parseError(err, "get user tokens")
+	}
+	return tokens, nil
+}
+
+// RevokeUserToken deletes user's login session by its id.
+func (s *AuthStore) RevokeUserToken(userId, id int) error {
+	builder := s.sq.Delete("auth_tokens").Where("id = ? AND user_id = ?", id, userId).Suffix("RETURNING key")
+	sql, args, err := builder.ToSql()
+	if err != nil {
+		return fmt.Errorf("build sql: %v", err)
+	}
+
+	key := ""
+	err = s.db.Get(&key, sql, args...)
+	if err != nil {
+		return s.parseError(err, "revoke user token")
+	}
+	s.deleteTokenFromCache(key)
+	return nil
+}
+
+// RevokeUserTokens deletes all login sessions of the user, except the session with exceptKey, if set.
+// It returns the number of sessions revoked.
+func (s *AuthStore) RevokeUserTokens(userId int, exceptKey string) (int, error) {
+	builder := s.sq.Delete("auth_tokens").Where("user_id = ?", userId).Suffix("RETURNING key")
+	if exceptKey != "" {
+		builder = builder.Where("key != ?", exceptKey)
+	}
+	sql, args, err := builder.ToSql()
+	if err != nil {
+		return 0, fmt.Errorf("build sql: %v", err)
+	}
+
+	keys := make([]string, 0)
+	err = s.db.Select(&keys, sql, args...)
+	if err != nil {
+		return 0, s.parseError(err, "revoke user tokens")
+	}
+	for _, key := range keys {
+		s.deleteTokenFromCache(key)
+	}
+	return len(keys), nil
+}
+
+// GetUserTotp returns user's totp secret.
+func (s *AuthStore) GetUserTotp(userId int) (*models.UserTotp, error) {
+	builder := s.sq.Select("*").From("user_totp").Where("user_id = ?", userId)
+	sql, args, err := builder.ToSql()
+	if err != nil {
+		return nil, fmt.Errorf("build sql: %v", err)
+	}
+
+	totp := &models.UserTotp{}
+	err = s.db.Get(totp, sql, args...)
+	if err != nil {
+		return nil, s.parseError(err, "get user totp")
+	}
+	return totp, nil
+}
+
+// SaveUserTotp inserts or replaces user's totp secret.
+func (s *AuthStore) SaveUserTotp(totp *models.UserTotp) error {
+	totp.Update()
+	if totp.CreatedAt.IsZero() {
+		totp.CreatedAt = totp.UpdatedAt
+	}
+	builder := s.sq.Insert("user_totp").
+		Columns("user_id", "secret", "enabled", "last_used_step", "created_at", "updated_at").
+		Values(totp.UserId, totp.Secret, totp.Enabled, totp.LastUsedStep, totp.CreatedAt, totp.UpdatedAt).
+		Suffix("ON CONFLICT (user_id) DO UPDATE SET secret = EXCLUDED.secret, enabled = EXCLUDED.enabled, " +
+			"last_used_step = EXCLUDED.last_used_step, updated_at = EXCLUDED.updated_at")
+	sql, args, err := builder.ToSql()
+	if err != nil {
+		return fmt.Errorf("build sql: %v", err)
+	}
+
+	_, err = s.db.Exec(sql, args...)
+	return s.parseError(err, "save user totp")
+}
+
+// UseTotpStep marks time step as used. It returns false if the step or a later step has already been used.
+func (s *AuthStore) UseTotpStep(userId int, step int64) (bool, error) {
+	builder := s.sq.Update("user_totp").
+		Set("last_used_step", step).
+		Where("user_id = ? AND last_used_step < ?", userId, step)
+	sql, args, err := builder.ToSql()
+	if err != nil {
+		return false, fmt.Errorf("build sql: %v", err)
+	}
+
+	res, err := s.db.Exec(sql, args...)
+	if err != nil {
+		return false, s.parseError(err, "update totp last used step")
+	}
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return false, s.parseError(err, "update totp last used step")
+	}
+	return affected == 1, nil
+}
+
+// DeleteUserTotp removes user's totp secret and recovery codes.
+func (s *AuthStore) DeleteUserTotp(userId int) error {
+	tx, err := s.db.Beginx()
+	if err != nil {
+		return fmt.Errorf("begin transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec("DELETE FROM totp_recovery_codes WHERE user_id = $1", userId)
+	if err != nil {
+		return s.parseError(err, "delete recovery codes")
+	}
+	_, err = tx.Exec("DELETE FROM user_totp WHERE user_id = $1", userId)
+	if err != nil {
+		return s.parseError(err, "delete user totp")
+	}
+	return s.parseError(tx.Commit(), "delete user totp")
+}
+
+// ReplaceRecoveryCodes replaces user's recovery codes with the hashes.
+func (s *AuthStore) ReplaceRecoveryCodes(userId int, hashes []string) error {
+	tx, err := s.db.Beginx()
+	if err != nil {
+		return fmt.Errorf("begin transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec("DELETE FROM totp_recovery_codes WHERE user_id = $1", userId)
+	if err != nil {
+		return s.parseError(err, "delete recovery codes")
+	}
+	builder := s.sq.Insert("totp_recovery_codes").Columns("user_id", "code_hash")
+	for _, v := range hashes {
+		builder = builder.Values(userId, v)
+	}
+	sql, args, err := builder.ToSql()
+	if err != nil {
+		return fmt.Errorf("build sql: %v", err)
+	}
+	_, err = tx.Exec(sql, args...)
+	if err != nil {
+		return s.parseError(err, "insert recovery codes")
+	}
+	return s.parseError(tx.Commit(), "insert recovery codes")
+}
+
+// UseRecoveryCode marks unused recovery code as used. It returns false if code does not exist or is already used.
+func (s *AuthStore) UseRecoveryCode(userId int, hash string) (bool, error) {
+	builder := s.sq.Update("totp_recovery_codes").
+		Set("used_at", time.Now()).
+		Where("user_id = ? AND code_hash = ? AND used_at IS NULL", userId, hash)
+	sql, args, err := builder.ToSql()
+	if err != nil {
+		return false, fmt.Errorf("build sql: %v", err)
+	}
+
+	res, err := s.db.Exec(sql, args...)
+	if err != nil {
+		return false, s.parseError(err, "use recovery code")
+	}
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return false, s.parseError(err, "use recovery code")
+	}
+	return affected == 1, nil
+}
+
+// CountRecoveryCodes returns the number of unused recovery codes.
+func (s *AuthStore) CountRecoveryCodes(userId int) (int, error) {
+	count := 0
+	err := s.db.Get(&count, "SELECT COUNT(*) FROM totp_recovery_codes WHERE user_id = $1 AND used_at IS NULL", userId)
+	return count, s.parseError(err, "count recovery codes")
+}
@@ -0,0 +1,148 @@
+/*
+ * Virtualpaper is a service to manage users paper documents in virtual format.
+ * Copyright (C) 2024  Tero Vierimaa
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package storage
+
+import (
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"tryffel.net/go/virtualpaper/models"
+)
+
+func TestAuthStore_RevokeUserTokens(t *testing.T) {
+	db, mock, err := NewMockDatabase(sqlmock.QueryMatcherEqual)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	store := db.AuthStore
+	store.setTokenCache(&models.Token{Id: 1, UserId: 5, Key: "current"})
+	store.setTokenCache(&models.Token{Id: 2, UserId: 5, Key: "other"})
+	store.setTokenCache(&models.Token{Id: 3, UserId: 5, Key: "another"})
+
+	mock.ExpectQuery("DELETE FROM auth_tokens WHERE user_id = $1 AND key != $2 RETURNING key").
+		WithArgs(5, "current").
+		WillReturnRows(sqlmock.NewRows([]string{"key"}).AddRow("other"))
+	mock.ExpectQuery("DELETE FROM auth_tokens WHERE id = $1 AND user_id = $2 RETURNING key").
+		WithArgs(3, 5).
+		WillReturnRows(sqlmock.NewRows([]string{"key"}).AddRow("another"))
+
+	count, err := store.RevokeUserTokens(5, "current")
+	if err != nil {
+		t.Error(err)
+	}
+	if count != 1 {
+		t.Errorf("revoked tokens, want 1, got %d", count)
+	}
+	err = store.RevokeUserToken(5, 3)
+	if err != nil {
+		t.Error(err)
+	}
+	if err = mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("invalid query: %v", err)
+	}
+
+	if store.getTokenKeyCache("current") == nil {
+		t.Errorf("current token must remain in cache")
+	}
+	if store.getTokenKeyCache("other") != nil || store.getTokenKeyCache("another") != nil {
+		t.Errorf("revoked tokens must be removed from cache")
+	}
+}
+
+func TestAuthStore_DeleteUserApiTokens(t *testing.T) {
+	db, mock, err := NewMockDatabase(sqlmock.QueryMatcherEqual)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	store := db.AuthStore
+	mock.ExpectQuery("SELECT * FROM api_tokens WHERE token_hash = $1").
+		WithArgs("hash-1").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "user_id", "token_hash"}).AddRow(1, 5, "hash-1"))
+	mock.ExpectQuery("DELETE FROM api_tokens WHERE user_id = $1 RETURNING token_hash").
+		WithArgs(5).
+		WillReturnRows(sqlmock.NewRows([]string{"token_hash"}).AddRow("hash-1").AddRow("hash-2"))
+	mock.ExpectQuery("SELECT * FROM api_tokens WHERE token_hash = $1").
+		WithArgs("hash-1").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "user_id", "token_hash"}))
+
+	_, err = store.GetApiTokenByHash("hash-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	count, err := store.DeleteUserApiTokens(5)
+	if err != nil {
+		t.Error(err)
+	}
+	if count != 2 {
+		t.Errorf("deleted api tokens, want 2, got %d", count)
+	}
+	// deleted token must not be served from cache
+	_, err = store.GetApiTokenByHash("hash-1")
+	if err == nil {
+		t.Errorf("deleted api token found")
+	}
+	if err = mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("invalid query: %v", err)
+	}
+}
+
+func TestAuthStore_UseTotpStep(t *testing.T) {
+	db, mock, err := NewMockDatabase(sqlmock.QueryMatcherEqual)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	query := "UPDATE user_totp SET last_used_step = $1 WHERE user_id = $2 AND last_used_step < $3"
+	mock.ExpectExec(query).WithArgs(int64(100), 5, int64(100)).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(query).WithArgs(int64(100), 5, int64(100)).WillReturnResult(sqlmock.NewResult(0, 0))
+
+	ok, err := db.AuthStore.UseTotpStep(5, 100)
+	if err != nil || !ok {
+		t.Errorf("first use of step must succeed, got %t, %v", ok, err)
+	}
+	ok, err = db.AuthStore.UseTotpStep(5, 100)
+	if err != nil || ok {
+		t.Errorf("reused step must be rejected, got %t, %v", ok, err)
+	}
+	if err = mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("invalid query: %v", err)
+	}
+}
+
+func TestAuthStore_DeleteUserTotp(t *testing.T) {
+	db, mock, err := NewMockDatabase(sqlmock.QueryMatcherEqual)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	mock.ExpectBegin()
+	mock.ExpectExec("DELETE FROM totp_recovery_codes WHERE user_id = $1").WithArgs(5).WillReturnResult(sqlmock.NewResult(0, 10))
+	mock.ExpectExec("DELETE FROM user_totp WHERE user_id = $1").WithArgs(5).WillReturnError(sqlmock.ErrCancelled)
+	mock.ExpectRollback()
+
+	err = db.AuthStore.DeleteUserTotp(5)
+	if err == nil {
+		t.Errorf("delete totp must fail")
+	}
+	if err = mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("recovery codes must not be deleted without totp: %v", err)
+	}
+}
@@ -0,0 +1,184 @@
+/*
+ * Virtualpaper is a service to manage users paper documents in virtual format.
+ * Copyright (C) 2024  Tero Vierimaa
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+// Package blob stores document files and previews. Blobs are identified by keys
+// like 'documents/a/b/cdef', see storage.DocumentKey and storage.PreviewKey.
+package blob
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"tryffel.net/go/virtualpaper/config"
+)
+
+// ErrNotFound is returned when blob does not exist. It can be checked with errors.Is(err, os.ErrNotExist) as well.
+var ErrNotFound = os.ErrNotExist
+
+const (
+	BackendLocal = "local"
+	BackendS3    = "s3"
+)
+
+// Info describes a single blob.
+type Info struct {
+	Key        string
+	Size       int64
+	ModifiedAt time.Time
+}
+
+// Store is a storage backend for blobs.
+type Store interface {
+	// Name returns the name of the backend.
+	Name() string
+	// Put stores blob, overwriting existing blob. Size is the size of r, or -1 if unknown.
+	Put(ctx context.Context, key string, r io.Reader, size int64) error
+	// Get opens blob for reading. Caller must close the reader.
+	Get(ctx context.Context, key string) (io.ReadCloser, error)
+	// Stat returns info about the blob.
+	Stat(ctx context.Context, key string) (*Info, error)
+	// Delete removes blob. Deleting blob that does not exist is not an error.
+	Delete(ctx context.Context, key string) error
+	// List returns all blobs whose key starts with prefix.
+	List(ctx context.Context, prefix string) ([]Info, error)
+}
+
+// localPather is implemented by stores that keep blobs as plain files on local filesystem.
+type localPather interface {
+	LocalPath(key string) string
+}
+
+// rangeGetter is implemented by stores that can read part of a blob without reading all of it.
+type rangeGetter interface {
+	// GetRange opens length bytes of blob starting at offset for reading. If blob is shorter,
+	// reader returns the bytes that exist.
+	GetRange(ctx context.Context, key string, offset, length int64) (io.ReadCloser, error)
+}
+
+// GetRange opens length bytes of blob starting at offset for reading. If store does not support
+// reading ranges, the blob is read from the beginning and bytes before offset are discarded.
+func GetRange(ctx context.Context, store Store, key string, offset, length int64) (io.ReadCloser, error) {
+	if ranger, ok := store.(rangeGetter); ok {
+		return ranger.GetRange(ctx, key, offset, length)
+	}
+	reader, err := store.Get(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	_, err = io.CopyN(io.Discard, reader, offset)
+	if err != nil && !errors.Is(err, io.EOF) {
+		reader.Close()
+		return nil, err
+	}
+	return &readCloser{Reader: io.LimitReader(reader, length), Closer: reader}, nil
+}
+
+// NewStore creates store for given backend from config.C.
+func NewStore(backend string) (Store, error) {
+	switch backend {
+	case BackendLocal, "":
+		return NewLocalStore(config.C.Processing.DataDir), nil
+	case BackendS3:
+		return NewS3Store(config.C.Storage.S3)
+	default:
+		return nil, fmt.Errorf("unknown storage backend: '%s'", backend)
+	}
+}
+
+// PutFile stores local file as blob.
+func PutFile(ctx context.Context, store Store, key string, fileName string) error {
+	file, err := os.Open(fileName)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	stat, err := file.Stat()
+	if err != nil {
+		return err
+	}
+	return store.Put(ctx, key, file, stat.Size())
+}
+
+// Copy copies blob inside the store.
+func Copy(ctx context.Context, store Store, from, to string) error {
+	info, err := store.Stat(ctx, from)
+	if err != nil {
+		return err
+	}
+	reader, err := store.Get(ctx, from)
+	if err != nil {
+		return err
+	}
+	defer reader.Close()
+	return store.Put(ctx, to, reader, info.Size)
+}
+
+// Move moves blob inside the store.
+func Move(ctx context.Context, store Store, from, to string) error {
+	if local, ok := store.(*LocalStore); ok {
+		return local.move(from, to)
+	}
+	if encrypted, ok := store.(*EncryptedStore); ok {
+		// encrypted content does not depend on the key, so there is no need to decrypt it
+		return Move(ctx, encrypted.Store, from, to)
+	}
+	err := Copy(ctx, store, from, to)
+	if err != nil {
+		return err
+	}
+	return store.Delete(ctx, from)
+}
+
+// LocalFile returns a path to a local file with the contents of the blob, e.g. for external programs
+// that can only read files. If store keeps blobs as plain files, the file is returned as it is.
+// Otherwise, blob is downloaded to tempFile. Cleanup removes the temporary file, if any, and must be called
+// once the file is not needed anymore.
+func LocalFile(ctx context.Context, store Store, key string, tempFile string) (path string, cleanup func(), err error) {
+	if local, ok := store.(localPather); ok {
+		path = local.LocalPath(key)
+		_, err = os.Stat(path)
+		return path, func() {}, err
+	}
+
+	reader, err := store.Get(ctx, key)
+	if err != nil {
+		return "", func() {}, err
+	}
+	defer reader.Close()
+
+	file, err := os.OpenFile(tempFile, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0600)
+	if err != nil {
+		return "", func() {}, err
+	}
+	cleanup = func() { os.Remove(tempFile) }
+	_, err = io.Copy(file, reader)
+	closeErr := file.Close()
+	if err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		cleanup()
+		return "", func() {}, fmt.Errorf("download blob: %v", err)
+	}
+	return tempFile, cleanup, nil
+}
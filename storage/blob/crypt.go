@@ -0,0 +1,488 @@
+/*
+ * Virtualpaper is a service to manage users paper documents in virtual format.
+ * Copyright (C) 2024  Tero Vierimaa
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package blob
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// Encrypted blob starts with a header: magic, owner user id (uint64) and nonce prefix.
+// Content is split into chunks that are encrypted with AES-256-GCM. Each chunk nonce consists of
+// nonce prefix, chunk counter (uint32) and a flag marking the final chunk, so that reordering or truncating
+// chunks is detected.
+const (
+	cryptMagic           = "VPENC1"
+	cryptNoncePrefixSize = 7
+	cryptHeaderSize      = len(cryptMagic) + 8 + cryptNoncePrefixSize
+	cryptChunkSize       = 64 * 1024
+	cryptOverhead        = 16
+
+	// KeySize is the size of master and data keys in bytes.
+	KeySize = 32
+)
+
+// ErrDecrypt is returned when encrypted blob cannot be decrypted, e.g. when it is corrupted or has been modified.
+var ErrDecrypt = errors.New("decrypt blob: message authentication failed")
+
+// KeyProvider provides data keys for encrypting blobs.
+type KeyProvider interface {
+	// BlobOwner returns id of the user that owns the blob.
+	BlobOwner(ctx context.Context, key string) (int, error)
+	// DataKey returns data key of the user. If create is true and user does not have a key, a new key is created.
+	DataKey(ctx context.Context, userId int, create bool) ([]byte, error)
+}
+
+// EncryptedStore encrypts blobs with data key of the blob owner before storing them to underlying store,
+// and decrypts them when reading. Blobs that are not encrypted are read as they are, so
+// existing unencrypted files remain readable.
+type EncryptedStore struct {
+	Store
+	keys KeyProvider
+}
+
+func NewEncryptedStore(store Store, keys KeyProvider) *EncryptedStore {
+	return &EncryptedStore{Store: store, keys: keys}
+}
+
+// Unwrap returns underlying store, which returns blobs without decrypting them.
+func (s *EncryptedStore) Unwrap() Store {
+	return s.Store
+}
+
+func (s *EncryptedStore) Put(ctx context.Context, key string, r io.Reader, size int64) error {
+	owner, err := s.keys.BlobOwner(ctx, key)
+	if err != nil {
+		return fmt.Errorf("get owner of blob %s: %v", key, err)
+	}
+	dataKey, err := s.keys.DataKey(ctx, owner, true)
+	if err != nil {
+		return fmt.Errorf("get data key: %v", err)
+	}
+	reader, err := newEncryptReader(r, dataKey, owner)
+	if err != nil {
+		return err
+	}
+	if size >= 0 {
+		size = EncryptedSize(size)
+	}
+	return s.Store.Put(ctx, key, reader, size)
+}
+
+func (s *EncryptedStore) Get(ctx context.Context, key string) (io.ReadCloser, error) {
+	reader, err := s.Store.Get(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	header := make([]byte, cryptHeaderSize)
+	n, err := io.ReadFull(reader, header)
+	if err != nil && !errors.Is(err, io.EOF) && !errors.Is(err, io.ErrUnexpectedEOF) {
+		reader.Close()
+		return nil, err
+	}
+	if n < cryptHeaderSize || !bytes.HasPrefix(header, []byte(cryptMagic)) {
+		// not encrypted
+		return &readCloser{Reader: io.MultiReader(bytes.NewReader(header[:n]), reader), Closer: reader}, nil
+	}
+
+	owner := binary.BigEndian.Uint64(header[len(cryptMagic):])
+	dataKey, err := s.keys.DataKey(ctx, int(owner), false)
+	if err != nil {
+		reader.Close()
+		return nil, fmt.Errorf("get data key: %v", err)
+	}
+	decrypter, err := newDecryptReader(reader, dataKey, header[len(cryptMagic)+8:])
+	if err != nil {
+		reader.Close()
+		return nil, err
+	}
+	return &readCloser{Reader: decrypter, Closer: reader}, nil
+}
+
+// Stat returns info about the blob. Size is the size of the decrypted content.
+// Only the header of the blob is read to check whether it is encrypted.
+func (s *EncryptedStore) Stat(ctx context.Context, key string) (*Info, error) {
+	info, err := s.Store.Stat(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	if info.Size < int64(cryptHeaderSize) {
+		return info, nil
+	}
+	encrypted, err := IsEncrypted(ctx, s.Store, key)
+	if err != nil {
+		return nil, err
+	}
+	if encrypted {
+		info.Size = PlaintextSize(info.Size)
+	}
+	return info, nil
+}
+
+// IsEncrypted returns true if blob in store is encrypted. Only the header of the blob is read.
+func IsEncrypted(ctx context.Context, store Store, key string) (bool, error) {
+	reader, err := GetRange(ctx, store, key, 0, int64(len(cryptMagic)))
+	if err != nil {
+		return false, err
+	}
+	defer reader.Close()
+	header := make([]byte, len(cryptMagic))
+	_, err = io.ReadFull(reader, header)
+	if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+	return string(header) == cryptMagic, nil
+}
+
+// EncryptedSize returns size of encrypted blob for given content size.
+func EncryptedSize(size int64) int64 {
+	chunks := (size + cryptChunkSize - 1) / cryptChunkSize
+	if chunks == 0 {
+		chunks = 1
+	}
+	return int64(cryptHeaderSize) + size + chunks*cryptOverhead
+}
+
+// PlaintextSize returns size of content for given encrypted blob size.
+func PlaintextSize(size int64) int64 {
+	body := size - int64(cryptHeaderSize)
+	chunks := (body + cryptChunkSize + cryptOverhead - 1) / (cryptChunkSize + cryptOverhead)
+	if chunks == 0 {
+		chunks = 1
+	}
+	plain := body - chunks*cryptOverhead
+	if plain < 0 {
+		return 0
+	}
+	return plain
+}
+
+// Encrypt encrypts all unencrypted blobs with given prefixes. Each blob is first written encrypted to a
+// temporary key and verified by comparing hashes of the decrypted content and the original content.
+// Only then the original blob is replaced.
+func Encrypt(ctx context.Context, store *EncryptedStore, prefixes []string, onError func(key string, err error)) (*MigrateResult, error) {
+	result := &MigrateResult{}
+	for _, prefix := range prefixes {
+		blobs, err := store.Store.List(ctx, prefix)
+		if err != nil {
+			return result, fmt.Errorf("list blobs in %s: %v", prefix, err)
+		}
+		for _, v := range blobs {
+			if ctx.Err() != nil {
+				return result, ctx.Err()
+			}
+			encrypted, err := encryptBlob(ctx, store, v)
+			if err != nil {
+				result.Failed += 1
+				if onError != nil {
+					onError(v.Key, err)
+				}
+				continue
+			}
+			if encrypted {
+				result.Copied += 1
+				result.Bytes += v.Size
+			} else {
+				result.Skipped += 1
+			}
+		}
+	}
+	return result, nil
+}
+
+func encryptBlob(ctx context.Context, store *EncryptedStore, blob Info) (bool, error) {
+	encrypted, err := IsEncrypted(ctx, store.Store, blob.Key)
+	if err != nil {
+		return false, fmt.Errorf("read blob: %v", err)
+	}
+	if encrypted {
+		return false, nil
+	}
+	hash, err := HashBlob(ctx, store.Store, blob.Key)
+	if err != nil {
+		return false, fmt.Errorf("read blob: %v", err)
+	}
+
+	tempKey := blob.Key + ".encrypting"
+	reader, err := store.Store.Get(ctx, blob.Key)
+	if err != nil {
+		return false, fmt.Errorf("read blob: %v", err)
+	}
+	err = store.Put(ctx, tempKey, reader, blob.Size)
+	reader.Close()
+	if err != nil {
+		store.Store.Delete(ctx, tempKey)
+		return false, fmt.Errorf("write encrypted blob: %v", err)
+	}
+
+	encryptedHash, err := HashBlob(ctx, store, tempKey)
+	if err == nil && encryptedHash != hash {
+		err = fmt.Errorf("hash mismatch after encryption: original %s, encrypted %s", hash, encryptedHash)
+	}
+	if err != nil {
+		store.Store.Delete(ctx, tempKey)
+		return false, fmt.Errorf("verify encrypted blob: %v", err)
+	}
+	return true, Move(ctx, store.Store, tempKey, blob.Key)
+}
+
+// GenerateKey returns a new random key.
+func GenerateKey() ([]byte, error) {
+	key := make([]byte, KeySize)
+	_, err := rand.Read(key)
+	return key, err
+}
+
+// ParseKey parses base64-encoded key.
+func ParseKey(key string) ([]byte, error) {
+	data, err := base64.StdEncoding.DecodeString(key)
+	if err != nil {
+		return nil, fmt.Errorf("decode key: %v", err)
+	}
+	if len(data) != KeySize {
+		return nil, fmt.Errorf("key must be %d bytes, got %d bytes", KeySize, len(data))
+	}
+	return data, nil
+}
+
+// KeyId returns identifier for the key that can be stored without revealing the key.
+func KeyId(key []byte) string {
+	hash := sha256.Sum256(key)
+	return hex.EncodeToString(hash[:8])
+}
+
+// WrapKey encrypts data key with master key.
+func WrapKey(masterKey, dataKey []byte) (string, error) {
+	aead, err := newAead(masterKey)
+	if err != nil {
+		return "", err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	_, err = rand.Read(nonce)
+	if err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(aead.Seal(nonce, nonce, dataKey, nil)), nil
+}
+
+// UnwrapKey decrypts data key that was encrypted with WrapKey.
+func UnwrapKey(masterKey []byte, wrapped string) ([]byte, error) {
+	data, err := base64.StdEncoding.DecodeString(wrapped)
+	if err != nil {
+		return nil, fmt.Errorf("decode wrapped key: %v", err)
+	}
+	aead, err := newAead(masterKey)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) < aead.NonceSize() {
+		return nil, fmt.Errorf("invalid wrapped key")
+	}
+	key, err := aead.Open(nil, data[:aead.NonceSize()], data[aead.NonceSize():], nil)
+	if err != nil {
+		return nil, fmt.Errorf("unwrap key: invalid master key or corrupted data key")
+	}
+	return key, nil
+}
+
+func newAead(key []byte) (cipher.AEAD, error) {
+	if len(key) != KeySize {
+		return nil, fmt.Errorf("key must be %d bytes, got %d bytes", KeySize, len(key))
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+type readCloser struct {
+	io.Reader
+	io.Closer
+}
+
+func chunkNonce(nonce []byte, prefix []byte, counter uint32, last bool) []byte {
+	copy(nonce, prefix)
+	binary.BigEndian.PutUint32(nonce[cryptNoncePrefixSize:], counter)
+	nonce[cryptNoncePrefixSize+4] = 0
+	if last {
+		nonce[cryptNoncePrefixSize+4] = 1
+	}
+	return nonce
+}
+
+// encryptReader encrypts the source reader.
+type encryptReader struct {
+	source  *bufio.Reader
+	aead    cipher.AEAD
+	prefix  []byte
+	nonce   []byte
+	counter uint32
+	plain   []byte
+	sealed  []byte
+	output  []byte
+	done    bool
+}
+
+func newEncryptReader(source io.Reader, key []byte, owner int) (*encryptReader, error) {
+	aead, err := newAead(key)
+	if err != nil {
+		return nil, err
+	}
+	r := &encryptReader{
+		source: bufio.NewReaderSize(source, cryptChunkSize),
+		aead:   aead,
+		prefix: make([]byte, cryptNoncePrefixSize),
+		nonce:  make([]byte, aead.NonceSize()),
+		plain:  make([]byte, cryptChunkSize),
+		sealed: make([]byte, 0, cryptChunkSize+cryptOverhead),
+	}
+	_, err = rand.Read(r.prefix)
+	if err != nil {
+		return nil, err
+	}
+	r.output = make([]byte, 0, cryptHeaderSize)
+	r.output = append(r.output, cryptMagic...)
+	r.output = binary.BigEndian.AppendUint64(r.output, uint64(owner))
+	r.output = append(r.output, r.prefix...)
+	return r, nil
+}
+
+func (r *encryptReader) Read(p []byte) (int, error) {
+	for len(r.output) == 0 {
+		if r.done {
+			return 0, io.EOF
+		}
+		err := r.next()
+		if err != nil {
+			return 0, err
+		}
+	}
+	n := copy(p, r.output)
+	r.output = r.output[n:]
+	return n, nil
+}
+
+func (r *encryptReader) next() error {
+	n, err := io.ReadFull(r.source, r.plain)
+	last := false
+	if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+		last = true
+	} else if err != nil {
+		return err
+	} else {
+		_, err = r.source.Peek(1)
+		if errors.Is(err, io.EOF) {
+			last = true
+		} else if err != nil {
+			return err
+		}
+	}
+	if r.counter == ^uint32(0) && !last {
+		return fmt.Errorf("blob is too large to encrypt")
+	}
+	r.output = r.aead.Seal(r.sealed[:0], chunkNonce(r.nonce, r.prefix, r.counter, last), r.plain[:n], nil)
+	r.counter += 1
+	r.done = last
+	return nil
+}
+
+// decryptReader decrypts content encrypted with encryptReader. Source must be positioned after header.
+type decryptReader struct {
+	source  *bufio.Reader
+	aead    cipher.AEAD
+	prefix  []byte
+	nonce   []byte
+	counter uint32
+	sealed  []byte
+	plain   []byte
+	output  []byte
+	done    bool
+}
+
+func newDecryptReader(source io.Reader, key []byte, prefix []byte) (*decryptReader, error) {
+	aead, err := newAead(key)
+	if err != nil {
+		return nil, err
+	}
+	return &decryptReader{
+		source: bufio.NewReaderSize(source, cryptChunkSize+cryptOverhead),
+		aead:   aead,
+		prefix: append([]byte{}, prefix...),
+		nonce:  make([]byte, aead.NonceSize()),
+		sealed: make([]byte, cryptChunkSize+cryptOverhead),
+		plain:  make([]byte, 0, cryptChunkSize),
+	}, nil
+}
+
+func (r *decryptReader) Read(p []byte) (int, error) {
+	for len(r.output) == 0 {
+		if r.done {
+			return 0, io.EOF
+		}
+		err := r.next()
+		if err != nil {
+			return 0, err
+		}
+	}
+	n := copy(p, r.output)
+	r.output = r.output[n:]
+	return n, nil
+}
+
+func (r *decryptReader) next() error {
+	n, err := io.ReadFull(r.source, r.sealed)
+	last := false
+	if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+		last = true
+	} else if err != nil {
+		return err
+	} else {
+		_, err = r.source.Peek(1)
+		if errors.Is(err, io.EOF) {
+			last = true
+		} else if err != nil {
+			return err
+		}
+	}
+	if n < cryptOverhead {
+		return ErrDecrypt
+	}
+	plain, err := r.aead.Open(r.plain[:0], chunkNonce(r.nonce, r.prefix, r.counter, last), r.sealed[:n], nil)
+	if err != nil {
+		return ErrDecrypt
+	}
+	r.output = plain
+	r.counter += 1
+	r.done = last
+	return nil
+}
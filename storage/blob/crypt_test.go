@@ -0,0 +1,205 @@
+/*
+ * Virtualpaper is a service to manage users paper documents in virtual format.
+ * Copyright (C) 2024  Tero Vierimaa
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package blob
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"io"
+	"testing"
+)
+
+type testKeys struct {
+	owner int
+	keys  map[int][]byte
+}
+
+func (k *testKeys) BlobOwner(ctx context.Context, key string) (int, error) {
+	return k.owner, nil
+}
+
+func (k *testKeys) DataKey(ctx context.Context, userId int, create bool) ([]byte, error) {
+	key, ok := k.keys[userId]
+	if !ok {
+		if !create {
+			return nil, fmt.Errorf("no key for user %d", userId)
+		}
+		key, _ = GenerateKey()
+		k.keys[userId] = key
+	}
+	return key, nil
+}
+
+func newTestEncryptedStore(t *testing.T) (*EncryptedStore, *LocalStore) {
+	raw := NewLocalStore(t.TempDir())
+	return NewEncryptedStore(raw, &testKeys{owner: 5, keys: map[int][]byte{}}), raw
+}
+
+func TestEncryptedStore(t *testing.T) {
+	testStore(t, NewEncryptedStore(NewLocalStore(t.TempDir()), &testKeys{owner: 5, keys: map[int][]byte{}}))
+}
+
+func TestEncryptedStore_Sizes(t *testing.T) {
+	ctx := context.Background()
+	store, raw := newTestEncryptedStore(t)
+
+	sizes := []int{0, 1, 100, cryptChunkSize - 1, cryptChunkSize, cryptChunkSize + 1, 3*cryptChunkSize + 17}
+	for _, size := range sizes {
+		t.Run(fmt.Sprintf("%d bytes", size), func(t *testing.T) {
+			content := make([]byte, size)
+			rand.Read(content)
+			err := store.Put(ctx, "documents/a/b/c", bytes.NewReader(content), int64(size))
+			if err != nil {
+				t.Fatalf("Put(): %v", err)
+			}
+
+			rawInfo, err := raw.Stat(ctx, "documents/a/b/c")
+			if err != nil {
+				t.Fatalf("raw Stat(): %v", err)
+			}
+			if rawInfo.Size != EncryptedSize(int64(size)) {
+				t.Errorf("encrypted size = %d, want %d", rawInfo.Size, EncryptedSize(int64(size)))
+			}
+			if size > 16 && bytes.Contains(readBlobBytes(t, raw, "documents/a/b/c"), content[:16]) {
+				t.Errorf("raw blob contains plaintext")
+			}
+
+			info, err := store.Stat(ctx, "documents/a/b/c")
+			if err != nil {
+				t.Fatalf("Stat(): %v", err)
+			}
+			if info.Size != int64(size) {
+				t.Errorf("Stat() size = %d, want %d", info.Size, size)
+			}
+			if got := readBlobBytes(t, store, "documents/a/b/c"); !bytes.Equal(got, content) {
+				t.Errorf("decrypted content does not match, got %d bytes, want %d bytes", len(got), size)
+			}
+		})
+	}
+}
+
+func TestEncryptedStore_Tampering(t *testing.T) {
+	ctx := context.Background()
+	store, raw := newTestEncryptedStore(t)
+	content := make([]byte, 2*cryptChunkSize+10)
+	rand.Read(content)
+	putBlob(t, store, "documents/a/b/c", string(content))
+	encrypted := readBlobBytes(t, raw, "documents/a/b/c")
+
+	tests := []struct {
+		name string
+		data []byte
+	}{
+		{name: "modified", data: func() []byte {
+			data := append([]byte{}, encrypted...)
+			data[cryptHeaderSize+10] ^= 1
+			return data
+		}()},
+		{name: "truncated at chunk", data: encrypted[:cryptHeaderSize+2*(cryptChunkSize+cryptOverhead)]},
+		{name: "truncated", data: encrypted[:len(encrypted)-5]},
+		{name: "chunks reordered", data: func() []byte {
+			chunk := cryptChunkSize + cryptOverhead
+			data := append([]byte{}, encrypted[:cryptHeaderSize]...)
+			data = append(data, encrypted[cryptHeaderSize+chunk:cryptHeaderSize+2*chunk]...)
+			data = append(data, encrypted[cryptHeaderSize:cryptHeaderSize+chunk]...)
+			return append(data, encrypted[cryptHeaderSize+2*chunk:]...)
+		}()},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := raw.Put(ctx, "documents/a/b/c", bytes.NewReader(tt.data), int64(len(tt.data)))
+			if err != nil {
+				t.Fatal(err)
+			}
+			reader, err := store.Get(ctx, "documents/a/b/c")
+			if err != nil {
+				t.Fatalf("Get(): %v", err)
+			}
+			defer reader.Close()
+			_, err = io.ReadAll(reader)
+			if !errors.Is(err, ErrDecrypt) {
+				t.Errorf("read tampered blob error = %v, want ErrDecrypt", err)
+			}
+		})
+	}
+}
+
+func TestEncrypt(t *testing.T) {
+	ctx := context.Background()
+	store, raw := newTestEncryptedStore(t)
+	putBlob(t, raw, "documents/a/b/c", "plain document")
+	putBlob(t, raw, "previews/a/b/c.png", "plain preview")
+	putBlob(t, store, "documents/x/y/z", "encrypted document")
+
+	// unencrypted blobs are readable as they are
+	if got := readBlob(t, store, "documents/a/b/c"); got != "plain document" {
+		t.Errorf("read unencrypted blob = %s", got)
+	}
+
+	result, err := Encrypt(ctx, store, Prefixes, func(key string, err error) {
+		t.Errorf("encrypt %s: %v", key, err)
+	})
+	if err != nil {
+		t.Fatalf("Encrypt(): %v", err)
+	}
+	if result.Copied != 2 || result.Skipped != 1 {
+		t.Errorf("Encrypt() = %+v, want 2 encrypted and 1 skipped", result)
+	}
+	for _, key := range []string{"documents/a/b/c", "previews/a/b/c.png"} {
+		encrypted, err := IsEncrypted(ctx, raw, key)
+		if err != nil || !encrypted {
+			t.Errorf("blob %s is not encrypted: %v", key, err)
+		}
+	}
+	if got := readBlob(t, store, "documents/a/b/c"); got != "plain document" {
+		t.Errorf("read encrypted blob = %s", got)
+	}
+	blobs, _ := raw.List(ctx, "documents/")
+	if len(blobs) != 2 {
+		t.Errorf("temporary files left: %v", blobs)
+	}
+}
+
+func TestWrapKey(t *testing.T) {
+	master, _ := GenerateKey()
+	other, _ := GenerateKey()
+	key, _ := GenerateKey()
+
+	wrapped, err := WrapKey(master, key)
+	if err != nil {
+		t.Fatalf("WrapKey(): %v", err)
+	}
+	unwrapped, err := UnwrapKey(master, wrapped)
+	if err != nil {
+		t.Fatalf("UnwrapKey(): %v", err)
+	}
+	if !bytes.Equal(unwrapped, key) {
+		t.Errorf("unwrapped key does not match")
+	}
+	_, err = UnwrapKey(other, wrapped)
+	if err == nil {
+		t.Errorf("UnwrapKey() with wrong master key succeeded")
+	}
+	if KeyId(master) == KeyId(other) {
+		t.Errorf("KeyId() is same for different keys")
+	}
+}
@@ -0,0 +1,157 @@
+/*
+ * Virtualpaper is a service to manage users paper documents in virtual format.
+ * Copyright (C) 2024  Tero Vierimaa
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package blob
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// LocalStore stores blobs as files under root directory. Key is the relative path of the file.
+type LocalStore struct {
+	root string
+}
+
+func NewLocalStore(root string) *LocalStore {
+	return &LocalStore{root: root}
+}
+
+func (s *LocalStore) Name() string {
+	return BackendLocal
+}
+
+// LocalPath returns path of the file for key.
+func (s *LocalStore) LocalPath(key string) string {
+	return filepath.Join(s.root, filepath.FromSlash(key))
+}
+
+func (s *LocalStore) Put(ctx context.Context, key string, r io.Reader, size int64) error {
+	path := s.LocalPath(key)
+	err := os.MkdirAll(filepath.Dir(path), 0755)
+	if err != nil {
+		return fmt.Errorf("create directory: %v", err)
+	}
+
+	// write to temporary file first so that existing file is not left half-written on errors.
+	file, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+"-*")
+	if err != nil {
+		return err
+	}
+	n, err := io.Copy(file, r)
+	closeErr := file.Close()
+	if err == nil {
+		err = closeErr
+	}
+	if err == nil && size >= 0 && n != size {
+		err = fmt.Errorf("did not fully write file: expect %d bytes, wrote %d bytes", size, n)
+	}
+	if err != nil {
+		os.Remove(file.Name())
+		return err
+	}
+	err = os.Chmod(file.Name(), 0644)
+	if err != nil {
+		os.Remove(file.Name())
+		return err
+	}
+	return os.Rename(file.Name(), path)
+}
+
+func (s *LocalStore) Get(ctx context.Context, key string) (io.ReadCloser, error) {
+	return os.Open(s.LocalPath(key))
+}
+
+func (s *LocalStore) GetRange(ctx context.Context, key string, offset, length int64) (io.ReadCloser, error) {
+	file, err := os.Open(s.LocalPath(key))
+	if err != nil {
+		return nil, err
+	}
+	_, err = file.Seek(offset, io.SeekStart)
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	return &readCloser{Reader: io.LimitReader(file, length), Closer: file}, nil
+}
+
+func (s *LocalStore) Stat(ctx context.Context, key string) (*Info, error) {
+	stat, err := os.Stat(s.LocalPath(key))
+	if err != nil {
+		return nil, err
+	}
+	return &Info{Key: key, Size: stat.Size(), ModifiedAt: stat.ModTime()}, nil
+}
+
+func (s *LocalStore) Delete(ctx context.Context, key string) error {
+	err := os.Remove(s.LocalPath(key))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	return err
+}
+
+func (s *LocalStore) List(ctx context.Context, prefix string) ([]Info, error) {
+	// walk the deepest directory that contains all matching files
+	dir := prefix
+	if !strings.HasSuffix(dir, "/") {
+		dir = filepath.ToSlash(filepath.Dir(dir))
+	}
+	blobs := make([]Info, 0)
+	err := filepath.WalkDir(s.LocalPath(dir), func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if errors.Is(err, os.ErrNotExist) {
+				return nil
+			}
+			return err
+		}
+		if d.IsDir() || strings.HasPrefix(d.Name(), ".") {
+			return nil
+		}
+		rel, err := filepath.Rel(s.root, path)
+		if err != nil {
+			return err
+		}
+		key := filepath.ToSlash(rel)
+		if !strings.HasPrefix(key, prefix) {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		blobs = append(blobs, Info{Key: key, Size: info.Size(), ModifiedAt: info.ModTime()})
+		return nil
+	})
+	return blobs, err
+}
+
+func (s *LocalStore) move(from, to string) error {
+	toPath := s.LocalPath(to)
+	err := os.MkdirAll(filepath.Dir(toPath), 0755)
+	if err != nil {
+		return fmt.Errorf("create directory: %v", err)
+	}
+	return os.Rename(s.LocalPath(from), toPath)
+}
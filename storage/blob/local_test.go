@@ -0,0 +1,231 @@
+/*
+ * Virtualpaper is a service to manage users paper documents in virtual format.
+ * Copyright (C) 2024  Tero Vierimaa
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package blob
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"os"
+	"strings"
+	"testing"
+)
+
+func readBlobBytes(t *testing.T, store Store, key string) []byte {
+	t.Helper()
+	reader, err := store.Get(context.Background(), key)
+	if err != nil {
+		t.Fatalf("get %s: %v", key, err)
+	}
+	defer reader.Close()
+	data, err := io.ReadAll(reader)
+	if err != nil {
+		t.Fatalf("read %s: %v", key, err)
+	}
+	return data
+}
+
+func readBlob(t *testing.T, store Store, key string) string {
+	t.Helper()
+	return string(readBlobBytes(t, store, key))
+}
+
+func putBlob(t *testing.T, store Store, key, content string) {
+	t.Helper()
+	err := store.Put(context.Background(), key, strings.NewReader(content), int64(len(content)))
+	if err != nil {
+		t.Fatalf("put %s: %v", key, err)
+	}
+}
+
+// testStore runs common tests for Store implementation.
+func testStore(t *testing.T, store Store) {
+	ctx := context.Background()
+	putBlob(t, store, "documents/a/b/cdef", "document")
+	putBlob(t, store, "documents/a/b/cdef.v1", "version 1")
+	putBlob(t, store, "documents/x/y/zzzz", "other document")
+	putBlob(t, store, "previews/a/b/cdef.png", "preview")
+
+	if got := readBlob(t, store, "documents/a/b/cdef"); got != "document" {
+		t.Errorf("Get() = %s, want document", got)
+	}
+
+	putBlob(t, store, "documents/a/b/cdef", "updated")
+	if got := readBlob(t, store, "documents/a/b/cdef"); got != "updated" {
+		t.Errorf("Get() after overwrite = %s, want updated", got)
+	}
+
+	info, err := store.Stat(ctx, "documents/a/b/cdef.v1")
+	if err != nil {
+		t.Fatalf("Stat(): %v", err)
+	}
+	if info.Size != int64(len("version 1")) {
+		t.Errorf("Stat() size = %d, want %d", info.Size, len("version 1"))
+	}
+
+	for _, r := range []struct {
+		offset, length int64
+		want           string
+	}{{0, 3, "ver"}, {2, 4, "rsio"}, {6, 10, "n 1"}, {20, 5, ""}} {
+		reader, err := GetRange(ctx, store, "documents/a/b/cdef.v1", r.offset, r.length)
+		if err != nil {
+			t.Fatalf("GetRange(%d, %d): %v", r.offset, r.length, err)
+		}
+		data, err := io.ReadAll(reader)
+		reader.Close()
+		if err != nil || string(data) != r.want {
+			t.Errorf("GetRange(%d, %d) = '%s', %v, want '%s'", r.offset, r.length, data, err, r.want)
+		}
+	}
+
+	_, err = store.Get(ctx, "documents/not/found")
+	if !errors.Is(err, ErrNotFound) {
+		t.Errorf("Get() missing blob error = %v, want ErrNotFound", err)
+	}
+	_, err = store.Stat(ctx, "documents/not/found")
+	if !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("Stat() missing blob error = %v, want os.ErrNotExist", err)
+	}
+
+	blobs, err := store.List(ctx, "documents/a/b/cdef")
+	if err != nil {
+		t.Fatalf("List(): %v", err)
+	}
+	keys := map[string]bool{}
+	for _, v := range blobs {
+		keys[v.Key] = true
+	}
+	if len(keys) != 2 || !keys["documents/a/b/cdef"] || !keys["documents/a/b/cdef.v1"] {
+		t.Errorf("List() = %v, want document and its version", blobs)
+	}
+
+	blobs, err = store.List(ctx, "documents/")
+	if err != nil {
+		t.Fatalf("List(): %v", err)
+	}
+	if len(blobs) != 3 {
+		t.Errorf("List(documents/) returned %d blobs, want 3", len(blobs))
+	}
+
+	err = Move(ctx, store, "documents/a/b/cdef", "documents/a/b/cdef.v2")
+	if err != nil {
+		t.Fatalf("Move(): %v", err)
+	}
+	if got := readBlob(t, store, "documents/a/b/cdef.v2"); got != "updated" {
+		t.Errorf("Get() after move = %s, want updated", got)
+	}
+	_, err = store.Stat(ctx, "documents/a/b/cdef")
+	if !errors.Is(err, ErrNotFound) {
+		t.Errorf("Stat() after move error = %v, want ErrNotFound", err)
+	}
+
+	err = Copy(ctx, store, "documents/a/b/cdef.v1", "documents/a/b/cdef")
+	if err != nil {
+		t.Fatalf("Copy(): %v", err)
+	}
+	if got := readBlob(t, store, "documents/a/b/cdef"); got != "version 1" {
+		t.Errorf("Get() after copy = %s, want version 1", got)
+	}
+
+	path, cleanup, err := LocalFile(ctx, store, "previews/a/b/cdef.png", t.TempDir()+"/preview")
+	if err != nil {
+		t.Fatalf("LocalFile(): %v", err)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("read local file: %v", err)
+	}
+	if string(data) != "preview" {
+		t.Errorf("LocalFile() content = %s, want preview", data)
+	}
+	cleanup()
+
+	err = store.Delete(ctx, "previews/a/b/cdef.png")
+	if err != nil {
+		t.Fatalf("Delete(): %v", err)
+	}
+	_, err = store.Get(ctx, "previews/a/b/cdef.png")
+	if !errors.Is(err, ErrNotFound) {
+		t.Errorf("Get() after delete error = %v, want ErrNotFound", err)
+	}
+	err = store.Delete(ctx, "previews/a/b/cdef.png")
+	if err != nil {
+		t.Errorf("Delete() missing blob: %v", err)
+	}
+}
+
+func TestLocalStore(t *testing.T) {
+	testStore(t, NewLocalStore(t.TempDir()))
+}
+
+func TestLocalStore_PutSizeMismatch(t *testing.T) {
+	store := NewLocalStore(t.TempDir())
+	err := store.Put(context.Background(), "documents/a/b/c", bytes.NewReader([]byte("abc")), 10)
+	if err == nil {
+		t.Fatalf("Put() expected error with size mismatch")
+	}
+	blobs, err := store.List(context.Background(), "documents/")
+	if err != nil {
+		t.Fatalf("List(): %v", err)
+	}
+	if len(blobs) != 0 {
+		t.Errorf("Put() left files after error: %v", blobs)
+	}
+}
+
+func TestMigrate(t *testing.T) {
+	ctx := context.Background()
+	source := NewLocalStore(t.TempDir())
+	target := NewLocalStore(t.TempDir())
+
+	putBlob(t, source, "documents/a/b/cdef", "document")
+	putBlob(t, source, "documents/a/b/cdef.v1", "version")
+	putBlob(t, source, "previews/a/b/cdef.png", "preview")
+	putBlob(t, source, "other/file", "not migrated")
+	putBlob(t, target, "documents/a/b/cdef.v1", "version")
+
+	result, err := Migrate(ctx, source, target, Prefixes, func(key string, err error) {
+		t.Errorf("migrate %s: %v", key, err)
+	})
+	if err != nil {
+		t.Fatalf("Migrate(): %v", err)
+	}
+	if result.Copied != 2 || result.Skipped != 1 || result.Failed != 0 {
+		t.Errorf("Migrate() = %+v, want 2 copied and 1 skipped", result)
+	}
+	if got := readBlob(t, target, "documents/a/b/cdef"); got != "document" {
+		t.Errorf("migrated document = %s, want document", got)
+	}
+	if got := readBlob(t, target, "previews/a/b/cdef.png"); got != "preview" {
+		t.Errorf("migrated preview = %s, want preview", got)
+	}
+	_, err = target.Stat(ctx, "other/file")
+	if !errors.Is(err, ErrNotFound) {
+		t.Errorf("file outside prefixes was migrated")
+	}
+
+	result, err = Migrate(ctx, source, target, Prefixes, nil)
+	if err != nil {
+		t.Fatalf("Migrate() again: %v", err)
+	}
+	if result.Copied != 0 || result.Skipped != 3 {
+		t.Errorf("Migrate() again = %+v, want all skipped", result)
+	}
+}
@@ -0,0 +1,125 @@
+/*
+ * Virtualpaper is a service to manage users paper documents in virtual format.
+ * Copyright (C) 2024  Tero Vierimaa
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package blob
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// Prefixes are the key prefixes of all blobs virtualpaper stores.
+var Prefixes = []string{"documents/", "previews/"}
+
+// MigrateResult summarizes a migration.
+type MigrateResult struct {
+	Copied  int
+	Skipped int
+	Failed  int
+	Bytes   int64
+}
+
+// Migrate copies all blobs with given prefixes from source to target. Each copied blob is read back from
+// target and its hash is compared to the source. Blobs that already exist in target with the same hash
+// are skipped. Migration continues on errors, and failed blobs are reported to onError.
+func Migrate(ctx context.Context, source, target Store, prefixes []string, onError func(key string, err error)) (*MigrateResult, error) {
+	result := &MigrateResult{}
+	for _, prefix := range prefixes {
+		blobs, err := source.List(ctx, prefix)
+		if err != nil {
+			return result, fmt.Errorf("list blobs in %s: %v", prefix, err)
+		}
+
+		for _, v := range blobs {
+			if ctx.Err() != nil {
+				return result, ctx.Err()
+			}
+			copied, err := migrateBlob(ctx, source, target, v)
+			if err != nil {
+				result.Failed += 1
+				if onError != nil {
+					onError(v.Key, err)
+				}
+				continue
+			}
+			if copied {
+				result.Copied += 1
+				result.Bytes += v.Size
+			} else {
+				result.Skipped += 1
+			}
+		}
+	}
+	return result, nil
+}
+
+func migrateBlob(ctx context.Context, source, target Store, blob Info) (bool, error) {
+	sourceHash, err := HashBlob(ctx, source, blob.Key)
+	if err != nil {
+		return false, fmt.Errorf("read source: %v", err)
+	}
+
+	existing, err := target.Stat(ctx, blob.Key)
+	if err == nil && existing.Size == blob.Size {
+		targetHash, err := HashBlob(ctx, target, blob.Key)
+		if err == nil && targetHash == sourceHash {
+			return false, nil
+		}
+	} else if err != nil && !errors.Is(err, ErrNotFound) {
+		return false, fmt.Errorf("stat target: %v", err)
+	}
+
+	reader, err := source.Get(ctx, blob.Key)
+	if err != nil {
+		return false, fmt.Errorf("read source: %v", err)
+	}
+	err = target.Put(ctx, blob.Key, reader, blob.Size)
+	reader.Close()
+	if err != nil {
+		return false, fmt.Errorf("write target: %v", err)
+	}
+
+	targetHash, err := HashBlob(ctx, target, blob.Key)
+	if err != nil {
+		return false, fmt.Errorf("verify target: %v", err)
+	}
+	if targetHash != sourceHash {
+		return false, fmt.Errorf("hash mismatch after copy: source %s, target %s", sourceHash, targetHash)
+	}
+	return true, nil
+}
+
+// HashBlob returns hex-encoded sha256 of the blob contents.
+func HashBlob(ctx context.Context, store Store, key string) (string, error) {
+	reader, err := store.Get(ctx, key)
+	if err != nil {
+		return "", err
+	}
+	defer reader.Close()
+
+	hash := sha256.New()
+	_, err = io.Copy(hash, reader)
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
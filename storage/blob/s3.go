@@ -0,0 +1,176 @@
+/*
+ * Virtualpaper is a service to manage users paper documents in virtual format.
+ * Copyright (C) 2024  Tero Vierimaa
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package blob
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/minio/minio-go/v7"
+	"github.com/minio/minio-go/v7/pkg/credentials"
+	"tryffel.net/go/virtualpaper/config"
+)
+
+// S3Store stores blobs in S3-compatible object storage.
+type S3Store struct {
+	client *minio.Client
+	bucket string
+	prefix string
+}
+
+func NewS3Store(conf config.S3Storage) (*S3Store, error) {
+	return newS3Store(conf, nil)
+}
+
+func newS3Store(conf config.S3Storage, transport http.RoundTripper) (*S3Store, error) {
+	if conf.Endpoint == "" || conf.Bucket == "" {
+		return nil, fmt.Errorf("s3 storage requires endpoint and bucket")
+	}
+	lookup := minio.BucketLookupAuto
+	if conf.PathStyle {
+		lookup = minio.BucketLookupPath
+	}
+	client, err := minio.New(conf.Endpoint, &minio.Options{
+		Creds:        credentials.NewStaticV4(conf.AccessKey, conf.SecretKey, ""),
+		Secure:       !conf.NoTLS,
+		Region:       conf.Region,
+		BucketLookup: lookup,
+		Transport:    transport,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("create s3 client: %v", err)
+	}
+	prefix := conf.Prefix
+	if prefix != "" && !strings.HasSuffix(prefix, "/") {
+		prefix += "/"
+	}
+	return &S3Store{client: client, bucket: conf.Bucket, prefix: prefix}, nil
+}
+
+func (s *S3Store) Name() string {
+	return BackendS3
+}
+
+func (s *S3Store) Put(ctx context.Context, key string, r io.Reader, size int64) error {
+	_, err := s.client.PutObject(ctx, s.bucket, s.prefix+key, r, size, minio.PutObjectOptions{
+		ContentType: "application/octet-stream",
+	})
+	return s.parseError(err, key)
+}
+
+func (s *S3Store) Get(ctx context.Context, key string) (io.ReadCloser, error) {
+	object, err := s.client.GetObject(ctx, s.bucket, s.prefix+key, minio.GetObjectOptions{})
+	if err != nil {
+		return nil, s.parseError(err, key)
+	}
+	// GetObject does not return errors before first read, stat the object to catch missing objects early.
+	_, err = object.Stat()
+	if err != nil {
+		object.Close()
+		return nil, s.parseError(err, key)
+	}
+	return object, nil
+}
+
+func (s *S3Store) GetRange(ctx context.Context, key string, offset, length int64) (io.ReadCloser, error) {
+	opts := minio.GetObjectOptions{}
+	err := opts.SetRange(offset, offset+length-1)
+	if err != nil {
+		return nil, err
+	}
+	object, err := s.client.GetObject(ctx, s.bucket, s.prefix+key, opts)
+	if err != nil {
+		return nil, s.parseError(err, key)
+	}
+	return &s3RangeReader{object: object, store: s, key: key}, nil
+}
+
+func (s *S3Store) Stat(ctx context.Context, key string) (*Info, error) {
+	stat, err := s.client.StatObject(ctx, s.bucket, s.prefix+key, minio.StatObjectOptions{})
+	if err != nil {
+		return nil, s.parseError(err, key)
+	}
+	return &Info{Key: key, Size: stat.Size, ModifiedAt: stat.LastModified}, nil
+}
+
+func (s *S3Store) Delete(ctx context.Context, key string) error {
+	err := s.client.RemoveObject(ctx, s.bucket, s.prefix+key, minio.RemoveObjectOptions{})
+	err = s.parseError(err, key)
+	if errors.Is(err, ErrNotFound) {
+		return nil
+	}
+	return err
+}
+
+func (s *S3Store) List(ctx context.Context, prefix string) ([]Info, error) {
+	blobs := make([]Info, 0)
+	objects := s.client.ListObjects(ctx, s.bucket, minio.ListObjectsOptions{
+		Prefix:    s.prefix + prefix,
+		Recursive: true,
+	})
+	for object := range objects {
+		if object.Err != nil {
+			return blobs, s.parseError(object.Err, prefix)
+		}
+		blobs = append(blobs, Info{
+			Key:        strings.TrimPrefix(object.Key, s.prefix),
+			Size:       object.Size,
+			ModifiedAt: object.LastModified,
+		})
+	}
+	return blobs, nil
+}
+
+// s3RangeReader reads a range of an object. S3 rejects ranges that start after the end of the object,
+// those are read as empty.
+type s3RangeReader struct {
+	object *minio.Object
+	store  *S3Store
+	key    string
+}
+
+func (r *s3RangeReader) Read(p []byte) (int, error) {
+	n, err := r.object.Read(p)
+	if err == nil || errors.Is(err, io.EOF) {
+		return n, err
+	}
+	if minio.ToErrorResponse(err).Code == "InvalidRange" {
+		return n, io.EOF
+	}
+	return n, r.store.parseError(err, r.key)
+}
+
+func (r *s3RangeReader) Close() error {
+	return r.object.Close()
+}
+
+func (s *S3Store) parseError(err error, key string) error {
+	if err == nil {
+		return nil
+	}
+	resp := minio.ToErrorResponse(err)
+	if resp.Code == "NoSuchKey" || resp.StatusCode == http.StatusNotFound {
+		return fmt.Errorf("s3 object %s: %w", key, ErrNotFound)
+	}
+	return fmt.Errorf("s3 object %s: %v", key, err)
+}
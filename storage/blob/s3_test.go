@@ -0,0 +1,258 @@
+/*
+ * Virtualpaper is a service to manage users paper documents in virtual format.
+ * Copyright (C) 2024  Tero Vierimaa
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package blob
+
+import (
+	"context"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"tryffel.net/go/virtualpaper/config"
+)
+
+// fakeS3 is a minimal in-memory S3-compatible server that supports path-style requests
+// for a single bucket.
+type fakeS3 struct {
+	bucket  string
+	lock    sync.Mutex
+	objects map[string][]byte
+	// sent is the number of object bytes returned by GET requests
+	sent int
+}
+
+type fakeS3ListResult struct {
+	XMLName     xml.Name `xml:"ListBucketResult"`
+	Name        string
+	Prefix      string
+	KeyCount    int
+	MaxKeys     int
+	IsTruncated bool
+	Contents    []fakeS3Object
+}
+
+type fakeS3Object struct {
+	Key          string
+	LastModified string
+	ETag         string
+	Size         int
+	StorageClass string
+}
+
+func (f *fakeS3) writeError(w http.ResponseWriter, status int, code string) {
+	w.Header().Set("Content-Type", "application/xml")
+	w.WriteHeader(status)
+	fmt.Fprintf(w, "<?xml version=\"1.0\" encoding=\"UTF-8\"?><Error><Code>%s</Code><Message>%s</Message></Error>", code, code)
+}
+
+func (f *fakeS3) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	path := strings.TrimPrefix(r.URL.Path, "/")
+	bucket, key, _ := strings.Cut(path, "/")
+	if bucket != f.bucket {
+		f.writeError(w, http.StatusNotFound, "NoSuchBucket")
+		return
+	}
+	// read body before locking, client may stream the body from another request to this server
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		f.writeError(w, http.StatusBadRequest, "IncompleteBody")
+		return
+	}
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	if key == "" {
+		if r.Method != http.MethodGet || r.URL.Query().Get("list-type") != "2" {
+			f.writeError(w, http.StatusNotImplemented, "NotImplemented")
+			return
+		}
+		f.list(w, r.URL.Query())
+		return
+	}
+
+	modified := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC).Format(http.TimeFormat)
+	switch r.Method {
+	case http.MethodPut:
+		f.objects[key] = body
+		w.Header().Set("ETag", "\"etag\"")
+		w.WriteHeader(http.StatusOK)
+	case http.MethodGet, http.MethodHead:
+		data, ok := f.objects[key]
+		if !ok {
+			if r.Method == http.MethodHead {
+				w.WriteHeader(http.StatusNotFound)
+				return
+			}
+			f.writeError(w, http.StatusNotFound, "NoSuchKey")
+			return
+		}
+		status := http.StatusOK
+		if rangeHeader := r.Header.Get("Range"); rangeHeader != "" && r.Method == http.MethodGet {
+			var start, end int
+			_, err = fmt.Sscanf(rangeHeader, "bytes=%d-%d", &start, &end)
+			if err != nil || start >= len(data) {
+				f.writeError(w, http.StatusRequestedRangeNotSatisfiable, "InvalidRange")
+				return
+			}
+			if end >= len(data) {
+				end = len(data) - 1
+			}
+			w.Header().Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", start, end, len(data)))
+			data = data[start : end+1]
+			status = http.StatusPartialContent
+		}
+		w.Header().Set("Content-Length", fmt.Sprintf("%d", len(data)))
+		w.Header().Set("Content-Type", "application/octet-stream")
+		w.Header().Set("Last-Modified", modified)
+		w.Header().Set("ETag", "\"etag\"")
+		w.WriteHeader(status)
+		if r.Method == http.MethodGet {
+			f.sent += len(data)
+			w.Write(data)
+		}
+	case http.MethodDelete:
+		delete(f.objects, key)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		f.writeError(w, http.StatusNotImplemented, "NotImplemented")
+	}
+}
+
+func (f *fakeS3) list(w http.ResponseWriter, query url.Values) {
+	prefix := query.Get("prefix")
+	result := fakeS3ListResult{Name: f.bucket, Prefix: prefix, MaxKeys: 1000}
+	keys := make([]string, 0)
+	for key := range f.objects {
+		if strings.HasPrefix(key, prefix) {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		result.Contents = append(result.Contents, fakeS3Object{
+			Key:          key,
+			LastModified: "2024-01-01T00:00:00.000Z",
+			ETag:         "\"etag\"",
+			Size:         len(f.objects[key]),
+			StorageClass: "STANDARD",
+		})
+	}
+	result.KeyCount = len(result.Contents)
+	w.Header().Set("Content-Type", "application/xml")
+	w.WriteHeader(http.StatusOK)
+	xml.NewEncoder(w).Encode(result)
+}
+
+func newTestS3Store(t *testing.T, prefix string) (*S3Store, *fakeS3) {
+	fake := &fakeS3{bucket: "virtualpaper", objects: map[string][]byte{}}
+	server := httptest.NewTLSServer(fake)
+	t.Cleanup(server.Close)
+
+	endpoint, err := url.Parse(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	store, err := newS3Store(config.S3Storage{
+		Endpoint:  endpoint.Host,
+		Region:    "us-east-1",
+		Bucket:    "virtualpaper",
+		AccessKey: "access",
+		SecretKey: "secret",
+		Prefix:    prefix,
+		PathStyle: true,
+	}, server.Client().Transport)
+	if err != nil {
+		t.Fatalf("create s3 store: %v", err)
+	}
+	return store, fake
+}
+
+func TestS3Store(t *testing.T) {
+	store, fake := newTestS3Store(t, "instance-1")
+	testStore(t, store)
+
+	for key := range fake.objects {
+		if !strings.HasPrefix(key, "instance-1/") {
+			t.Errorf("object %s stored without prefix", key)
+		}
+	}
+}
+
+func TestEncryptedStore_StatS3(t *testing.T) {
+	ctx := context.Background()
+	raw, fake := newTestS3Store(t, "")
+	store := NewEncryptedStore(raw, &testKeys{owner: 5, keys: map[int][]byte{}})
+
+	content := strings.Repeat("a", 3*cryptChunkSize)
+	putBlob(t, store, "documents/a/b/encrypted", content)
+	putBlob(t, raw, "documents/a/b/plain", content)
+	putBlob(t, raw, "documents/a/b/empty", "")
+
+	fake.sent = 0
+	for _, key := range []string{"documents/a/b/encrypted", "documents/a/b/plain", "documents/a/b/empty"} {
+		info, err := store.Stat(ctx, key)
+		if err != nil {
+			t.Fatalf("Stat(%s): %v", key, err)
+		}
+		want := int64(len(content))
+		if key == "documents/a/b/empty" {
+			want = 0
+		}
+		if info.Size != want {
+			t.Errorf("Stat(%s) size = %d, want %d", key, info.Size, want)
+		}
+	}
+	if fake.sent > 2*len(cryptMagic) {
+		t.Errorf("Stat() read %d bytes of blobs, want only headers", fake.sent)
+	}
+
+	encrypted, err := IsEncrypted(ctx, raw, "documents/a/b/empty")
+	if err != nil || encrypted {
+		t.Errorf("IsEncrypted(empty) = %v, %v, want false", encrypted, err)
+	}
+}
+
+func TestMigrate_LocalToS3(t *testing.T) {
+	source := NewLocalStore(t.TempDir())
+	target, _ := newTestS3Store(t, "")
+
+	putBlob(t, source, "documents/a/b/cdef", "document")
+	putBlob(t, source, "previews/a/b/cdef.png", "preview")
+
+	result, err := Migrate(context.Background(), source, target, Prefixes, func(key string, err error) {
+		t.Errorf("migrate %s: %v", key, err)
+	})
+	if err != nil {
+		t.Fatalf("Migrate(): %v", err)
+	}
+	if result.Copied != 2 {
+		t.Errorf("Migrate() = %+v, want 2 copied", result)
+	}
+	if got := readBlob(t, target, "documents/a/b/cdef"); got != "document" {
+		t.Errorf("migrated document = %s, want document", got)
+	}
+}
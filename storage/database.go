@@ -9,6 +9,7 @@ import (
 	"github.com/jmoiron/sqlx"
 	_ "github.com/lib/pq"
 	"tryffel.net/go/virtualpaper/config"
+	"tryffel.net/go/virtualpaper/storage/blob"
 )
 
 // Database connects to postgresql database
@@ -16,14 +17,19 @@ import (
 type Database struct {
 	conn *sqlx.DB
 
-	UserStore     *UserStore
-	DocumentStore *DocumentStore
-	JobStore      *JobStore
-	MetadataStore *MetadataStore
-	StatsStore    *StatsStore
-	RuleStore     *RuleStore
-	AuthStore     *AuthStore
-	PropertyStore *PropertyStore
+	UserStore       *UserStore
+	DocumentStore   *DocumentStore
+	JobStore        *JobStore
+	MetadataStore   *MetadataStore
+	StatsStore      *StatsStore
+	RuleStore       *RuleStore
+	AuthStore       *AuthStore
+	PropertyStore   *PropertyStore
+	EncryptionStore *EncryptionStore
+	WebhookStore    *WebhookStore
+
+	// Files stores document files and previews.
+	Files blob.Store
 }
 
 func (d *Database) Exec(query string, args ...interface{}) (sql.Result, error) {
@@ -127,10 +133,24 @@ func NewDatabase(conf config.Database) (*Database, error) {
 	db.UserStore = newUserStore(db.conn)
 	db.DocumentStore = NewDocumentStore(db.conn, db.MetadataStore)
 	db.JobStore = newJobStore(db.conn)
-	db.StatsStore = NewStatsStore(db.conn)
+	db.Files, err = blob.NewStore(config.C.Storage.Backend)
+	if err != nil {
+		return db, fmt.Errorf("init file storage: %v", err)
+	}
+	db.StatsStore = NewStatsStore(db.conn, db.Files)
 	db.RuleStore = newRuleStore(db.conn, db.MetadataStore)
 	db.AuthStore = newAuthStore(db.conn)
 	db.PropertyStore = NewPropertyStore(db.conn)
+	db.EncryptionStore = newEncryptionStore(db.conn)
+	db.WebhookStore = newWebhookStore(db.conn)
+
+	if config.C.Storage.MasterKey != "" {
+		masterKey, err := blob.ParseKey(config.C.Storage.MasterKey)
+		if err != nil {
+			return db, fmt.Errorf("invalid storage master key: %v", err)
+		}
+		db.Files = blob.NewEncryptedStore(db.Files, newBlobKeys(db, masterKey))
+	}
 	return db, nil
 }
 
@@ -152,8 +172,11 @@ func NewMockDatabase(matcher sqlmock.QueryMatcher) (*Database, sqlmock.Sqlmock,
 	db.DocumentStore = NewDocumentStore(db.conn, db.MetadataStore)
 	db.JobStore = newJobStore(db.conn)
 	db.StatsStore = &StatsStore{db: db.conn}
+	db.RuleStore = newRuleStore(db.conn, db.MetadataStore)
 	db.AuthStore = newAuthStore(db.conn)
 	db.PropertyStore = NewPropertyStore(db.conn)
+	db.EncryptionStore = newEncryptionStore(db.conn)
+	db.WebhookStore = newWebhookStore(db.conn)
 	return db, mock, nil
 }
 
@@ -62,7 +62,7 @@ func (s *DocumentStore) GetDocuments(exec SqlExecer, userId int, paging Paging,
 		contentSelect = "content"
 	}
 
-	query := s.sq.Select("id, name, filename, documents.created_at as created_at, documents.updated_at as updated_at, hash, mimetype, size, date, description, lang, deleted_at, count(shares.user_id) as shares, favorite", contentSelect).
+	query := s.sq.Select("id, name, filename, documents.created_at as created_at, documents.updated_at as updated_at, hash, mimetype, size, date, description, lang, deleted_at, count(shares.user_id) as shares, favorite, page_count", contentSelect).
 		From("documents").
 		LeftJoin("user_shared_documents shares on documents.id = shares.document_id")
 
@@ -249,13 +249,13 @@ func (s *DocumentStore) GetByHash(userId int, hash string) (*models.Document, er
 
 func (s *DocumentStore) Create(exec SqlExecer, doc *models.Document) error {
 	sql := `
-INSERT INTO documents (id, user_id, name, content, filename, hash, mimetype, size, description, date, lang, favorite)
-	VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12) RETURNING id;`
+INSERT INTO documents (id, user_id, name, content, filename, hash, mimetype, size, description, date, lang, favorite, ocr_languages)
+	VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13) RETURNING id;`
 
 	doc.Init()
 
 	rows, err := s.db.Query(sql, doc.Id, doc.UserId, doc.Name, doc.Content, doc.Filename, doc.Hash, doc.Mimetype, doc.Size,
-		doc.Description, doc.Date, doc.Lang, doc.Favorite)
+		doc.Description, doc.Date, doc.Lang, doc.Favorite, doc.OcrLanguages)
 	if err != nil {
 		return s.parseError(err, "created")
 	}
@@ -296,14 +296,14 @@ func AddDocumentHistoryAction(exec SqlExecer, queryBuilder squirrel.StatementBui
 }
 
 // SetDocumentContent sets content for given document id
-func (s *DocumentStore) SetDocumentContent(id string, content string) error {
+func (s *DocumentStore) SetDocumentContent(exec SqlExecer, id string, content string) error {
 
 	sql := `
 UPDATE documents SET content=$2
 WHERE id=$1;
 `
 
-	_, err := s.db.Exec(sql, id, content)
+	_, err := exec.Exec(sql, id, content)
 	return s.parseError(err, "set content")
 }
 
@@ -351,6 +351,49 @@ WHERE id=$1
 	return err
 }
 
+// SetDocumentPages replaces the extracted text of document pages. Pages start from 1.
+func (s *DocumentStore) SetDocumentPages(exec SqlExecer, docId string, pages []string) error {
+	_, err := exec.ExecSq(s.sq.Delete("document_pages").Where("document_id = ?", docId))
+	if err != nil {
+		return s.parseError(err, "delete document pages")
+	}
+	if len(pages) == 0 {
+		return nil
+	}
+
+	query := s.sq.Insert("document_pages").Columns("document_id", "page", "content")
+	for i, v := range pages {
+		query = query.Values(docId, i+1, v)
+	}
+	_, err = exec.ExecSq(query)
+	return s.parseError(err, "insert document pages")
+}
+
+// GetDocumentPages returns the extracted text of document pages ordered by page.
+func (s *DocumentStore) GetDocumentPages(exec SqlExecer, docId string) ([]models.DocumentPage, error) {
+	query := s.sq.Select("document_id", "page", "content").
+		From("document_pages").
+		Where("document_id = ?", docId).
+		OrderBy("page ASC")
+	pages := make([]models.DocumentPage, 0)
+	err := exec.SelectSq(&pages, query)
+	return pages, s.parseError(err, "get document pages")
+}
+
+// SetOcrLanguages sets the languages used for OCR of the document. Empty languages removes the override.
+func (s *DocumentStore) SetOcrLanguages(exec SqlExecer, docId string, languages models.OcrLanguages) error {
+	query := s.sq.Update("documents").Set("ocr_languages", languages.String()).Where("id = ?", docId)
+	_, err := exec.ExecSq(query)
+	return s.parseError(err, "update ocr languages")
+}
+
+// SetPageCount sets the number of pages in document file.
+func (s *DocumentStore) SetPageCount(exec SqlExecer, docId string, pageCount int) error {
+	query := s.sq.Update("documents").Set("page_count", pageCount).Where("id = ?", docId)
+	_, err := exec.ExecSq(query)
+	return s.parseError(err, "update page count")
+}
+
 func (s *DocumentStore) SetModifiedAt(exec SqlExecer, docIds []string, modifiedAt time.Time) error {
 	query := s.sq.Update("documents").Set("updated_at", modifiedAt).Where(squirrel.Eq{"id": docIds})
 	sql, args, err := query.ToSql()
@@ -0,0 +1,80 @@
+/*
+ * Virtualpaper is a service to manage users paper documents in virtual format.
+ * Copyright (C) 2024  Tero Vierimaa
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package storage
+
+import (
+	"strconv"
+
+	"tryffel.net/go/virtualpaper/models"
+)
+
+// GetDocumentVersions returns all previous versions of the document, latest version first.
+func (s *DocumentStore) GetDocumentVersions(exec SqlExecer, docId string) (*[]models.DocumentVersion, error) {
+	query := s.sq.Select("dv.id", "dv.document_id", "dv.version", "coalesce(dv.user_id, 0) AS user_id",
+		"coalesce(u.name, 'Server') AS user", "dv.filename", "dv.hash", "dv.mimetype", "dv.size", "dv.created_at").
+		From("document_versions dv").
+		LeftJoin("users u ON dv.user_id = u.id").
+		Where("dv.document_id = ?", docId).
+		OrderBy("dv.version DESC")
+
+	data := &[]models.DocumentVersion{}
+	err := exec.SelectSq(data, query)
+	return data, s.parseError(err, "get document versions")
+}
+
+// GetDocumentVersion returns single version of the document.
+func (s *DocumentStore) GetDocumentVersion(exec SqlExecer, docId string, version int) (*models.DocumentVersion, error) {
+	query := s.sq.Select("dv.id", "dv.document_id", "dv.version", "coalesce(dv.user_id, 0) AS user_id",
+		"coalesce(u.name, 'Server') AS user", "dv.filename", "dv.hash", "dv.mimetype", "dv.size", "dv.created_at").
+		From("document_versions dv").
+		LeftJoin("users u ON dv.user_id = u.id").
+		Where("dv.document_id = ?", docId).
+		Where("dv.version = ?", version)
+
+	data := &models.DocumentVersion{}
+	err := exec.GetSq(data, query)
+	return data, s.parseError(err, "get document version")
+}
+
+// AddDocumentVersion stores the current file of the document as a new version and records
+// the action to document history. Version number is assigned to version.Version.
+func (s *DocumentStore) AddDocumentVersion(exec SqlExecer, userId int, version *models.DocumentVersion, action string, newValue string) error {
+	sql := `
+INSERT INTO document_versions (document_id, version, user_id, filename, hash, mimetype, size)
+SELECT $1, coalesce(max(version), 0) + 1, $2, $3, $4, $5, $6
+FROM document_versions WHERE document_id = $1
+RETURNING id, version, created_at;
+`
+	var user interface{}
+	if userId != UserIdInternal {
+		user = userId
+	}
+
+	err := exec.Get(version, sql, version.DocumentId, user, version.Filename, version.Hash, version.Mimetype, version.Size)
+	if err != nil {
+		return s.parseError(err, "add document version")
+	}
+
+	return AddDocumentHistoryAction(exec, s.sq, []models.DocumentHistory{{
+		DocumentId: version.DocumentId,
+		Action:     action,
+		OldValue:   strconv.Itoa(version.Version),
+		NewValue:   newValue,
+	}}, userId)
+}
@@ -0,0 +1,204 @@
+/*
+ * Virtualpaper is a service to manage users paper documents in virtual format.
+ * Copyright (C) 2024  Tero Vierimaa
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package storage
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/Masterminds/squirrel"
+	"github.com/jmoiron/sqlx"
+	"tryffel.net/go/virtualpaper/errors"
+	"tryffel.net/go/virtualpaper/models"
+	"tryffel.net/go/virtualpaper/storage/blob"
+)
+
+// EncryptionStore persists users' data keys, which are used to encrypt stored files.
+type EncryptionStore struct {
+	*resource
+	db *sqlx.DB
+	sq squirrel.StatementBuilderType
+}
+
+func newEncryptionStore(db *sqlx.DB) *EncryptionStore {
+	return &EncryptionStore{
+		resource: &resource{
+			name: "data key",
+			db:   db,
+		},
+		db: db,
+		sq: squirrel.StatementBuilder.PlaceholderFormat(squirrel.Dollar),
+	}
+}
+
+func (s *EncryptionStore) GetUserDataKey(exec SqlExecer, userId int) (*models.UserDataKey, error) {
+	query := s.sq.Select("*").From("user_data_keys").Where("user_id = ?", userId)
+	key := &models.UserDataKey{}
+	err := exec.GetSq(key, query)
+	if err != nil {
+		return nil, s.parseError(err, "get")
+	}
+	return key, nil
+}
+
+func (s *EncryptionStore) GetUserDataKeys(exec SqlExecer) (*[]models.UserDataKey, error) {
+	query := s.sq.Select("*").From("user_data_keys").OrderBy("user_id")
+	keys := &[]models.UserDataKey{}
+	err := exec.SelectSq(keys, query)
+	if err != nil {
+		return nil, s.parseError(err, "get all")
+	}
+	return keys, nil
+}
+
+// AddUserDataKey adds data key for the user. If user already has a key, existing key is kept.
+func (s *EncryptionStore) AddUserDataKey(exec SqlExecer, key *models.UserDataKey) error {
+	key.CreatedAt = time.Now()
+	key.UpdatedAt = key.CreatedAt
+	query := s.sq.Insert("user_data_keys").
+		Columns("user_id", "wrapped_key", "master_key_id", "created_at", "updated_at").
+		Values(key.UserId, key.WrappedKey, key.MasterKeyId, key.CreatedAt, key.UpdatedAt).
+		Suffix("ON CONFLICT (user_id) DO NOTHING")
+	_, err := exec.ExecSq(query)
+	return s.parseError(err, "add")
+}
+
+func (s *EncryptionStore) UpdateUserDataKey(exec SqlExecer, key *models.UserDataKey) error {
+	key.Update()
+	query := s.sq.Update("user_data_keys").
+		SetMap(map[string]interface{}{
+			"wrapped_key":   key.WrappedKey,
+			"master_key_id": key.MasterKeyId,
+			"updated_at":    key.UpdatedAt,
+		}).Where("user_id = ?", key.UserId)
+	_, err := exec.ExecSq(query)
+	return s.parseError(err, "update")
+}
+
+// RotateMasterKey re-encrypts all data keys with new master key. Data keys that are already encrypted
+// with new key are skipped, so rotation can be continued if it fails. Files do not need to be re-encrypted,
+// since data keys do not change.
+func (s *EncryptionStore) RotateMasterKey(exec SqlExecer, oldKey, newKey []byte) (int, error) {
+	keys, err := s.GetUserDataKeys(exec)
+	if err != nil {
+		return 0, err
+	}
+	oldId := blob.KeyId(oldKey)
+	newId := blob.KeyId(newKey)
+	rotated := 0
+	for _, v := range *keys {
+		if v.MasterKeyId == newId {
+			continue
+		}
+		if v.MasterKeyId != oldId {
+			return 0, fmt.Errorf("data key of user %d is encrypted with unknown master key (%s)", v.UserId, v.MasterKeyId)
+		}
+		key, err := blob.UnwrapKey(oldKey, v.WrappedKey)
+		if err != nil {
+			return 0, fmt.Errorf("user %d: %v", v.UserId, err)
+		}
+		v.WrappedKey, err = blob.WrapKey(newKey, key)
+		if err != nil {
+			return 0, err
+		}
+		v.MasterKeyId = newId
+		err = s.UpdateUserDataKey(exec, &v)
+		if err != nil {
+			return 0, err
+		}
+		rotated += 1
+	}
+	return rotated, nil
+}
+
+// blobKeys provides data keys for encrypting stored files. It implements blob.KeyProvider.
+type blobKeys struct {
+	db        *Database
+	masterKey []byte
+	lock      sync.Mutex
+	keys      map[int][]byte
+}
+
+func newBlobKeys(db *Database, masterKey []byte) *blobKeys {
+	return &blobKeys{
+		db:        db,
+		masterKey: masterKey,
+		keys:      map[int][]byte{},
+	}
+}
+
+func (k *blobKeys) BlobOwner(ctx context.Context, key string) (int, error) {
+	docId := DocumentIdFromKey(key)
+	if docId == "" {
+		return 0, fmt.Errorf("not a document file: %s", key)
+	}
+	doc, err := k.db.DocumentStore.GetDocument(k.db, docId)
+	if err != nil {
+		return 0, err
+	}
+	return doc.UserId, nil
+}
+
+func (k *blobKeys) DataKey(ctx context.Context, userId int, create bool) ([]byte, error) {
+	k.lock.Lock()
+	defer k.lock.Unlock()
+	if key, ok := k.keys[userId]; ok {
+		return key, nil
+	}
+
+	stored, err := k.db.EncryptionStore.GetUserDataKey(k.db, userId)
+	if errors.Is(err, errors.ErrRecordNotFound) && create {
+		stored, err = k.createDataKey(userId)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if stored.MasterKeyId != blob.KeyId(k.masterKey) {
+		return nil, fmt.Errorf("data key of user %d is encrypted with another master key (%s)", userId, stored.MasterKeyId)
+	}
+	key, err := blob.UnwrapKey(k.masterKey, stored.WrappedKey)
+	if err != nil {
+		return nil, err
+	}
+	k.keys[userId] = key
+	return key, nil
+}
+
+func (k *blobKeys) createDataKey(userId int) (*models.UserDataKey, error) {
+	key, err := blob.GenerateKey()
+	if err != nil {
+		return nil, fmt.Errorf("generate data key: %v", err)
+	}
+	wrapped, err := blob.WrapKey(k.masterKey, key)
+	if err != nil {
+		return nil, err
+	}
+	err = k.db.EncryptionStore.AddUserDataKey(k.db, &models.UserDataKey{
+		UserId:      userId,
+		WrappedKey:  wrapped,
+		MasterKeyId: blob.KeyId(k.masterKey),
+	})
+	if err != nil {
+		return nil, err
+	}
+	// read the key back in case another instance created it at the same time
+	return k.db.EncryptionStore.GetUserDataKey(k.db, userId)
+}
@@ -28,10 +28,10 @@ import (
 	"tryffel.net/go/virtualpaper/config"
 )
 
-// DocumentPath returns path for document by its id. Function
-// splits documents to 2-level directories inside config.C.Processing.DocumentsDir.
+// DocumentKey returns blob key for document file by its id. Function
+// splits documents to 2-level directories inside 'documents'.
 // Id must be at least 3 characters long, else empty string is returned.
-func DocumentPath(documentId string) string {
+func DocumentKey(documentId string) string {
 	if len(documentId) < 3 {
 		return ""
 	}
@@ -39,27 +39,43 @@ func DocumentPath(documentId string) string {
 	dir0 := string(documentId[0])
 	dir1 := string(documentId[1])
 	rest := documentId[2:]
-	out := path.Join(config.C.Processing.DocumentsDir, dir0, dir1, rest)
-	return out
+	return path.Join("documents", dir0, dir1, rest)
 }
 
-// CreateDocumentDir creates (if not yet existing) directory for document.
-func CreateDocumentDir(documentId string) error {
-	path := path.Dir(DocumentPath(documentId))
-	err := os.MkdirAll(path, 0755|os.ModeSetgid|os.ModeSetuid)
-	if err != nil {
-		if errors.Is(err, os.ErrExist) {
-			return nil
-		}
-		return err
+// DocumentVersionKey returns blob key for a previous version of document file. Versions are stored
+// next to the current file with suffix '.v<version>'.
+func DocumentVersionKey(documentId string, version int) string {
+	key := DocumentKey(documentId)
+	if key == "" {
+		return ""
 	}
-	return nil
+	return fmt.Sprintf("%s.v%d", key, version)
 }
 
-// DocumentPath returns path for document preview by its id. Function
-// splits previews to 2-level directories inside config.C.Processing.PreviewsDir.
+// SearchablePdfKey returns blob key for searchable pdf generated from document file. It is stored next to
+// the document file with suffix '.searchable.pdf'.
+func SearchablePdfKey(documentId string) string {
+	key := DocumentKey(documentId)
+	if key == "" {
+		return ""
+	}
+	return key + ".searchable.pdf"
+}
+
+// PreprocessedKey returns blob key for preprocessed (rotated, deskewed) pdf generated from document file.
+// It is stored next to the document file with suffix '.preprocessed.pdf'.
+func PreprocessedKey(documentId string) string {
+	key := DocumentKey(documentId)
+	if key == "" {
+		return ""
+	}
+	return key + ".preprocessed.pdf"
+}
+
+// PreviewKey returns blob key for document preview by its id. Function
+// splits previews to 2-level directories inside 'previews'.
 // Id must be at least 3 characters long, else empty string is returned.
-func PreviewPath(documentId string) string {
+func PreviewKey(documentId string) string {
 	if len(documentId) < 3 {
 		return ""
 	}
@@ -67,21 +83,52 @@ func PreviewPath(documentId string) string {
 	dir0 := string(documentId[0])
 	dir1 := string(documentId[1])
 	rest := documentId[2:]
-	out := path.Join(config.C.Processing.PreviewsDir, dir0, dir1, rest) + ".png"
-	return out
+	return path.Join("previews", dir0, dir1, rest) + ".png"
 }
 
-// CreatePreviewDir creates (if not yet existing) directory for preview.
-func CreatePreviewDir(documentId string) error {
-	path := path.Dir(PreviewPath(documentId))
-	err := os.MkdirAll(path, 0755|os.ModeSetgid|os.ModeSetuid)
-	if err != nil {
-		if errors.Is(err, os.ErrExist) {
-			return nil
-		}
-		return err
+// PagePreviewPrefix returns blob key prefix for all page previews of the document. Page previews
+// are stored in a directory next to the document preview.
+// Id must be at least 3 characters long, else empty string is returned.
+func PagePreviewPrefix(documentId string) string {
+	if len(documentId) < 3 {
+		return ""
+	}
+
+	dir0 := string(documentId[0])
+	dir1 := string(documentId[1])
+	rest := documentId[2:]
+	return path.Join("previews", dir0, dir1, rest) + "/"
+}
+
+// PagePreviewKey returns blob key for preview of single page with given size. Pages start from 1.
+// Id must be at least 3 characters long, else empty string is returned.
+func PagePreviewKey(documentId string, page int, size string) string {
+	prefix := PagePreviewPrefix(documentId)
+	if prefix == "" {
+		return ""
+	}
+	return fmt.Sprintf("%s%d-%s.png", prefix, page, size)
+}
+
+// DocumentIdFromKey returns document id for blob key returned by DocumentKey, DocumentVersionKey, PreviewKey
+// or PagePreviewKey. Any suffix after the id is ignored. If key is not a document key, empty string is returned.
+func DocumentIdFromKey(key string) string {
+	parts := strings.Split(key, "/")
+	if len(parts) == 5 && parts[0] == "previews" {
+		// page preview
+		parts = parts[:4]
+	}
+	if len(parts) != 4 || (parts[0] != "documents" && parts[0] != "previews") {
+		return ""
+	}
+	if len(parts[1]) != 1 || len(parts[2]) != 1 {
+		return ""
+	}
+	rest, _, _ := strings.Cut(parts[3], ".")
+	if rest == "" {
+		return ""
 	}
-	return nil
+	return parts[1] + parts[2] + rest
 }
 
 // TempFilePath returns filename in temporary directory for given id.
@@ -20,16 +20,9 @@ package storage
 
 import (
 	"testing"
-	"tryffel.net/go/virtualpaper/config"
 )
 
-func TestDocumentPath(t *testing.T) {
-	config.C = &config.Config{
-		Processing: config.Processing{
-			DocumentsDir: "/data/documents",
-		},
-	}
-
+func TestDocumentKey(t *testing.T) {
 	type args struct {
 		documentId string
 	}
@@ -40,7 +33,7 @@ func TestDocumentPath(t *testing.T) {
 	}{
 		{
 			args: args{documentId: "3f24f12f-7977-4bae-8a22-3a304397b979"},
-			want: "/data/documents/3/f/24f12f-7977-4bae-8a22-3a304397b979",
+			want: "documents/3/f/24f12f-7977-4bae-8a22-3a304397b979",
 		},
 		{
 			args: args{documentId: "3f"},
@@ -49,22 +42,44 @@ func TestDocumentPath(t *testing.T) {
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			if got := DocumentPath(tt.args.documentId); got != tt.want {
-				t.Errorf("DocumentPath() = %v, want %v", got, tt.want)
+			if got := DocumentKey(tt.args.documentId); got != tt.want {
+				t.Errorf("DocumentKey() = %v, want %v", got, tt.want)
 			}
 		})
 	}
 }
 
-func TestPreviewPath(t *testing.T) {
-	config.C = &config.Config{
-		Processing: config.Processing{
-			PreviewsDir: "/data/previews",
+func TestPreviewKey(t *testing.T) {
+	type args struct {
+		documentId string
+	}
+	tests := []struct {
+		name string
+		args args
+		want string
+	}{
+		{
+			args: args{documentId: "3f24f12f-7977-4bae-8a22-3a304397b979"},
+			want: "previews/3/f/24f12f-7977-4bae-8a22-3a304397b979.png",
+		},
+		{
+			args: args{documentId: ""},
+			want: "",
 		},
 	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := PreviewKey(tt.args.documentId); got != tt.want {
+				t.Errorf("PreviewKey() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
 
+func TestDocumentVersionKey(t *testing.T) {
 	type args struct {
 		documentId string
+		version    int
 	}
 	tests := []struct {
 		name string
@@ -72,18 +87,64 @@ func TestPreviewPath(t *testing.T) {
 		want string
 	}{
 		{
-			args: args{documentId: "3f24f12f-7977-4bae-8a22-3a304397b979"},
-			want: "/data/previews/3/f/24f12f-7977-4bae-8a22-3a304397b979.png",
+			args: args{documentId: "3f24f12f-7977-4bae-8a22-3a304397b979", version: 2},
+			want: "documents/3/f/24f12f-7977-4bae-8a22-3a304397b979.v2",
 		},
 		{
-			args: args{documentId: ""},
+			args: args{documentId: "3f", version: 1},
 			want: "",
 		},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			if got := PreviewPath(tt.args.documentId); got != tt.want {
-				t.Errorf("PreviewPath() = %v, want %v", got, tt.want)
+			if got := DocumentVersionKey(tt.args.documentId, tt.args.version); got != tt.want {
+				t.Errorf("DocumentVersionKey() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPagePreviewKey(t *testing.T) {
+	tests := []struct {
+		name       string
+		documentId string
+		page       int
+		size       string
+		want       string
+	}{
+		{"valid", "3f24f12f-7977-4bae-8a22-3a304397b979", 1, "medium", "previews/3/f/24f12f-7977-4bae-8a22-3a304397b979/1-medium.png"},
+		{"short id", "ab", 1, "medium", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := PagePreviewKey(tt.documentId, tt.page, tt.size); got != tt.want {
+				t.Errorf("PagePreviewKey() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDocumentIdFromKey(t *testing.T) {
+	id := "3f24f12f-7977-4bae-8a22-3a304397b979"
+	tests := []struct {
+		name string
+		key  string
+		want string
+	}{
+		{name: "document", key: DocumentKey(id), want: id},
+		{name: "version", key: DocumentVersionKey(id, 3), want: id},
+		{name: "preview", key: PreviewKey(id), want: id},
+		{name: "page preview", key: PagePreviewKey(id, 2, "small"), want: id},
+		{name: "searchable pdf", key: SearchablePdfKey(id), want: id},
+		{name: "preprocessed pdf", key: PreprocessedKey(id), want: id},
+		{name: "temporary file", key: DocumentKey(id) + ".encrypting", want: id},
+		{name: "other prefix", key: "other/3/f/24f12f", want: ""},
+		{name: "invalid", key: "documents/3f/24f12f", want: ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := DocumentIdFromKey(tt.key); got != tt.want {
+				t.Errorf("DocumentIdFromKey() = %v, want %v", got, tt.want)
 			}
 		})
 	}
@@ -137,6 +137,76 @@ var Migrations = []Migrator{
 		Level:  22,
 		Schema: schemaV22,
 	},
+	&Migration{
+		Name:   "add document versions table",
+		Level:  23,
+		Schema: schemaV23,
+	},
+	&Migration{
+		Name:   "add user data keys table",
+		Level:  24,
+		Schema: schemaV24,
+	},
+	&Migration{
+		Name:   "add webhook tables",
+		Level:  25,
+		Schema: schemaV25,
+	},
+	&Migration{
+		Name:   "add share and property columns to rule actions",
+		Level:  26,
+		Schema: schemaV26,
+	},
+	&Migration{
+		Name:   "add property column to rule conditions",
+		Level:  27,
+		Schema: schemaV27,
+	},
+	&Migration{
+		Name:   "add rule condition groups",
+		Level:  28,
+		Schema: schemaV28,
+	},
+	&Migration{
+		Name:   "add document page count",
+		Level:  29,
+		Schema: schemaV29,
+	},
+	&Migration{
+		Name:   "add document pages",
+		Level:  30,
+		Schema: schemaV30,
+	},
+	&Migration{
+		Name:   "add document ocr languages",
+		Level:  31,
+		Schema: schemaV31,
+	},
+	&Migration{
+		Name:   "add api tokens",
+		Level:  32,
+		Schema: schemaV32,
+	},
+	&Migration{
+		Name:   "add user identities",
+		Level:  33,
+		Schema: schemaV33,
+	},
+	&Migration{
+		Name:   "add totp",
+		Level:  34,
+		Schema: schemaV34,
+	},
+	&Migration{
+		Name:   "add document share links",
+		Level:  35,
+		Schema: schemaV35,
+	},
+	&Migration{
+		Name:   "add generated property values",
+		Level:  36,
+		Schema: schemaV36,
+	},
 }
 
 type Schema struct {
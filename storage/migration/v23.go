@@ -0,0 +1,36 @@
+/*
+ * Virtualpaper is a service to manage users paper documents in virtual format.
+ * Copyright (C) 2024  Tero Vierimaa
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+package migration
+
+const schemaV23 = `
+CREATE TABLE document_versions (
+    id SERIAL PRIMARY KEY,
+    document_id TEXT NOT NULL,
+    version INT NOT NULL,
+    user_id INT,
+    filename TEXT NOT NULL DEFAULT '',
+    hash TEXT NOT NULL DEFAULT '',
+    mimetype TEXT NOT NULL DEFAULT '',
+    size BIGINT NOT NULL DEFAULT 0,
+    created_at TIMESTAMPTZ DEFAULT now(),
+
+	CONSTRAINT fk_document_id FOREIGN KEY(document_id) REFERENCES documents(id) ON DELETE CASCADE,
+	CONSTRAINT fk_user_id FOREIGN KEY(user_id) REFERENCES users(id) ON DELETE SET NULL,
+	CONSTRAINT c_document_version_unique UNIQUE (document_id, version)
+);
+`
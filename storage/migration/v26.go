@@ -0,0 +1,34 @@
+/*
+ * Virtualpaper is a service to manage users paper documents in virtual format.
+ * Copyright (C) 2024  Tero Vierimaa
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package migration
+
+const schemaV26 = `
+ALTER TABLE rule_actions
+ADD COLUMN share_user_id INT DEFAULT NULL,
+ADD COLUMN permissions JSONB DEFAULT NULL,
+ADD COLUMN property_id INT DEFAULT NULL,
+ADD CONSTRAINT fk_share_user_id
+	FOREIGN KEY (share_user_id)
+	REFERENCES users(id)
+	ON DELETE CASCADE,
+ADD CONSTRAINT fk_property_id
+	FOREIGN KEY (property_id)
+	REFERENCES properties(id)
+	ON DELETE CASCADE;
+`
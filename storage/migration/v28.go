@@ -0,0 +1,51 @@
+/*
+ * Virtualpaper is a service to manage users paper documents in virtual format.
+ * Copyright (C) 2024  Tero Vierimaa
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package migration
+
+const schemaV28 = `
+CREATE TABLE rule_condition_groups (
+    id SERIAL PRIMARY KEY,
+    rule_id INT NOT NULL,
+    parent_id INT DEFAULT NULL,
+    mode INT NOT NULL DEFAULT 1,
+    inverted BOOLEAN NOT NULL DEFAULT FALSE,
+    group_order INT NOT NULL DEFAULT 0,
+
+	CONSTRAINT fk_rule FOREIGN KEY(rule_id)
+        REFERENCES rules(id) ON DELETE CASCADE,
+	CONSTRAINT fk_parent FOREIGN KEY(parent_id)
+        REFERENCES rule_condition_groups(id) ON DELETE CASCADE
+);
+
+ALTER TABLE rule_conditions
+ADD COLUMN group_id INT DEFAULT NULL,
+ADD CONSTRAINT fk_group_id
+	FOREIGN KEY (group_id)
+	REFERENCES rule_condition_groups(id)
+	ON DELETE CASCADE;
+
+-- existing rules have flat conditions, convert them to a single root group with rule's mode.
+INSERT INTO rule_condition_groups (rule_id, mode)
+SELECT id, mode FROM rules;
+
+UPDATE rule_conditions
+SET group_id = g.id
+FROM rule_condition_groups g
+WHERE g.rule_id = rule_conditions.rule_id;
+`
@@ -0,0 +1,37 @@
+/*
+ * Virtualpaper is a service to manage users paper documents in virtual format.
+ * Copyright (C) 2024  Tero Vierimaa
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package migration
+
+const schemaV30 = `
+CREATE TABLE document_pages (
+    document_id TEXT NOT NULL,
+    page INT NOT NULL,
+    content TEXT NOT NULL DEFAULT '',
+
+	PRIMARY KEY (document_id, page),
+	CONSTRAINT fk_document_id FOREIGN KEY(document_id) REFERENCES documents(id) ON DELETE CASCADE
+);
+
+-- split existing content to pages by the page markers of OCR and form feeds of pdftotext.
+INSERT INTO document_pages (document_id, page, content)
+SELECT d.id, p.page, p.content
+FROM documents d,
+	regexp_split_to_table(d.content, E'\\f|\n\n\\(Page [0-9]+\\)\n\n') WITH ORDINALITY AS p(content, page)
+WHERE p.content <> '';
+`
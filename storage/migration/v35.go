@@ -0,0 +1,55 @@
+/*
+ * Virtualpaper is a service to manage users paper documents in virtual format.
+ * Copyright (C) 2024  Tero Vierimaa
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package migration
+
+const schemaV35 = `
+CREATE TABLE document_share_links (
+    id SERIAL PRIMARY KEY,
+    document_id TEXT NOT NULL,
+    user_id INT NOT NULL,
+    name TEXT NOT NULL DEFAULT '',
+    token_hash TEXT NOT NULL UNIQUE,
+    password TEXT NOT NULL DEFAULT '',
+    allow_content BOOLEAN NOT NULL DEFAULT FALSE,
+    expires_at TIMESTAMPTZ NOT NULL,
+    access_count INT NOT NULL DEFAULT 0,
+    last_accessed_at TIMESTAMPTZ,
+    revoked_at TIMESTAMPTZ,
+    created_at TIMESTAMPTZ DEFAULT now(),
+    updated_at TIMESTAMPTZ DEFAULT now(),
+
+	CONSTRAINT fk_document_id FOREIGN KEY(document_id) REFERENCES documents(id) ON DELETE CASCADE,
+	CONSTRAINT fk_user_id FOREIGN KEY(user_id) REFERENCES users(id) ON DELETE CASCADE
+);
+
+CREATE INDEX document_share_links_document_id ON document_share_links(document_id);
+
+CREATE TABLE document_share_link_access (
+    id SERIAL PRIMARY KEY,
+    link_id INT NOT NULL,
+    action TEXT NOT NULL,
+    ip_address TEXT NOT NULL DEFAULT '',
+    user_agent TEXT NOT NULL DEFAULT '',
+    created_at TIMESTAMPTZ DEFAULT now(),
+
+	CONSTRAINT fk_link_id FOREIGN KEY(link_id) REFERENCES document_share_links(id) ON DELETE CASCADE
+);
+
+CREATE INDEX document_share_link_access_link_id ON document_share_link_access(link_id);
+`
@@ -0,0 +1,27 @@
+/*
+ * Virtualpaper is a service to manage users paper documents in virtual format.
+ * Copyright (C) 2024  Tero Vierimaa
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package migration
+
+// Values that field extraction and barcode detection stored earlier were recognized by their description.
+const schemaV36 = `
+ALTER TABLE document_properties ADD COLUMN generated_by TEXT NOT NULL DEFAULT '';
+
+UPDATE document_properties SET generated_by = 'extract-fields' WHERE description = 'extracted from content';
+UPDATE document_properties SET generated_by = 'barcodes' WHERE description ~ '^\S+, page \d+$';
+`
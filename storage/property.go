@@ -47,6 +47,17 @@ func (store *PropertyStore) GetProperty(execer SqlExecer, id int) (*models.Prope
 	return property, nil
 }
 
+// GetPropertyByName returns user's property with given name.
+func (store *PropertyStore) GetPropertyByName(execer SqlExecer, userId int, name string) (*models.Property, error) {
+	query := store.sq.Select("*").From("properties").Where("user_id = ?", userId).Where("name = ?", name)
+	property := &models.Property{}
+	err := execer.GetSq(property, query)
+	if err != nil {
+		return nil, store.parseError(err, "get by name")
+	}
+	return property, nil
+}
+
 func (store *PropertyStore) GetProperties(execer SqlExecer, userId int, paging Paging, sort SortKey) (*[]models.Property, error) {
 	sort.Validate("name")
 
@@ -154,10 +165,13 @@ func (store *PropertyStore) UpdateProperty(execer SqlExecer, property *models.Pr
 	return nil
 }
 
-func (store *PropertyStore) AddDocumentProperty(execer SqlExecer, property *models.Property, documentId, value, description string, updateProperty bool) error {
+// AddDocumentProperty adds value of the property to document. GeneratedBy is the processing step that created
+// the value, or empty.
+func (store *PropertyStore) AddDocumentProperty(execer SqlExecer, property *models.Property, documentId, value, description string,
+	generatedBy models.ProcessStep, updateProperty bool) error {
 	query := store.sq.Insert("document_properties").
-		Columns("document_id", "property_id", "user_id", "value", "description", "is_unique", "is_exclusive", "global").
-		Values(documentId, property.Id, property.User, value, description, property.Unique, property.Exclusive, property.Global)
+		Columns("document_id", "property_id", "user_id", "value", "description", "is_unique", "is_exclusive", "global", "generated_by").
+		Values(documentId, property.Id, property.User, value, description, property.Unique, property.Exclusive, property.Global, generatedBy)
 
 	_, err := execer.ExecSq(query)
 	if err != nil {
@@ -170,10 +184,23 @@ func (store *PropertyStore) AddDocumentProperty(execer SqlExecer, property *mode
 	return nil
 }
 
+// PropertyValueExists returns true if any document has the value for the property.
+func (store *PropertyStore) PropertyValueExists(execer SqlExecer, propertyId int, value string) (bool, error) {
+	query := store.sq.Select("count(*)").From("document_properties").
+		Where("property_id = ?", propertyId).
+		Where("value = ?", value)
+	count := 0
+	err := execer.GetSq(&count, query)
+	if err != nil {
+		return false, store.parseError(err, "property value exists")
+	}
+	return count > 0, nil
+}
+
 func (store *PropertyStore) GetDocumentProperties(execer SqlExecer, documentId string) (*[]models.DocumentProperty, error) {
 	data := &[]models.DocumentProperty{}
 	query := store.sq.Select("dp.id as id", "dp.document_id as document_id", "dp.property_id as property_id",
-		"dp.value as value", "dp.description as description",
+		"dp.value as value", "dp.description as description", "dp.generated_by as generated_by",
 		"dp.created_at as created_at", "dp.updated_at as updated_at", "p.name as property_name").
 		From("document_properties dp").
 		LeftJoin("properties p ON dp.property_id = p.id").
@@ -218,14 +245,18 @@ func (store *PropertyStore) UpdateDocumentProperties(execer SqlExecer, propertie
 	return nil
 }
 
+// UpdateDocumentProperty updates value that user edited. Value is not considered generated anymore, so that
+// it is kept when processing is run again.
 func (store *PropertyStore) UpdateDocumentProperty(execer SqlExecer, property *models.DocumentProperty) error {
 	property.Update()
+	property.GeneratedBy = ""
 	query := store.sq.Update("document_properties").SetMap(map[string]interface{}{
-		"document_id": property.Document,
-		"property_id": property.Property,
-		"value":       property.Value,
-		"description": property.Description,
-		"updated_at":  property.UpdatedAt,
+		"document_id":  property.Document,
+		"property_id":  property.Property,
+		"value":        property.Value,
+		"description":  property.Description,
+		"generated_by": property.GeneratedBy,
+		"updated_at":   property.UpdatedAt,
 	}).Where("id = ?", property.Id)
 	_, err := execer.ExecSq(query)
 	if err != nil {
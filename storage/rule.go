@@ -148,6 +148,9 @@ func (s *RuleStore) AddRule(execer SqlExecer, userId int, rule *models.Rule) err
 		return err
 	}
 
+	if rule.ConditionGroup != nil {
+		rule.Mode = rule.ConditionGroup.Mode
+	}
 	// insert rule
 	query := s.sq.Insert("rules").
 		Columns("user_id", "name", "description", "enabled", "rule_order", "mode", "triggers").
@@ -165,7 +168,7 @@ func (s *RuleStore) AddRule(execer SqlExecer, userId int, rule *models.Rule) err
 		return fmt.Errorf("add actions: %v", err)
 	}
 
-	err = s.addConditionsToRule(execer, rule.Id, rule.Conditions)
+	err = s.addConditionGroup(execer, rule.Id, rule.ConditionTree(), 0, 0)
 	if err != nil {
 		return fmt.Errorf("add conditions: %v", err)
 	}
@@ -211,6 +214,7 @@ func (s *RuleStore) getUserRuleConditionsForRules(userId int, rules []*models.Ru
 SELECT
     rule_conditions.id AS id,
     rule_id,
+    group_id,
     rule_conditions.enabled AS enabled,
     case_insensitive,
     inverted_match,
@@ -221,11 +225,16 @@ SELECT
     metadata_value,
     mk.key as metadata_key_name,
     mv.value as metadata_value_name,
-	date_fmt
+	rule_conditions.date_fmt as date_fmt,
+    property_id,
+    p.name as property_name,
+    p.type as property_type,
+    p.date_fmt as property_date_fmt
 FROM rule_conditions
 	LEFT JOIN rules ON rule_conditions.rule_id = rules.id
 	LEFT join metadata_keys mk on rule_conditions.metadata_key = mk.id
 	LEFT JOIN metadata_values mv on rule_conditions.metadata_value = mv.id
+    LEFT JOIN properties p on rule_conditions.property_id = p.id
 WHERE rules.user_id = $1
 ORDER BY rule_id, rule_conditions.id ASC;
 `
@@ -235,7 +244,20 @@ ORDER BY rule_id, rule_conditions.id ASC;
 	if err != nil {
 		return s.parseError(err, "get rule conditions")
 	}
-	mapConditionsToRules(rules, conditions)
+
+	sql = `
+SELECT rule_condition_groups.*
+FROM rule_condition_groups
+	LEFT JOIN rules ON rule_condition_groups.rule_id = rules.id
+WHERE rules.user_id = $1
+ORDER BY rule_id, group_order, rule_condition_groups.id ASC;
+`
+	groups := &[]models.RuleConditionGroup{}
+	err = s.db.Select(groups, sql, userId)
+	if err != nil {
+		return s.parseError(err, "get rule condition groups")
+	}
+	mapConditionsToRules(rules, conditions, groups)
 	return nil
 }
 
@@ -252,11 +274,18 @@ SELECT
     metadata_key,
     metadata_value,
 	mk.key as metadata_key_name,
-    mv.value as metadata_value_name
+    mv.value as metadata_value_name,
+    share_user_id,
+    u.name as share_user_name,
+    permissions,
+    property_id,
+    p.name as property_name
 FROM rule_actions
 	LEFT JOIN rules ON rule_actions.rule_id = rules.id
 	LEFT join metadata_keys mk on rule_actions.metadata_key = mk.id
     LEFT JOIN metadata_values mv on rule_actions.metadata_value = mv.id
+    LEFT JOIN users u on rule_actions.share_user_id = u.id
+    LEFT JOIN properties p on rule_actions.property_id = p.id
 WHERE rules.user_id = $1
 ORDER BY rule_id, rule_actions.id ASC;
 `
@@ -285,7 +314,7 @@ func (s *RuleStore) UserOwnsRule(userId, ruleId int) (bool, error) {
 }
 
 // UpdateRule updates rule.
-func (s *RuleStore) UpdateRule(exec ExecerSq, userId int, rule *models.Rule) error {
+func (s *RuleStore) UpdateRule(exec SqlExecer, userId int, rule *models.Rule) error {
 	owns, err := s.UserOwnsRule(userId, rule.Id)
 	if err != nil {
 		return err
@@ -301,6 +330,9 @@ func (s *RuleStore) UpdateRule(exec ExecerSq, userId int, rule *models.Rule) err
 		return err
 	}
 
+	if rule.ConditionGroup != nil {
+		rule.Mode = rule.ConditionGroup.Mode
+	}
 	rule.Update()
 	query := s.sq.Update("rules").SetMap(map[string]interface{}{
 		"name":        rule.Name,
@@ -324,13 +356,17 @@ func (s *RuleStore) UpdateRule(exec ExecerSq, userId int, rule *models.Rule) err
 	if err != nil {
 		return err
 	}
+	_, err = exec.ExecSq(s.sq.Delete("rule_condition_groups").Where("rule_id = ?", rule.Id))
+	if err != nil {
+		return err
+	}
 
 	err = s.addActionsToRule(exec, rule.Id, rule.Actions)
 	if err != nil {
 		return fmt.Errorf("add actions: %v", err)
 	}
 
-	err = s.addConditionsToRule(exec, rule.Id, rule.Conditions)
+	err = s.addConditionGroup(exec, rule.Id, rule.ConditionTree(), 0, 0)
 	if err != nil {
 		return fmt.Errorf("add conditions: %v", err)
 	}
@@ -356,7 +392,7 @@ func (s *RuleStore) validateRule(userId int, rule *models.Rule) error {
 	}
 
 	metadata := make([]models.Metadata, 0, 5)
-	for _, v := range rule.Conditions {
+	for _, v := range rule.ConditionTree().AllConditions() {
 		if v.MetadataValue > 0 && v.MetadataKey > 0 {
 			m := models.Metadata{
 				KeyId:   int(v.MetadataKey),
@@ -381,15 +417,81 @@ func (s *RuleStore) validateRule(userId int, rule *models.Rule) error {
 			return err
 		}
 	}
+
+	for _, v := range rule.Actions {
+		if v.Action == models.RuleActionShare {
+			if int(v.ShareUserId) == userId {
+				userErr := errors.ErrInvalid
+				userErr.ErrMsg = "cannot share with self"
+				return userErr
+			}
+			exists := false
+			err := s.db.Get(&exists, "SELECT EXISTS(SELECT id FROM users WHERE id = $1 AND active = TRUE)", v.ShareUserId)
+			if err != nil {
+				return s.parseError(err, "check user exists")
+			}
+			if !exists {
+				userErr := errors.ErrInvalid
+				userErr.ErrMsg = fmt.Sprintf("user %d not found", v.ShareUserId)
+				return userErr
+			}
+		} else if v.Action == models.RuleActionAddProperty {
+			_, err := s.getRuleProperty(userId, int(v.PropertyId))
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	for _, v := range rule.ConditionTree().AllConditions() {
+		if !v.ConditionType.IsPropertyCondition() {
+			continue
+		}
+		property, err := s.getRuleProperty(userId, int(v.PropertyId))
+		if err != nil {
+			return err
+		}
+		if v.ConditionType == models.RuleConditionPropertyLessThan || v.ConditionType == models.RuleConditionPropertyMoreThan {
+			switch property.Type {
+			case models.IntProperty, models.FloatProperty, models.CounterProperty, models.DateProperty:
+			default:
+				userErr := errors.ErrInvalid
+				userErr.ErrMsg = fmt.Sprintf("property '%s' of type %s cannot be compared", property.Name, property.Type)
+				return userErr
+			}
+		}
+	}
 	return nil
 }
 
+// getRuleProperty returns property that user can use in rules.
+func (s *RuleStore) getRuleProperty(userId int, propertyId int) (*models.Property, error) {
+	property := &models.Property{}
+	err := s.db.Get(property, "SELECT * FROM properties WHERE id = $1 AND (user_id = $2 OR global = TRUE)", propertyId, userId)
+	if err != nil {
+		err = s.parseError(err, "get property")
+		if errors.Is(err, errors.ErrRecordNotFound) {
+			userErr := errors.ErrInvalid
+			userErr.ErrMsg = fmt.Sprintf("property %d not found", propertyId)
+			return nil, userErr
+		}
+		return nil, err
+	}
+	return property, nil
+}
+
 func (s *RuleStore) addActionsToRule(exec ExecerSq, ruleId int, actions []*models.RuleAction) error {
 	query := s.sq.Insert("rule_actions").
-		Columns("rule_id", "enabled", "on_condition", "action", "value", "metadata_key", "metadata_value")
+		Columns("rule_id", "enabled", "on_condition", "action", "value", "metadata_key", "metadata_value",
+			"share_user_id", "permissions", "property_id")
 
 	for _, v := range actions {
-		query = query.Values(ruleId, v.Enabled, v.OnCondition, v.Action, v.Value, v.MetadataKey, v.MetadataValue)
+		var permissions interface{}
+		if v.Action == models.RuleActionShare {
+			permissions = v.Permissions
+		}
+		query = query.Values(ruleId, v.Enabled, v.OnCondition, v.Action, v.Value, v.MetadataKey, v.MetadataValue,
+			v.ShareUserId, permissions, v.PropertyId)
 	}
 
 	_, err := exec.ExecSq(query)
@@ -399,35 +501,92 @@ func (s *RuleStore) addActionsToRule(exec ExecerSq, ruleId int, actions []*model
 	return nil
 }
 
-func (s *RuleStore) addConditionsToRule(exec ExecerSq, ruleId int, conditions []*models.RuleCondition) error {
-	if len(conditions) == 0 {
-		return nil
+// addConditionGroup inserts condition group with its conditions and subgroups recursively.
+func (s *RuleStore) addConditionGroup(exec SqlExecer, ruleId int, group *models.RuleConditionGroup, parentId models.IntId, order int) error {
+	query := s.sq.Insert("rule_condition_groups").
+		Columns("rule_id", "parent_id", "mode", "inverted", "group_order").
+		Values(ruleId, parentId, group.Mode, group.Inverted, order).
+		Suffix("RETURNING \"id\"")
+	var id int
+	err := exec.GetSq(&id, query)
+	if err != nil {
+		return getDatabaseError(err, s, "insert rule condition group")
 	}
-	query := s.sq.Insert("rule_conditions").
-		Columns("rule_id", "enabled", "case_insensitive", "inverted_match", "condition_type",
-			"is_regex", "value", "date_fmt", "metadata_key", "metadata_value")
+	group.Id = id
+	group.RuleId = ruleId
+	group.ParentId = parentId
+	group.Order = order
+
+	if len(group.Conditions) > 0 {
+		query := s.sq.Insert("rule_conditions").
+			Columns("rule_id", "group_id", "enabled", "case_insensitive", "inverted_match", "condition_type",
+				"is_regex", "value", "date_fmt", "metadata_key", "metadata_value", "property_id")
+
+		for _, v := range group.Conditions {
+			query = query.Values(ruleId, id, v.Enabled, v.CaseInsensitive, v.Inverted, v.ConditionType, v.IsRegex, v.Value, v.DateFmt,
+				v.MetadataKey, v.MetadataValue, v.PropertyId)
+		}
 
-	for _, v := range conditions {
-		query = query.Values(ruleId, v.Enabled, v.CaseInsensitive, v.Inverted, v.ConditionType, v.IsRegex, v.Value, v.DateFmt,
-			v.MetadataKey, v.MetadataValue)
+		_, err = exec.ExecSq(query)
+		if err != nil {
+			return getDatabaseError(err, s, "insert rule conditions")
+		}
 	}
 
-	_, err := exec.ExecSq(query)
-	if err != nil {
-		return getDatabaseError(err, s, "insert rule conditions")
+	for i, v := range group.Groups {
+		err = s.addConditionGroup(exec, ruleId, v, models.IntId(id), i)
+		if err != nil {
+			return err
+		}
 	}
 	return nil
 }
 
-func mapConditionsToRules(rules []*models.Rule, conditions *[]models.RuleCondition) {
+// mapConditionsToRules builds the condition tree for each rule. Conditions without a group
+// belong to the root group.
+func mapConditionsToRules(rules []*models.Rule, conditions *[]models.RuleCondition, groups *[]models.RuleConditionGroup) {
+	groupsById := make(map[int]*models.RuleConditionGroup, len(*groups))
+	for i := range *groups {
+		group := &(*groups)[i]
+		group.Conditions = make([]*models.RuleCondition, 0, 5)
+		group.Groups = make([]*models.RuleConditionGroup, 0)
+		groupsById[group.Id] = group
+	}
+	for i := range *groups {
+		group := &(*groups)[i]
+		if group.ParentId == 0 {
+			continue
+		}
+		if parent, ok := groupsById[int(group.ParentId)]; ok {
+			parent.Groups = append(parent.Groups, group)
+		}
+	}
+
 	for i, _ := range rules {
 		rule := rules[i]
-		rule.Conditions = make([]*models.RuleCondition, 0, 10)
+		var root *models.RuleConditionGroup
+		for groupI := range *groups {
+			group := &(*groups)[groupI]
+			if group.RuleId == rule.Id && group.ParentId == 0 {
+				root = group
+				break
+			}
+		}
+		if root == nil {
+			root = &models.RuleConditionGroup{RuleId: rule.Id, Mode: rule.Mode}
+		}
+
 		for conditionI, condition := range *conditions {
-			if condition.RuleId == rule.Id {
-				rule.Conditions = append(rule.Conditions, &(*conditions)[conditionI])
+			if condition.RuleId != rule.Id {
+				continue
+			}
+			group, ok := groupsById[int(condition.GroupId)]
+			if !ok || group.RuleId != rule.Id {
+				group = root
 			}
+			group.Conditions = append(group.Conditions, &(*conditions)[conditionI])
 		}
+		rule.SetConditionTree(root)
 	}
 }
 
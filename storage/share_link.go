@@ -0,0 +1,139 @@
+/*
+ * Virtualpaper is a service to manage users paper documents in virtual format.
+ * Copyright (C) 2024  Tero Vierimaa
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package storage
+
+import (
+	"time"
+
+	"github.com/Masterminds/squirrel"
+	"tryffel.net/go/virtualpaper/errors"
+	"tryffel.net/go/virtualpaper/models"
+)
+
+// AddShareLink adds a new share link to the document and records it in document history.
+func (s *DocumentStore) AddShareLink(exec SqlExecer, link *models.DocumentShareLink) error {
+	link.CreatedAt = time.Now()
+	link.UpdatedAt = link.CreatedAt
+	query := s.sq.Insert("document_share_links").
+		Columns("document_id", "user_id", "name", "token_hash", "password", "allow_content", "expires_at",
+			"created_at", "updated_at").
+		Values(link.DocumentId, link.UserId, link.Name, link.Hash, link.Password, link.AllowContent, link.ExpiresAt,
+			link.CreatedAt, link.UpdatedAt).
+		Suffix("RETURNING id")
+
+	err := exec.GetSq(&link.Id, query)
+	if err != nil {
+		return s.parseError(err, "add share link")
+	}
+	return AddDocumentHistoryAction(exec, s.sq, []models.DocumentHistory{{
+		DocumentId: link.DocumentId,
+		Action:     models.DocumentHistoryActionShareLink,
+		NewValue:   link.Name,
+	}}, link.UserId)
+}
+
+// GetShareLinks returns all share links of the document, latest first.
+func (s *DocumentStore) GetShareLinks(exec SqlExecer, docId string) (*[]models.DocumentShareLink, error) {
+	query := s.sq.Select("*").From("document_share_links").
+		Where("document_id = ?", docId).
+		OrderBy("id DESC")
+
+	data := &[]models.DocumentShareLink{}
+	err := exec.SelectSq(data, query)
+	return data, s.parseError(err, "get share links")
+}
+
+// GetShareLink returns share link of the document.
+func (s *DocumentStore) GetShareLink(exec SqlExecer, docId string, id int) (*models.DocumentShareLink, error) {
+	query := s.sq.Select("*").From("document_share_links").
+		Where("document_id = ? AND id = ?", docId, id)
+
+	data := &models.DocumentShareLink{}
+	err := exec.GetSq(data, query)
+	return data, s.parseError(err, "get share link")
+}
+
+// GetShareLinkByHash returns share link by the hash of its token.
+func (s *DocumentStore) GetShareLinkByHash(exec SqlExecer, hash string) (*models.DocumentShareLink, error) {
+	query := s.sq.Select("*").From("document_share_links").Where("token_hash = ?", hash)
+
+	data := &models.DocumentShareLink{}
+	err := exec.GetSq(data, query)
+	return data, s.parseError(err, "get share link")
+}
+
+// RevokeShareLink revokes active share link and records it in document history.
+func (s *DocumentStore) RevokeShareLink(exec SqlExecer, userId int, docId string, id int) error {
+	query := s.sq.Update("document_share_links").
+		Set("revoked_at", time.Now()).
+		Set("updated_at", time.Now()).
+		Where("document_id = ? AND id = ? AND revoked_at IS NULL", docId, id).
+		Suffix("RETURNING name")
+
+	name := ""
+	err := exec.GetSq(&name, query)
+	if err != nil {
+		err = s.parseError(err, "revoke share link")
+		if errors.Is(err, errors.ErrRecordNotFound) {
+			e := errors.ErrRecordNotFound
+			e.ErrMsg = "active share link not found"
+			return e
+		}
+		return err
+	}
+	return AddDocumentHistoryAction(exec, s.sq, []models.DocumentHistory{{
+		DocumentId: docId,
+		Action:     models.DocumentHistoryActionRevokeLink,
+		OldValue:   name,
+	}}, userId)
+}
+
+// AddShareLinkAccess records share link usage. If count is true, access count of the link is incremented.
+func (s *DocumentStore) AddShareLinkAccess(exec SqlExecer, access *models.DocumentShareLinkAccess, count bool) error {
+	access.CreatedAt = time.Now()
+	query := s.sq.Insert("document_share_link_access").
+		Columns("link_id", "action", "ip_address", "user_agent", "created_at").
+		Values(access.LinkId, access.Action, access.IpAddr, access.UserAgent, access.CreatedAt).
+		Suffix("RETURNING id")
+
+	err := exec.GetSq(&access.Id, query)
+	if err != nil {
+		return s.parseError(err, "add share link access")
+	}
+	if !count {
+		return nil
+	}
+	_, err = exec.ExecSq(s.sq.Update("document_share_links").
+		Set("access_count", squirrel.Expr("access_count + 1")).
+		Set("last_accessed_at", access.CreatedAt).
+		Where("id = ?", access.LinkId))
+	return s.parseError(err, "update share link access count")
+}
+
+// GetShareLinkAccess returns the latest accesses of the share link.
+func (s *DocumentStore) GetShareLinkAccess(exec SqlExecer, linkId int) (*[]models.DocumentShareLinkAccess, error) {
+	query := s.sq.Select("*").From("document_share_link_access").
+		Where("link_id = ?", linkId).
+		OrderBy("id DESC").
+		Limit(500)
+
+	data := &[]models.DocumentShareLinkAccess{}
+	err := exec.SelectSq(data, query)
+	return data, s.parseError(err, "get share link access")
+}
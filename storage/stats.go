@@ -19,27 +19,28 @@
 package storage
 
 import (
+	"context"
 	"fmt"
-	"os"
-	"path/filepath"
 	"time"
 
 	"github.com/jmoiron/sqlx"
 	"github.com/patrickmn/go-cache"
 	"github.com/sirupsen/logrus"
-	"tryffel.net/go/virtualpaper/config"
 	"tryffel.net/go/virtualpaper/errors"
 	"tryffel.net/go/virtualpaper/models"
+	"tryffel.net/go/virtualpaper/storage/blob"
 )
 
 type StatsStore struct {
 	db    *sqlx.DB
+	files blob.Store
 	cache *cache.Cache
 }
 
-func NewStatsStore(db *sqlx.DB) *StatsStore {
+func NewStatsStore(db *sqlx.DB, files blob.Store) *StatsStore {
 	return &StatsStore{
 		db:    db,
+		files: files,
 		cache: cache.New(30*time.Second, time.Minute),
 	}
 }
@@ -198,7 +199,7 @@ from documents d
 		return stats, s.parseError(err, "get system stats")
 	}
 
-	totalSize, err := getStorageTotalSize()
+	totalSize, err := s.getStorageTotalSize()
 	if err != nil {
 		e := errors.ErrInternalError
 		e.Err = fmt.Errorf("get total storage size: %v", err)
@@ -211,21 +212,17 @@ from documents d
 	return stats, nil
 }
 
-func getStorageTotalSize() (uint64, error) {
+func (s *StatsStore) getStorageTotalSize() (uint64, error) {
 	logrus.Warningf("start calculating storage total size, this could take a while")
-	path := config.C.Processing.DocumentsDir
 	var size uint64
+	if s.files == nil {
+		return size, nil
+	}
 
-	err := filepath.Walk(path, func(_ string, info os.FileInfo, err error) error {
-		if err != nil {
-			return err
-		}
-
-		if !info.IsDir() {
-			size += uint64(info.Size())
-		}
-		return err
-	})
+	files, err := s.files.List(context.Background(), "documents/")
+	for _, v := range files {
+		size += uint64(v.Size)
+	}
 
 	logrus.Infof("total storage size")
 	return size, err
@@ -184,9 +184,11 @@ SELECT
 	u.created_at as created_at, 
 	u.updated_at as updated_at, 
 	count(d) as documents_count, 
-	sum(d."size") as documents_size 
+	sum(d."size") as documents_size,
+	COALESCE(bool_or(t.enabled), false) as two_factor_enabled
 from users u 
 left join documents d on u.id = d.user_id 
+left join user_totp t on u.id = t.user_id
 group by u.id
 order by u.name asc
 limit 1000;`
@@ -323,6 +325,11 @@ GROUP BY(s.id);`
 
 type PreferenceKey string
 
+const (
+	// PreferenceOcrLanguages is a comma-separated list of user's preferred OCR languages.
+	PreferenceOcrLanguages PreferenceKey = "ocr_languages"
+)
+
 func (s *UserStore) GetPreferenceValue(userId int, key PreferenceKey) (string, error) {
 	sql := `
 SELECT value
@@ -336,6 +343,18 @@ AND key=$2
 	return value, s.parseError(err, "get preference value")
 }
 
+// GetOcrLanguages returns user's preferred OCR languages, or nil if user has not set them.
+func (s *UserStore) GetOcrLanguages(userId int) (models.OcrLanguages, error) {
+	value, err := s.GetPreferenceValue(userId, PreferenceOcrLanguages)
+	if errors.Is(err, errors.ErrRecordNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return models.ParseOcrLanguages(value), nil
+}
+
 func (s *UserStore) SetPreferenceValue(userId int, key PreferenceKey, value string) error {
 	now := time.Now()
 
@@ -395,3 +414,30 @@ func (s *UserStore) DeleteExpiredPasswordResetTokens() (int, error) {
 	}
 	return int(affected), nil
 }
+
+// GetUserIdentity returns the identity of issuer and subject.
+func (s *UserStore) GetUserIdentity(issuer, subject string) (*models.UserIdentity, error) {
+	sql := `SELECT * FROM user_identities WHERE issuer = $1 AND subject = $2`
+
+	identity := &models.UserIdentity{}
+	err := s.db.Get(identity, sql, issuer, subject)
+	return identity, s.parseError(err, "get user identity")
+}
+
+// AddUserIdentity links user to identity. Id is updated.
+func (s *UserStore) AddUserIdentity(identity *models.UserIdentity) error {
+	sql := `
+INSERT INTO user_identities (user_id, issuer, subject, email, created_at, last_login)
+VALUES ($1, $2, $3, $4, $5, $6) RETURNING id;
+`
+	err := s.db.Get(&identity.Id, sql, identity.UserId, identity.Issuer, identity.Subject, identity.Email,
+		identity.CreatedAt, identity.LastLogin)
+	return s.parseError(err, "add user identity")
+}
+
+// UpdateUserIdentityLogin sets last login time and email of the identity.
+func (s *UserStore) UpdateUserIdentityLogin(identity *models.UserIdentity) error {
+	sql := `UPDATE user_identities SET last_login = $2, email = $3 WHERE id = $1`
+	_, err := s.db.Exec(sql, identity.Id, identity.LastLogin, identity.Email)
+	return s.parseError(err, "update user identity")
+}
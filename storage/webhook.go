@@ -0,0 +1,208 @@
+/*
+ * Virtualpaper is a service to manage users paper documents in virtual format.
+ * Copyright (C) 2024  Tero Vierimaa
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package storage
+
+import (
+	"time"
+
+	"github.com/Masterminds/squirrel"
+	"github.com/jmoiron/sqlx"
+	"tryffel.net/go/virtualpaper/models"
+)
+
+type WebhookStore struct {
+	*resource
+	db *sqlx.DB
+	sq squirrel.StatementBuilderType
+}
+
+func newWebhookStore(db *sqlx.DB) *WebhookStore {
+	return &WebhookStore{
+		resource: &resource{
+			name: "webhook",
+			db:   db,
+		},
+		db: db,
+		sq: squirrel.StatementBuilder.PlaceholderFormat(squirrel.Dollar),
+	}
+}
+
+func (s *WebhookStore) GetWebhooks(exec SqlExecer, userId int) (*[]models.Webhook, error) {
+	query := s.sq.Select("*").From("webhooks").Where("user_id = ?", userId).OrderBy("id")
+	webhooks := &[]models.Webhook{}
+	err := exec.SelectSq(webhooks, query)
+	if err != nil {
+		return nil, s.parseError(err, "get list")
+	}
+	return webhooks, nil
+}
+
+// GetEnabledWebhooks returns all enabled webhooks of the user.
+func (s *WebhookStore) GetEnabledWebhooks(exec SqlExecer, userId int) (*[]models.Webhook, error) {
+	query := s.sq.Select("*").From("webhooks").
+		Where(squirrel.Eq{"user_id": userId, "enabled": true}).OrderBy("id")
+	webhooks := &[]models.Webhook{}
+	err := exec.SelectSq(webhooks, query)
+	if err != nil {
+		return nil, s.parseError(err, "get enabled")
+	}
+	return webhooks, nil
+}
+
+func (s *WebhookStore) GetWebhook(exec SqlExecer, id int) (*models.Webhook, error) {
+	query := s.sq.Select("*").From("webhooks").Where("id = ?", id)
+	webhook := &models.Webhook{}
+	err := exec.GetSq(webhook, query)
+	if err != nil {
+		return nil, s.parseError(err, "get")
+	}
+	return webhook, nil
+}
+
+func (s *WebhookStore) UserOwnsWebhook(exec SqlExecer, userId, id int) (bool, error) {
+	webhook, err := s.GetWebhook(exec, id)
+	if err != nil {
+		return false, err
+	}
+	return webhook.UserId == userId, nil
+}
+
+func (s *WebhookStore) AddWebhook(exec SqlExecer, webhook *models.Webhook) error {
+	webhook.CreatedAt = time.Now()
+	webhook.UpdatedAt = webhook.CreatedAt
+	query := s.sq.Insert("webhooks").
+		Columns("user_id", "name", "url", "secret", "events", "enabled", "created_at", "updated_at").
+		Values(webhook.UserId, webhook.Name, webhook.Url, webhook.Secret, webhook.Events, webhook.Enabled,
+			webhook.CreatedAt, webhook.UpdatedAt).
+		Suffix("RETURNING id")
+	rows, err := exec.QuerySq(query)
+	if err != nil {
+		return s.parseError(err, "add")
+	}
+	defer rows.Close()
+	if rows.Next() {
+		err = rows.Scan(&webhook.Id)
+	}
+	return s.parseError(err, "add")
+}
+
+func (s *WebhookStore) UpdateWebhook(exec SqlExecer, webhook *models.Webhook) error {
+	webhook.Update()
+	query := s.sq.Update("webhooks").SetMap(map[string]interface{}{
+		"name":       webhook.Name,
+		"url":        webhook.Url,
+		"secret":     webhook.Secret,
+		"events":     webhook.Events,
+		"enabled":    webhook.Enabled,
+		"updated_at": webhook.UpdatedAt,
+	}).Where("id = ?", webhook.Id)
+	_, err := exec.ExecSq(query)
+	return s.parseError(err, "update")
+}
+
+func (s *WebhookStore) DeleteWebhook(exec SqlExecer, id int) error {
+	query := s.sq.Delete("webhooks").Where("id = ?", id)
+	_, err := exec.ExecSq(query)
+	return s.parseError(err, "delete")
+}
+
+func (s *WebhookStore) AddDelivery(exec SqlExecer, delivery *models.WebhookDelivery) error {
+	delivery.CreatedAt = time.Now()
+	delivery.UpdatedAt = delivery.CreatedAt
+	if delivery.NextAttemptAt.IsZero() {
+		delivery.NextAttemptAt = delivery.CreatedAt
+	}
+	if delivery.Status == "" {
+		delivery.Status = models.WebhookDeliveryPending
+	}
+	query := s.sq.Insert("webhook_deliveries").
+		Columns("webhook_id", "event", "document_id", "payload", "status", "next_attempt_at", "created_at", "updated_at").
+		Values(delivery.WebhookId, delivery.Event, delivery.DocumentId, delivery.Payload, delivery.Status,
+			delivery.NextAttemptAt, delivery.CreatedAt, delivery.UpdatedAt).
+		Suffix("RETURNING id")
+	rows, err := exec.QuerySq(query)
+	if err != nil {
+		return s.parseError(err, "add delivery")
+	}
+	defer rows.Close()
+	if rows.Next() {
+		err = rows.Scan(&delivery.Id)
+	}
+	return s.parseError(err, "add delivery")
+}
+
+func (s *WebhookStore) UpdateDelivery(exec SqlExecer, delivery *models.WebhookDelivery) error {
+	delivery.Update()
+	query := s.sq.Update("webhook_deliveries").SetMap(map[string]interface{}{
+		"status":          delivery.Status,
+		"attempts":        delivery.Attempts,
+		"response_code":   delivery.ResponseCode,
+		"error":           delivery.Error,
+		"next_attempt_at": delivery.NextAttemptAt,
+		"updated_at":      delivery.UpdatedAt,
+	}).Where("id = ?", delivery.Id)
+	_, err := exec.ExecSq(query)
+	return s.parseError(err, "update delivery")
+}
+
+// GetDeliveries returns deliveries of the webhook, latest first.
+func (s *WebhookStore) GetDeliveries(exec SqlExecer, webhookId int, paging Paging) (*[]models.WebhookDelivery, int, error) {
+	query := s.sq.Select("*").From("webhook_deliveries").
+		Where("webhook_id = ?", webhookId).
+		OrderBy("id DESC").
+		Limit(uint64(paging.Limit)).Offset(uint64(paging.Offset))
+	deliveries := &[]models.WebhookDelivery{}
+	err := exec.SelectSq(deliveries, query)
+	if err != nil {
+		return nil, 0, s.parseError(err, "get deliveries")
+	}
+
+	total := 0
+	countQuery := s.sq.Select("count(id)").From("webhook_deliveries").Where("webhook_id = ?", webhookId)
+	err = exec.GetSq(&total, countQuery)
+	return deliveries, total, s.parseError(err, "count deliveries")
+}
+
+// GetDueDeliveries returns pending deliveries that should be sent now, oldest first.
+func (s *WebhookStore) GetDueDeliveries(exec SqlExecer, limit int) (*[]models.WebhookDelivery, error) {
+	query := s.sq.Select("*").From("webhook_deliveries").
+		Where(squirrel.Eq{"status": models.WebhookDeliveryPending}).
+		Where("next_attempt_at <= ?", time.Now()).
+		OrderBy("next_attempt_at", "id").
+		Limit(uint64(limit))
+	deliveries := &[]models.WebhookDelivery{}
+	err := exec.SelectSq(deliveries, query)
+	if err != nil {
+		return nil, s.parseError(err, "get due deliveries")
+	}
+	return deliveries, nil
+}
+
+// DeleteOldDeliveries deletes completed deliveries that were created before given time.
+func (s *WebhookStore) DeleteOldDeliveries(exec SqlExecer, before time.Time) (int, error) {
+	query := s.sq.Delete("webhook_deliveries").
+		Where(squirrel.NotEq{"status": models.WebhookDeliveryPending}).
+		Where("created_at < ?", before)
+	res, err := exec.ExecSq(query)
+	if err != nil {
+		return 0, s.parseError(err, "delete old deliveries")
+	}
+	rows, err := res.RowsAffected()
+	return int(rows), err
+}